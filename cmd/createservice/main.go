@@ -1,14 +1,13 @@
 package main
 
 import (
+	"aggregat4/go-commentservice/internal/domain"
 	"aggregat4/go-commentservice/internal/repository"
-	"encoding/hex"
 	"flag"
 	"fmt"
 	"log"
 	"os"
-
-	"github.com/aggregat4/go-baselib/crypto"
+	"strings"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -17,8 +16,10 @@ func main() {
 	// Define command-line flags
 	dbPath := flag.String("db", "comments.sqlite", "Path to the SQLite database file")
 	serviceKey := flag.String("servicekey", "", "Service key for the new service")
-	serviceOrigin := flag.String("serviceorigin", "", "Origin URL for the new service")
+	serviceOrigin := flag.String("serviceorigin", "", "Comma separated origins that may embed the new service, e.g. https://example.com,*.example.com")
+	adminRole := flag.String("adminrole", domain.DefaultServiceAdminRole, "Role in the admin's roles claim that grants admin rights for the new service")
 	encryptionKey := flag.String("encryptionkey", "", "32-byte encryption key for AES-256")
+	encryptionKeyVersion := flag.Int("encryptionkeyversion", 1, "Version of the encryption key, see encryption_key_version")
 
 	// Parse command-line flags
 	flag.Parse()
@@ -34,19 +35,20 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Create cipher for encryption
-	secretKey, err := hex.DecodeString(*encryptionKey)
+	origins, err := domain.ParseOrigins(*serviceOrigin)
 	if err != nil {
-		panic(err)
+		log.Fatalf("Error parsing service origins: %v", err)
 	}
-	aesCipher, err := crypto.CreateAes256GcmAead(secretKey)
+
+	// Create keyring for encryption
+	keys, err := repository.ParseKeyring(*encryptionKeyVersion, *encryptionKey, nil)
 	if err != nil {
 		panic(err)
 	}
 
 	// Initialize repository
 	store := &repository.Store{
-		Cipher: aesCipher,
+		Keys: keys,
 	}
 
 	// Initialize and verify database
@@ -58,12 +60,13 @@ func main() {
 	defer store.Close()
 
 	// Create new service
-	serviceId, err := store.CreateService(*serviceKey, *serviceOrigin)
+	serviceId, err := store.CreateService(*serviceKey, origins, *adminRole)
 	if err != nil {
 		log.Fatalf("Error creating service: %v", err)
 	}
 
 	fmt.Printf("Service created successfully with ID: %d\n", serviceId)
 	fmt.Printf("Service Key: %s\n", *serviceKey)
-	fmt.Printf("Service Origin: %s\n", *serviceOrigin)
+	fmt.Printf("Service Origins: %s\n", strings.Join(origins, " "))
+	fmt.Printf("Admin Role: %s\n", *adminRole)
 }
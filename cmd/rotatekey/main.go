@@ -0,0 +1,96 @@
+package main
+
+import (
+	"aggregat4/go-commentservice/internal/repository"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// rotatekey re-encrypts all personal data in the database with the current encryption key. Configure the new key
+// as the current key and the old key as a previous key in the server first, then run this tool. Once it has
+// finished the previous key can be removed from the configuration.
+func main() {
+	// Define command-line flags
+	dbPath := flag.String("db", "comments", "Name of the SQLite database, as configured with database_filename")
+	encryptionKey := flag.String("encryptionkey", "", "The new (current) 32-byte hex encoded encryption key")
+	encryptionKeyVersion := flag.Int("encryptionkeyversion", 0, "Version of the new encryption key, must be higher than the versions of all previous keys")
+	previousKeys := flag.String("previouskeys", "", "Comma separated list of previous keys as version:hexkey")
+	batchSize := flag.Int("batchsize", 500, "Number of rows to re-encrypt per transaction")
+	startTable := flag.String("table", "", "Resume with this table, skipping the tables before it")
+	startAfterId := flag.Int("after", 0, "Resume after the row with this id in the first table")
+
+	// Parse command-line flags
+	flag.Parse()
+
+	// Validate required flags
+	if *encryptionKey == "" || *encryptionKeyVersion == 0 || *previousKeys == "" || *batchSize <= 0 {
+		fmt.Printf("Error: the new encryption key, its version, the previous keys and a positive batch size are required\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+	tables := repository.EncryptedTables()
+	if *startTable != "" {
+		startIndex := slices.Index(tables, *startTable)
+		if startIndex < 0 {
+			log.Fatalf("Unknown table %s, tables with encrypted data are: %s", *startTable, strings.Join(tables, ", "))
+		}
+		tables = tables[startIndex:]
+	} else if *startAfterId != 0 {
+		log.Fatalf("Resuming after an id requires the table to resume with")
+	}
+
+	keys, err := repository.ParseKeyring(*encryptionKeyVersion, *encryptionKey, strings.Split(*previousKeys, ","))
+	if err != nil {
+		log.Fatalf("Error reading encryption keys: %v", err)
+	}
+
+	// Initialize repository
+	store := &repository.Store{
+		Keys: keys,
+	}
+	err = store.InitAndVerifyDb(repository.CreateFileDbUrl(*dbPath))
+	if err != nil {
+		log.Fatalf("Error initializing database: %v", err)
+	}
+	defer store.Close()
+
+	for i, table := range tables {
+		afterId := 0
+		if i == 0 {
+			afterId = *startAfterId
+		}
+		err = rotateTable(store, table, afterId, *batchSize)
+		if err != nil {
+			store.Close()
+			log.Fatalf("Error rotating keys: %v", err)
+		}
+	}
+	fmt.Printf("All data is now encrypted with key version %d, previous keys can be removed from the configuration\n", keys.CurrentVersion())
+}
+
+func rotateTable(store *repository.Store, table string, afterId int, batchSize int) error {
+	total, err := store.CountRows(table)
+	if err != nil {
+		return err
+	}
+	processed, rotated := 0, 0
+	for {
+		batch, err := store.RotateKeysInBatch(table, afterId, batchSize)
+		if err != nil {
+			return fmt.Errorf("%w (resume with -table %s -after %d)", err, table, afterId)
+		}
+		afterId = batch.LastId
+		processed += batch.Processed
+		rotated += batch.Rotated
+		fmt.Printf("%s: checked %d of %d rows, re-encrypted %d (last id %d)\n", table, processed, total, rotated, afterId)
+		if batch.Done {
+			return nil
+		}
+	}
+}
@@ -5,12 +5,12 @@ import (
 	"aggregat4/go-commentservice/internal/email"
 	"aggregat4/go-commentservice/internal/repository"
 	"aggregat4/go-commentservice/internal/server"
-	"encoding/hex"
+	"aggregat4/go-commentservice/internal/spam"
 	"flag"
 	"fmt"
 	"log"
+	"time"
 
-	"github.com/aggregat4/go-baselib/crypto"
 	"github.com/aggregat4/go-baselib/lang"
 	"github.com/kirsle/configdir"
 	"github.com/kkyr/fig"
@@ -37,35 +37,85 @@ func main() {
 	}
 	fmt.Printf("%+v\n", config)
 
-	secretKey, err := hex.DecodeString(config.EncryptionKey)
-	if err != nil {
-		panic(err)
-	}
-	aesCipher, err := crypto.CreateAes256GcmAead(secretKey)
+	keys, err := repository.ParseKeyring(config.EncryptionKeyVersion, config.EncryptionKey, config.PreviousEncryptionKeys)
 	if err != nil {
 		panic(err)
 	}
 	var store = repository.Store{
-		Cipher: aesCipher,
+		Keys: keys,
 	}
 	defer store.Close()
 	err = store.InitAndVerifyDb(repository.CreateFileDbUrl(config.DatabaseFilename))
 	if err != nil {
 		log.Fatalf("Error initializing database: %s", err)
 	}
-	sendGridEmailSender := email.NewSendgridEmailSender(
-		config.EmailFromName,
-		config.EmailFromAddress,
-		config.EmailSubject,
-		config.SendgridApiKey,
-		config.BaseURL,
-	)
-	emailSender := email.NewEmailSender(sendGridEmailSender.SendgridEmailSenderStrategy)
+	emailSender := email.NewEmailSender(&store, createEmailSendingStrategy(config), email.RetryPolicy{
+		MaxAttempts: config.EmailMaxAttempts,
+		BaseDelay:   time.Duration(config.EmailRetryBaseDelaySeconds) * time.Second,
+		MaxDelay:    time.Duration(config.EmailRetryMaxDelaySeconds) * time.Second,
+	}, email.RateLimits{
+		PerRecipientPerHour:              config.EmailRateLimitPerRecipient,
+		PerClientPerHour:                 config.EmailRateLimitPerClient,
+		GlobalPerHour:                    config.EmailRateLimitGlobal,
+		NotificationsPerRecipientPerHour: config.NotificationRateLimit,
+		NotificationsGlobalPerHour:       config.NotificationRateLimitGlobal,
+	})
+	defer emailSender.Close()
+	spamPipeline := spam.NewPipeline(spam.Options{
+		RejectScore:          config.SpamRejectScore,
+		FormTokenKey:         []byte(config.SessionCookieSecretKey),
+		MinimumSubmitTime:    time.Duration(config.SpamMinimumSubmitSeconds) * time.Second,
+		MaximumFormAge:       time.Duration(config.SpamMaximumFormAgeHours) * time.Hour,
+		MaxLinks:             config.SpamMaxLinks,
+		BlockedWords:         config.SpamBlockedWords,
+		BlockedDomains:       config.SpamBlockedDomains,
+		BayesMinimumTraining: config.SpamBayesMinimumTraining,
+	}, &store)
 	server.RunServer(
 		server.Controller{
 			Store:       &store,
 			Config:      config,
 			EmailSender: emailSender,
+			Spam:        spamPipeline,
 		},
 	)
 }
+
+func createEmailSendingStrategy(config domain.Config) func(email email.Email) error {
+	switch config.EmailTransport {
+	case "sendgrid":
+		if config.SendgridApiKey == "" {
+			log.Fatalf("sendgrid_api_key is required when using the sendgrid email transport")
+		}
+		sendGridEmailSender := email.NewSendgridEmailSender(
+			config.EmailFromName,
+			config.EmailFromAddress,
+			config.EmailSubject,
+			config.SendgridApiKey,
+			config.BaseURL,
+		)
+		return sendGridEmailSender.SendgridEmailSenderStrategy
+	case "smtp":
+		smtpEmailSender, err := email.NewSmtpEmailSender(
+			config.EmailFromName,
+			config.EmailFromAddress,
+			config.EmailSubject,
+			config.BaseURL,
+			email.SmtpConfiguration{
+				Host:          config.SmtpHost,
+				Port:          config.SmtpPort,
+				Username:      config.SmtpUsername,
+				Password:      config.SmtpPassword,
+				TlsMode:       config.SmtpTlsMode,
+				AuthMechanism: config.SmtpAuthMechanism,
+			},
+		)
+		if err != nil {
+			log.Fatalf("Error configuring the smtp email transport: %s", err)
+		}
+		return smtpEmailSender.SmtpEmailSenderStrategy
+	default:
+		log.Fatalf("Unknown email transport: %s", config.EmailTransport)
+		return nil
+	}
+}
@@ -0,0 +1,104 @@
+package domain
+
+import "time"
+
+// ApiError is the body of every unsuccessful response of the /api/v1 endpoints
+type ApiError struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+// ApiComment is the public representation of an approved comment on a post. It must never contain the email
+// address of the author.
+type ApiComment struct {
+	Id              int       `json:"id"`
+	ParentCommentId int       `json:"parentCommentId,omitempty"`
+	Name            string    `json:"name"`
+	Website         string    `json:"website"`
+	Comment         string    `json:"comment"`
+	Edited          bool      `json:"edited"`
+	CreatedAt       time.Time `json:"createdAt"`
+}
+
+// ApiManagedComment is the representation of a comment for its author and for admins, it includes the moderation
+// state and where the comment was posted
+type ApiManagedComment struct {
+	ApiComment
+	Status          string `json:"status"`
+	Service         string `json:"service"`
+	PostKey         string `json:"postKey"`
+	ParentUrl       string `json:"parentUrl"`
+	RejectionReason string `json:"rejectionReason,omitempty"`
+}
+
+// ApiCommentList is a list of comments, paginated lists contain the cursor of the next page until the last page
+type ApiCommentList struct {
+	Comments   []ApiComment `json:"comments"`
+	NextCursor string       `json:"nextCursor,omitempty"`
+}
+
+type ApiManagedCommentList struct {
+	Comments   []ApiManagedComment `json:"comments"`
+	NextCursor string              `json:"nextCursor,omitempty"`
+}
+
+// ApiCommentCounts maps post keys to the number of approved comments on each post
+type ApiCommentCounts struct {
+	Counts map[string]int `json:"counts"`
+}
+
+// ApiCommentRequest is the body for creating and updating comments. The email address is only used when creating a
+// comment without an authenticated user, the parent comment can only be set on creation.
+type ApiCommentRequest struct {
+	Email           string `json:"email"`
+	Name            string `json:"name"`
+	Website         string `json:"website"`
+	Comment         string `json:"comment"`
+	ParentUrl       string `json:"parentUrl"`
+	ParentCommentId int    `json:"parentCommentId"`
+}
+
+// ApiRejectRequest is the optional body for rejecting a comment
+type ApiRejectRequest struct {
+	Reason       string `json:"reason"`
+	NotifyAuthor bool   `json:"notifyAuthor"`
+}
+
+func NewApiComment(comment Comment) ApiComment {
+	return ApiComment{
+		Id:              comment.Id,
+		ParentCommentId: comment.ParentCommentId,
+		Name:            comment.Name,
+		Website:         comment.Website,
+		Comment:         comment.Comment,
+		Edited:          comment.Edited,
+		CreatedAt:       comment.CreatedAt.UTC(),
+	}
+}
+
+func NewApiManagedComment(comment Comment) ApiManagedComment {
+	return ApiManagedComment{
+		ApiComment:      NewApiComment(comment),
+		Status:          comment.Status.String(),
+		Service:         comment.ServiceKey,
+		PostKey:         comment.PostKey,
+		ParentUrl:       comment.ParentUrl,
+		RejectionReason: comment.RejectionReason,
+	}
+}
+
+func NewApiCommentList(comments []Comment) ApiCommentList {
+	apiComments := make([]ApiComment, 0, len(comments))
+	for _, comment := range comments {
+		apiComments = append(apiComments, NewApiComment(comment))
+	}
+	return ApiCommentList{Comments: apiComments}
+}
+
+func NewApiManagedCommentList(comments []Comment) ApiManagedCommentList {
+	apiComments := make([]ApiManagedComment, 0, len(comments))
+	for _, comment := range comments {
+		apiComments = append(apiComments, NewApiManagedComment(comment))
+	}
+	return ApiManagedCommentList{Comments: apiComments}
+}
@@ -0,0 +1,40 @@
+package domain
+
+import "encoding/xml"
+
+// AtomFeed is an Atom feed (RFC 4287) of approved comments. Entries name their authors but never contain their
+// email addresses.
+type AtomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Id      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []AtomLink  `xml:"link"`
+	Entries []AtomEntry `xml:"entry"`
+}
+
+type AtomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type AtomPerson struct {
+	Name string `xml:"name"`
+}
+
+// AtomContent holds the rendered comment, Type is "html" since the markup is escaped as text in the feed
+type AtomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type AtomEntry struct {
+	Id        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Published string      `xml:"published"`
+	Updated   string      `xml:"updated"`
+	Author    AtomPerson  `xml:"author"`
+	Links     []AtomLink  `xml:"link"`
+	Content   AtomContent `xml:"content"`
+}
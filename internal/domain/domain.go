@@ -3,29 +3,57 @@ package domain
 import (
 	"fmt"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 )
 
 type Config struct {
-	Port                        int    `fig:"port" validate:"required"`
-	DatabaseFilename            string `fig:"database_filename" validate:"required"`
-	ServerReadTimeoutSeconds    int    `fig:"server_read_timeout_seconds" default:"5"`
-	ServerWriteTimeoutSeconds   int    `fig:"server_write_timeout_seconds" default:"10"`
-	BaseURL                     string `fig:"base_url" validate:"required"` // Base URL where the service is hosted (e.g. https://comments.example.com)
-	OidcIdpServer               string `fig:"oidc_idp_server" validate:"required"`
-	OidcClientId                string `fig:"oidc_client_id" validate:"required"`
-	OidcClientSecret            string `fig:"oidc_client_secret" validate:"required"`
-	OidcRedirectUri             string `fig:"oidc_redirect_uri" validate:"required"`
-	EncryptionKey               string `fig:"encryption_key" validate:"required"`
-	SessionCookieSecretKey      string `fig:"session_cookie_secret_key" validate:"required"`
-	SessionCookieSecureFlag     bool   `fig:"session_cookie_secure_flag" validate:"required"`   // sadly fig can not set default values for booleans, see https://github.com/kkyr/fig/issues/13
-	SessionCookieCookieMaxAge   int    `fig:"session_cookie_max_age" default:"2592000"`         // Max age in seconds, 0 = session cookie, default 2592000 is 30 days
-	SessionCookieCookieSameSite string `fig:"session_cookie_same_site" default:"none"`          // SameSite policy
-	EmailFromName               string `fig:"email_from_name" default:"Go Comments"`            // Name to use as the sender of emails
-	EmailFromAddress            string `fig:"email_from_address" validate:"required"`           // Email address to use as the sender
-	EmailSubject                string `fig:"email_subject" default:"Your Authentication Code"` // Subject line for authentication emails
-	SendgridApiKey              string `fig:"sendgrid_api_key" validate:"required"`             // Sendgrid API key for sending emails
+	Port                        int      `fig:"port" validate:"required"`
+	DatabaseFilename            string   `fig:"database_filename" validate:"required"`
+	ServerReadTimeoutSeconds    int      `fig:"server_read_timeout_seconds" default:"5"`
+	ServerWriteTimeoutSeconds   int      `fig:"server_write_timeout_seconds" default:"10"`
+	TrustedProxies              []string `fig:"trusted_proxies"`              // IP ranges like "10.0.0.0/8" of reverse proxies whose X-Forwarded-For header is trusted
+	BaseURL                     string   `fig:"base_url" validate:"required"` // Base URL where the service is hosted (e.g. https://comments.example.com)
+	OidcIdpServer               string   `fig:"oidc_idp_server" validate:"required"`
+	OidcClientId                string   `fig:"oidc_client_id" validate:"required"`
+	OidcClientSecret            string   `fig:"oidc_client_secret" validate:"required"`
+	OidcRedirectUri             string   `fig:"oidc_redirect_uri" validate:"required"`
+	EncryptionKey               string   `fig:"encryption_key" validate:"required"`
+	EncryptionKeyVersion        int      `fig:"encryption_key_version" default:"1"` // Version of encryption_key, increment it when rotating keys
+	PreviousEncryptionKeys      []string `fig:"previous_encryption_keys"`           // Keys that are only used to decrypt existing data, each as "version:hexkey"
+	SessionCookieSecretKey      string   `fig:"session_cookie_secret_key" validate:"required"`
+	SessionCookieSecureFlag     bool     `fig:"session_cookie_secure_flag" validate:"required"`   // sadly fig can not set default values for booleans, see https://github.com/kkyr/fig/issues/13
+	SessionCookieCookieMaxAge   int      `fig:"session_cookie_max_age" default:"2592000"`         // Max age in seconds, 0 = session cookie, default 2592000 is 30 days
+	SessionCookieCookieSameSite string   `fig:"session_cookie_same_site" default:"none"`          // SameSite policy
+	EmailFromName               string   `fig:"email_from_name" default:"Go Comments"`            // Name to use as the sender of emails
+	EmailFromAddress            string   `fig:"email_from_address" validate:"required"`           // Email address to use as the sender
+	EmailSubject                string   `fig:"email_subject" default:"Your Authentication Code"` // Subject line for authentication emails
+	EmailTransport              string   `fig:"email_transport" default:"sendgrid"`               // How emails are sent: "sendgrid" or "smtp"
+	SendgridApiKey              string   `fig:"sendgrid_api_key"`                                 // Sendgrid API key for sending emails, required for the sendgrid transport
+	SmtpHost                    string   `fig:"smtp_host"`                                        // SMTP server host name, required for the smtp transport
+	SmtpPort                    int      `fig:"smtp_port" default:"587"`                          // SMTP server port
+	SmtpUsername                string   `fig:"smtp_username"`                                    // SMTP username, leave empty to send without authentication
+	SmtpPassword                string   `fig:"smtp_password"`                                    // SMTP password
+	SmtpTlsMode                 string   `fig:"smtp_tls_mode" default:"starttls"`                 // "starttls", "tls" (implicit TLS) or "none"
+	SmtpAuthMechanism           string   `fig:"smtp_auth_mechanism" default:"plain"`              // "plain" or "login"
+	EmailMaxAttempts            int      `fig:"email_max_attempts" default:"8"`                   // Delivery attempts before an email is dead lettered
+	EmailRetryBaseDelaySeconds  int      `fig:"email_retry_base_delay_seconds" default:"30"`      // Delay before the first retry, doubles with every attempt
+	EmailRetryMaxDelaySeconds   int      `fig:"email_retry_max_delay_seconds" default:"3600"`     // Upper bound for the delay between retries
+	EmailRateLimitPerRecipient  int      `fig:"email_rate_limit_per_recipient" default:"5"`       // Emails per hour to a single address, 0 disables the limit
+	EmailRateLimitPerClient     int      `fig:"email_rate_limit_per_client" default:"20"`         // Emails per hour requested from a single IP address, 0 disables the limit
+	EmailRateLimitGlobal        int      `fig:"email_rate_limit_global" default:"200"`            // Emails per hour in total, 0 disables the limit
+	NotificationRateLimit       int      `fig:"notification_rate_limit" default:"30"`             // Notifications per hour to a single address, 0 disables the limit
+	NotificationRateLimitGlobal int      `fig:"notification_rate_limit_global" default:"1000"`    // Notifications per hour in total, 0 disables the limit
+	OidcRolesClaim              string   `fig:"oidc_roles_claim" default:"roles"`                 // ID token claim that contains the roles of an admin
+	SuperadminRole              string   `fig:"superadmin_role" default:"superadmin"`             // Role that grants admin rights for all services and the server
+	SpamRejectScore             float64  `fig:"spam_reject_score" default:"1"`                    // Spam score from 0 to 1 at which new comments are refused, 0 never refuses comments
+	SpamMinimumSubmitSeconds    int      `fig:"spam_minimum_submit_seconds" default:"3"`          // Comment forms submitted faster are spam, 0 disables the check
+	SpamMaximumFormAgeHours     int      `fig:"spam_maximum_form_age_hours" default:"24"`         // Comment forms submitted later are suspicious, 0 disables the check
+	SpamMaxLinks                int      `fig:"spam_max_links" default:"3"`                       // Comments with more links are likely spam, 0 disables the check
+	SpamBlockedWords            []string `fig:"spam_blocked_words"`                               // Comments containing one of these words are likely spam
+	SpamBlockedDomains          []string `fig:"spam_blocked_domains"`                             // Comments linking to one of these domains or their subdomains are likely spam
+	SpamBayesMinimumTraining    int      `fig:"spam_bayes_minimum_training" default:"20"`         // Approved and rejected comments each before the Bayesian classifier is used
 }
 
 func SameSiteFromString(sameSite string) http.SameSite {
@@ -53,14 +81,57 @@ func (u User) IsValid() bool {
 	return u.Id != 0
 }
 
+// DefaultServiceAdminRole is the admin role of services that were created without specifying one
+const DefaultServiceAdminRole = "service-admin"
+
+// AdminUser is an administrator authenticated with OIDC. Superadmins administer all services and the server itself,
+// other admins only the services whose admin role is one of their roles.
 type AdminUser struct {
-	UserId string
+	UserId     string
+	Superadmin bool
+	Services   []Service
+}
+
+func (a AdminUser) IsValid() bool {
+	return a.UserId != ""
+}
+
+// CanAdminister returns whether the admin may moderate the comments of the service
+func (a AdminUser) CanAdminister(serviceId int) bool {
+	for _, service := range a.Services {
+		if service.Id == serviceId {
+			return true
+		}
+	}
+	return false
+}
+
+func (a AdminUser) ServiceIds() []int {
+	serviceIds := make([]int, 0, len(a.Services))
+	for _, service := range a.Services {
+		serviceIds = append(serviceIds, service.Id)
+	}
+	return serviceIds
 }
 
 type Service struct {
 	Id         int
 	ServiceKey string
-	Origin     string
+	// Origins may embed the comments of this service, see ParseOrigins for the supported formats
+	Origins []string
+	// AdminRole is the value of the roles claim that grants admin rights for this service
+	AdminRole string
+	// EditPolicy decides whether approved comments can be edited, EditGraceWindow is only used by
+	// EditPolicyGraceWindow
+	EditPolicy      EditPolicy
+	EditGraceWindow time.Duration
+}
+
+// ServiceOverview is a service together with the number of its comments, for managing services
+type ServiceOverview struct {
+	Service
+	Comments        int
+	PendingApproval int
 }
 
 type CommentStatus int
@@ -88,6 +159,22 @@ func ParseCommentStatus(status string) (CommentStatus, error) {
 	}
 }
 
+// String returns the same representation that ParseCommentStatus accepts
+func (status CommentStatus) String() string {
+	switch status {
+	case CommentStatusPendingAuthentication:
+		return "pending-authentication"
+	case CommentStatusPendingApproval:
+		return "pending-approval"
+	case CommentStatusApproved:
+		return "approved"
+	case CommentStatusRejected:
+		return "rejected"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(status))
+	}
+}
+
 type Comment struct {
 	Id         int
 	Status     CommentStatus
@@ -101,4 +188,143 @@ type Comment struct {
 	Edited     bool
 	CreatedAt  time.Time
 	ParentUrl  string
+	// ParentCommentId is the id of the comment this comment replies to, 0 for top level comments
+	ParentCommentId int
+	// RejectionReason is the optional explanation of the admin for rejected comments, it is shown to the author
+	RejectionReason string
+	// SpamScore is between 0 and 1 as rated by the spam filters when the comment was submitted
+	SpamScore float64
+}
+
+// moderationTransitions are the status changes admins can make. Approved and rejected comments can be sent back to
+// moderation, only the author can confirm a comment that is pending authentication.
+var moderationTransitions = map[CommentStatus][]CommentStatus{
+	CommentStatusPendingAuthentication: {CommentStatusApproved, CommentStatusRejected},
+	CommentStatusPendingApproval:       {CommentStatusApproved, CommentStatusRejected},
+	CommentStatusApproved:              {CommentStatusPendingApproval, CommentStatusRejected},
+	CommentStatusRejected:              {CommentStatusPendingApproval, CommentStatusApproved},
+}
+
+// CanBeModeratedTo returns whether an admin may change the status of this comment to the given status
+func (c Comment) CanBeModeratedTo(status CommentStatus) bool {
+	return slices.Contains(moderationTransitions[c.Status], status)
+}
+
+// AuthorConfirmed returns whether the author confirmed the email address of the comment. Until then the address may
+// belong to someone else and must not be sent anything but the authentication link.
+func (c Comment) AuthorConfirmed() bool {
+	return c.Status != CommentStatusPendingAuthentication
+}
+
+// AcceptsReplies returns whether other users may reply to this comment. Only approved comments can be
+// replied to so that replies never reference content that is not publicly visible.
+func (c Comment) AcceptsReplies() bool {
+	return c.Status == CommentStatusApproved
+}
+
+// CommentThread is a comment together with its (recursively nested) replies. When the parent of a reply
+// is not available anymore (deleted, rejected or otherwise not visible) the thread contains a placeholder
+// node with Available set to false so that the structure of the discussion is retained.
+type CommentThread struct {
+	Comment   Comment
+	Available bool
+	Replies   []CommentThread
+}
+
+// BuildCommentThreads arranges a chronologically ordered list of comments into threads. Replies whose
+// parent is not part of the list are grouped under a placeholder for that parent. hiddenParents has the parents of
+// the comments that are not part of the list, so that their placeholders are nested where the comments were. Parents
+// that are not known there get a placeholder at the top level.
+func BuildCommentThreads(comments []Comment, hiddenParents map[int]int) []CommentThread {
+	available := make(map[int]bool, len(comments))
+	for _, comment := range comments {
+		available[comment.Id] = true
+	}
+	children := make(map[int][]Comment)
+	roots := make([]Comment, 0)
+	placeholders := make(map[int]bool)
+	var addPlaceholder func(id int, createdAt time.Time)
+	addPlaceholder = func(id int, createdAt time.Time) {
+		if placeholders[id] {
+			return
+		}
+		placeholders[id] = true
+		// the placeholder takes the position of the first reply to the missing comment
+		placeholder := Comment{Id: id, CreatedAt: createdAt}
+		parentId := hiddenParents[id]
+		if parentId == 0 {
+			roots = append(roots, placeholder)
+			return
+		}
+		if !available[parentId] {
+			addPlaceholder(parentId, createdAt)
+		}
+		children[parentId] = append(children[parentId], placeholder)
+	}
+	for _, comment := range comments {
+		if comment.ParentCommentId == 0 {
+			roots = append(roots, comment)
+			continue
+		}
+		if !available[comment.ParentCommentId] {
+			addPlaceholder(comment.ParentCommentId, comment.CreatedAt)
+		}
+		children[comment.ParentCommentId] = append(children[comment.ParentCommentId], comment)
+	}
+	threads := make([]CommentThread, 0, len(roots))
+	for _, root := range roots {
+		threads = append(threads, buildCommentThread(root, available, children))
+	}
+	return threads
+}
+
+func buildCommentThread(comment Comment, available map[int]bool, children map[int][]Comment) CommentThread {
+	thread := CommentThread{Comment: comment, Available: available[comment.Id]}
+	for _, reply := range children[comment.Id] {
+		thread.Replies = append(thread.Replies, buildCommentThread(reply, available, children))
+	}
+	return thread
+}
+
+type OutboxStatus int
+
+const (
+	_ OutboxStatus = iota
+	OutboxStatusPending
+	OutboxStatusSent
+	OutboxStatusDeadLetter
+)
+
+func ParseOutboxStatus(status string) (OutboxStatus, error) {
+	switch status {
+	case "pending":
+		return OutboxStatusPending, nil
+	case "sent":
+		return OutboxStatusSent, nil
+	case "dead-letter":
+		return OutboxStatusDeadLetter, nil
+	default:
+		return -1, fmt.Errorf("invalid outbox status: %s", status)
+	}
+}
+
+// AccountDeletionStatistics summarizes the anonymous tombstones that are left behind when users delete their account
+type AccountDeletionStatistics struct {
+	DeletedAccounts int
+	DeletedComments int
+}
+
+// OutboxEmail is an email that has been queued for delivery. Payload is the serialized email, its format
+// depends on Kind and is only interpreted by the email package.
+type OutboxEmail struct {
+	Id            int
+	Kind          string
+	Recipient     string
+	Payload       string
+	Status        OutboxStatus
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+	CreatedAt     time.Time
+	SentAt        time.Time
 }
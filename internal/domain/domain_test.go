@@ -0,0 +1,50 @@
+package domain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// threadIds describes threads as nested lists of comment ids, placeholders are negative
+func threadIds(threads []CommentThread) []interface{} {
+	ids := make([]interface{}, 0, len(threads))
+	for _, thread := range threads {
+		id := thread.Comment.Id
+		if !thread.Available {
+			id = -id
+		}
+		if len(thread.Replies) == 0 {
+			ids = append(ids, id)
+		} else {
+			ids = append(ids, []interface{}{id, threadIds(thread.Replies)})
+		}
+	}
+	return ids
+}
+
+func TestBuildCommentThreadsNestsPlaceholdersUnderTheirParent(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	comment := func(id int, parentId int) Comment {
+		return Comment{Id: id, ParentCommentId: parentId, CreatedAt: start.Add(time.Duration(id) * time.Minute)}
+	}
+	// 1 is shown, 2 is rejected, 3 replies to 2, 4 was pending and its reply 5 is shown, 6 replies to a deleted
+	// comment 7 that is not known anymore
+	comments := []Comment{comment(1, 0), comment(3, 2), comment(5, 4), comment(6, 7)}
+	threads := BuildCommentThreads(comments, map[int]int{2: 1, 4: 2})
+	assert.Equal(t, []interface{}{
+		[]interface{}{1, []interface{}{
+			[]interface{}{-2, []interface{}{3, []interface{}{-4, []interface{}{5}}}},
+		}},
+		[]interface{}{-7, []interface{}{6}},
+	}, threadIds(threads))
+
+	// without the hidden parents every placeholder starts a thread
+	assert.Equal(t, []interface{}{
+		1,
+		[]interface{}{-2, []interface{}{3}},
+		[]interface{}{-4, []interface{}{5}},
+		[]interface{}{-7, []interface{}{6}},
+	}, threadIds(BuildCommentThreads(comments, nil)))
+}
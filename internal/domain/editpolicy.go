@@ -0,0 +1,80 @@
+package domain
+
+import (
+	"fmt"
+	"time"
+)
+
+// EditPolicy decides whether authors may edit their comments once they have been approved. Comments that are not
+// approved can always be edited.
+type EditPolicy int
+
+const (
+	_ EditPolicy = iota
+	// EditPolicyForbid does not allow editing approved comments
+	EditPolicyForbid
+	// EditPolicyGraceWindow allows editing approved comments for a while after they were written, they stay approved
+	EditPolicyGraceWindow
+	// EditPolicyRemoderate allows editing approved comments at any time, they have to be approved again
+	EditPolicyRemoderate
+)
+
+// DefaultEditGraceWindow is used for new services and whenever no grace window is configured
+const DefaultEditGraceWindow = 15 * time.Minute
+
+// MaxEditGraceWindow keeps the grace window short enough that readers can rely on approved comments
+const MaxEditGraceWindow = 7 * 24 * time.Hour
+
+func (p EditPolicy) String() string {
+	switch p {
+	case EditPolicyForbid:
+		return "forbid"
+	case EditPolicyGraceWindow:
+		return "grace-window"
+	case EditPolicyRemoderate:
+		return "remoderate"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(p))
+	}
+}
+
+func ParseEditPolicy(policy string) (EditPolicy, error) {
+	switch policy {
+	case "forbid":
+		return EditPolicyForbid, nil
+	case "grace-window":
+		return EditPolicyGraceWindow, nil
+	case "remoderate":
+		return EditPolicyRemoderate, nil
+	default:
+		return -1, fmt.Errorf("invalid edit policy: %s", policy)
+	}
+}
+
+// CanEdit tells whether the author may edit the comment at the given time
+func (s Service) CanEdit(comment Comment, now time.Time) bool {
+	if comment.Status != CommentStatusApproved {
+		return true
+	}
+	switch s.EditPolicy {
+	case EditPolicyGraceWindow:
+		return now.Before(comment.CreatedAt.Add(s.EditGraceWindow))
+	case EditPolicyRemoderate:
+		return true
+	default:
+		return false
+	}
+}
+
+// StatusAfterEdit is the status of the comment once its author has edited it. Editing requires the author to be
+// authenticated, so comments pending authentication move on to pending approval.
+func (s Service) StatusAfterEdit(comment Comment) CommentStatus {
+	switch {
+	case comment.Status == CommentStatusPendingAuthentication:
+		return CommentStatusPendingApproval
+	case comment.Status == CommentStatusApproved && s.EditPolicy == EditPolicyRemoderate:
+		return CommentStatusPendingApproval
+	default:
+		return comment.Status
+	}
+}
@@ -0,0 +1,42 @@
+package domain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanEditFollowsTheEditPolicy(t *testing.T) {
+	created := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	approved := Comment{Status: CommentStatusApproved, CreatedAt: created}
+	pending := Comment{Status: CommentStatusPendingApproval, CreatedAt: created}
+	forbid := Service{EditPolicy: EditPolicyForbid}
+	graceWindow := Service{EditPolicy: EditPolicyGraceWindow, EditGraceWindow: 15 * time.Minute}
+	remoderate := Service{EditPolicy: EditPolicyRemoderate}
+
+	assert.False(t, forbid.CanEdit(approved, created))
+	assert.True(t, forbid.CanEdit(pending, created.Add(time.Hour)), "Comments that are not approved can always be edited")
+	assert.True(t, graceWindow.CanEdit(approved, created.Add(14*time.Minute)))
+	assert.False(t, graceWindow.CanEdit(approved, created.Add(15*time.Minute)))
+	assert.True(t, remoderate.CanEdit(approved, created.Add(24*time.Hour)))
+}
+
+func TestStatusAfterEdit(t *testing.T) {
+	graceWindow := Service{EditPolicy: EditPolicyGraceWindow, EditGraceWindow: 15 * time.Minute}
+	remoderate := Service{EditPolicy: EditPolicyRemoderate}
+	assert.Equal(t, CommentStatusApproved, graceWindow.StatusAfterEdit(Comment{Status: CommentStatusApproved}))
+	assert.Equal(t, CommentStatusPendingApproval, remoderate.StatusAfterEdit(Comment{Status: CommentStatusApproved}))
+	assert.Equal(t, CommentStatusPendingApproval, remoderate.StatusAfterEdit(Comment{Status: CommentStatusPendingAuthentication}))
+	assert.Equal(t, CommentStatusRejected, remoderate.StatusAfterEdit(Comment{Status: CommentStatusRejected}))
+}
+
+func TestParseEditPolicy(t *testing.T) {
+	for _, policy := range []EditPolicy{EditPolicyForbid, EditPolicyGraceWindow, EditPolicyRemoderate} {
+		parsed, err := ParseEditPolicy(policy.String())
+		assert.Nil(t, err)
+		assert.Equal(t, policy, parsed)
+	}
+	_, err := ParseEditPolicy("always")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,105 @@
+package domain
+
+import "time"
+
+// UserDataExportVersion is incremented whenever fields are removed or change their meaning. Adding fields does not
+// change the version, consumers should ignore fields they do not know.
+const UserDataExportVersion = 1
+
+// UserDataExport is the document users can download to get a copy of all personal data we store about them. Its
+// format is documented in the README, keep the two in sync.
+type UserDataExport struct {
+	Version    int                     `json:"version"`
+	ExportedAt time.Time               `json:"exportedAt"`
+	User       UserDataExportUser      `json:"user"`
+	Comments   []UserDataExportComment `json:"comments"`
+	// Subscriptions are the emails the user asked for when writing comments
+	Subscriptions []UserDataExportSubscription `json:"subscriptions"`
+}
+
+type UserDataExportUser struct {
+	Email string `json:"email"`
+}
+
+type UserDataExportComment struct {
+	Id              int       `json:"id"`
+	Status          string    `json:"status"`
+	Service         string    `json:"service"`
+	PostKey         string    `json:"postKey"`
+	ParentUrl       string    `json:"parentUrl"`
+	ParentCommentId int       `json:"parentCommentId,omitempty"`
+	Name            string    `json:"name"`
+	Website         string    `json:"website"`
+	Comment         string    `json:"comment"`
+	Edited          bool      `json:"edited"`
+	CreatedAt       time.Time `json:"createdAt"`
+	RejectionReason string    `json:"rejectionReason,omitempty"`
+	// Revisions are the earlier versions of edited comments, oldest first
+	Revisions []UserDataExportRevision `json:"revisions,omitempty"`
+}
+
+type UserDataExportRevision struct {
+	Revision  int       `json:"revision"`
+	Name      string    `json:"name"`
+	Website   string    `json:"website"`
+	Comment   string    `json:"comment"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type UserDataExportSubscription struct {
+	Kind      string    `json:"kind"`
+	Service   string    `json:"service"`
+	PostKey   string    `json:"postKey"`
+	CommentId int       `json:"commentId,omitempty"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func NewUserDataExport(user User, comments []Comment, revisions map[int][]CommentRevision, subscriptions []Subscription, exportedAt time.Time) UserDataExport {
+	exportedComments := make([]UserDataExportComment, 0, len(comments))
+	for _, comment := range comments {
+		var exportedRevisions []UserDataExportRevision
+		for _, revision := range revisions[comment.Id] {
+			exportedRevisions = append(exportedRevisions, UserDataExportRevision{
+				Revision:  revision.Revision,
+				Name:      revision.Name,
+				Website:   revision.Website,
+				Comment:   revision.Comment,
+				CreatedAt: revision.CreatedAt.UTC(),
+			})
+		}
+		exportedComments = append(exportedComments, UserDataExportComment{
+			Id:              comment.Id,
+			Status:          comment.Status.String(),
+			Service:         comment.ServiceKey,
+			PostKey:         comment.PostKey,
+			ParentUrl:       comment.ParentUrl,
+			ParentCommentId: comment.ParentCommentId,
+			Name:            comment.Name,
+			Website:         comment.Website,
+			Comment:         comment.Comment,
+			Edited:          comment.Edited,
+			CreatedAt:       comment.CreatedAt.UTC(),
+			RejectionReason: comment.RejectionReason,
+			Revisions:       exportedRevisions,
+		})
+	}
+	exportedSubscriptions := make([]UserDataExportSubscription, 0, len(subscriptions))
+	for _, subscription := range subscriptions {
+		exportedSubscriptions = append(exportedSubscriptions, UserDataExportSubscription{
+			Kind:      subscription.Kind.String(),
+			Service:   subscription.ServiceKey,
+			PostKey:   subscription.PostKey,
+			CommentId: subscription.CommentId,
+			Active:    subscription.Active,
+			CreatedAt: subscription.CreatedAt.UTC(),
+		})
+	}
+	return UserDataExport{
+		Version:       UserDataExportVersion,
+		ExportedAt:    exportedAt.UTC(),
+		User:          UserDataExportUser{Email: user.Email},
+		Comments:      exportedComments,
+		Subscriptions: exportedSubscriptions,
+	}
+}
@@ -0,0 +1,92 @@
+package domain
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+type ActorKind int
+
+const (
+	_ ActorKind = iota
+	ActorKindUser
+	ActorKindAdmin
+)
+
+func (k ActorKind) String() string {
+	switch k {
+	case ActorKindUser:
+		return "user"
+	case ActorKindAdmin:
+		return "admin"
+	default:
+		return "unknown"
+	}
+}
+
+func ParseActorKind(kind string) (ActorKind, error) {
+	switch kind {
+	case "user":
+		return ActorKindUser, nil
+	case "admin":
+		return ActorKindAdmin, nil
+	default:
+		return -1, fmt.Errorf("invalid actor kind: %s", kind)
+	}
+}
+
+// Actor is whoever changed a comment: an admin identified by the subject of their OIDC identity or a user
+// identified by their id
+type Actor struct {
+	Kind ActorKind
+	Id   string
+}
+
+func AdminActor(adminUser AdminUser) Actor {
+	return Actor{Kind: ActorKindAdmin, Id: adminUser.UserId}
+}
+
+func UserActor(userId int) Actor {
+	return Actor{Kind: ActorKindUser, Id: strconv.Itoa(userId)}
+}
+
+func (a Actor) String() string {
+	return a.Kind.String() + " " + a.Id
+}
+
+// ModerationLogEntry records one status change or the deletion of a comment. NewStatus is 0 for deletions.
+type ModerationLogEntry struct {
+	Id         int
+	CommentId  int
+	ServiceId  int
+	ServiceKey string
+	PostKey    string
+	Actor      Actor
+	OldStatus  CommentStatus
+	NewStatus  CommentStatus
+	Reason     string
+	CreatedAt  time.Time
+}
+
+func (e ModerationLogEntry) Deleted() bool {
+	return e.NewStatus == 0
+}
+
+// ModerationLogFilter selects moderation log entries. ServiceIds limits the result to the services the admin may
+// see and is required, all other fields only restrict the result when they are set.
+type ModerationLogFilter struct {
+	ServiceIds []int
+	CommentId  int
+	Actor      Actor
+	// From is inclusive, To is exclusive
+	From time.Time
+	To   time.Time
+}
+
+// ModerationLogPage is one page of the moderation log, newest first. NextCursor is the id the next page starts
+// below and 0 on the last page.
+type ModerationLogPage struct {
+	Entries    []ModerationLogEntry
+	NextCursor int
+}
@@ -0,0 +1,89 @@
+package domain
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// AdminNotificationMode is how an admin wants to be told about comments that wait for approval
+type AdminNotificationMode int
+
+const (
+	// AdminNotificationsOff sends no notifications, it is the default for admins without settings
+	AdminNotificationsOff AdminNotificationMode = iota
+	// AdminNotificationsImmediate sends an email for every comment as soon as it waits for approval
+	AdminNotificationsImmediate
+	// AdminNotificationsHourly sends at most one email per hour with all comments that started waiting since the last one
+	AdminNotificationsHourly
+	// AdminNotificationsDaily is like AdminNotificationsHourly with at most one email per day
+	AdminNotificationsDaily
+)
+
+func (m AdminNotificationMode) String() string {
+	switch m {
+	case AdminNotificationsOff:
+		return "off"
+	case AdminNotificationsImmediate:
+		return "immediate"
+	case AdminNotificationsHourly:
+		return "hourly"
+	case AdminNotificationsDaily:
+		return "daily"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(m))
+	}
+}
+
+func ParseAdminNotificationMode(mode string) (AdminNotificationMode, error) {
+	switch mode {
+	case "off":
+		return AdminNotificationsOff, nil
+	case "immediate":
+		return AdminNotificationsImmediate, nil
+	case "hourly":
+		return AdminNotificationsHourly, nil
+	case "daily":
+		return AdminNotificationsDaily, nil
+	default:
+		return -1, fmt.Errorf("invalid notification mode: %s", mode)
+	}
+}
+
+// DigestInterval is the minimum time between two digests, it is 0 for modes without digests
+func (m AdminNotificationMode) DigestInterval() time.Duration {
+	switch m {
+	case AdminNotificationsHourly:
+		return time.Hour
+	case AdminNotificationsDaily:
+		return 24 * time.Hour
+	default:
+		return 0
+	}
+}
+
+// AdminNotificationSettings belong to an admin, who is only known by their OIDC subject. The roles are those of the
+// admin's last login, they decide which services the admin is notified about.
+type AdminNotificationSettings struct {
+	Id      int
+	AdminId string
+	Email   string
+	Mode    AdminNotificationMode
+	Roles   []string
+	// LastDigestAt is when the last digest was sent, the zero time when there was none yet
+	LastDigestAt time.Time
+}
+
+// NotifiesAbout tells whether the admin wants notifications about the comments of the service
+func (s AdminNotificationSettings) NotifiesAbout(service Service, superadminRole string) bool {
+	if s.Mode == AdminNotificationsOff {
+		return false
+	}
+	return slices.Contains(s.Roles, service.AdminRole) || (superadminRole != "" && slices.Contains(s.Roles, superadminRole))
+}
+
+// DigestDue tells whether the next digest may be sent at the given time
+func (s AdminNotificationSettings) DigestDue(now time.Time) bool {
+	interval := s.Mode.DigestInterval()
+	return interval > 0 && !now.Before(s.LastDigestAt.Add(interval))
+}
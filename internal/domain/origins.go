@@ -0,0 +1,108 @@
+package domain
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// originPattern accepts the CSP host sources we allow for services: an optional scheme, a host name that may start
+// with a "*." wildcard for all subdomains and an optional port. Anything else, in particular whitespace, quotes and
+// semicolons, could change the meaning of the Content-Security-Policy header the origins end up in.
+var originPattern = regexp.MustCompile(`^([a-z][a-z0-9+.-]*://)?(\*\.)?[a-z0-9-]+(\.[a-z0-9-]+)*(:([0-9]+|\*))?$`)
+
+// ValidateOrigin returns an error when the origin can not be used in a frame-ancestors directive
+func ValidateOrigin(origin string) error {
+	if !originPattern.MatchString(origin) {
+		return fmt.Errorf("%q is not a valid origin, use for example https://blog.example.com or https://*.example.com", origin)
+	}
+	return nil
+}
+
+// ParseOrigins parses origins separated by whitespace or commas. Origins are lowercased and duplicates removed.
+func ParseOrigins(list string) ([]string, error) {
+	origins := make([]string, 0)
+	for _, origin := range strings.FieldsFunc(list, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' || r == '\r' || r == '\n' }) {
+		origin = strings.ToLower(origin)
+		err := ValidateOrigin(origin)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(origins, origin) {
+			origins = append(origins, origin)
+		}
+	}
+	if len(origins) == 0 {
+		return nil, fmt.Errorf("at least one origin is required")
+	}
+	return origins, nil
+}
+
+// FrameAncestorsPolicy returns the Content-Security-Policy that only allows the origins to embed our pages
+func FrameAncestorsPolicy(origins []string) string {
+	if len(origins) == 0 {
+		return "frame-ancestors 'none'"
+	}
+	return "frame-ancestors " + strings.Join(origins, " ")
+}
+
+// OriginAllowed tells whether the Origin header of a request matches one of the origins of a service. The origins are
+// matched like CSP host sources: without a scheme http and https are allowed, "*." allows all subdomains but not the
+// domain itself and without a port only the default port of the scheme is allowed, ":*" allows every port.
+func OriginAllowed(origins []string, origin string) bool {
+	parsedOrigin, err := url.Parse(strings.ToLower(origin))
+	if err != nil || parsedOrigin.Host == "" || parsedOrigin.Path != "" {
+		return false
+	}
+	originPort := parsedOrigin.Port()
+	if originPort == "" {
+		originPort = DefaultPort(parsedOrigin.Scheme)
+	}
+	for _, allowed := range origins {
+		scheme, hostAndPort, found := strings.Cut(allowed, "://")
+		if !found {
+			scheme, hostAndPort = "", allowed
+		}
+		if scheme == "" && parsedOrigin.Scheme != "http" && parsedOrigin.Scheme != "https" {
+			continue
+		}
+		if scheme != "" && scheme != parsedOrigin.Scheme {
+			continue
+		}
+		host, port, hasPort := strings.Cut(hostAndPort, ":")
+		if !hasPort {
+			port = DefaultPort(parsedOrigin.Scheme)
+		}
+		if port != "*" && port != originPort {
+			continue
+		}
+		if wildcardDomain, isWildcard := strings.CutPrefix(host, "*."); isWildcard {
+			if strings.HasSuffix(parsedOrigin.Hostname(), "."+wildcardDomain) {
+				return true
+			}
+		} else if host == parsedOrigin.Hostname() {
+			return true
+		}
+	}
+	return false
+}
+
+// PageUrlAllowed tells whether the URL is an http or https page on one of the origins of a service, like the page a
+// comment was written on
+func PageUrlAllowed(origins []string, pageUrl string) bool {
+	parsedUrl, err := url.Parse(pageUrl)
+	if err != nil || (parsedUrl.Scheme != "http" && parsedUrl.Scheme != "https") || parsedUrl.Host == "" || parsedUrl.User != nil {
+		return false
+	}
+	return OriginAllowed(origins, parsedUrl.Scheme+"://"+parsedUrl.Host)
+}
+
+// DefaultPort returns the port of the scheme when a URL does not have one
+func DefaultPort(scheme string) string {
+	if scheme == "https" {
+		return "443"
+	}
+	return "80"
+}
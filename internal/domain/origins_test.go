@@ -0,0 +1,85 @@
+package domain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseOrigins(t *testing.T) {
+	origins, err := ParseOrigins("https://example.com, https://www.example.com\nhttps://*.staging.example.com:8443 example.org HTTPS://EXAMPLE.COM")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"https://example.com", "https://www.example.com", "https://*.staging.example.com:8443", "example.org"}, origins)
+}
+
+func TestParseOriginsRejectsInvalidOrigins(t *testing.T) {
+	for _, list := range []string{
+		"",
+		" , ",
+		"*",
+		"https://example.com/path",
+		"https://example.com; script-src *",
+		"'self'",
+		"https://www.*.example.com",
+	} {
+		_, err := ParseOrigins(list)
+		assert.NotNil(t, err, "%q should be rejected", list)
+	}
+}
+
+func TestFrameAncestorsPolicy(t *testing.T) {
+	assert.Equal(t, "frame-ancestors https://example.com https://*.example.com", FrameAncestorsPolicy([]string{"https://example.com", "https://*.example.com"}))
+	assert.Equal(t, "frame-ancestors 'none'", FrameAncestorsPolicy(nil))
+}
+
+func TestOriginAllowed(t *testing.T) {
+	origins := []string{"https://blog.example.com", "*.example.org", "http://localhost:*", "example.net"}
+	for _, origin := range []string{
+		"https://blog.example.com",
+		"https://blog.example.com:443",
+		"https://www.example.org",
+		"http://a.b.example.org",
+		"http://localhost:8080",
+		"http://example.net",
+		"https://example.net",
+	} {
+		assert.True(t, OriginAllowed(origins, origin), "%q should be allowed", origin)
+	}
+	for _, origin := range []string{
+		"",
+		"null",
+		"http://blog.example.com",
+		"https://blog.example.com:8443",
+		"https://evil.blog.example.com",
+		"https://example.org",
+		"https://localhost:8080",
+		"https://example.net:8443",
+		"https://example.net.evil.com",
+		"https://blog.example.com/path",
+	} {
+		assert.False(t, OriginAllowed(origins, origin), "%q should not be allowed", origin)
+	}
+}
+
+func TestPageUrlAllowed(t *testing.T) {
+	origins := []string{"https://blog.example.com", "*.example.org"}
+	for _, pageUrl := range []string{
+		"https://blog.example.com/posts/1",
+		"https://blog.example.com",
+		"http://www.example.org/a?b=c#d",
+	} {
+		assert.True(t, PageUrlAllowed(origins, pageUrl), "%q should be allowed", pageUrl)
+	}
+	for _, pageUrl := range []string{
+		"",
+		"/posts/1",
+		"javascript:alert(1)",
+		"http://blog.example.com/posts/1",
+		"https://evil.example.com/posts/1",
+		"https://blog.example.com@evil.example.com/",
+		"https://user@blog.example.com/",
+		"//blog.example.com/posts/1",
+	} {
+		assert.False(t, PageUrlAllowed(origins, pageUrl), "%q should not be allowed", pageUrl)
+	}
+}
@@ -1,5 +1,7 @@
 package domain
 
+import "time"
+
 // BasePage contains fields common to all pages
 type BasePage struct {
 	Stylesheets []string
@@ -17,13 +19,37 @@ type PostCommentsPage struct {
 	User       User
 	ServiceKey string
 	PostKey    string
-	Comments   []Comment
+	Threads    []CommentThread
+	// NextCursor loads the following threads, it is empty on the last page
+	NextCursor string
+	// EditableComments are the ids of the comments of the user that the edit policy still allows to edit
+	EditableComments map[int]bool
 }
 
 type UserCommentsPage struct {
 	BasePage
 	User     User
 	Comments []Comment
+	// EditableComments are the ids of the comments that the edit policy still allows to edit
+	EditableComments map[int]bool
+	Subscriptions    []Subscription
+}
+
+type UnsubscribePage struct {
+	BasePage
+	Subscription Subscription
+	Token        string
+}
+
+type UnsubscribedPage struct {
+	BasePage
+	Subscription Subscription
+}
+
+type DeleteAccountPage struct {
+	BasePage
+	User             User
+	NumberOfComments int
 }
 
 type UserAuthenticationPage struct {
@@ -32,10 +58,86 @@ type UserAuthenticationPage struct {
 }
 
 type AdminDashboardPage struct {
+	BasePage
+	AdminUser        AdminUser
+	Comments         []Comment
+	Statuses         []CommentStatus
+	Filter           CommentFilterForm
+	AccountDeletions AccountDeletionStatistics
+	// NextPageUrl links to the next page with the same filters, it is empty on the last page
+	NextPageUrl string
+}
+
+// CommentFilterForm holds the filters of the admin dashboard as they were entered
+type CommentFilterForm struct {
+	CommentId  string
+	ShowStatus string
+	ServiceKey string
+	PostKey    string
+	Author     string
+	From       string
+	To         string
+}
+
+type AdminCommentRevisionsPage struct {
+	BasePage
+	AdminUser AdminUser
+	Comment   Comment
+	// Versions are newest first, starting with the current version
+	Versions []CommentVersion
+}
+
+type AdminModerationLogPage struct {
+	BasePage
+	AdminUser AdminUser
+	Entries   []ModerationLogEntry
+	Filter    ModerationLogFilterForm
+	// NextPageUrl links to the next page with the same filters, it is empty on the last page
+	NextPageUrl string
+}
+
+// ModerationLogFilterForm holds the filters of the moderation log as they were entered
+type ModerationLogFilterForm struct {
+	ServiceKey string
+	CommentId  string
+	ActorKind  string
+	Actor      string
+	From       string
+	To         string
+}
+
+type AdminEmailsPage struct {
+	BasePage
+	AdminUser AdminUser
+	Emails    []OutboxEmail
+	Statuses  []OutboxStatus
+}
+
+type AdminServicesPage struct {
+	BasePage
+	AdminUser AdminUser
+	Services  []ServiceOverview
+}
+
+type AdminNotificationsPage struct {
+	BasePage
+	AdminUser AdminUser
+	// Settings are the zero value with AdminNotificationsOff when the admin has none
+	Settings AdminNotificationSettings
+}
+
+type AdminServicePage struct {
+	BasePage
+	AdminUser AdminUser
+	Service   ServiceOverview
+	// NewServiceKey is a random key proposed when rotating the service key
+	NewServiceKey string
+}
+
+type AdminDeleteServicePage struct {
 	BasePage
 	AdminUser AdminUser
-	Comments  []Comment
-	Statuses  []CommentStatus
+	Service   ServiceOverview
 }
 
 type AddOrEditCommentPage struct {
@@ -46,6 +148,15 @@ type AddOrEditCommentPage struct {
 	User         User
 	CommentFound bool
 	Comment      Comment
+	// ParentFound is set when the form is used to reply to ParentComment
+	ParentFound   bool
+	ParentComment Comment
+	// EditRequiresApproval is set when editing an approved comment sends it back to moderation
+	EditRequiresApproval bool
+	// EditableUntil is the end of the grace window for editing an approved comment, zero when there is none
+	EditableUntil time.Time
+	// FormToken tells the spam filters when the form was shown, it is empty when they do not check this
+	FormToken string
 }
 
 type AdminLoginPage struct {
@@ -0,0 +1,74 @@
+package domain
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CommentCursor points at the last comment of a page, the next page continues right after it. Comments are ordered
+// by their creation time and then by id so that comments created in the same second keep a stable order.
+type CommentCursor struct {
+	CreatedAt time.Time
+	Id        int
+}
+
+func CursorAfter(comment Comment) CommentCursor {
+	return CommentCursor{CreatedAt: comment.CreatedAt, Id: comment.Id}
+}
+
+// IsValid returns false for the zero cursor, which stands for the first page
+func (c CommentCursor) IsValid() bool {
+	return c.Id > 0
+}
+
+// String encodes the cursor for use in URLs, clients should treat it as opaque
+func (c CommentCursor) String() string {
+	if !c.IsValid() {
+		return ""
+	}
+	return fmt.Sprintf("%d-%d", c.CreatedAt.Unix(), c.Id)
+}
+
+// ParseCommentCursor parses a cursor created by CommentCursor.String, the empty string is the cursor of the first page
+func ParseCommentCursor(cursor string) (CommentCursor, error) {
+	if cursor == "" {
+		return CommentCursor{}, nil
+	}
+	createdAtString, idString, found := strings.Cut(cursor, "-")
+	if !found {
+		return CommentCursor{}, fmt.Errorf("invalid cursor: %s", cursor)
+	}
+	createdAt, err := strconv.ParseInt(createdAtString, 10, 64)
+	if err != nil {
+		return CommentCursor{}, fmt.Errorf("invalid cursor: %s", cursor)
+	}
+	id, err := strconv.Atoi(idString)
+	if err != nil || id <= 0 {
+		return CommentCursor{}, fmt.Errorf("invalid cursor: %s", cursor)
+	}
+	return CommentCursor{CreatedAt: time.Unix(createdAt, 0), Id: id}, nil
+}
+
+// CommentPage is one page of comments. NextCursor is invalid on the last page.
+type CommentPage struct {
+	Comments   []Comment
+	NextCursor CommentCursor
+	// HiddenParents maps the ids of comments that are not shown, but have replies that are, to the ids of their
+	// parents. Top level comments have parent 0.
+	HiddenParents map[int]int
+}
+
+// CommentFilter selects the comments shown to admins. ServiceIds limits the result to the services the admin may
+// see and is required, all other fields only restrict the result when they are set.
+type CommentFilter struct {
+	ServiceIds  []int
+	CommentId   int
+	Statuses    []CommentStatus
+	PostKey     string
+	AuthorEmail string
+	// From is inclusive, To is exclusive
+	From time.Time
+	To   time.Time
+}
@@ -0,0 +1,27 @@
+package domain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommentCursorRoundTrip(t *testing.T) {
+	cursor := CursorAfter(Comment{Id: 42, CreatedAt: time.Unix(1_700_000_000, 0)})
+	parsedCursor, err := ParseCommentCursor(cursor.String())
+	assert.Nil(t, err)
+	assert.Equal(t, cursor, parsedCursor)
+
+	firstPage, err := ParseCommentCursor("")
+	assert.Nil(t, err)
+	assert.False(t, firstPage.IsValid())
+	assert.Equal(t, "", firstPage.String())
+}
+
+func TestParseCommentCursorRejectsInvalidCursors(t *testing.T) {
+	for _, cursor := range []string{"42", "abc-42", "1700000000-abc", "1700000000-0", "1700000000--1"} {
+		_, err := ParseCommentCursor(cursor)
+		assert.NotNil(t, err, cursor)
+	}
+}
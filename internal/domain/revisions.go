@@ -0,0 +1,154 @@
+package domain
+
+import (
+	"regexp"
+	"time"
+)
+
+// CommentRevision is an earlier version of an edited comment. Revisions are numbered from 1, the current version of
+// the comment is not a revision.
+type CommentRevision struct {
+	Revision int
+	Comment  string
+	Name     string
+	Website  string
+	// CreatedAt is when this version was written, ReplacedAt when it was replaced by an edit
+	CreatedAt  time.Time
+	ReplacedAt time.Time
+}
+
+type DiffOperation int
+
+const (
+	DiffEqual DiffOperation = iota
+	DiffInsert
+	DiffDelete
+)
+
+// DiffSegment is a run of text that is unchanged, inserted or deleted between two versions of a text
+type DiffSegment struct {
+	Operation DiffOperation
+	Text      string
+}
+
+func (s DiffSegment) Inserted() bool {
+	return s.Operation == DiffInsert
+}
+
+func (s DiffSegment) Deleted() bool {
+	return s.Operation == DiffDelete
+}
+
+// CommentVersion is one version of a comment together with what changed compared to the version before it
+type CommentVersion struct {
+	// Revision is 0 for the current version
+	Revision  int
+	Name      string
+	Website   string
+	CreatedAt time.Time
+	// Diff is the change of the comment text, the first version consists of a single unchanged segment
+	Diff            []DiffSegment
+	NameChanged     bool
+	WebsiteChanged  bool
+	PreviousName    string
+	PreviousWebsite string
+}
+
+func (v CommentVersion) Current() bool {
+	return v.Revision == 0
+}
+
+// CommentVersions lists all versions of the comment, newest first, each compared to the version before it. The
+// revisions have to be ordered by revision.
+func CommentVersions(comment Comment, revisions []CommentRevision) []CommentVersion {
+	versions := make([]CommentVersion, 0, len(revisions)+1)
+	current := CommentRevision{Comment: comment.Comment, Name: comment.Name, Website: comment.Website, CreatedAt: comment.CreatedAt}
+	if len(revisions) > 0 {
+		current.CreatedAt = revisions[len(revisions)-1].ReplacedAt
+	}
+	all := append(append([]CommentRevision{}, revisions...), current)
+	for i := len(all) - 1; i >= 0; i-- {
+		version := CommentVersion{
+			Revision:  all[i].Revision,
+			Name:      all[i].Name,
+			Website:   all[i].Website,
+			CreatedAt: all[i].CreatedAt,
+		}
+		if i == 0 {
+			version.Diff = []DiffSegment{{Operation: DiffEqual, Text: all[i].Comment}}
+		} else {
+			previous := all[i-1]
+			version.Diff = DiffWords(previous.Comment, all[i].Comment)
+			version.NameChanged = previous.Name != all[i].Name
+			version.WebsiteChanged = previous.Website != all[i].Website
+			version.PreviousName = previous.Name
+			version.PreviousWebsite = previous.Website
+		}
+		versions = append(versions, version)
+	}
+	return versions
+}
+
+var diffTokens = regexp.MustCompile(`\s+|\S+`)
+
+// maxDiffCells limits the size of the table of the word diff, larger texts are shown as completely replaced
+const maxDiffCells = 4_000_000
+
+// DiffWords compares two texts word by word, whitespace is kept so that the segments add up to the original texts
+func DiffWords(oldText string, newText string) []DiffSegment {
+	oldTokens := diffTokens.FindAllString(oldText, -1)
+	newTokens := diffTokens.FindAllString(newText, -1)
+	segments := make([]DiffSegment, 0)
+	appendSegment := func(operation DiffOperation, text string) {
+		if len(segments) > 0 && segments[len(segments)-1].Operation == operation {
+			segments[len(segments)-1].Text += text
+		} else {
+			segments = append(segments, DiffSegment{Operation: operation, Text: text})
+		}
+	}
+	if len(oldTokens)*len(newTokens) > maxDiffCells {
+		if oldText != "" {
+			appendSegment(DiffDelete, oldText)
+		}
+		if newText != "" {
+			appendSegment(DiffInsert, newText)
+		}
+		return segments
+	}
+	// lengths[i][j] is the length of the longest common subsequence of oldTokens[i:] and newTokens[j:]
+	lengths := make([][]int32, len(oldTokens)+1)
+	for i := range lengths {
+		lengths[i] = make([]int32, len(newTokens)+1)
+	}
+	for i := len(oldTokens) - 1; i >= 0; i-- {
+		for j := len(newTokens) - 1; j >= 0; j-- {
+			if oldTokens[i] == newTokens[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else {
+				lengths[i][j] = max(lengths[i+1][j], lengths[i][j+1])
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(oldTokens) && j < len(newTokens) {
+		switch {
+		case oldTokens[i] == newTokens[j]:
+			appendSegment(DiffEqual, oldTokens[i])
+			i++
+			j++
+		case lengths[i+1][j] >= lengths[i][j+1]:
+			appendSegment(DiffDelete, oldTokens[i])
+			i++
+		default:
+			appendSegment(DiffInsert, newTokens[j])
+			j++
+		}
+	}
+	for ; i < len(oldTokens); i++ {
+		appendSegment(DiffDelete, oldTokens[i])
+	}
+	for ; j < len(newTokens); j++ {
+		appendSegment(DiffInsert, newTokens[j])
+	}
+	return segments
+}
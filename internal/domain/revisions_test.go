@@ -0,0 +1,50 @@
+package domain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffWords(t *testing.T) {
+	assert.Equal(t, []DiffSegment{
+		{Operation: DiffEqual, Text: "The "},
+		{Operation: DiffDelete, Text: "quick"},
+		{Operation: DiffInsert, Text: "slow"},
+		{Operation: DiffEqual, Text: " fox"},
+		{Operation: DiffInsert, Text: " jumps"},
+	}, DiffWords("The quick fox", "The slow fox jumps"))
+	assert.Equal(t, []DiffSegment{{Operation: DiffEqual, Text: "unchanged"}}, DiffWords("unchanged", "unchanged"))
+	assert.Equal(t, []DiffSegment{{Operation: DiffInsert, Text: "new"}}, DiffWords("", "new"))
+	assert.Equal(t, []DiffSegment{{Operation: DiffDelete, Text: "old"}}, DiffWords("old", ""))
+}
+
+func TestCommentVersionsAreNewestFirst(t *testing.T) {
+	created := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	firstEdit := created.Add(time.Hour)
+	secondEdit := created.Add(2 * time.Hour)
+	comment := Comment{Comment: "third text", Name: "Bob", CreatedAt: created}
+	revisions := []CommentRevision{
+		{Revision: 1, Comment: "first text", Name: "Alice", CreatedAt: created, ReplacedAt: firstEdit},
+		{Revision: 2, Comment: "second text", Name: "Alice", CreatedAt: firstEdit, ReplacedAt: secondEdit},
+	}
+	versions := CommentVersions(comment, revisions)
+	assert.Equal(t, 3, len(versions))
+
+	assert.True(t, versions[0].Current())
+	assert.Equal(t, secondEdit, versions[0].CreatedAt)
+	assert.True(t, versions[0].NameChanged)
+	assert.Equal(t, "Alice", versions[0].PreviousName)
+	assert.Equal(t, []DiffSegment{
+		{Operation: DiffDelete, Text: "second"},
+		{Operation: DiffInsert, Text: "third"},
+		{Operation: DiffEqual, Text: " text"},
+	}, versions[0].Diff)
+
+	assert.Equal(t, 2, versions[1].Revision)
+	assert.False(t, versions[1].NameChanged)
+
+	assert.Equal(t, 1, versions[2].Revision)
+	assert.Equal(t, []DiffSegment{{Operation: DiffEqual, Text: "first text"}}, versions[2].Diff)
+}
@@ -0,0 +1,20 @@
+package domain
+
+// SpamTokenCounts tells in how many comments that admins approved (ham) or rejected (spam) a token of the Bayesian
+// spam classifier occurred. For the totals of the classifier it is the number of comments it learned from.
+type SpamTokenCounts struct {
+	Spam int
+	Ham  int
+}
+
+// likelySpamScore is the spam score from which comments are highlighted for admins
+const likelySpamScore = 0.5
+
+// SpamScorePercent is the spam score of the comment in percent, rounded down
+func (c Comment) SpamScorePercent() int {
+	return int(c.SpamScore * 100)
+}
+
+func (c Comment) LikelySpam() bool {
+	return c.SpamScore >= likelySpamScore
+}
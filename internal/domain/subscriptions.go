@@ -0,0 +1,43 @@
+package domain
+
+import (
+	"fmt"
+	"time"
+)
+
+// SubscriptionKind is what a commenter wants to be told about by email
+type SubscriptionKind int
+
+const (
+	// SubscriptionModeration tells the author of a comment when it is approved or rejected
+	SubscriptionModeration SubscriptionKind = 1
+	// SubscriptionPost tells the subscriber about every newly approved comment on a post
+	SubscriptionPost SubscriptionKind = 2
+)
+
+func (k SubscriptionKind) String() string {
+	switch k {
+	case SubscriptionModeration:
+		return "moderation"
+	case SubscriptionPost:
+		return "post"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(k))
+	}
+}
+
+// Subscription is the opt-in of a user to emails about one of their comments or about a post. Subscriptions made
+// together with a comment that is not confirmed yet are inactive until the comment is confirmed, so that nobody can
+// subscribe an email address that is not theirs.
+type Subscription struct {
+	Id         int
+	UserId     int
+	Kind       SubscriptionKind
+	ServiceId  int
+	ServiceKey string
+	PostKey    string
+	// CommentId is the comment of moderation subscriptions, it is 0 for post subscriptions
+	CommentId int
+	Active    bool
+	CreatedAt time.Time
+}
@@ -25,35 +25,27 @@ func NewSendgridEmailSender(fromName, fromAddress, subject, apiKey, baseURL stri
 	}
 }
 
-func (sender *SendgridEmailSender) SendgridEmailSenderStrategy(email AuthenticationCodeEmail) {
+func (sender *SendgridEmailSender) SendgridEmailSenderStrategy(email Email) error {
 	from := mail.NewEmail(sender.fromName, sender.fromAddress)
-	to := mail.NewEmail("", email.EmailAddress) // We don't know the user's name
-	subject := sender.subject
-
-	authLink := fmt.Sprintf("%s/userauthentication/%s", sender.baseURL, email.Code)
-	plainTextContent := fmt.Sprintf("Your authentication code is: %s\n\nClick this link to authenticate: %s\n\nIf you prefer to enter the code manually, you can do so at %s/userauthentication/\n\nThis code will expire in 15 minutes.", email.Code, authLink, sender.baseURL)
-	htmlContent := fmt.Sprintf(`
-		<p>Your authentication code is: <strong>%s</strong></p>
-		<p><a href="%s">Click here to authenticate</a></p>
-		<p>If you prefer to enter the code manually, you can do so at <a href="%s/userauthentication/">%s/userauthentication/</a></p>
-		<p>This code will expire in 15 minutes.</p>
-	`, email.Code, authLink, sender.baseURL, sender.baseURL)
+	to := mail.NewEmail("", email.Recipient()) // We don't know the user's name
+
+	subject, plainTextContent, htmlContent := email.content(sender.baseURL, sender.subject)
 
 	message := mail.NewSingleEmail(from, subject, to, plainTextContent, htmlContent)
+	for _, header := range additionalHeaders(email, sender.baseURL) {
+		message.SetHeader(header.name, header.value)
+	}
 	client := sendgrid.NewSendClient(sender.apiKey)
 
 	response, err := client.Send(message)
 	if err != nil {
-		logger.Error("Failed to send authentication email", "error", err)
-		return
+		return err
 	}
 
 	if response.StatusCode >= 200 && response.StatusCode < 300 {
-		logger.Debug("Successfully sent authentication email", "to", email.EmailAddress)
+		logger.Debug("Successfully sent email", "kind", email.kind(), "to", email.Recipient())
+		return nil
 	} else {
-		logger.Error("Failed to send authentication email",
-			"status_code", response.StatusCode,
-			"body", response.Body,
-			"headers", response.Headers)
+		return fmt.Errorf("sendgrid responded with status %d: %s", response.StatusCode, response.Body)
 	}
 }
@@ -0,0 +1,272 @@
+package email
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SmtpTlsModeStartTls = "starttls" // connect in plain text and upgrade the connection with STARTTLS
+	SmtpTlsModeImplicit = "tls"      // connect with TLS from the start (usually port 465)
+	SmtpTlsModeNone     = "none"     // no encryption at all, only use this for relays on the local network
+
+	SmtpAuthPlain = "plain"
+	SmtpAuthLogin = "login"
+)
+
+var smtpDialTimeout = 10 * time.Second
+
+type SmtpConfiguration struct {
+	Host          string
+	Port          int
+	Username      string // when empty no authentication is attempted
+	Password      string
+	TlsMode       string
+	AuthMechanism string
+}
+
+type SmtpEmailSender struct {
+	fromName    string
+	fromAddress string
+	subject     string
+	baseURL     string
+	config      SmtpConfiguration
+	// tlsConfig can be overridden to trust other certificates, when nil the system roots are used
+	tlsConfig *tls.Config
+}
+
+func NewSmtpEmailSender(fromName, fromAddress, subject, baseURL string, config SmtpConfiguration) (*SmtpEmailSender, error) {
+	switch config.TlsMode {
+	case SmtpTlsModeStartTls, SmtpTlsModeImplicit, SmtpTlsModeNone:
+	default:
+		return nil, fmt.Errorf("invalid SMTP TLS mode: %s", config.TlsMode)
+	}
+	switch config.AuthMechanism {
+	case SmtpAuthPlain, SmtpAuthLogin:
+	default:
+		return nil, fmt.Errorf("invalid SMTP authentication mechanism: %s", config.AuthMechanism)
+	}
+	if config.Host == "" || config.Port <= 0 {
+		return nil, errors.New("SMTP host and port are required")
+	}
+	return &SmtpEmailSender{
+		fromName:    fromName,
+		fromAddress: fromAddress,
+		subject:     subject,
+		baseURL:     baseURL,
+		config:      config,
+	}, nil
+}
+
+func (sender *SmtpEmailSender) SmtpEmailSenderStrategy(email Email) error {
+	err := sender.Send(email)
+	if err != nil {
+		return err
+	}
+	logger.Debug("Successfully sent email", "kind", email.kind(), "to", email.Recipient())
+	return nil
+}
+
+// Send delivers the email over a new SMTP connection
+func (sender *SmtpEmailSender) Send(email Email) error {
+	message, err := sender.createMessage(email)
+	if err != nil {
+		return err
+	}
+	client, err := sender.connect()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if sender.config.Username != "" {
+		err = client.Auth(sender.auth())
+		if err != nil {
+			return fmt.Errorf("SMTP authentication failed: %w", err)
+		}
+	}
+	err = client.Mail(sender.fromAddress)
+	if err != nil {
+		return err
+	}
+	err = client.Rcpt(email.Recipient())
+	if err != nil {
+		return err
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(message)
+	if err != nil {
+		return err
+	}
+	err = writer.Close()
+	if err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func (sender *SmtpEmailSender) getTlsConfig() *tls.Config {
+	if sender.tlsConfig != nil {
+		return sender.tlsConfig
+	}
+	return &tls.Config{ServerName: sender.config.Host, MinVersion: tls.VersionTLS12}
+}
+
+func (sender *SmtpEmailSender) connect() (*smtp.Client, error) {
+	address := net.JoinHostPort(sender.config.Host, strconv.Itoa(sender.config.Port))
+	dialer := &net.Dialer{Timeout: smtpDialTimeout}
+	var conn net.Conn
+	var err error
+	if sender.config.TlsMode == SmtpTlsModeImplicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, sender.getTlsConfig())
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	client, err := smtp.NewClient(conn, sender.config.Host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if sender.config.TlsMode == SmtpTlsModeStartTls {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			client.Close()
+			return nil, errors.New("SMTP server does not support STARTTLS")
+		}
+		err = client.StartTLS(sender.getTlsConfig())
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+func (sender *SmtpEmailSender) auth() smtp.Auth {
+	if sender.config.AuthMechanism == SmtpAuthLogin {
+		return &loginAuth{username: sender.config.Username, password: sender.config.Password, host: sender.config.Host}
+	}
+	return smtp.PlainAuth("", sender.config.Username, sender.config.Password, sender.config.Host)
+}
+
+// loginAuth implements the AUTH LOGIN mechanism that net/smtp does not provide. Like smtp.PlainAuth it
+// refuses to send credentials over an unencrypted connection to anything but localhost.
+type loginAuth struct {
+	username string
+	password string
+	host     string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	default:
+		return nil, fmt.Errorf("unexpected LOGIN challenge: %s", fromServer)
+	}
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
+
+func (sender *SmtpEmailSender) createMessage(email Email) ([]byte, error) {
+	subject, plainTextContent, htmlContent := email.content(sender.baseURL, sender.subject)
+	boundary, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	messageIdPart, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	from := mail.Address{Name: sender.fromName, Address: sender.fromAddress}
+	to := mail.Address{Address: email.Recipient()}
+	var message bytes.Buffer
+	writeHeader(&message, "From", from.String())
+	writeHeader(&message, "To", to.String())
+	writeHeader(&message, "Subject", mime.QEncoding.Encode("utf-8", subject))
+	writeHeader(&message, "Date", time.Now().Format(time.RFC1123Z))
+	writeHeader(&message, "Message-ID", "<"+messageIdPart+"@"+domainOf(sender.fromAddress)+">")
+	for _, header := range additionalHeaders(email, sender.baseURL) {
+		writeHeader(&message, header.name, header.value)
+	}
+	writeHeader(&message, "MIME-Version", "1.0")
+	writeHeader(&message, "Content-Type", "multipart/alternative; boundary=\""+boundary+"\"")
+	message.WriteString("\r\n")
+	for _, part := range []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=utf-8", plainTextContent},
+		{"text/html; charset=utf-8", htmlContent},
+	} {
+		message.WriteString("--" + boundary + "\r\n")
+		writeHeader(&message, "Content-Type", part.contentType)
+		writeHeader(&message, "Content-Transfer-Encoding", "quoted-printable")
+		message.WriteString("\r\n")
+		qpWriter := quotedprintable.NewWriter(&message)
+		_, err = qpWriter.Write([]byte(strings.ReplaceAll(part.content, "\n", "\r\n")))
+		if err != nil {
+			return nil, err
+		}
+		err = qpWriter.Close()
+		if err != nil {
+			return nil, err
+		}
+		message.WriteString("\r\n")
+	}
+	message.WriteString("--" + boundary + "--\r\n")
+	return message.Bytes(), nil
+}
+
+func writeHeader(buffer *bytes.Buffer, name string, value string) {
+	buffer.WriteString(name + ": " + value + "\r\n")
+}
+
+func domainOf(address string) string {
+	if index := strings.LastIndex(address, "@"); index >= 0 {
+		return address[index+1:]
+	}
+	return "localhost"
+}
+
+func randomHex(byteCount int) (string, error) {
+	b := make([]byte, byteCount)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,295 @@
+package email
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var TEST_SMTP_USERNAME = "smtpuser"
+var TEST_SMTP_PASSWORD = "smtppassword"
+
+// testSmtpServer is a minimal in-process SMTP server that understands just enough of the protocol
+// (EHLO, STARTTLS, AUTH PLAIN/LOGIN, MAIL, RCPT, DATA, QUIT) to receive mails from SmtpEmailSender
+type testSmtpServer struct {
+	listener     net.Listener
+	tlsConfig    *tls.Config
+	implicitTls  bool
+	mutex        sync.Mutex
+	messages     []string
+	recipients   []string
+	authUsername string
+	usedTls      bool
+}
+
+func startTestSmtpServer(t *testing.T, implicitTls bool) (*testSmtpServer, *tls.Config) {
+	serverTlsConfig, clientTlsConfig := createTestTlsConfigs(t)
+	var listener net.Listener
+	var err error
+	if implicitTls {
+		listener, err = tls.Listen("tcp", "127.0.0.1:0", serverTlsConfig)
+	} else {
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &testSmtpServer{listener: listener, tlsConfig: serverTlsConfig, implicitTls: implicitTls}
+	go server.serve()
+	t.Cleanup(func() { listener.Close() })
+	return server, clientTlsConfig
+}
+
+func (server *testSmtpServer) port() int {
+	return server.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (server *testSmtpServer) serve() {
+	for {
+		conn, err := server.listener.Accept()
+		if err != nil {
+			return
+		}
+		go server.handle(conn)
+	}
+}
+
+func (server *testSmtpServer) handle(conn net.Conn) {
+	defer conn.Close()
+	usingTls := server.implicitTls
+	reader := bufio.NewReader(conn)
+	reply := func(line string) {
+		_, _ = conn.Write([]byte(line + "\r\n"))
+	}
+	readLine := func() (string, bool) {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return "", false
+		}
+		return strings.TrimRight(line, "\r\n"), true
+	}
+	reply("220 localhost test SMTP server")
+	for {
+		line, ok := readLine()
+		if !ok {
+			return
+		}
+		command := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(command, "EHLO"):
+			if usingTls {
+				reply("250-localhost")
+			} else {
+				reply("250-localhost")
+				reply("250-STARTTLS")
+			}
+			reply("250 AUTH PLAIN LOGIN")
+		case command == "STARTTLS":
+			reply("220 Ready to start TLS")
+			tlsConn := tls.Server(conn, server.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+			reader = bufio.NewReader(conn)
+			usingTls = true
+		case strings.HasPrefix(command, "AUTH PLAIN"):
+			decoded, _ := base64.StdEncoding.DecodeString(strings.TrimSpace(line[len("AUTH PLAIN"):]))
+			parts := strings.Split(string(decoded), "\x00")
+			if len(parts) == 3 && parts[1] == TEST_SMTP_USERNAME && parts[2] == TEST_SMTP_PASSWORD {
+				server.setAuth(parts[1])
+				reply("235 Authentication successful")
+			} else {
+				reply("535 Authentication failed")
+			}
+		case command == "AUTH LOGIN":
+			reply("334 " + base64.StdEncoding.EncodeToString([]byte("Username:")))
+			usernameLine, _ := readLine()
+			reply("334 " + base64.StdEncoding.EncodeToString([]byte("Password:")))
+			passwordLine, _ := readLine()
+			username, _ := base64.StdEncoding.DecodeString(usernameLine)
+			password, _ := base64.StdEncoding.DecodeString(passwordLine)
+			if string(username) == TEST_SMTP_USERNAME && string(password) == TEST_SMTP_PASSWORD {
+				server.setAuth(string(username))
+				reply("235 Authentication successful")
+			} else {
+				reply("535 Authentication failed")
+			}
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			reply("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			server.mutex.Lock()
+			server.recipients = append(server.recipients, strings.Trim(line[len("RCPT TO:"):], "<> "))
+			server.mutex.Unlock()
+			reply("250 OK")
+		case command == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				dataLine, ok := readLine()
+				if !ok || dataLine == "." {
+					break
+				}
+				data.WriteString(dataLine + "\n")
+			}
+			server.mutex.Lock()
+			server.messages = append(server.messages, data.String())
+			server.usedTls = usingTls
+			server.mutex.Unlock()
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+func (server *testSmtpServer) setAuth(username string) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.authUsername = username
+}
+
+func createTestTlsConfigs(t *testing.T) (*tls.Config, *tls.Config) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	certificateBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &privateKey.PublicKey, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(certificateBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(certificate)
+	serverTlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{certificateBytes}, PrivateKey: privateKey}},
+		MinVersion:   tls.VersionTLS12,
+	}
+	clientTlsConfig := &tls.Config{RootCAs: roots, ServerName: "localhost", MinVersion: tls.VersionTLS12}
+	return serverTlsConfig, clientTlsConfig
+}
+
+func createTestSmtpSender(t *testing.T, server *testSmtpServer, clientTlsConfig *tls.Config, tlsMode string, authMechanism string) *SmtpEmailSender {
+	sender, err := NewSmtpEmailSender("Go Comments", "comments@example.com", "Your Authentication Code", "https://comments.example.com", SmtpConfiguration{
+		Host:          "localhost",
+		Port:          server.port(),
+		Username:      TEST_SMTP_USERNAME,
+		Password:      TEST_SMTP_PASSWORD,
+		TlsMode:       tlsMode,
+		AuthMechanism: authMechanism,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender.tlsConfig = clientTlsConfig
+	return sender
+}
+
+func assertAuthenticationEmailReceived(t *testing.T, server *testSmtpServer, expectTls bool) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	assert.Equal(t, 1, len(server.messages))
+	assert.Equal(t, []string{"user@example.com"}, server.recipients)
+	assert.Equal(t, TEST_SMTP_USERNAME, server.authUsername)
+	assert.Equal(t, expectTls, server.usedTls)
+	message := server.messages[0]
+	assert.Contains(t, message, "From: \"Go Comments\" <comments@example.com>")
+	assert.Contains(t, message, "To: <user@example.com>")
+	assert.Contains(t, message, "Content-Type: multipart/alternative")
+	assert.Contains(t, message, "https://comments.example.com/userauthentication/TESTCODE")
+}
+
+var testAuthenticationEmail = AuthenticationCodeEmail{EmailAddress: "user@example.com", Code: "TESTCODE"}
+
+func TestSmtpStartTlsWithPlainAuth(t *testing.T) {
+	server, clientTlsConfig := startTestSmtpServer(t, false)
+	sender := createTestSmtpSender(t, server, clientTlsConfig, SmtpTlsModeStartTls, SmtpAuthPlain)
+	err := sender.Send(testAuthenticationEmail)
+	assert.Nil(t, err)
+	assertAuthenticationEmailReceived(t, server, true)
+}
+
+func TestSmtpStartTlsWithLoginAuth(t *testing.T) {
+	server, clientTlsConfig := startTestSmtpServer(t, false)
+	sender := createTestSmtpSender(t, server, clientTlsConfig, SmtpTlsModeStartTls, SmtpAuthLogin)
+	err := sender.Send(testAuthenticationEmail)
+	assert.Nil(t, err)
+	assertAuthenticationEmailReceived(t, server, true)
+}
+
+func TestSmtpImplicitTlsWithPlainAuth(t *testing.T) {
+	server, clientTlsConfig := startTestSmtpServer(t, true)
+	sender := createTestSmtpSender(t, server, clientTlsConfig, SmtpTlsModeImplicit, SmtpAuthPlain)
+	err := sender.Send(testAuthenticationEmail)
+	assert.Nil(t, err)
+	assertAuthenticationEmailReceived(t, server, true)
+}
+
+func TestSmtpWithoutTlsToLocalhost(t *testing.T) {
+	server, clientTlsConfig := startTestSmtpServer(t, false)
+	sender := createTestSmtpSender(t, server, clientTlsConfig, SmtpTlsModeNone, SmtpAuthLogin)
+	err := sender.Send(testAuthenticationEmail)
+	assert.Nil(t, err)
+	assertAuthenticationEmailReceived(t, server, false)
+}
+
+func TestSmtpSubscriptionEmailHasListUnsubscribeHeaders(t *testing.T) {
+	server, clientTlsConfig := startTestSmtpServer(t, false)
+	sender := createTestSmtpSender(t, server, clientTlsConfig, SmtpTlsModeStartTls, SmtpAuthPlain)
+	err := sender.Send(NewCommentEmail{EmailAddress: "user@example.com", PostKey: "post", Comments: []NewComment{{Comment: "Hello"}}, Unsubscribe: testUnsubscribe})
+	assert.Nil(t, err)
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	assert.Equal(t, 1, len(server.messages))
+	assert.Contains(t, server.messages[0], "List-Unsubscribe: <https://comments.example.com/subscriptions/5/unsubscribe?token=abc123>\n")
+	assert.Contains(t, server.messages[0], "List-Unsubscribe-Post: List-Unsubscribe=One-Click\n")
+}
+
+func TestSmtpWithWrongPassword(t *testing.T) {
+	server, clientTlsConfig := startTestSmtpServer(t, false)
+	sender := createTestSmtpSender(t, server, clientTlsConfig, SmtpTlsModeStartTls, SmtpAuthPlain)
+	sender.config.Password = "wrong"
+	err := sender.Send(testAuthenticationEmail)
+	assert.NotNil(t, err)
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	assert.Equal(t, 0, len(server.messages))
+}
+
+func TestSmtpInvalidConfiguration(t *testing.T) {
+	_, err := NewSmtpEmailSender("", "comments@example.com", "", "", SmtpConfiguration{Host: "localhost", Port: 25, TlsMode: "ssl", AuthMechanism: SmtpAuthPlain})
+	assert.NotNil(t, err)
+	_, err = NewSmtpEmailSender("", "comments@example.com", "", "", SmtpConfiguration{Host: "localhost", Port: 25, TlsMode: SmtpTlsModeNone, AuthMechanism: "cram-md5"})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,97 @@
+package email
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+// PendingComment describes a comment waiting for approval in the notifications to admins
+type PendingComment struct {
+	CommentId  int
+	ServiceKey string
+	PostKey    string
+	ParentUrl  string
+	Name       string
+	Comment    string
+}
+
+func (comment PendingComment) dashboardLink(baseURL string) string {
+	return fmt.Sprintf("%s/admin/comments?commentId=%d", baseURL, comment.CommentId)
+}
+
+func (comment PendingComment) author() string {
+	if comment.Name == "" {
+		return "Anonymous"
+	}
+	return comment.Name
+}
+
+func (comment PendingComment) location() string {
+	if comment.ParentUrl != "" {
+		return comment.ParentUrl
+	}
+	return comment.ServiceKey + " / " + comment.PostKey
+}
+
+func (comment PendingComment) plainText(baseURL string) string {
+	return fmt.Sprintf("%s wrote on %s:\n\n%s\n\nModerate the comment: %s", comment.author(), comment.location(), comment.Comment, comment.dashboardLink(baseURL))
+}
+
+func (comment PendingComment) html(baseURL string) string {
+	return fmt.Sprintf(`
+		<p>%s wrote on %s:</p>
+		<blockquote>%s</blockquote>
+		<p><a href="%s">Moderate the comment</a></p>
+	`, html.EscapeString(comment.author()), html.EscapeString(comment.location()), html.EscapeString(comment.Comment), html.EscapeString(comment.dashboardLink(baseURL)))
+}
+
+// PendingCommentEmail tells an admin about a single comment as soon as it waits for approval
+type PendingCommentEmail struct {
+	EmailAddress string
+	Comment      PendingComment
+}
+
+func (email PendingCommentEmail) Recipient() string {
+	return email.EmailAddress
+}
+
+func (email PendingCommentEmail) kind() string {
+	return kindPendingComment
+}
+
+func (email PendingCommentEmail) content(baseURL string, configuredSubject string) (string, string, string) {
+	subject := fmt.Sprintf("New comment on %s waiting for approval", email.Comment.ServiceKey)
+	return subject, email.Comment.plainText(baseURL), email.Comment.html(baseURL)
+}
+
+// PendingCommentsDigestEmail tells an admin about all comments that started waiting for approval since their last
+// digest
+type PendingCommentsDigestEmail struct {
+	EmailAddress string
+	Comments     []PendingComment
+}
+
+func (email PendingCommentsDigestEmail) Recipient() string {
+	return email.EmailAddress
+}
+
+func (email PendingCommentsDigestEmail) kind() string {
+	return kindPendingCommentsDigest
+}
+
+func (email PendingCommentsDigestEmail) content(baseURL string, configuredSubject string) (string, string, string) {
+	subject := fmt.Sprintf("%d new comments waiting for approval", len(email.Comments))
+	if len(email.Comments) == 1 {
+		subject = "1 new comment waiting for approval"
+	}
+	allPendingLink := baseURL + "/admin/comments?showStatus=pending-approval"
+	var plainText, htmlContent strings.Builder
+	for _, comment := range email.Comments {
+		plainText.WriteString(comment.plainText(baseURL) + "\n\n---\n\n")
+		htmlContent.WriteString(comment.html(baseURL) + "<hr>")
+	}
+	plainText.WriteString("All comments waiting for approval: " + allPendingLink)
+	htmlContent.WriteString(fmt.Sprintf(`<p><a href="%s">See all comments waiting for approval</a></p>`, html.EscapeString(allPendingLink)))
+	return subject, plainText.String(), htmlContent.String()
+}
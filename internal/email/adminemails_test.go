@@ -0,0 +1,46 @@
+package email
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testPendingComment = PendingComment{CommentId: 7, ServiceKey: "blog", PostKey: "post", ParentUrl: "https://blog.example.com/post", Name: "Jane", Comment: "<b>First!</b>"}
+
+func TestPendingCommentEmailsAreDelivered(t *testing.T) {
+	store := createTestStore(t)
+	mock := NewMockEmailSender()
+	clock := &testClock{now: time.Unix(1_700_000_000, 0)}
+	sender := createTestEmailSender(store, mock, clock)
+	pendingEmail := PendingCommentEmail{EmailAddress: "admin@example.com", Comment: testPendingComment}
+	digestEmail := PendingCommentsDigestEmail{EmailAddress: "admin@example.com", Comments: []PendingComment{testPendingComment, {CommentId: 8, ServiceKey: "blog", PostKey: "other", Comment: "Second"}}}
+	assert.Nil(t, sender.SendEmail(pendingEmail, ""))
+	assert.Nil(t, sender.SendEmail(digestEmail, ""))
+	sender.ProcessOutbox()
+	assert.Equal(t, []Email{pendingEmail, digestEmail}, mock.SentEmails)
+}
+
+func TestPendingCommentEmailContent(t *testing.T) {
+	subject, plainText, html := PendingCommentEmail{EmailAddress: "admin@example.com", Comment: testPendingComment}.content("https://comments.example.com", "Your Authentication Code")
+	assert.Equal(t, "New comment on blog waiting for approval", subject)
+	assert.Contains(t, plainText, "Jane wrote on https://blog.example.com/post")
+	assert.Contains(t, plainText, "https://comments.example.com/admin/comments?commentId=7")
+	assert.Contains(t, html, `href="https://comments.example.com/admin/comments?commentId=7"`)
+	assert.Contains(t, html, "&lt;b&gt;First!&lt;/b&gt;", "The comment must be escaped in HTML emails")
+}
+
+func TestPendingCommentsDigestEmailContent(t *testing.T) {
+	subject, plainText, html := PendingCommentsDigestEmail{
+		EmailAddress: "admin@example.com",
+		Comments:     []PendingComment{testPendingComment, {CommentId: 8, ServiceKey: "blog", PostKey: "other", Comment: "Second"}},
+	}.content("https://comments.example.com", "")
+	assert.Equal(t, "2 new comments waiting for approval", subject)
+	assert.Contains(t, plainText, "admin/comments?commentId=7")
+	assert.Contains(t, plainText, "Anonymous wrote on blog / other")
+	assert.Contains(t, html, `href="https://comments.example.com/admin/comments?commentId=8"`)
+	assert.Contains(t, html, `href="https://comments.example.com/admin/comments?showStatus=pending-approval"`)
+	subject, _, _ = PendingCommentsDigestEmail{EmailAddress: "admin@example.com", Comments: []PendingComment{testPendingComment}}.content("https://comments.example.com", "")
+	assert.Equal(t, "1 new comment waiting for approval", subject)
+}
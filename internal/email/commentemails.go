@@ -0,0 +1,40 @@
+package email
+
+import (
+	"fmt"
+	"html"
+)
+
+// CommentRejectedEmail tells the author of a comment that it was rejected and, when the admin gave one, why
+type CommentRejectedEmail struct {
+	EmailAddress string
+	UserId       int
+	ParentUrl    string
+	Comment      string
+	Reason       string
+}
+
+func (email CommentRejectedEmail) Recipient() string {
+	return email.EmailAddress
+}
+
+func (email CommentRejectedEmail) kind() string {
+	return kindCommentRejected
+}
+
+func (email CommentRejectedEmail) content(baseURL string, configuredSubject string) (string, string, string) {
+	commentsLink := fmt.Sprintf("%s/users/%d/comments/", baseURL, email.UserId)
+	reason := email.Reason
+	if reason == "" {
+		reason = "No reason was given."
+	}
+	plainTextContent := fmt.Sprintf("Your comment on %s was not approved.\n\nReason: %s\n\nYour comment:\n\n%s\n\nYou can see all your comments at %s", email.ParentUrl, reason, email.Comment, commentsLink)
+	htmlContent := fmt.Sprintf(`
+		<p>Your comment on <a href="%s">%s</a> was not approved.</p>
+		<p>Reason: %s</p>
+		<p>Your comment:</p>
+		<blockquote>%s</blockquote>
+		<p><a href="%s">See all your comments</a></p>
+	`, html.EscapeString(email.ParentUrl), html.EscapeString(email.ParentUrl), html.EscapeString(reason), html.EscapeString(email.Comment), html.EscapeString(commentsLink))
+	return "Your comment was not approved", plainTextContent, htmlContent
+}
@@ -0,0 +1,29 @@
+package email
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommentRejectedEmailIsDelivered(t *testing.T) {
+	store := createTestStore(t)
+	mock := NewMockEmailSender()
+	clock := &testClock{now: time.Unix(1_700_000_000, 0)}
+	sender := createTestEmailSender(store, mock, clock)
+	rejectedEmail := CommentRejectedEmail{EmailAddress: "user@example.com", UserId: 42, ParentUrl: "https://example.com/post", Comment: "<b>Buy now</b>", Reason: "Spam"}
+	assert.Nil(t, sender.SendEmail(rejectedEmail, ""))
+	sender.ProcessOutbox()
+	assert.Equal(t, []Email{rejectedEmail}, mock.SentEmails)
+}
+
+func TestCommentRejectedEmailContent(t *testing.T) {
+	subject, plainText, html := CommentRejectedEmail{EmailAddress: "user@example.com", UserId: 42, ParentUrl: "https://example.com/post", Comment: "<b>Buy now</b>", Reason: "Spam"}.content("https://comments.example.com", "Your Authentication Code")
+	assert.Equal(t, "Your comment was not approved", subject)
+	assert.Contains(t, plainText, "Reason: Spam")
+	assert.Contains(t, plainText, "https://comments.example.com/users/42/comments/")
+	assert.Contains(t, html, "&lt;b&gt;Buy now&lt;/b&gt;", "The comment must be escaped in HTML emails")
+	_, plainText, _ = CommentRejectedEmail{EmailAddress: "user@example.com", UserId: 42}.content("https://comments.example.com", "")
+	assert.Contains(t, plainText, "No reason was given.")
+}
@@ -1,45 +1,302 @@
 package email
 
 import (
+	"aggregat4/go-commentservice/internal/domain"
+	"encoding/json"
+	"fmt"
 	"log/slog"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var logger = slog.New(slog.NewTextHandler(os.Stdout, nil))
-var maxEmailsToSend = 20
+
+const (
+	kindAuthenticationCode    = "authentication-code"
+	kindCommentRejected       = "comment-rejected"
+	kindCommentModerated      = "comment-moderated"
+	kindNewComment            = "new-comment"
+	kindPendingComment        = "pending-comment"
+	kindPendingCommentsDigest = "pending-comments-digest"
+	outboxBatchSize           = 50
+	outboxPollInterval        = 10 * time.Second
+	// sent emails are kept for a while so that delivery can be inspected, after that they are purged
+	sentEmailRetention = 7 * 24 * time.Hour
+)
+
+// Email is a message that is queued in the outbox and delivered by a sending strategy. Its kind determines how the
+// payload is deserialized again, so every email type has to be registered in decodeEmail.
+type Email interface {
+	Recipient() string
+	kind() string
+	// content renders the subject, the plain text and the HTML body. The configured subject is only used by
+	// authentication emails, other emails have their own subject.
+	content(baseURL string, configuredSubject string) (string, string, string)
+}
+
+// unsubscribableEmail is implemented by emails that are sent because of a subscription. The sending strategies add
+// the List-Unsubscribe headers of RFC 8058 for them so that mail clients can offer to unsubscribe with one click.
+type unsubscribableEmail interface {
+	unsubscribeLink(baseURL string) string
+}
+
+type header struct {
+	name  string
+	value string
+}
+
+// additionalHeaders returns the headers that the email needs besides the ones every email has
+func additionalHeaders(email Email, baseURL string) []header {
+	unsubscribable, ok := email.(unsubscribableEmail)
+	if !ok {
+		return nil
+	}
+	return []header{
+		{"List-Unsubscribe", "<" + unsubscribable.unsubscribeLink(baseURL) + ">"},
+		{"List-Unsubscribe-Post", "List-Unsubscribe=One-Click"},
+	}
+}
 
 type AuthenticationCodeEmail struct {
 	EmailAddress string
 	Code         string
 }
 
+func (email AuthenticationCodeEmail) Recipient() string {
+	return email.EmailAddress
+}
+
+func (email AuthenticationCodeEmail) kind() string {
+	return kindAuthenticationCode
+}
+
+// Outbox persists emails until they have been delivered, it is implemented by repository.Store
+type Outbox interface {
+	EnqueueEmail(kind string, recipient string, payload string, notBefore time.Time) (int, error)
+	FindDueEmails(now time.Time, limit int) ([]domain.OutboxEmail, error)
+	MarkEmailSent(emailId int, attempts int, sentAt time.Time) error
+	MarkEmailFailed(emailId int, attempts int, nextAttemptAt time.Time, lastError string, deadLetter bool) error
+	DeleteSentEmailsBefore(before time.Time) error
+}
+
+// RetryPolicy determines how often and how fast failed deliveries are retried. The delay doubles with every
+// failed attempt starting at BaseDelay up to MaxDelay. After MaxAttempts the email is dead lettered.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 8,
+	BaseDelay:   30 * time.Second,
+	MaxDelay:    1 * time.Hour,
+}
+
+func (policy RetryPolicy) delayAfter(attempts int) time.Duration {
+	delay := policy.BaseDelay
+	for i := 1; i < attempts && delay < policy.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, policy.MaxDelay)
+}
+
 type EmailSender struct {
-	emailChannel       chan AuthenticationCodeEmail
-	NumberOfEmailsSent int
+	outbox               Outbox
+	emailSendingStrategy func(email Email) error
+	retryPolicy          RetryPolicy
+	now                  func() time.Time
+	// rateLimiter and notificationLimiter are optional, without them emails are never rejected
+	rateLimiter         *RateLimiter
+	notificationLimiter *RateLimiter
+	// processing guards against concurrent delivery of the same emails
+	processing sync.Mutex
+	wakeUp     chan struct{}
+	stop       chan struct{}
+	stopOnce   sync.Once
+	emailsSent atomic.Int64
+}
+
+func NewEmailSender(outbox Outbox, emailSendingStrategy func(email Email) error, retryPolicy RetryPolicy, rateLimits RateLimits) *EmailSender {
+	return NewEmailSenderWithClock(outbox, emailSendingStrategy, retryPolicy, rateLimits, time.Now)
 }
 
-func NewEmailSender(emailSendingStrategy func(email AuthenticationCodeEmail)) *EmailSender {
+// NewEmailSenderWithClock creates an EmailSender that uses the provided clock to schedule, rate limit and deliver emails
+func NewEmailSenderWithClock(outbox Outbox, emailSendingStrategy func(email Email) error, retryPolicy RetryPolicy, rateLimits RateLimits, now func() time.Time) *EmailSender {
 	var emailSender = EmailSender{
-		emailChannel:       make(chan AuthenticationCodeEmail, 100),
-		NumberOfEmailsSent: 0,
+		outbox:               outbox,
+		emailSendingStrategy: emailSendingStrategy,
+		retryPolicy:          retryPolicy,
+		now:                  now,
+		rateLimiter:          NewRateLimiter(rateLimits, now),
+		notificationLimiter:  NewRateLimiter(rateLimits.notificationLimits(), now),
+		wakeUp:               make(chan struct{}, 1),
+		stop:                 make(chan struct{}),
 	}
-	go emailSender.startWorker(emailSendingStrategy)
+	go emailSender.startWorker()
 	return &emailSender
 }
 
-func (emailSender *EmailSender) SendEmail(email AuthenticationCodeEmail) bool {
-	if emailSender.NumberOfEmailsSent >= maxEmailsToSend {
-		logger.Warn("Reached maximum number of emails to send, ignoring email to %s", "emailaddress", email.EmailAddress)
-		return false
+// NumberOfEmailsSent returns how many emails were successfully delivered since this sender was created
+func (emailSender *EmailSender) NumberOfEmailsSent() int {
+	return int(emailSender.emailsSent.Load())
+}
+
+// SendEmail stores the email in the outbox for immediate delivery, see ScheduleEmail
+func (emailSender *EmailSender) SendEmail(email Email, client string) error {
+	return emailSender.ScheduleEmail(email, client, 0)
+}
+
+// ScheduleEmail stores the email in the outbox, it will not be delivered before the delay has passed. Since the
+// schedule is persisted in the outbox it survives restarts. The client identifies who requested the email (usually
+// the IP address) and is used for rate limiting. When a rate limit is exceeded an error matching ErrRateLimited
+// is returned and nothing is queued.
+func (emailSender *EmailSender) ScheduleEmail(email Email, client string, delay time.Duration) error {
+	return emailSender.enqueue(email, emailSender.rateLimiter, client, delay)
+}
+
+// SendNotification stores an email that no client asked for, like notifications about moderation, in the outbox for
+// immediate delivery. Notifications are only subject to the per recipient and the global notification limits.
+func (emailSender *EmailSender) SendNotification(email Email) error {
+	return emailSender.enqueue(email, emailSender.notificationLimiter, "", 0)
+}
+
+func (emailSender *EmailSender) enqueue(email Email, rateLimiter *RateLimiter, client string, delay time.Duration) error {
+	if rateLimiter != nil {
+		err := rateLimiter.Allow(strings.ToLower(strings.TrimSpace(email.Recipient())), client)
+		if err != nil {
+			logger.Warn("Rate limit exceeded, not sending email", "client", client, "kind", email.kind(), "error", err)
+			return err
+		}
+	}
+	payload, err := json.Marshal(email)
+	if err != nil {
+		return fmt.Errorf("failed to serialize email: %w", err)
+	}
+	_, err = emailSender.outbox.EnqueueEmail(email.kind(), email.Recipient(), string(payload), emailSender.now().Add(delay))
+	if err != nil {
+		return fmt.Errorf("failed to queue email: %w", err)
+	}
+	select {
+	case emailSender.wakeUp <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Close stops the background worker, emails that are still pending remain in the outbox
+func (emailSender *EmailSender) Close() {
+	emailSender.stopOnce.Do(func() {
+		close(emailSender.stop)
+	})
+}
+
+func (emailSender *EmailSender) startWorker() {
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+	for {
+		emailSender.ProcessOutbox()
+		select {
+		case <-emailSender.stop:
+			return
+		case <-emailSender.wakeUp:
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessOutbox attempts to deliver all emails that are due
+func (emailSender *EmailSender) ProcessOutbox() {
+	emailSender.processing.Lock()
+	defer emailSender.processing.Unlock()
+	now := emailSender.now()
+	dueEmails, err := emailSender.outbox.FindDueEmails(now, outboxBatchSize)
+	if err != nil {
+		logger.Error("Failed to read the email outbox", "error", err)
+		return
+	}
+	for _, outboxEmail := range dueEmails {
+		emailSender.deliver(outboxEmail, now)
+	}
+	err = emailSender.outbox.DeleteSentEmailsBefore(now.Add(-sentEmailRetention))
+	if err != nil {
+		logger.Error("Failed to purge sent emails from the outbox", "error", err)
+	}
+}
+
+func (emailSender *EmailSender) deliver(outboxEmail domain.OutboxEmail, now time.Time) {
+	attempts := outboxEmail.Attempts + 1
+	err := emailSender.dispatch(outboxEmail)
+	if err == nil {
+		err = emailSender.outbox.MarkEmailSent(outboxEmail.Id, attempts, now)
+		if err != nil {
+			logger.Error("Failed to mark email as sent", "id", outboxEmail.Id, "error", err)
+		}
+		emailSender.emailsSent.Add(1)
+		return
+	}
+	deadLetter := attempts >= emailSender.retryPolicy.MaxAttempts
+	nextAttemptAt := now.Add(emailSender.retryPolicy.delayAfter(attempts))
+	if deadLetter {
+		logger.Error("Giving up on email after too many attempts", "id", outboxEmail.Id, "attempts", attempts, "error", err)
+	} else {
+		logger.Warn("Failed to send email, will retry", "id", outboxEmail.Id, "attempts", attempts, "nextAttemptAt", nextAttemptAt, "error", err)
+	}
+	err = emailSender.outbox.MarkEmailFailed(outboxEmail.Id, attempts, nextAttemptAt, err.Error(), deadLetter)
+	if err != nil {
+		logger.Error("Failed to record email delivery failure", "id", outboxEmail.Id, "error", err)
 	}
-	emailSender.emailChannel <- email
-	return true
 }
 
-func (emailSender *EmailSender) startWorker(emailSendingStrategy func(email AuthenticationCodeEmail)) {
-	for email := range emailSender.emailChannel {
-		// TODO: send email
-		emailSendingStrategy(email)
-		emailSender.NumberOfEmailsSent += 1
+func (emailSender *EmailSender) dispatch(outboxEmail domain.OutboxEmail) error {
+	email, err := decodeEmail(outboxEmail.Kind, outboxEmail.Payload)
+	if err != nil {
+		return err
 	}
+	return emailSender.emailSendingStrategy(email)
+}
+
+func decodeEmail(kind string, payload string) (Email, error) {
+	switch kind {
+	case kindAuthenticationCode:
+		return decodePayload[AuthenticationCodeEmail](payload)
+	case kindCommentRejected:
+		return decodePayload[CommentRejectedEmail](payload)
+	case kindPendingComment:
+		return decodePayload[PendingCommentEmail](payload)
+	case kindPendingCommentsDigest:
+		return decodePayload[PendingCommentsDigestEmail](payload)
+	case kindCommentModerated:
+		return decodePayload[CommentModeratedEmail](payload)
+	case kindNewComment:
+		return decodePayload[NewCommentEmail](payload)
+	default:
+		return nil, fmt.Errorf("unknown email kind: %s", kind)
+	}
+}
+
+func decodePayload[T Email](payload string) (Email, error) {
+	var email T
+	err := json.Unmarshal([]byte(payload), &email)
+	if err != nil {
+		return nil, err
+	}
+	return email, nil
+}
+
+// content renders an authentication email, it is shared by all sending strategies so that users get the same
+// email regardless of the transport
+func (email AuthenticationCodeEmail) content(baseURL string, configuredSubject string) (string, string, string) {
+	authLink := fmt.Sprintf("%s/userauthentication/%s", baseURL, email.Code)
+	plainTextContent := fmt.Sprintf("Your authentication code is: %s\n\nClick this link to authenticate: %s\n\nIf you prefer to enter the code manually, you can do so at %s/userauthentication/\n\nThis code will expire in 15 minutes.", email.Code, authLink, baseURL)
+	htmlContent := fmt.Sprintf(`
+		<p>Your authentication code is: <strong>%s</strong></p>
+		<p><a href="%s">Click here to authenticate</a></p>
+		<p>If you prefer to enter the code manually, you can do so at <a href="%s/userauthentication/">%s/userauthentication/</a></p>
+		<p>This code will expire in 15 minutes.</p>
+	`, email.Code, authLink, baseURL, baseURL)
+	return configuredSubject, plainTextContent, htmlContent
 }
@@ -0,0 +1,203 @@
+package email
+
+import (
+	"aggregat4/go-commentservice/internal/domain"
+	"aggregat4/go-commentservice/internal/repository"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+)
+
+var TEST_ENCRYPTIONKEY = "12345678901234567890123456789012"
+
+var testClient = "192.0.2.1"
+
+var testRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   1 * time.Minute,
+	MaxDelay:    10 * time.Minute,
+}
+
+type testClock struct {
+	now time.Time
+}
+
+func (clock *testClock) Now() time.Time {
+	return clock.now
+}
+
+func (clock *testClock) Advance(duration time.Duration) {
+	clock.now = clock.now.Add(duration)
+}
+
+func createTestStore(t *testing.T) *repository.Store {
+	keys, err := repository.NewKeyring(1, []byte(TEST_ENCRYPTIONKEY))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &repository.Store{Keys: keys}
+	err = store.InitAndVerifyDb(repository.CreateInMemoryDbUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// createTestEmailSender creates a sender without a background worker so that tests can drive the outbox
+// processing and the clock themselves
+func createTestEmailSender(store *repository.Store, mock *MockEmailSender, clock *testClock) *EmailSender {
+	return &EmailSender{
+		outbox:               store,
+		emailSendingStrategy: mock.MockEmailSenderStrategy,
+		retryPolicy:          testRetryPolicy,
+		now:                  clock.Now,
+		wakeUp:               make(chan struct{}, 1),
+		stop:                 make(chan struct{}),
+	}
+}
+
+func getOutboxEmails(t *testing.T, store *repository.Store) []domain.OutboxEmail {
+	emails, err := store.GetOutboxEmails([]domain.OutboxStatus{}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return emails
+}
+
+func TestQueuedEmailIsDelivered(t *testing.T) {
+	store := createTestStore(t)
+	mock := NewMockEmailSender()
+	clock := &testClock{now: time.Unix(1_700_000_000, 0)}
+	sender := createTestEmailSender(store, mock, clock)
+	assert.Nil(t, sender.SendEmail(testAuthenticationEmail, testClient))
+	assert.Equal(t, 0, mock.NumberOfSentEmails(), "Queueing should not send the email synchronously")
+	sender.ProcessOutbox()
+	assert.Equal(t, []Email{testAuthenticationEmail}, mock.SentEmails)
+	emails := getOutboxEmails(t, store)
+	assert.Equal(t, 1, len(emails))
+	assert.Equal(t, domain.OutboxStatusSent, emails[0].Status)
+	assert.Equal(t, 1, emails[0].Attempts)
+	assert.Equal(t, testAuthenticationEmail.EmailAddress, emails[0].Recipient)
+	// a second run must not send the email again
+	sender.ProcessOutbox()
+	assert.Equal(t, 1, mock.NumberOfSentEmails())
+}
+
+func TestFailedEmailIsRetriedWithBackoff(t *testing.T) {
+	store := createTestStore(t)
+	mock := NewMockEmailSender()
+	mock.FailuresRemaining = 2
+	clock := &testClock{now: time.Unix(1_700_000_000, 0)}
+	sender := createTestEmailSender(store, mock, clock)
+	assert.Nil(t, sender.SendEmail(testAuthenticationEmail, testClient))
+
+	sender.ProcessOutbox()
+	emails := getOutboxEmails(t, store)
+	assert.Equal(t, domain.OutboxStatusPending, emails[0].Status)
+	assert.Equal(t, 1, emails[0].Attempts)
+	assert.Equal(t, clock.Now().Add(1*time.Minute), emails[0].NextAttemptAt)
+	assert.Equal(t, "mock email delivery failure", emails[0].LastError)
+
+	// not yet due
+	clock.Advance(30 * time.Second)
+	sender.ProcessOutbox()
+	assert.Equal(t, 1, getOutboxEmails(t, store)[0].Attempts)
+
+	// second attempt fails again and doubles the delay
+	clock.Advance(30 * time.Second)
+	sender.ProcessOutbox()
+	emails = getOutboxEmails(t, store)
+	assert.Equal(t, 2, emails[0].Attempts)
+	assert.Equal(t, clock.Now().Add(2*time.Minute), emails[0].NextAttemptAt)
+
+	clock.Advance(2 * time.Minute)
+	sender.ProcessOutbox()
+	emails = getOutboxEmails(t, store)
+	assert.Equal(t, domain.OutboxStatusSent, emails[0].Status)
+	assert.Equal(t, 3, emails[0].Attempts)
+	assert.Equal(t, "", emails[0].LastError)
+	assert.Equal(t, 1, mock.NumberOfSentEmails())
+}
+
+func TestEmailIsDeadLetteredAfterMaxAttempts(t *testing.T) {
+	store := createTestStore(t)
+	mock := NewMockEmailSender()
+	mock.FailuresRemaining = 100
+	clock := &testClock{now: time.Unix(1_700_000_000, 0)}
+	sender := createTestEmailSender(store, mock, clock)
+	assert.Nil(t, sender.SendEmail(testAuthenticationEmail, testClient))
+	for i := 0; i < 10; i++ {
+		sender.ProcessOutbox()
+		clock.Advance(time.Hour)
+	}
+	emails := getOutboxEmails(t, store)
+	assert.Equal(t, domain.OutboxStatusDeadLetter, emails[0].Status)
+	assert.Equal(t, testRetryPolicy.MaxAttempts, emails[0].Attempts)
+	assert.Equal(t, 100-testRetryPolicy.MaxAttempts, mock.FailuresRemaining, "Dead lettered emails should not be retried")
+}
+
+func TestQueuedEmailSurvivesRestart(t *testing.T) {
+	store := createTestStore(t)
+	clock := &testClock{now: time.Unix(1_700_000_000, 0)}
+	firstMock := NewMockEmailSender()
+	firstSender := createTestEmailSender(store, firstMock, clock)
+	assert.Nil(t, firstSender.SendEmail(testAuthenticationEmail, testClient))
+	// the first sender never gets to process its outbox, a new one takes over
+	secondMock := NewMockEmailSender()
+	secondSender := createTestEmailSender(store, secondMock, clock)
+	secondSender.ProcessOutbox()
+	assert.Equal(t, 0, firstMock.NumberOfSentEmails())
+	assert.Equal(t, []Email{testAuthenticationEmail}, secondMock.SentEmails)
+}
+
+func TestScheduledEmailIsDeliveredAfterDelay(t *testing.T) {
+	store := createTestStore(t)
+	mock := NewMockEmailSender()
+	clock := &testClock{now: time.Unix(1_700_000_000, 0)}
+	sender := createTestEmailSender(store, mock, clock)
+	assert.Nil(t, sender.ScheduleEmail(testAuthenticationEmail, testClient, 5*time.Minute))
+	sender.ProcessOutbox()
+	assert.Equal(t, 0, mock.NumberOfSentEmails(), "Scheduled email should not be sent before its delay")
+	clock.Advance(4 * time.Minute)
+	sender.ProcessOutbox()
+	assert.Equal(t, 0, mock.NumberOfSentEmails(), "Scheduled email should not be sent before its delay")
+	clock.Advance(1 * time.Minute)
+	sender.ProcessOutbox()
+	assert.Equal(t, 1, mock.NumberOfSentEmails(), "Scheduled email should be sent once its delay has passed")
+}
+
+func TestScheduledEmailSurvivesRestart(t *testing.T) {
+	store := createTestStore(t)
+	clock := &testClock{now: time.Unix(1_700_000_000, 0)}
+	firstSender := createTestEmailSender(store, NewMockEmailSender(), clock)
+	assert.Nil(t, firstSender.ScheduleEmail(testAuthenticationEmail, testClient, 1*time.Minute))
+	clock.Advance(2 * time.Minute)
+	secondMock := NewMockEmailSender()
+	secondSender := createTestEmailSender(store, secondMock, clock)
+	secondSender.ProcessOutbox()
+	assert.Equal(t, 1, secondMock.NumberOfSentEmails())
+}
+
+func TestSentEmailsArePurged(t *testing.T) {
+	store := createTestStore(t)
+	mock := NewMockEmailSender()
+	clock := &testClock{now: time.Unix(1_700_000_000, 0)}
+	sender := createTestEmailSender(store, mock, clock)
+	assert.Nil(t, sender.SendEmail(testAuthenticationEmail, testClient))
+	sender.ProcessOutbox()
+	assert.Equal(t, 1, len(getOutboxEmails(t, store)))
+	clock.Advance(sentEmailRetention + time.Minute)
+	sender.ProcessOutbox()
+	assert.Equal(t, 0, len(getOutboxEmails(t, store)))
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	assert.Equal(t, 1*time.Minute, testRetryPolicy.delayAfter(1))
+	assert.Equal(t, 2*time.Minute, testRetryPolicy.delayAfter(2))
+	assert.Equal(t, 4*time.Minute, testRetryPolicy.delayAfter(3))
+	assert.Equal(t, 10*time.Minute, testRetryPolicy.delayAfter(5))
+	assert.Equal(t, 10*time.Minute, testRetryPolicy.delayAfter(100))
+}
@@ -1,16 +1,37 @@
 package email
 
+import (
+	"errors"
+	"sync"
+)
+
 type MockEmailSender struct {
-	SentEmails []AuthenticationCodeEmail
+	mutex      sync.Mutex
+	SentEmails []Email
+	// FailuresRemaining makes the next n send attempts fail
+	FailuresRemaining int
 }
 
 func NewMockEmailSender() *MockEmailSender {
 	mockEmailSender := MockEmailSender{}
-	mockEmailSender.SentEmails = []AuthenticationCodeEmail{}
+	mockEmailSender.SentEmails = []Email{}
 	return &mockEmailSender
 }
 
-func (sender *MockEmailSender) MockEmailSenderStrategy(email AuthenticationCodeEmail) {
-	logger.Debug("MockEmailSenderStrategy: Sending email to %s with code %s", email.EmailAddress, email.Code)
+func (sender *MockEmailSender) MockEmailSenderStrategy(email Email) error {
+	sender.mutex.Lock()
+	defer sender.mutex.Unlock()
+	if sender.FailuresRemaining > 0 {
+		sender.FailuresRemaining--
+		return errors.New("mock email delivery failure")
+	}
+	logger.Debug("MockEmailSenderStrategy: Sending email", "kind", email.kind(), "to", email.Recipient())
 	sender.SentEmails = append(sender.SentEmails, email)
+	return nil
+}
+
+func (sender *MockEmailSender) NumberOfSentEmails() int {
+	sender.mutex.Lock()
+	defer sender.mutex.Unlock()
+	return len(sender.SentEmails)
 }
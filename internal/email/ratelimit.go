@@ -0,0 +1,157 @@
+package email
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrRateLimited          = errors.New("email rate limit exceeded")
+	ErrRecipientRateLimited = rateLimitError("too many emails for this recipient")
+	ErrClientRateLimited    = rateLimitError("too many emails requested from this client")
+	ErrGlobalRateLimited    = rateLimitError("too many emails sent overall")
+)
+
+func rateLimitError(message string) error {
+	return &wrappedRateLimitError{message}
+}
+
+// wrappedRateLimitError makes all specific rate limit errors match ErrRateLimited with errors.Is
+type wrappedRateLimitError struct {
+	message string
+}
+
+func (e *wrappedRateLimitError) Error() string { return e.message }
+
+func (e *wrappedRateLimitError) Unwrap() error { return ErrRateLimited }
+
+// RateLimits configures how many emails may be sent per hour. The limits are enforced with token buckets so
+// short bursts up to the limit are allowed while the sustained rate can not exceed it. A limit of 0 or less
+// disables that particular check. Notifications, which are not requested by a client, have buckets of their own so
+// that they can not use up the emails that users need to log in.
+type RateLimits struct {
+	PerRecipientPerHour              int
+	PerClientPerHour                 int
+	GlobalPerHour                    int
+	NotificationsPerRecipientPerHour int
+	NotificationsGlobalPerHour       int
+}
+
+var DefaultRateLimits = RateLimits{
+	PerRecipientPerHour:              5,
+	PerClientPerHour:                 20,
+	GlobalPerHour:                    200,
+	NotificationsPerRecipientPerHour: 30,
+	NotificationsGlobalPerHour:       1000,
+}
+
+// notificationLimits returns the limits that apply to notifications
+func (limits RateLimits) notificationLimits() RateLimits {
+	return RateLimits{PerRecipientPerHour: limits.NotificationsPerRecipientPerHour, GlobalPerHour: limits.NotificationsGlobalPerHour}
+}
+
+// buckets that have been idle for this long are full again and can be forgotten
+const rateLimiterPruneInterval = 10 * time.Minute
+
+type tokenBucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+type keyedTokenBuckets struct {
+	capacity float64
+	buckets  map[string]*tokenBucket
+}
+
+func newKeyedTokenBuckets(perHour int) *keyedTokenBuckets {
+	return &keyedTokenBuckets{capacity: float64(perHour), buckets: make(map[string]*tokenBucket)}
+}
+
+func (b *keyedTokenBuckets) enabled() bool {
+	return b.capacity > 0
+}
+
+// refill returns the bucket for the key with all tokens added that accumulated since it was last used
+func (b *keyedTokenBuckets) refill(key string, now time.Time) *tokenBucket {
+	bucket, ok := b.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: b.capacity, updatedAt: now}
+		b.buckets[key] = bucket
+		return bucket
+	}
+	elapsed := now.Sub(bucket.updatedAt)
+	if elapsed > 0 {
+		bucket.tokens = min(b.capacity, bucket.tokens+elapsed.Hours()*b.capacity)
+		bucket.updatedAt = now
+	}
+	return bucket
+}
+
+func (b *keyedTokenBuckets) prune(now time.Time) {
+	for key, bucket := range b.buckets {
+		if bucket.tokens+now.Sub(bucket.updatedAt).Hours()*b.capacity >= b.capacity {
+			delete(b.buckets, key)
+		}
+	}
+}
+
+// RateLimiter enforces RateLimits, it is safe for concurrent use
+type RateLimiter struct {
+	mutex        sync.Mutex
+	now          func() time.Time
+	perRecipient *keyedTokenBuckets
+	perClient    *keyedTokenBuckets
+	global       *keyedTokenBuckets
+	lastPrune    time.Time
+}
+
+func NewRateLimiter(limits RateLimits, now func() time.Time) *RateLimiter {
+	return &RateLimiter{
+		now:          now,
+		perRecipient: newKeyedTokenBuckets(limits.PerRecipientPerHour),
+		perClient:    newKeyedTokenBuckets(limits.PerClientPerHour),
+		global:       newKeyedTokenBuckets(limits.GlobalPerHour),
+		lastPrune:    now(),
+	}
+}
+
+// Allow takes a token for the recipient, the client and the global bucket. Tokens are only taken when all
+// buckets have one available so that a rejected request does not count against the other limits. An empty client
+// skips the per client limit.
+func (limiter *RateLimiter) Allow(recipient string, client string) error {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	now := limiter.now()
+	if now.Sub(limiter.lastPrune) >= rateLimiterPruneInterval {
+		limiter.perRecipient.prune(now)
+		limiter.perClient.prune(now)
+		limiter.global.prune(now)
+		limiter.lastPrune = now
+	}
+	type check struct {
+		buckets *keyedTokenBuckets
+		key     string
+		err     error
+	}
+	checks := []check{{limiter.perRecipient, recipient, ErrRecipientRateLimited}}
+	if client != "" {
+		checks = append(checks, check{limiter.perClient, client, ErrClientRateLimited})
+	}
+	checks = append(checks, check{limiter.global, "", ErrGlobalRateLimited})
+	var available []*tokenBucket
+	for _, check := range checks {
+		if !check.buckets.enabled() {
+			continue
+		}
+		bucket := check.buckets.refill(check.key, now)
+		if bucket.tokens < 1 {
+			return check.err
+		}
+		available = append(available, bucket)
+	}
+	for _, bucket := range available {
+		bucket.tokens--
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package email
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func createTestRateLimiter(limits RateLimits) (*RateLimiter, *testClock) {
+	clock := &testClock{now: time.Unix(1_700_000_000, 0)}
+	return NewRateLimiter(limits, clock.Now), clock
+}
+
+func TestRecipientRateLimitRefills(t *testing.T) {
+	limiter, clock := createTestRateLimiter(RateLimits{PerRecipientPerHour: 2})
+	assert.Nil(t, limiter.Allow("user@example.com", testClient))
+	assert.Nil(t, limiter.Allow("user@example.com", testClient))
+	assert.ErrorIs(t, limiter.Allow("user@example.com", testClient), ErrRecipientRateLimited)
+	assert.Nil(t, limiter.Allow("other@example.com", testClient), "Other recipients have their own bucket")
+	// two emails per hour means one token every 30 minutes
+	clock.Advance(29 * time.Minute)
+	assert.ErrorIs(t, limiter.Allow("user@example.com", testClient), ErrRecipientRateLimited)
+	clock.Advance(1 * time.Minute)
+	assert.Nil(t, limiter.Allow("user@example.com", testClient))
+	assert.ErrorIs(t, limiter.Allow("user@example.com", testClient), ErrRecipientRateLimited)
+}
+
+func TestClientRateLimit(t *testing.T) {
+	limiter, _ := createTestRateLimiter(RateLimits{PerClientPerHour: 2})
+	assert.Nil(t, limiter.Allow("user1@example.com", testClient))
+	assert.Nil(t, limiter.Allow("user2@example.com", testClient))
+	err := limiter.Allow("user3@example.com", testClient)
+	assert.ErrorIs(t, err, ErrClientRateLimited)
+	assert.ErrorIs(t, err, ErrRateLimited)
+	assert.Nil(t, limiter.Allow("user3@example.com", "192.0.2.2"))
+}
+
+func TestGlobalRateLimit(t *testing.T) {
+	limiter, clock := createTestRateLimiter(RateLimits{GlobalPerHour: 1})
+	assert.Nil(t, limiter.Allow("user1@example.com", testClient))
+	assert.ErrorIs(t, limiter.Allow("user2@example.com", "192.0.2.2"), ErrGlobalRateLimited)
+	clock.Advance(time.Hour)
+	assert.Nil(t, limiter.Allow("user2@example.com", "192.0.2.2"))
+}
+
+func TestRejectedRequestDoesNotConsumeOtherLimits(t *testing.T) {
+	limiter, _ := createTestRateLimiter(RateLimits{PerRecipientPerHour: 1, PerClientPerHour: 2})
+	assert.Nil(t, limiter.Allow("user@example.com", testClient))
+	assert.ErrorIs(t, limiter.Allow("user@example.com", testClient), ErrRecipientRateLimited)
+	assert.ErrorIs(t, limiter.Allow("user@example.com", testClient), ErrRecipientRateLimited)
+	// the client still has its second token since the rejected requests did not take one
+	assert.Nil(t, limiter.Allow("other@example.com", testClient))
+}
+
+func TestIdleBucketsArePruned(t *testing.T) {
+	limiter, clock := createTestRateLimiter(RateLimits{PerRecipientPerHour: 2})
+	assert.Nil(t, limiter.Allow("user@example.com", testClient))
+	clock.Advance(time.Hour)
+	assert.Nil(t, limiter.Allow("other@example.com", testClient))
+	assert.Equal(t, 1, len(limiter.perRecipient.buckets))
+}
+
+func TestRateLimiterIsSafeForConcurrentUse(t *testing.T) {
+	limiter, _ := createTestRateLimiter(RateLimits{GlobalPerHour: 10})
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if limiter.Allow("user@example.com", testClient) == nil {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(10), allowed.Load())
+}
+
+func TestRateLimitedEmailIsNotQueued(t *testing.T) {
+	store := createTestStore(t)
+	mock := NewMockEmailSender()
+	clock := &testClock{now: time.Unix(1_700_000_000, 0)}
+	sender := createTestEmailSender(store, mock, clock)
+	sender.rateLimiter = NewRateLimiter(RateLimits{PerRecipientPerHour: 1}, clock.Now)
+	assert.Nil(t, sender.SendEmail(testAuthenticationEmail, testClient))
+	upperCased := AuthenticationCodeEmail{EmailAddress: "USER@example.com", Code: "OTHERCODE"}
+	err := sender.SendEmail(upperCased, testClient)
+	assert.True(t, errors.Is(err, ErrRecipientRateLimited), "Recipient addresses should be compared case insensitively")
+	sender.ProcessOutbox()
+	assert.Equal(t, 1, len(getOutboxEmails(t, store)))
+	assert.Equal(t, 1, mock.NumberOfSentEmails())
+	assert.Equal(t, 1, sender.NumberOfEmailsSent())
+}
+
+func TestEmptyClientSkipsClientRateLimit(t *testing.T) {
+	limiter, _ := createTestRateLimiter(RateLimits{PerClientPerHour: 1, PerRecipientPerHour: 2})
+	assert.Nil(t, limiter.Allow("user@example.com", ""))
+	assert.Nil(t, limiter.Allow("other@example.com", ""), "Emails without a client are not limited per client")
+	assert.Nil(t, limiter.Allow("user@example.com", ""))
+	assert.ErrorIs(t, limiter.Allow("user@example.com", ""), ErrRecipientRateLimited)
+}
+
+func TestNotificationsHaveTheirOwnRateLimits(t *testing.T) {
+	store := createTestStore(t)
+	mock := NewMockEmailSender()
+	clock := &testClock{now: time.Unix(1_700_000_000, 0)}
+	sender := createTestEmailSender(store, mock, clock)
+	limits := RateLimits{PerRecipientPerHour: 1, GlobalPerHour: 1, NotificationsPerRecipientPerHour: 2}
+	sender.rateLimiter = NewRateLimiter(limits, clock.Now)
+	sender.notificationLimiter = NewRateLimiter(limits.notificationLimits(), clock.Now)
+	notification := PendingCommentEmail{EmailAddress: "user@example.com"}
+	assert.Nil(t, sender.SendNotification(notification))
+	assert.Nil(t, sender.SendNotification(notification))
+	assert.ErrorIs(t, sender.SendNotification(notification), ErrRecipientRateLimited)
+	assert.Nil(t, sender.SendEmail(testAuthenticationEmail, testClient), "Notifications do not use up the emails for authentication")
+	assert.ErrorIs(t, sender.SendEmail(testAuthenticationEmail, testClient), ErrRecipientRateLimited)
+}
@@ -0,0 +1,128 @@
+package email
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+// Unsubscribe identifies the subscription an email was sent for, the token is the signature that allows cancelling
+// it with a single click
+type Unsubscribe struct {
+	SubscriptionId int
+	Token          string
+}
+
+// link leads to a page that asks for confirmation, mail clients that support one click unsubscribing POST to it
+func (unsubscribe Unsubscribe) link(baseURL string) string {
+	return fmt.Sprintf("%s/subscriptions/%d/unsubscribe?token=%s", baseURL, unsubscribe.SubscriptionId, unsubscribe.Token)
+}
+
+func (unsubscribe Unsubscribe) plainText(baseURL string) string {
+	return "\n\n--\nYou get this email because you asked for it when you wrote a comment. Unsubscribe: " + unsubscribe.link(baseURL)
+}
+
+func (unsubscribe Unsubscribe) html(baseURL string) string {
+	return fmt.Sprintf(`<p><small>You get this email because you asked for it when you wrote a comment. <a href="%s">Unsubscribe</a></small></p>`, html.EscapeString(unsubscribe.link(baseURL)))
+}
+
+// CommentModeratedEmail tells the author of a comment who subscribed to its moderation that it was approved or
+// rejected
+type CommentModeratedEmail struct {
+	EmailAddress string
+	UserId       int
+	ParentUrl    string
+	Comment      string
+	Approved     bool
+	Reason       string
+	Unsubscribe  Unsubscribe
+}
+
+func (email CommentModeratedEmail) Recipient() string {
+	return email.EmailAddress
+}
+
+func (email CommentModeratedEmail) kind() string {
+	return kindCommentModerated
+}
+
+func (email CommentModeratedEmail) unsubscribeLink(baseURL string) string {
+	return email.Unsubscribe.link(baseURL)
+}
+
+func (email CommentModeratedEmail) content(baseURL string, configuredSubject string) (string, string, string) {
+	commentsLink := fmt.Sprintf("%s/users/%d/comments/", baseURL, email.UserId)
+	subject, outcome, outcomeHtml := "Your comment was approved", "was approved and is now shown", "was approved and is now shown"
+	if !email.Approved {
+		reason := email.Reason
+		if reason == "" {
+			reason = "No reason was given."
+		}
+		subject = "Your comment was not approved"
+		outcome = "was not approved.\n\nReason: " + reason
+		outcomeHtml = "was not approved.</p><p>Reason: " + html.EscapeString(reason)
+	}
+	plainTextContent := fmt.Sprintf("Your comment on %s %s\n\nYour comment:\n\n%s\n\nYou can see all your comments at %s", email.ParentUrl, outcome, email.Comment, commentsLink)
+	htmlContent := fmt.Sprintf(`
+		<p>Your comment on <a href="%s">%s</a> %s</p>
+		<p>Your comment:</p>
+		<blockquote>%s</blockquote>
+		<p><a href="%s">See all your comments</a></p>
+	`, html.EscapeString(email.ParentUrl), html.EscapeString(email.ParentUrl), outcomeHtml, html.EscapeString(email.Comment), html.EscapeString(commentsLink))
+	return subject, plainTextContent + email.Unsubscribe.plainText(baseURL), htmlContent + email.Unsubscribe.html(baseURL)
+}
+
+// NewComment is a comment in a NewCommentEmail
+type NewComment struct {
+	Name    string
+	Comment string
+}
+
+// NewCommentEmail tells a subscriber of a post about the comments on it that were approved at once
+type NewCommentEmail struct {
+	EmailAddress string
+	PostKey      string
+	ParentUrl    string
+	Comments     []NewComment
+	Unsubscribe  Unsubscribe
+}
+
+func (email NewCommentEmail) Recipient() string {
+	return email.EmailAddress
+}
+
+func (email NewCommentEmail) kind() string {
+	return kindNewComment
+}
+
+func (email NewCommentEmail) unsubscribeLink(baseURL string) string {
+	return email.Unsubscribe.link(baseURL)
+}
+
+func (email NewCommentEmail) content(baseURL string, configuredSubject string) (string, string, string) {
+	location, locationHtml := email.PostKey, html.EscapeString(email.PostKey)
+	if email.ParentUrl != "" {
+		location = email.ParentUrl
+		locationHtml = fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(email.ParentUrl), html.EscapeString(email.ParentUrl))
+	}
+	subject := "New comment on " + email.PostKey
+	if len(email.Comments) > 1 {
+		subject = fmt.Sprintf("%d new comments on %s", len(email.Comments), email.PostKey)
+	}
+	var plainTextContent, htmlContent strings.Builder
+	for i, comment := range email.Comments {
+		author := comment.Name
+		if author == "" {
+			author = "Anonymous"
+		}
+		if i > 0 {
+			plainTextContent.WriteString("\n\n")
+		}
+		fmt.Fprintf(&plainTextContent, "%s wrote a new comment on %s:\n\n%s", author, location, comment.Comment)
+		fmt.Fprintf(&htmlContent, `
+			<p>%s wrote a new comment on %s:</p>
+			<blockquote>%s</blockquote>
+		`, html.EscapeString(author), locationHtml, html.EscapeString(comment.Comment))
+	}
+	return subject, plainTextContent.String() + email.Unsubscribe.plainText(baseURL), htmlContent.String() + email.Unsubscribe.html(baseURL)
+}
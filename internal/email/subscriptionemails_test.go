@@ -0,0 +1,61 @@
+package email
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testUnsubscribe = Unsubscribe{SubscriptionId: 5, Token: "abc123"}
+
+func TestSubscriptionEmailsAreDelivered(t *testing.T) {
+	store := createTestStore(t)
+	mock := NewMockEmailSender()
+	clock := &testClock{now: time.Unix(1_700_000_000, 0)}
+	sender := createTestEmailSender(store, mock, clock)
+	moderatedEmail := CommentModeratedEmail{EmailAddress: "user@example.com", UserId: 42, ParentUrl: "https://example.com/post", Comment: "Hello", Approved: true, Unsubscribe: testUnsubscribe}
+	newCommentEmail := NewCommentEmail{EmailAddress: "user@example.com", PostKey: "post", ParentUrl: "https://example.com/post", Comments: []NewComment{{Name: "Jane", Comment: "Hello"}}, Unsubscribe: testUnsubscribe}
+	assert.Nil(t, sender.SendNotification(moderatedEmail))
+	assert.Nil(t, sender.SendNotification(newCommentEmail))
+	sender.ProcessOutbox()
+	assert.Equal(t, []Email{moderatedEmail, newCommentEmail}, mock.SentEmails)
+}
+
+func TestCommentModeratedEmailContent(t *testing.T) {
+	subject, plainText, html := CommentModeratedEmail{EmailAddress: "user@example.com", UserId: 42, ParentUrl: "https://example.com/post", Comment: "<b>Hello</b>", Approved: true, Unsubscribe: testUnsubscribe}.content("https://comments.example.com", "Your Authentication Code")
+	assert.Equal(t, "Your comment was approved", subject)
+	assert.Contains(t, plainText, "Your comment on https://example.com/post was approved")
+	assert.Contains(t, plainText, "Unsubscribe: https://comments.example.com/subscriptions/5/unsubscribe?token=abc123")
+	assert.Contains(t, html, `href="https://comments.example.com/subscriptions/5/unsubscribe?token=abc123"`)
+	assert.Contains(t, html, "&lt;b&gt;Hello&lt;/b&gt;", "The comment must be escaped in HTML emails")
+
+	subject, plainText, html = CommentModeratedEmail{EmailAddress: "user@example.com", UserId: 42, Comment: "Hello", Reason: "<i>Off topic</i>", Unsubscribe: testUnsubscribe}.content("https://comments.example.com", "")
+	assert.Equal(t, "Your comment was not approved", subject)
+	assert.Contains(t, plainText, "Reason: <i>Off topic</i>")
+	assert.Contains(t, html, "Reason: &lt;i&gt;Off topic&lt;/i&gt;")
+	_, plainText, _ = CommentModeratedEmail{EmailAddress: "user@example.com", UserId: 42}.content("https://comments.example.com", "")
+	assert.Contains(t, plainText, "No reason was given.")
+}
+
+func TestNewCommentEmailContent(t *testing.T) {
+	subject, plainText, html := NewCommentEmail{EmailAddress: "user@example.com", PostKey: "post", ParentUrl: "https://example.com/post", Comments: []NewComment{{Name: "<Jane>", Comment: "Hello"}}, Unsubscribe: testUnsubscribe}.content("https://comments.example.com", "")
+	assert.Equal(t, "New comment on post", subject)
+	assert.Contains(t, plainText, "<Jane> wrote a new comment on https://example.com/post:\n\nHello")
+	assert.Contains(t, plainText, "https://comments.example.com/subscriptions/5/unsubscribe?token=abc123")
+	assert.Contains(t, html, "&lt;Jane&gt; wrote a new comment on <a href=\"https://example.com/post\">")
+	subject, plainText, html = NewCommentEmail{EmailAddress: "user@example.com", PostKey: "post", Comments: []NewComment{{Comment: "Hello"}, {Name: "Joe", Comment: "Hi"}}, Unsubscribe: testUnsubscribe}.content("https://comments.example.com", "")
+	assert.Equal(t, "2 new comments on post", subject)
+	assert.Contains(t, plainText, "Anonymous wrote a new comment on post:\n\nHello\n\nJoe wrote a new comment on post:\n\nHi")
+	assert.NotContains(t, html, `href=""`)
+}
+
+func TestSubscriptionEmailsCanBeUnsubscribedWithOneClick(t *testing.T) {
+	headers := additionalHeaders(NewCommentEmail{Unsubscribe: testUnsubscribe}, "https://comments.example.com")
+	assert.Equal(t, []header{
+		{"List-Unsubscribe", "<https://comments.example.com/subscriptions/5/unsubscribe?token=abc123>"},
+		{"List-Unsubscribe-Post", "List-Unsubscribe=One-Click"},
+	}, headers)
+	assert.Equal(t, headers, additionalHeaders(CommentModeratedEmail{Unsubscribe: testUnsubscribe}, "https://comments.example.com"))
+	assert.Empty(t, additionalHeaders(testAuthenticationEmail, "https://comments.example.com"))
+}
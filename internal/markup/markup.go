@@ -0,0 +1,179 @@
+// Package markup renders the restricted Markdown that comments are written in. It supports paragraphs with line
+// breaks, *emphasis*, **strong emphasis**, [links](https://example.com) and bare links, `inline code`, fenced code
+// blocks and > quotes. Everything else, including HTML, is shown as it was written.
+package markup
+
+import (
+	"html"
+	"html/template"
+	"strings"
+)
+
+// maxQuoteDepth limits how deeply quotes can be nested, deeper quote markers are shown as they are
+const maxQuoteDepth = 5
+
+// Render converts the comment to HTML. The result is run through Sanitize, so only the allowed elements can end up
+// on the page even if the renderer had a bug.
+func Render(text string) template.HTML {
+	var output strings.Builder
+	text = strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\r", "\n")
+	renderBlocks(&output, strings.Split(text, "\n"), 0)
+	return template.HTML(Sanitize(output.String()))
+}
+
+func isFence(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), "```")
+}
+
+func isQuote(line string, depth int) bool {
+	return depth < maxQuoteDepth && strings.HasPrefix(strings.TrimSpace(line), ">")
+}
+
+func renderBlocks(output *strings.Builder, lines []string, depth int) {
+	for i := 0; i < len(lines); {
+		switch {
+		case strings.TrimSpace(lines[i]) == "":
+			i++
+		case isFence(lines[i]):
+			// a code block without a closing fence runs to the end of the comment
+			end := i + 1
+			for end < len(lines) && !isFence(lines[end]) {
+				end++
+			}
+			output.WriteString("<pre><code>")
+			output.WriteString(html.EscapeString(strings.Join(lines[i+1:end], "\n")))
+			output.WriteString("</code></pre>")
+			i = end + 1
+		case isQuote(lines[i], depth):
+			quotedLines := make([]string, 0)
+			for ; i < len(lines) && isQuote(lines[i], depth); i++ {
+				quotedLine := strings.TrimPrefix(strings.TrimSpace(lines[i]), ">")
+				quotedLines = append(quotedLines, strings.TrimPrefix(quotedLine, " "))
+			}
+			output.WriteString("<blockquote>")
+			renderBlocks(output, quotedLines, depth+1)
+			output.WriteString("</blockquote>")
+		default:
+			// a paragraph ends at an empty line or where another block starts, its lines are kept as line breaks
+			output.WriteString("<p>")
+			renderInline(output, strings.TrimSpace(lines[i]), true)
+			for i++; i < len(lines) && strings.TrimSpace(lines[i]) != "" && !isFence(lines[i]) && !isQuote(lines[i], depth); i++ {
+				output.WriteString("<br>\n")
+				renderInline(output, strings.TrimSpace(lines[i]), true)
+			}
+			output.WriteString("</p>")
+		}
+	}
+}
+
+func isAlphanumeric(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
+
+// emphasis finds the end of the emphasis that starts with the delimiter at the beginning of text. The content must
+// not start or end with a space and underscores only count at word boundaries, so that snake_case stays as it is.
+func emphasis(text string, delimiter string) (string, bool) {
+	end := strings.Index(text[len(delimiter):], delimiter)
+	if end <= 0 {
+		return "", false
+	}
+	content := text[len(delimiter) : len(delimiter)+end]
+	if strings.TrimSpace(content) != content {
+		return "", false
+	}
+	after := len(delimiter) + end + len(delimiter)
+	if delimiter[0] == '_' && after < len(text) && isAlphanumeric(text[after]) {
+		return "", false
+	}
+	return content, true
+}
+
+// link parses a [label](url) link at the beginning of text and returns its label, url and length
+func link(text string) (string, string, int, bool) {
+	labelEnd := strings.Index(text, "](")
+	if labelEnd <= 1 {
+		return "", "", 0, false
+	}
+	urlEnd := strings.IndexByte(text[labelEnd+2:], ')')
+	if urlEnd <= 0 {
+		return "", "", 0, false
+	}
+	linkUrl, ok := safeUrl(text[labelEnd+2 : labelEnd+2+urlEnd])
+	if !ok || strings.ContainsAny(text[labelEnd+2:labelEnd+2+urlEnd], " \t") {
+		return "", "", 0, false
+	}
+	return text[1:labelEnd], linkUrl, labelEnd + 2 + urlEnd + 1, true
+}
+
+// bareLink returns the length of the http or https link at the beginning of text, punctuation at its end belongs to
+// the surrounding sentence
+func bareLink(text string) int {
+	if !strings.HasPrefix(text, "http://") && !strings.HasPrefix(text, "https://") {
+		return 0
+	}
+	end := strings.IndexAny(text, " \t<>\"")
+	if end < 0 {
+		end = len(text)
+	}
+	end = len(strings.TrimRight(text[:end], ".,;:!?)'"))
+	if _, ok := safeUrl(text[:end]); !ok {
+		return 0
+	}
+	return end
+}
+
+func writeLink(output *strings.Builder, linkUrl string) {
+	output.WriteString(`<a href="` + html.EscapeString(linkUrl) + `" rel="` + linkRel + `">`)
+}
+
+// renderInline renders the formatting within a line, links can not be nested in the label of other links
+func renderInline(output *strings.Builder, text string, linksAllowed bool) {
+	for i := 0; i < len(text); {
+		rest := text[i:]
+		switch {
+		case rest[0] == '\\' && len(rest) > 1 && strings.IndexByte("\\`*_[]()>#", rest[1]) >= 0:
+			output.WriteString(html.EscapeString(rest[1:2]))
+			i += 2
+			continue
+		case rest[0] == '`':
+			if end := strings.IndexByte(rest[1:], '`'); end > 0 {
+				output.WriteString("<code>" + html.EscapeString(rest[1:1+end]) + "</code>")
+				i += end + 2
+				continue
+			}
+		case strings.HasPrefix(rest, "**") || strings.HasPrefix(rest, "__"):
+			if content, ok := emphasis(rest, rest[:2]); ok && (rest[0] == '*' || i == 0 || !isAlphanumeric(text[i-1])) {
+				output.WriteString("<strong>")
+				renderInline(output, content, linksAllowed)
+				output.WriteString("</strong>")
+				i += len(content) + 4
+				continue
+			}
+		case rest[0] == '*' || rest[0] == '_':
+			if content, ok := emphasis(rest, rest[:1]); ok && (rest[0] == '*' || i == 0 || !isAlphanumeric(text[i-1])) {
+				output.WriteString("<em>")
+				renderInline(output, content, linksAllowed)
+				output.WriteString("</em>")
+				i += len(content) + 2
+				continue
+			}
+		case rest[0] == '[' && linksAllowed:
+			if label, linkUrl, length, ok := link(rest); ok {
+				writeLink(output, linkUrl)
+				renderInline(output, label, false)
+				output.WriteString("</a>")
+				i += length
+				continue
+			}
+		case rest[0] == 'h' && linksAllowed && (i == 0 || !isAlphanumeric(text[i-1])):
+			if length := bareLink(rest); length > 0 {
+				writeLink(output, rest[:length])
+				output.WriteString(html.EscapeString(rest[:length]) + "</a>")
+				i += length
+				continue
+			}
+		}
+		output.WriteString(html.EscapeString(rest[:1]))
+		i++
+	}
+}
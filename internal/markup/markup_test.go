@@ -0,0 +1,51 @@
+package markup
+
+import (
+	"html/template"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderParagraphsAndLineBreaks(t *testing.T) {
+	assert.Equal(t, template.HTML("<p>First line<br>\nsecond line</p><p>Second paragraph</p>"),
+		Render("First line\r\nsecond line\n\n\nSecond paragraph\n"))
+	assert.Equal(t, template.HTML(""), Render("  \n "))
+}
+
+func TestRenderEmphasis(t *testing.T) {
+	assert.Equal(t, template.HTML("<p>This is <em>important</em> and <strong>very <em>important</em></strong></p>"),
+		Render("This is *important* and **very _important_**"))
+	assert.Equal(t, template.HTML("<p>snake_case_name and 2 * 3 * 4</p>"), Render("snake_case_name and 2 * 3 * 4"))
+	assert.Equal(t, template.HTML("<p>*not emphasized*</p>"), Render(`\*not emphasized*`))
+}
+
+func TestRenderLinks(t *testing.T) {
+	assert.Equal(t, template.HTML(`<p>See <a href="https://example.com/a?b=1&amp;c=2" rel="nofollow ugc">the <em>docs</em></a>.</p>`),
+		Render("See [the *docs*](https://example.com/a?b=1&c=2)."))
+	assert.Equal(t, template.HTML(`<p>Visit <a href="https://example.com/page" rel="nofollow ugc">https://example.com/page</a>.</p>`),
+		Render("Visit https://example.com/page."))
+	assert.Equal(t, template.HTML(`<p>[click](javascript:alert(1))</p>`), Render("[click](javascript:alert(1))"))
+	assert.Equal(t, template.HTML(`<p>[relative](/admin)</p>`), Render("[relative](/admin)"))
+}
+
+func TestRenderCode(t *testing.T) {
+	assert.Equal(t, template.HTML("<p>Use <code>a *b* &lt;c&gt;</code> here</p>"), Render("Use `a *b* <c>` here"))
+	assert.Equal(t, template.HTML("<pre><code>func main() {\n\tfmt.Println(&#34;&lt;hi&gt;&#34;)\n}</code></pre><p>After</p>"),
+		Render("```go\nfunc main() {\n\tfmt.Println(\"<hi>\")\n}\n```\nAfter"))
+	assert.Equal(t, template.HTML("<pre><code>unclosed\n\n*code*</code></pre>"), Render("```\nunclosed\n\n*code*"))
+}
+
+func TestRenderQuotes(t *testing.T) {
+	assert.Equal(t, template.HTML("<blockquote><p>You wrote<br>\nthis</p><blockquote><p>nested</p></blockquote></blockquote><p>My answer</p>"),
+		Render("> You wrote\n> this\n>> nested\nMy answer"))
+	assert.Equal(t, template.HTML("<blockquote><blockquote><blockquote><blockquote><blockquote><p>&gt; too deep</p></blockquote></blockquote></blockquote></blockquote></blockquote>"),
+		Render(">>>>>> too deep"))
+}
+
+func TestRenderEscapesHtml(t *testing.T) {
+	assert.Equal(t, template.HTML("<p>&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt; &lt;b onclick=&#34;x&#34;&gt;bold&lt;/b&gt;</p>"),
+		Render(`<script>alert("x")</script> <b onclick="x">bold</b>`))
+	assert.Equal(t, template.HTML(`<p><a href="https://example.com/%22onmouseover=%22x" rel="nofollow ugc">x</a></p>`),
+		Render(`[x](https://example.com/"onmouseover="x)`))
+}
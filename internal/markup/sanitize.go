@@ -0,0 +1,126 @@
+package markup
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// allowedElements are the only elements that survive sanitizing, all of them without attributes except for links
+var allowedElements = map[string]bool{
+	"a":          true,
+	"blockquote": true,
+	"br":         true,
+	"code":       true,
+	"em":         true,
+	"p":          true,
+	"pre":        true,
+	"strong":     true,
+}
+
+// droppedElements are removed together with their content, other elements that are not allowed keep their text
+var droppedElements = map[string]bool{
+	"iframe":   true,
+	"noscript": true,
+	"object":   true,
+	"script":   true,
+	"style":    true,
+	"template": true,
+	"textarea": true,
+	"title":    true,
+}
+
+var allowedLinkSchemes = []string{"http", "https", "mailto"}
+
+// linkRel keeps search engines from rewarding links in comments and marks them as user generated content
+const linkRel = "nofollow ugc"
+
+// safeUrl returns the link when it is an absolute link with an allowed scheme. Relative links are not allowed since
+// comments are shown on other sites than the one they are served from.
+func safeUrl(link string) (string, bool) {
+	parsedUrl, err := url.Parse(strings.TrimSpace(link))
+	if err != nil || !slices.Contains(allowedLinkSchemes, strings.ToLower(parsedUrl.Scheme)) {
+		return "", false
+	}
+	if parsedUrl.Scheme != "mailto" && parsedUrl.Host == "" {
+		return "", false
+	}
+	return parsedUrl.String(), true
+}
+
+// Sanitize reduces the HTML to the allowed elements. Links keep their href when it is safe and always get
+// rel="nofollow ugc", all other attributes are removed. Elements that are left open are closed at the end.
+func Sanitize(input string) string {
+	var output strings.Builder
+	tokenizer := html.NewTokenizer(strings.NewReader(input))
+	openElements := make([]string, 0)
+	droppedDepth := 0
+	for {
+		tokenType := tokenizer.Next()
+		switch tokenType {
+		case html.ErrorToken:
+			for i := len(openElements) - 1; i >= 0; i-- {
+				output.WriteString("</" + openElements[i] + ">")
+			}
+			return output.String()
+		case html.TextToken:
+			if droppedDepth == 0 {
+				output.WriteString(html.EscapeString(string(tokenizer.Text())))
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			if droppedElements[token.Data] {
+				if tokenType == html.StartTagToken {
+					droppedDepth++
+				}
+				continue
+			}
+			if droppedDepth > 0 || !allowedElements[token.Data] {
+				continue
+			}
+			output.WriteString(startTag(token))
+			if token.Data != "br" && tokenType == html.StartTagToken {
+				openElements = append(openElements, token.Data)
+			}
+		case html.EndTagToken:
+			token := tokenizer.Token()
+			if droppedElements[token.Data] {
+				droppedDepth = max(droppedDepth-1, 0)
+				continue
+			}
+			if droppedDepth > 0 || !allowedElements[token.Data] {
+				continue
+			}
+			// close the innermost open element of this kind and everything that was left open inside of it, stray
+			// end tags are ignored
+			i := len(openElements) - 1
+			for i >= 0 && openElements[i] != token.Data {
+				i--
+			}
+			if i >= 0 {
+				for j := len(openElements) - 1; j >= i; j-- {
+					output.WriteString("</" + openElements[j] + ">")
+				}
+				openElements = openElements[:i]
+			}
+		}
+	}
+}
+
+func startTag(token html.Token) string {
+	if token.Data != "a" {
+		return "<" + token.Data + ">"
+	}
+	tag := "<a"
+	for _, attribute := range token.Attr {
+		if attribute.Key == "href" && attribute.Namespace == "" {
+			if link, ok := safeUrl(attribute.Val); ok {
+				tag += ` href="` + html.EscapeString(link) + `"`
+			}
+			break
+		}
+	}
+	return tag + ` rel="` + linkRel + `">`
+}
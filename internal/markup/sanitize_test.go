@@ -0,0 +1,32 @@
+package markup
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSanitizeKeepsAllowedElements(t *testing.T) {
+	allowed := `<p><em>a</em> <strong>b</strong> <code>c</code><br></p><pre><code>d</code></pre><blockquote><p>e</p></blockquote>`
+	assert.Equal(t, allowed, Sanitize(allowed))
+}
+
+func TestSanitizeRemovesOtherElementsAndAttributes(t *testing.T) {
+	assert.Equal(t, "<p>Hello world</p>", Sanitize(`<p class="x" onclick="alert(1)">Hello <span style="color: red">world</span><script>alert(1)</script></p>`))
+	assert.Equal(t, "before after", Sanitize(`before <style>p { display: none }</style><img src="x" onerror="alert(1)">after`))
+	assert.Equal(t, "&lt;b&gt;", Sanitize("&lt;b&gt;"))
+}
+
+func TestSanitizeLinks(t *testing.T) {
+	assert.Equal(t, `<a href="https://example.com" rel="nofollow ugc">x</a>`, Sanitize(`<a href="https://example.com" target="_blank" rel="opener">x</a>`))
+	assert.Equal(t, `<a href="mailto:me@example.com" rel="nofollow ugc">x</a>`, Sanitize(`<a href="mailto:me@example.com">x</a>`))
+	assert.Equal(t, `<a rel="nofollow ugc">x</a>`, Sanitize(`<a href="javascript:alert(1)">x</a>`))
+	assert.Equal(t, `<a rel="nofollow ugc">x</a>`, Sanitize(`<a href=" JAVASCRIPT:alert(1)">x</a>`))
+	assert.Equal(t, `<a rel="nofollow ugc">x</a>`, Sanitize(`<a href="//evil.example">x</a>`))
+}
+
+func TestSanitizeBalancesElements(t *testing.T) {
+	assert.Equal(t, "<p><em>open</em></p>", Sanitize("<p><em>open"))
+	assert.Equal(t, "<p><em>a</em></p>b", Sanitize("<p><em>a</p>b</em>"))
+	assert.Equal(t, "<blockquote><blockquote>a</blockquote>b</blockquote>", Sanitize("<blockquote><blockquote>a</blockquote>b</blockquote>"))
+}
@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"strings"
+)
+
+// deriveBlindIndexKey derives the key for the email blind index from an encryption key so that no additional
+// secret has to be configured. Hashing with a key that differs from the encryption key keeps the two independent.
+func deriveBlindIndexKey(encryptionKey []byte) []byte {
+	mac := hmac.New(sha256.New, encryptionKey)
+	mac.Write([]byte("go-commentservice email blind index"))
+	return mac.Sum(nil)
+}
+
+func blindIndex(blindIndexKey []byte, email string) []byte {
+	mac := hmac.New(sha256.New, blindIndexKey)
+	mac.Write([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return mac.Sum(nil)
+}
+
+// emailBlindIndex returns a keyed hash of the normalized email address. It allows looking up users by email
+// without storing the address in plain text, since the key is secret the hash can not be brute forced offline.
+func (store *Store) emailBlindIndex(email string) []byte {
+	return blindIndex(store.Keys.current.blindIndexKey, email)
+}
+
+// emailBlindIndexes returns the blind index of the email for every known key. Users whose data has not been
+// rotated to the current key yet are still indexed with the key their email was encrypted with.
+func (store *Store) emailBlindIndexes(email string) []interface{} {
+	keys := store.Keys.allKeys()
+	indexes := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		indexes = append(indexes, blindIndex(key.blindIndexKey, email))
+	}
+	return indexes
+}
+
+// encryptUserEmails converts databases that still store user email addresses in plain text. It encrypts every
+// address, computes its blind index and then drops the plain text column, all in one transaction. Schema
+// migrations can only run SQL, so this is run after them and does nothing when there is no plain text column.
+func (store *Store) encryptUserEmails() error {
+	var plainTextColumns int
+	err := store.db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('users') WHERE name = 'email'").Scan(&plainTextColumns)
+	if err != nil || plainTextColumns == 0 {
+		return err
+	}
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	//nolint:errcheck
+	defer tx.Rollback()
+	rows, err := tx.Query("SELECT id, email FROM users")
+	if err != nil {
+		return err
+	}
+	type plainTextUser struct {
+		id    int
+		email string
+	}
+	users := make([]plainTextUser, 0)
+	for rows.Next() {
+		var user plainTextUser
+		err = rows.Scan(&user.id, &user.email)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		users = append(users, user)
+	}
+	err = rows.Close()
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		emailEncrypted, err := store.Keys.Encrypt(user.email)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("UPDATE users SET email_encrypted = ?, email_hash = ? WHERE id = ?", emailEncrypted, store.emailBlindIndex(user.email), user.id)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("ALTER TABLE users DROP COLUMN email")
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/aggregat4/go-baselib/migrations"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+)
+
+var TEST_ENCRYPTIONKEY = "12345678901234567890123456789012"
+
+func createTestStore(t *testing.T) *Store {
+	keys, err := NewKeyring(1, []byte(TEST_ENCRYPTIONKEY))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Store{Keys: keys}
+}
+
+func TestFindUserByEmailIgnoresCase(t *testing.T) {
+	store := createTestStore(t)
+	err := store.InitAndVerifyDb(CreateInMemoryDbUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	userId, err := store.CreateUserByEmail("Jane.Doe@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := store.FindUserByEmail(" jane.doe@EXAMPLE.com")
+	assert.Nil(t, err)
+	assert.Equal(t, userId, user.Id)
+	assert.Equal(t, "Jane.Doe@example.com", user.Email, "The original address should be retained")
+}
+
+func TestPlainTextEmailsAreEncryptedByMigration(t *testing.T) {
+	store := createTestStore(t)
+	// simulate a database from before email addresses were encrypted
+	db, err := sql.Open("sqlite3", CreateInMemoryDbUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	err = migrations.MigrateSchema(db, mymigrations[:5])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, email := range []string{"first@example.com", "second@example.com"} {
+		_, err = db.Exec("INSERT INTO users (email, auth_token_created_at, auth_token_sent_to_client) VALUES (?, 0, 0)", email)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.db = db
+	defer store.Close()
+	err = migrations.MigrateSchema(db, mymigrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.encryptUserEmails()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var plainTextColumns int
+	err = db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('users') WHERE name = 'email'").Scan(&plainTextColumns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 0, plainTextColumns, "The plain text column should have been dropped")
+	for _, email := range []string{"first@example.com", "second@example.com"} {
+		user, err := store.FindUserByEmail(email)
+		assert.Nil(t, err)
+		assert.Equal(t, email, user.Email)
+	}
+	// running the conversion again does nothing
+	assert.Nil(t, store.encryptUserEmails())
+}
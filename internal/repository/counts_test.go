@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"aggregat4/go-commentservice/internal/domain"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountApprovedComments(t *testing.T) {
+	store := createTestStore(t)
+	err := store.InitAndVerifyDb(CreateInMemoryDbUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	serviceId := createServiceWithComments(t, store, "blog", domain.CommentStatusApproved, domain.CommentStatusApproved, domain.CommentStatusPendingApproval, domain.CommentStatusRejected)
+	otherServiceId := createServiceWithComments(t, store, "other", domain.CommentStatusApproved)
+	userId, err := store.CreateUserByEmail("author@example.com")
+	assert.Nil(t, err)
+	_, err = store.CreateComment(domain.CommentStatusApproved, serviceId, "blog", userId, "other-post", "A comment", "", "", "", 0, 0)
+	assert.Nil(t, err)
+
+	counts, err := store.CountApprovedComments(serviceId, []string{"post", "other-post", "no-comments"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int{"post": 2, "other-post": 1, "no-comments": 0}, counts)
+
+	counts, err = store.CountApprovedComments(otherServiceId, []string{"post"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int{"post": 1}, counts)
+
+	counts, err = store.CountApprovedComments(serviceId, []string{})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int{}, counts)
+}
+
+func TestCountApprovedCommentsOnlyReadsTheIndex(t *testing.T) {
+	store := createTestStore(t)
+	err := store.InitAndVerifyDb(CreateInMemoryDbUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	rows, err := store.db.Query("EXPLAIN QUERY PLAN "+fmt.Sprintf(countApprovedCommentsQuery, "?,?"), 1, int(domain.CommentStatusApproved), "a", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	plan := ""
+	for rows.Next() {
+		var id, parent, unused int
+		var detail string
+		err = rows.Scan(&id, &parent, &unused, &detail)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan += detail + "\n"
+	}
+	assert.Contains(t, plan, "USING COVERING INDEX comments_post_idx")
+}
@@ -0,0 +1,37 @@
+package repository
+
+import (
+	"aggregat4/go-commentservice/internal/domain"
+	"database/sql"
+	"time"
+)
+
+// GetCommentsChangedAt returns when the approved comments of a post, or of all posts of the service when postKey is
+// empty, last changed. That is the newest of the creation of an approved comment, the edit of a comment and an entry
+// in the moderation log, which also covers comments that were deleted or sent back to moderation. It is the zero
+// time when there never were any comments.
+func (store *Store) GetCommentsChangedAt(serviceId int, postKey string) (time.Time, error) {
+	where := "service_id = ?"
+	scope := []interface{}{serviceId}
+	if postKey != "" {
+		where += " AND post_key = ?"
+		scope = append(scope, postKey)
+	}
+	params := append([]interface{}{int(domain.CommentStatusApproved)}, scope...)
+	params = append(params, scope...)
+	params = append(params, scope...)
+	var changedAt sql.NullInt64
+	err := store.db.QueryRow(
+		`SELECT MAX(changed_at) FROM (
+			SELECT MAX(created_at) AS changed_at FROM comments WHERE status = ? AND `+where+`
+			UNION ALL
+			SELECT MAX(r.replaced_at) FROM comment_revisions r JOIN comments c ON c.id = r.comment_id WHERE `+where+`
+			UNION ALL
+			SELECT MAX(created_at) FROM moderation_log WHERE `+where+`
+		)`,
+		params...).Scan(&changedAt)
+	if err != nil || !changedAt.Valid {
+		return time.Time{}, err
+	}
+	return time.Unix(changedAt.Int64, 0), nil
+}
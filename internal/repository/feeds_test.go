@@ -0,0 +1,62 @@
+package repository
+
+import (
+	"aggregat4/go-commentservice/internal/domain"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetCommentsChangedAt(t *testing.T) {
+	store := createTestStore(t)
+	err := store.InitAndVerifyDb(CreateInMemoryDbUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	serviceId, err := store.CreateService("blog", []string{"https://blog.example.com"}, domain.DefaultServiceAdminRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userId, err := store.CreateUserByEmail("alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	changedAt, err := store.GetCommentsChangedAt(serviceId, "post")
+	assert.Nil(t, err)
+	assert.True(t, changedAt.IsZero())
+
+	approved := createPostComment(t, store, serviceId, userId, "post", domain.CommentStatusApproved, "Approved", 0)
+	pending := createPostComment(t, store, serviceId, userId, "post", domain.CommentStatusPendingApproval, "Pending", 0)
+	other := createPostComment(t, store, serviceId, userId, "other", domain.CommentStatusApproved, "Other post", 0)
+	_, err = store.db.Exec("UPDATE comments SET created_at = ? WHERE id = ?", 1000, approved)
+	assert.Nil(t, err)
+	_, err = store.db.Exec("UPDATE comments SET created_at = ? WHERE id = ?", 3000, pending)
+	assert.Nil(t, err)
+	_, err = store.db.Exec("UPDATE comments SET created_at = ? WHERE id = ?", 2000, other)
+	assert.Nil(t, err)
+
+	changedAt, err = store.GetCommentsChangedAt(serviceId, "post")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Unix(1000, 0), changedAt, "Comments that are not approved do not change the post")
+	changedAt, err = store.GetCommentsChangedAt(serviceId, "")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Unix(2000, 0), changedAt)
+
+	assert.Nil(t, store.UpdateComment(approved, domain.CommentStatusApproved, "Edited", "Alice", "", "", domain.UserActor(userId)))
+	editedAt, err := store.GetCommentsEditedAt([]int{approved, other})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(editedAt))
+	assert.True(t, editedAt[approved].Unix() > 3000)
+	changedAt, err = store.GetCommentsChangedAt(serviceId, "post")
+	assert.Nil(t, err)
+	assert.Equal(t, editedAt[approved], changedAt)
+
+	_, err = store.db.Exec("UPDATE comment_revisions SET replaced_at = ?", 1500)
+	assert.Nil(t, err)
+	assert.Nil(t, store.DeleteComment(other, testAdmin))
+	changedAt, err = store.GetCommentsChangedAt(serviceId, "")
+	assert.Nil(t, err)
+	assert.True(t, changedAt.Unix() > 3000, "Deleting a comment changes the comments of the service")
+}
@@ -0,0 +1,175 @@
+package repository
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aggregat4/go-baselib/crypto"
+)
+
+// Versioned ciphertexts start with this marker followed by the key version as a big endian uint16. Ciphertexts
+// written before keys were versioned have no header, they are decrypted by trying every known key.
+var versionedCiphertextMarker = []byte{0x00, 'k', 'v'}
+
+const versionedCiphertextHeaderLength = 5
+
+const maxKeyVersion = 0xFFFF
+
+type encryptionKey struct {
+	version        int
+	aead           cipher.AEAD
+	blindIndexKey  []byte
+	unsubscribeKey []byte
+	spamTokenKey   []byte
+}
+
+// Keyring encrypts with the current key and decrypts with the current or any of the previous keys. This allows
+// rotating the encryption key: the new key becomes current, the old one is kept as a previous key until all data
+// has been re-encrypted with cmd/rotatekey.
+type Keyring struct {
+	current  encryptionKey
+	previous []encryptionKey
+}
+
+func newEncryptionKey(version int, key []byte) (encryptionKey, error) {
+	if version < 1 || version > maxKeyVersion {
+		return encryptionKey{}, fmt.Errorf("key version must be between 1 and %d", maxKeyVersion)
+	}
+	aead, err := crypto.CreateAes256GcmAead(key)
+	if err != nil {
+		return encryptionKey{}, err
+	}
+	return encryptionKey{
+		version:        version,
+		aead:           aead,
+		blindIndexKey:  deriveBlindIndexKey(key),
+		unsubscribeKey: deriveUnsubscribeKey(key),
+		spamTokenKey:   deriveSpamTokenKey(key),
+	}, nil
+}
+
+func NewKeyring(currentVersion int, currentKey []byte) (*Keyring, error) {
+	current, err := newEncryptionKey(currentVersion, currentKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Keyring{current: current}, nil
+}
+
+// AddPreviousKey registers a key that is only used to decrypt existing data
+func (keyring *Keyring) AddPreviousKey(version int, key []byte) error {
+	if keyring.findKey(version) != nil {
+		return fmt.Errorf("duplicate key version %d", version)
+	}
+	previous, err := newEncryptionKey(version, key)
+	if err != nil {
+		return err
+	}
+	keyring.previous = append(keyring.previous, previous)
+	return nil
+}
+
+// ParseKeyring creates a keyring from hex encoded keys. Previous keys are given as "version:hexkey".
+func ParseKeyring(currentVersion int, currentKeyHex string, previousKeys []string) (*Keyring, error) {
+	currentKey, err := hex.DecodeString(currentKeyHex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %w", err)
+	}
+	keyring, err := NewKeyring(currentVersion, currentKey)
+	if err != nil {
+		return nil, err
+	}
+	for _, previousKey := range previousKeys {
+		versionString, keyHex, found := strings.Cut(strings.TrimSpace(previousKey), ":")
+		if !found {
+			return nil, errors.New("previous encryption keys must have the format version:hexkey")
+		}
+		version, err := strconv.Atoi(versionString)
+		if err != nil {
+			return nil, fmt.Errorf("invalid previous key version %q: %w", versionString, err)
+		}
+		key, err := hex.DecodeString(keyHex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid previous encryption key for version %d: %w", version, err)
+		}
+		err = keyring.AddPreviousKey(version, key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return keyring, nil
+}
+
+func (keyring *Keyring) CurrentVersion() int {
+	return keyring.current.version
+}
+
+func (keyring *Keyring) findKey(version int) *encryptionKey {
+	if keyring.current.version == version {
+		return &keyring.current
+	}
+	for i := range keyring.previous {
+		if keyring.previous[i].version == version {
+			return &keyring.previous[i]
+		}
+	}
+	return nil
+}
+
+func (keyring *Keyring) allKeys() []encryptionKey {
+	return append([]encryptionKey{keyring.current}, keyring.previous...)
+}
+
+func (keyring *Keyring) Encrypt(plaintext string) ([]byte, error) {
+	ciphertext, err := crypto.EncryptAes256(plaintext, keyring.current.aead)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, versionedCiphertextHeaderLength, versionedCiphertextHeaderLength+len(ciphertext))
+	copy(header, versionedCiphertextMarker)
+	binary.BigEndian.PutUint16(header[len(versionedCiphertextMarker):], uint16(keyring.current.version))
+	return append(header, ciphertext...), nil
+}
+
+// parseVersion returns the key version of a versioned ciphertext, ok is false for legacy ciphertexts
+func parseVersion(ciphertext []byte) (version int, ok bool) {
+	if len(ciphertext) < versionedCiphertextHeaderLength || !bytes.HasPrefix(ciphertext, versionedCiphertextMarker) {
+		return 0, false
+	}
+	return int(binary.BigEndian.Uint16(ciphertext[len(versionedCiphertextMarker):])), true
+}
+
+func (keyring *Keyring) Decrypt(ciphertext []byte) (string, error) {
+	if version, ok := parseVersion(ciphertext); ok {
+		if key := keyring.findKey(version); key != nil {
+			plaintext, err := crypto.DecryptAes256(ciphertext[versionedCiphertextHeaderLength:], key.aead)
+			if err == nil {
+				return plaintext, nil
+			}
+		}
+		// a legacy ciphertext can start with the marker by chance, so fall through to trying it as one
+	}
+	for _, key := range keyring.allKeys() {
+		plaintext, err := crypto.DecryptAes256(ciphertext, key.aead)
+		if err == nil {
+			return plaintext, nil
+		}
+	}
+	return "", errors.New("could not decrypt with any of the known keys")
+}
+
+// IsCurrent returns whether the ciphertext was encrypted with the current key and needs no rotation
+func (keyring *Keyring) IsCurrent(ciphertext []byte) bool {
+	version, ok := parseVersion(ciphertext)
+	if !ok || version != keyring.current.version {
+		return false
+	}
+	_, err := crypto.DecryptAes256(ciphertext[versionedCiphertextHeaderLength:], keyring.current.aead)
+	return err == nil
+}
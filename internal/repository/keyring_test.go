@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/aggregat4/go-baselib/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+var TEST_ENCRYPTIONKEY2 = "abcdefghijklmnopqrstuvwxyz123456"
+
+func createTestKeyring(t *testing.T, currentVersion int, currentKey string) *Keyring {
+	keys, err := NewKeyring(currentVersion, []byte(currentKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func TestKeyringRoundTrip(t *testing.T) {
+	keys := createTestKeyring(t, 3, TEST_ENCRYPTIONKEY)
+	ciphertext, err := keys.Encrypt("secret")
+	assert.Nil(t, err)
+	version, ok := parseVersion(ciphertext)
+	assert.True(t, ok)
+	assert.Equal(t, 3, version)
+	assert.True(t, keys.IsCurrent(ciphertext))
+	plaintext, err := keys.Decrypt(ciphertext)
+	assert.Nil(t, err)
+	assert.Equal(t, "secret", plaintext)
+}
+
+func TestKeyringDecryptsWithPreviousKeys(t *testing.T) {
+	oldKeys := createTestKeyring(t, 1, TEST_ENCRYPTIONKEY)
+	ciphertext, err := oldKeys.Encrypt("secret")
+	assert.Nil(t, err)
+	newKeys := createTestKeyring(t, 2, TEST_ENCRYPTIONKEY2)
+	_, err = newKeys.Decrypt(ciphertext)
+	assert.NotNil(t, err, "Data encrypted with an unknown key can not be decrypted")
+	assert.Nil(t, newKeys.AddPreviousKey(1, []byte(TEST_ENCRYPTIONKEY)))
+	plaintext, err := newKeys.Decrypt(ciphertext)
+	assert.Nil(t, err)
+	assert.Equal(t, "secret", plaintext)
+	assert.False(t, newKeys.IsCurrent(ciphertext))
+}
+
+func TestKeyringDecryptsLegacyCiphertexts(t *testing.T) {
+	legacyCipher, err := crypto.CreateAes256GcmAead([]byte(TEST_ENCRYPTIONKEY))
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacyCiphertext, err := crypto.EncryptAes256("secret", legacyCipher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := createTestKeyring(t, 2, TEST_ENCRYPTIONKEY2)
+	assert.Nil(t, keys.AddPreviousKey(1, []byte(TEST_ENCRYPTIONKEY)))
+	plaintext, err := keys.Decrypt(legacyCiphertext)
+	assert.Nil(t, err)
+	assert.Equal(t, "secret", plaintext)
+	assert.False(t, keys.IsCurrent(legacyCiphertext))
+}
+
+func TestParseKeyring(t *testing.T) {
+	currentKeyHex := "0000000000000000000000000000000000000000000000000000000000000002"
+	previousKeyHex := "0000000000000000000000000000000000000000000000000000000000000001"
+	keys, err := ParseKeyring(2, currentKeyHex, []string{"1:" + previousKeyHex})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, keys.CurrentVersion())
+	assert.Equal(t, 1, len(keys.previous))
+
+	_, err = ParseKeyring(2, currentKeyHex, []string{previousKeyHex})
+	assert.NotNil(t, err, "Previous keys need a version")
+	_, err = ParseKeyring(2, currentKeyHex, []string{"2:" + previousKeyHex})
+	assert.NotNil(t, err, "Key versions must be unique")
+	_, err = ParseKeyring(0, currentKeyHex, nil)
+	assert.NotNil(t, err, "Key versions start at 1")
+	_, err = ParseKeyring(1, "abcd", nil)
+	assert.NotNil(t, err, "Keys must be 32 bytes long")
+}
@@ -0,0 +1,141 @@
+package repository
+
+import (
+	"aggregat4/go-commentservice/internal/domain"
+	"strings"
+
+	"github.com/aggregat4/go-baselib/lang"
+)
+
+// UpdateCommentStatus is used for moderation, it changes the status without marking the comment as edited. The
+// rejection reason is only kept for rejected comments.
+func (store *Store) UpdateCommentStatus(commentId int, status domain.CommentStatus, rejectionReason string, actor domain.Actor) error {
+	changed, err := store.UpdateCommentStatuses([]int{commentId}, status, rejectionReason, actor)
+	if err != nil {
+		return err
+	}
+	if changed == 0 {
+		return lang.ErrNotFound
+	}
+	return nil
+}
+
+// UpdateCommentStatuses changes the status of all comments in one transaction, records the changes in the moderation
+// log and returns how many comments were changed. Comments that do not exist are ignored. Comments sent back to
+// moderation are unlearned by the spam classifier.
+func (store *Store) UpdateCommentStatuses(commentIds []int, status domain.CommentStatus, rejectionReason string, actor domain.Actor) (int, error) {
+	if status != domain.CommentStatusRejected {
+		rejectionReason = ""
+	}
+	var rejectionReasonEncrypted []byte
+	if rejectionReason != "" {
+		var err error
+		rejectionReasonEncrypted, err = store.Keys.Encrypt(rejectionReason)
+		if err != nil {
+			return 0, err
+		}
+	}
+	tx, err := store.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	//nolint:errcheck
+	defer tx.Rollback()
+	changed := 0
+	for _, commentId := range commentIds {
+		err = store.logModeration(tx, actor, status, rejectionReason, "id = ?", commentId)
+		if err != nil {
+			return 0, err
+		}
+		if !learnsSpamTokens(status) {
+			err = unlearnSpamTokens(tx, "id = ?", commentId)
+			if err != nil {
+				return 0, err
+			}
+		}
+		result, err := tx.Exec(
+			"UPDATE comments SET status = ?, rejection_reason_encrypted = ? WHERE id = ?",
+			int(status), rejectionReasonEncrypted, commentId)
+		if err != nil {
+			return 0, err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		changed += int(rowsAffected)
+	}
+	return changed, tx.Commit()
+}
+
+// DeleteComment deletes a single comment and records the deletion in the moderation log, replies to the comment are
+// kept
+func (store *Store) DeleteComment(commentId int, actor domain.Actor) error {
+	deleted, err := store.DeleteComments([]int{commentId}, actor)
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return lang.ErrNotFound
+	}
+	return nil
+}
+
+// DeleteComments deletes all comments in one transaction, records the deletions in the moderation log and returns how
+// many comments were deleted. Comments that do not exist are ignored, replies to deleted comments are kept.
+func (store *Store) DeleteComments(commentIds []int, actor domain.Actor) (int, error) {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	//nolint:errcheck
+	defer tx.Rollback()
+	deleted := 0
+	for _, commentId := range commentIds {
+		err = store.logModeration(tx, actor, commentDeleted, "", "id = ?", commentId)
+		if err != nil {
+			return 0, err
+		}
+		err = deleteCommentRevisions(tx, "id = ?", commentId)
+		if err != nil {
+			return 0, err
+		}
+		err = deleteCommentSubscriptions(tx, "id = ?", commentId)
+		if err != nil {
+			return 0, err
+		}
+		err = unlearnSpamTokens(tx, "id = ?", commentId)
+		if err != nil {
+			return 0, err
+		}
+		result, err := tx.Exec("DELETE FROM comments WHERE id = ?", commentId)
+		if err != nil {
+			return 0, err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		deleted += int(rowsAffected)
+	}
+	return deleted, tx.Commit()
+}
+
+// GetCommentsByIds returns the comments with the given ids, ids that do not exist are ignored
+func (store *Store) GetCommentsByIds(commentIds []int) ([]domain.Comment, error) {
+	if len(commentIds) == 0 {
+		return []domain.Comment{}, nil
+	}
+	params := make([]interface{}, len(commentIds))
+	for i, commentId := range commentIds {
+		params[i] = commentId
+	}
+	rows, err := store.db.Query(
+		"SELECT "+commentColumns+" FROM comments WHERE id IN ("+strings.TrimSuffix(strings.Repeat("?,", len(commentIds)), ",")+") ORDER BY id ASC",
+		params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return mapComments(rows, store.Keys)
+}
@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"aggregat4/go-commentservice/internal/domain"
+	"testing"
+
+	"github.com/aggregat4/go-baselib/lang"
+	"github.com/stretchr/testify/assert"
+)
+
+var testAdmin = domain.Actor{Kind: domain.ActorKindAdmin, Id: "admin-subject"}
+
+func TestUpdateCommentStatusKeepsReasonOnlyForRejections(t *testing.T) {
+	store := createTestStore(t)
+	err := store.InitAndVerifyDb(CreateInMemoryDbUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	serviceId := createServiceWithComments(t, store, "blog", domain.CommentStatusPendingApproval)
+	comments := getAllComments(t, store, serviceId)
+	commentId := comments[0].Id
+
+	assert.Nil(t, store.UpdateCommentStatus(commentId, domain.CommentStatusRejected, "Off topic", testAdmin))
+	comment, err := store.GetComment(commentId)
+	assert.Nil(t, err)
+	assert.Equal(t, domain.CommentStatusRejected, comment.Status)
+	assert.Equal(t, "Off topic", comment.RejectionReason)
+	assert.False(t, comment.Edited)
+	var reasonEncrypted []byte
+	err = store.db.QueryRow("SELECT rejection_reason_encrypted FROM comments WHERE id = ?", commentId).Scan(&reasonEncrypted)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(reasonEncrypted), "Off topic", "The reason must be encrypted")
+
+	assert.Nil(t, store.UpdateCommentStatus(commentId, domain.CommentStatusPendingApproval, "ignored", testAdmin))
+	comment, err = store.GetComment(commentId)
+	assert.Nil(t, err)
+	assert.Equal(t, domain.CommentStatusPendingApproval, comment.Status)
+	assert.Equal(t, "", comment.RejectionReason)
+
+	assert.ErrorIs(t, store.UpdateCommentStatus(999, domain.CommentStatusApproved, "", testAdmin), lang.ErrNotFound)
+}
+
+func TestBulkModerationCountsChangedComments(t *testing.T) {
+	store := createTestStore(t)
+	err := store.InitAndVerifyDb(CreateInMemoryDbUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	serviceId := createServiceWithComments(t, store, "blog", domain.CommentStatusPendingApproval, domain.CommentStatusPendingApproval, domain.CommentStatusApproved)
+	commentIds := []int{}
+	for _, comment := range getAllComments(t, store, serviceId) {
+		if comment.Status == domain.CommentStatusPendingApproval {
+			commentIds = append(commentIds, comment.Id)
+		}
+	}
+	commentIds = append(commentIds, 999)
+
+	selected, err := store.GetCommentsByIds(commentIds)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(selected), "Unknown ids should be ignored")
+
+	changed, err := store.UpdateCommentStatuses(commentIds, domain.CommentStatusRejected, "Spam", testAdmin)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, changed)
+	for _, commentId := range commentIds[:2] {
+		comment, err := store.GetComment(commentId)
+		assert.Nil(t, err)
+		assert.Equal(t, domain.CommentStatusRejected, comment.Status)
+		assert.Equal(t, "Spam", comment.RejectionReason)
+	}
+
+	deleted, err := store.DeleteComments(commentIds, testAdmin)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, deleted)
+	remaining := getAllComments(t, store, serviceId)
+	assert.Equal(t, 1, len(remaining))
+	assert.Equal(t, domain.CommentStatusApproved, remaining[0].Status)
+
+	selected, err = store.GetCommentsByIds([]int{})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(selected))
+}
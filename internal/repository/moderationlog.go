@@ -0,0 +1,133 @@
+package repository
+
+import (
+	"aggregat4/go-commentservice/internal/domain"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// commentDeleted is logged as the new status of deleted comments
+const commentDeleted domain.CommentStatus = 0
+
+// logModeration appends an entry to the moderation log for every comment matching the where clause. It has to run in
+// the transaction that changes the comments and before the change, so that the old status can still be read. Comments
+// that already have the new status are not logged.
+func (store *Store) logModeration(tx *sql.Tx, actor domain.Actor, newStatus domain.CommentStatus, reason string, where string, params ...interface{}) error {
+	actorEncrypted, err := store.Keys.Encrypt(actor.Id)
+	if err != nil {
+		return err
+	}
+	var reasonEncrypted []byte
+	if reason != "" {
+		reasonEncrypted, err = store.Keys.Encrypt(reason)
+		if err != nil {
+			return err
+		}
+	}
+	query := `INSERT INTO moderation_log (
+			comment_id, service_id, service_key, post_key, actor_kind, actor_encrypted, actor_hash,
+			old_status, new_status, reason_encrypted
+		) SELECT id, service_id, service_key, post_key, ?, ?, ?, status, ?, ? FROM comments WHERE ` + where
+	// actors are indexed like email addresses so that key rotation can recompute their blind index
+	insertParams := []interface{}{
+		int(actor.Kind), actorEncrypted, store.emailBlindIndex(actor.Id),
+		sql.NullInt64{Int64: int64(newStatus), Valid: newStatus != commentDeleted}, reasonEncrypted,
+	}
+	insertParams = append(insertParams, params...)
+	if newStatus != commentDeleted {
+		query += " AND status != ?"
+		insertParams = append(insertParams, int(newStatus))
+	}
+	_, err = tx.Exec(query, insertParams...)
+	return err
+}
+
+// GetModerationLog returns a page of the moderation log entries matching the filter, newest first. The page starts
+// below the entry id in the cursor, or with the newest entry when the cursor is 0.
+func (store *Store) GetModerationLog(filter domain.ModerationLogFilter, cursor int, limit int) (domain.ModerationLogPage, error) {
+	if len(filter.ServiceIds) == 0 {
+		return domain.ModerationLogPage{Entries: []domain.ModerationLogEntry{}}, nil
+	}
+	query := `SELECT id, comment_id, service_id, service_key, post_key, actor_kind, actor_encrypted, old_status,
+		new_status, reason_encrypted, created_at FROM moderation_log`
+	query += " WHERE service_id IN ("
+	query += strings.TrimSuffix(strings.Repeat("?,", len(filter.ServiceIds)), ",")
+	query += ")"
+	params := make([]interface{}, 0, len(filter.ServiceIds)+8)
+	for _, serviceId := range filter.ServiceIds {
+		params = append(params, serviceId)
+	}
+	if filter.CommentId > 0 {
+		query += " AND comment_id = ?"
+		params = append(params, filter.CommentId)
+	}
+	if filter.Actor.Kind != 0 {
+		query += " AND actor_kind = ?"
+		params = append(params, int(filter.Actor.Kind))
+	}
+	if filter.Actor.Id != "" {
+		actorHashes := store.emailBlindIndexes(filter.Actor.Id)
+		query += " AND actor_hash IN ("
+		query += strings.TrimSuffix(strings.Repeat("?,", len(actorHashes)), ",")
+		query += ")"
+		params = append(params, actorHashes...)
+	}
+	if !filter.From.IsZero() {
+		query += " AND created_at >= ?"
+		params = append(params, filter.From.Unix())
+	}
+	if !filter.To.IsZero() {
+		query += " AND created_at < ?"
+		params = append(params, filter.To.Unix())
+	}
+	if cursor > 0 {
+		query += " AND id < ?"
+		params = append(params, cursor)
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	params = append(params, limit+1)
+	rows, err := store.db.Query(query, params...)
+	if err != nil {
+		return domain.ModerationLogPage{}, err
+	}
+	defer rows.Close()
+	page := domain.ModerationLogPage{Entries: make([]domain.ModerationLogEntry, 0)}
+	for rows.Next() {
+		entry, err := mapModerationLogEntry(rows, store.Keys)
+		if err != nil {
+			return domain.ModerationLogPage{}, err
+		}
+		page.Entries = append(page.Entries, entry)
+	}
+	if len(page.Entries) > limit {
+		page.Entries = page.Entries[:limit]
+		page.NextCursor = page.Entries[limit-1].Id
+	}
+	return page, rows.Err()
+}
+
+func mapModerationLogEntry(rows *sql.Rows, keys *Keyring) (domain.ModerationLogEntry, error) {
+	var entry domain.ModerationLogEntry
+	var actorEncrypted, reasonEncrypted []byte
+	var newStatus sql.NullInt64
+	var createdAt int64
+	err := rows.Scan(&entry.Id, &entry.CommentId, &entry.ServiceId, &entry.ServiceKey, &entry.PostKey, &entry.Actor.Kind,
+		&actorEncrypted, &entry.OldStatus, &newStatus, &reasonEncrypted, &createdAt)
+	if err != nil {
+		return domain.ModerationLogEntry{}, err
+	}
+	entry.Actor.Id, err = keys.Decrypt(actorEncrypted)
+	if err != nil {
+		return domain.ModerationLogEntry{}, err
+	}
+	if len(reasonEncrypted) > 0 {
+		entry.Reason, err = keys.Decrypt(reasonEncrypted)
+		if err != nil {
+			return domain.ModerationLogEntry{}, err
+		}
+	}
+	entry.NewStatus = domain.CommentStatus(newStatus.Int64)
+	entry.CreatedAt = time.Unix(createdAt, 0)
+	return entry, nil
+}
@@ -0,0 +1,141 @@
+package repository
+
+import (
+	"aggregat4/go-commentservice/internal/domain"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func getModerationLog(t *testing.T, store *Store, filter domain.ModerationLogFilter) []domain.ModerationLogEntry {
+	page, err := store.GetModerationLog(filter, 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return page.Entries
+}
+
+func TestModerationLogRecordsStatusChangesAndDeletions(t *testing.T) {
+	store := createTestStore(t)
+	err := store.InitAndVerifyDb(CreateInMemoryDbUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	serviceId, err := store.CreateService("blog", []string{"https://blog.example.com"}, domain.DefaultServiceAdminRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userId, err := store.CreateUserByEmail("alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := domain.UserActor(userId)
+	pending := createPostComment(t, store, serviceId, userId, "post", domain.CommentStatusPendingAuthentication, "Pending", 0)
+	approved := createPostComment(t, store, serviceId, userId, "post", domain.CommentStatusPendingApproval, "Approved", 0)
+
+	assert.Nil(t, store.UpdateComment(pending, domain.CommentStatusPendingApproval, "Confirmed", "", "", "", user))
+	assert.Nil(t, store.UpdateComment(pending, domain.CommentStatusPendingApproval, "Edited again", "", "", "", user))
+	assert.Nil(t, store.UpdateCommentStatus(approved, domain.CommentStatusApproved, "", testAdmin))
+	assert.Nil(t, store.UpdateCommentStatus(pending, domain.CommentStatusRejected, "Off topic", testAdmin))
+	assert.Nil(t, store.DeleteComment(pending, user))
+	_, err = store.DeleteUser(userId)
+	assert.Nil(t, err)
+
+	entries := getModerationLog(t, store, domain.ModerationLogFilter{ServiceIds: []int{serviceId}})
+	// newest first, edits that do not change the status are not logged
+	assert.Equal(t, 5, len(entries))
+	assert.Equal(t, domain.ModerationLogEntry{
+		Id: entries[4].Id, CommentId: pending, ServiceId: serviceId, ServiceKey: "blog", PostKey: "post", Actor: user,
+		OldStatus: domain.CommentStatusPendingAuthentication, NewStatus: domain.CommentStatusPendingApproval, CreatedAt: entries[4].CreatedAt,
+	}, entries[4])
+	assert.Equal(t, approved, entries[3].CommentId)
+	assert.Equal(t, testAdmin, entries[3].Actor)
+	assert.Equal(t, domain.CommentStatusApproved, entries[3].NewStatus)
+	assert.Equal(t, domain.CommentStatusRejected, entries[2].NewStatus)
+	assert.Equal(t, "Off topic", entries[2].Reason)
+	assert.Equal(t, pending, entries[1].CommentId)
+	assert.True(t, entries[1].Deleted())
+	assert.Equal(t, domain.CommentStatusRejected, entries[1].OldStatus)
+	assert.Equal(t, user, entries[1].Actor)
+	// deleting the account deletes the remaining comment
+	assert.Equal(t, approved, entries[0].CommentId)
+	assert.True(t, entries[0].Deleted())
+	assert.Equal(t, domain.CommentStatusApproved, entries[0].OldStatus)
+	assert.Equal(t, user, entries[0].Actor)
+
+	var actorEncrypted, reasonEncrypted []byte
+	err = store.db.QueryRow("SELECT actor_encrypted, reason_encrypted FROM moderation_log WHERE id = ?", entries[2].Id).Scan(&actorEncrypted, &reasonEncrypted)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(actorEncrypted), testAdmin.Id, "The actor must be encrypted")
+	assert.NotContains(t, string(reasonEncrypted), "Off topic", "The reason must be encrypted")
+}
+
+func TestModerationLogIsAppendOnly(t *testing.T) {
+	store := createTestStore(t)
+	err := store.InitAndVerifyDb(CreateInMemoryDbUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	serviceId := createServiceWithComments(t, store, "blog", domain.CommentStatusPendingApproval)
+	commentId := getAllComments(t, store, serviceId)[0].Id
+	assert.Nil(t, store.UpdateCommentStatus(commentId, domain.CommentStatusApproved, "", testAdmin))
+
+	_, err = store.db.Exec("UPDATE moderation_log SET new_status = ?", domain.CommentStatusRejected)
+	assert.NotNil(t, err)
+	_, err = store.db.Exec("DELETE FROM moderation_log")
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, len(getModerationLog(t, store, domain.ModerationLogFilter{ServiceIds: []int{serviceId}})))
+	// key rotation has to be able to re-encrypt the entries
+	batch, err := store.RotateKeysInBatch("moderation_log", 0, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, batch.Processed)
+}
+
+func TestModerationLogFiltersAndPages(t *testing.T) {
+	store := createTestStore(t)
+	err := store.InitAndVerifyDb(CreateInMemoryDbUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	serviceId := createServiceWithComments(t, store, "blog", domain.CommentStatusPendingApproval, domain.CommentStatusPendingApproval, domain.CommentStatusPendingApproval)
+	otherServiceId := createServiceWithComments(t, store, "other", domain.CommentStatusPendingApproval)
+	otherAdmin := domain.Actor{Kind: domain.ActorKindAdmin, Id: "other-admin"}
+	comments := getAllComments(t, store, serviceId)
+	for _, comment := range comments {
+		assert.Nil(t, store.UpdateCommentStatus(comment.Id, domain.CommentStatusApproved, "", testAdmin))
+	}
+	assert.Nil(t, store.UpdateCommentStatus(comments[0].Id, domain.CommentStatusRejected, "", otherAdmin))
+	assert.Nil(t, store.UpdateCommentStatus(getAllComments(t, store, otherServiceId)[0].Id, domain.CommentStatusApproved, "", otherAdmin))
+
+	filter := domain.ModerationLogFilter{ServiceIds: []int{serviceId}}
+	assert.Equal(t, 4, len(getModerationLog(t, store, filter)), "Entries of other services are not included")
+	filter.Actor = otherAdmin
+	assert.Equal(t, 1, len(getModerationLog(t, store, filter)))
+	filter.Actor = domain.Actor{Kind: domain.ActorKindUser}
+	assert.Equal(t, 0, len(getModerationLog(t, store, filter)))
+	filter.Actor = domain.Actor{}
+	filter.CommentId = comments[0].Id
+	assert.Equal(t, 2, len(getModerationLog(t, store, filter)))
+
+	filter.CommentId = 0
+	seen := []int{}
+	cursor := 0
+	for {
+		page, err := store.GetModerationLog(filter, cursor, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range page.Entries {
+			seen = append(seen, entry.Id)
+		}
+		if page.NextCursor == 0 {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	assert.Equal(t, 4, len(seen), "All entries should be seen once")
+	assert.Greater(t, seen[0], seen[3])
+}
@@ -0,0 +1,189 @@
+package repository
+
+import (
+	"aggregat4/go-commentservice/internal/domain"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/aggregat4/go-baselib/lang"
+)
+
+const adminNotificationSettingsColumns = "id, admin_encrypted, email_encrypted, mode, roles, last_digest_at"
+
+func (store *Store) mapAdminNotificationSettings(rows *sql.Rows) (domain.AdminNotificationSettings, error) {
+	var settings domain.AdminNotificationSettings
+	var adminEncrypted, emailEncrypted []byte
+	var roles string
+	var lastDigestAt int64
+	err := rows.Scan(&settings.Id, &adminEncrypted, &emailEncrypted, &settings.Mode, &roles, &lastDigestAt)
+	if err != nil {
+		return domain.AdminNotificationSettings{}, err
+	}
+	settings.AdminId, err = store.Keys.Decrypt(adminEncrypted)
+	if err != nil {
+		return domain.AdminNotificationSettings{}, err
+	}
+	settings.Email, err = store.Keys.Decrypt(emailEncrypted)
+	if err != nil {
+		return domain.AdminNotificationSettings{}, err
+	}
+	settings.Roles = strings.Fields(roles)
+	if lastDigestAt > 0 {
+		settings.LastDigestAt = time.Unix(lastDigestAt, 0)
+	}
+	return settings, nil
+}
+
+// adminHashCondition matches the admin in tables where it may still be indexed with a previous key
+func (store *Store) adminHashCondition(adminId string) (string, []interface{}) {
+	adminHashes := store.emailBlindIndexes(adminId)
+	return "admin_hash IN (" + strings.TrimSuffix(strings.Repeat("?,", len(adminHashes)), ",") + ")", adminHashes
+}
+
+// GetAdminNotificationSettings returns the notification settings of the admin, lang.ErrNotFound when they have none
+func (store *Store) GetAdminNotificationSettings(adminId string) (domain.AdminNotificationSettings, error) {
+	condition, params := store.adminHashCondition(adminId)
+	rows, err := store.db.Query("SELECT "+adminNotificationSettingsColumns+" FROM admin_notification_settings WHERE "+condition, params...)
+	if err != nil {
+		return domain.AdminNotificationSettings{}, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return domain.AdminNotificationSettings{}, lang.ErrNotFound
+	}
+	return store.mapAdminNotificationSettings(rows)
+}
+
+// GetAllAdminNotificationSettings returns the settings of all admins that want to be notified
+func (store *Store) GetAllAdminNotificationSettings() ([]domain.AdminNotificationSettings, error) {
+	rows, err := store.db.Query("SELECT "+adminNotificationSettingsColumns+" FROM admin_notification_settings WHERE mode != ? ORDER BY id ASC", int(domain.AdminNotificationsOff))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	allSettings := make([]domain.AdminNotificationSettings, 0)
+	for rows.Next() {
+		settings, err := store.mapAdminNotificationSettings(rows)
+		if err != nil {
+			return nil, err
+		}
+		allSettings = append(allSettings, settings)
+	}
+	return allSettings, rows.Err()
+}
+
+// SaveAdminNotificationSettings creates or updates the notification settings of the admin. Comments waiting for a
+// digest are dropped when the admin no longer wants digests.
+func (store *Store) SaveAdminNotificationSettings(adminId string, email string, mode domain.AdminNotificationMode, roles []string) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	//nolint:errcheck
+	defer tx.Rollback()
+	condition, params := store.adminHashCondition(adminId)
+	if mode.DigestInterval() == 0 {
+		_, err = tx.Exec("DELETE FROM admin_digest_comments WHERE settings_id IN (SELECT id FROM admin_notification_settings WHERE "+condition+")", params...)
+		if err != nil {
+			return err
+		}
+	}
+	if mode == domain.AdminNotificationsOff {
+		_, err = tx.Exec("DELETE FROM admin_notification_settings WHERE "+condition, params...)
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+	adminEncrypted, err := store.Keys.Encrypt(adminId)
+	if err != nil {
+		return err
+	}
+	emailEncrypted, err := store.Keys.Encrypt(email)
+	if err != nil {
+		return err
+	}
+	// the time of the last digest is kept, so that changing the settings does not lead to an additional digest
+	values := []interface{}{adminEncrypted, store.emailBlindIndex(adminId), emailEncrypted, int(mode), strings.Join(roles, " ")}
+	result, err := tx.Exec(
+		"UPDATE admin_notification_settings SET admin_encrypted = ?, admin_hash = ?, email_encrypted = ?, mode = ?, roles = ? WHERE "+condition,
+		append(values, params...)...)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		_, err = tx.Exec("INSERT INTO admin_notification_settings (admin_encrypted, admin_hash, email_encrypted, mode, roles) VALUES (?, ?, ?, ?, ?)", values...)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// UpdateAdminNotificationRoles replaces the roles of an admin with notification settings, it does nothing for other
+// admins
+func (store *Store) UpdateAdminNotificationRoles(adminId string, roles []string) error {
+	condition, params := store.adminHashCondition(adminId)
+	_, err := store.db.Exec("UPDATE admin_notification_settings SET roles = ? WHERE "+condition, append([]interface{}{strings.Join(roles, " ")}, params...)...)
+	return err
+}
+
+// AddAdminDigestComment adds the comment to the next digest of the admin with the settings
+func (store *Store) AddAdminDigestComment(settingsId int, commentId int) error {
+	_, err := store.db.Exec("INSERT OR IGNORE INTO admin_digest_comments (settings_id, comment_id) VALUES (?, ?)", settingsId, commentId)
+	return err
+}
+
+// GetAdminDigestComments returns the ids of the comments waiting for the digest of the admin with the settings
+func (store *Store) GetAdminDigestComments(settingsId int) ([]int, error) {
+	rows, err := store.db.Query("SELECT comment_id FROM admin_digest_comments WHERE settings_id = ? ORDER BY comment_id ASC", settingsId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	commentIds := make([]int, 0)
+	for rows.Next() {
+		var commentId int
+		err = rows.Scan(&commentId)
+		if err != nil {
+			return nil, err
+		}
+		commentIds = append(commentIds, commentId)
+	}
+	return commentIds, rows.Err()
+}
+
+// CompleteAdminDigest removes the comments of a digest that has been sent and records the time it was sent at. It is
+// only called once the digest is queued, so that the comments stay for the next attempt when that fails. Comments
+// that were added in the meantime wait for the next digest.
+func (store *Store) CompleteAdminDigest(settingsId int, commentIds []int, sentAt time.Time) error {
+	if len(commentIds) == 0 {
+		return nil
+	}
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	//nolint:errcheck
+	defer tx.Rollback()
+	params := []interface{}{settingsId}
+	for _, commentId := range commentIds {
+		params = append(params, commentId)
+	}
+	_, err = tx.Exec(
+		"DELETE FROM admin_digest_comments WHERE settings_id = ? AND comment_id IN ("+strings.TrimSuffix(strings.Repeat("?,", len(commentIds)), ",")+")",
+		params...)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE admin_notification_settings SET last_digest_at = ? WHERE id = ?", sentAt.Unix(), settingsId)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
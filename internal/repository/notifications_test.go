@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"aggregat4/go-commentservice/internal/domain"
+	"testing"
+	"time"
+
+	"github.com/aggregat4/go-baselib/lang"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminNotificationSettings(t *testing.T) {
+	store := createTestStore(t)
+	err := store.InitAndVerifyDb(CreateInMemoryDbUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	_, err = store.GetAdminNotificationSettings("admin-subject")
+	assert.ErrorIs(t, err, lang.ErrNotFound)
+
+	err = store.SaveAdminNotificationSettings("admin-subject", "admin@example.com", domain.AdminNotificationsImmediate, []string{domain.DefaultServiceAdminRole})
+	assert.Nil(t, err)
+	err = store.SaveAdminNotificationSettings("other-subject", "other@example.com", domain.AdminNotificationsDaily, []string{"other-role"})
+	assert.Nil(t, err)
+	settings, err := store.GetAdminNotificationSettings("admin-subject")
+	assert.Nil(t, err)
+	assert.Equal(t, "admin-subject", settings.AdminId)
+	assert.Equal(t, "admin@example.com", settings.Email)
+	assert.Equal(t, domain.AdminNotificationsImmediate, settings.Mode)
+	assert.Equal(t, []string{domain.DefaultServiceAdminRole}, settings.Roles)
+	assert.True(t, settings.LastDigestAt.IsZero())
+
+	err = store.UpdateAdminNotificationRoles("admin-subject", []string{"role-a", "role-b"})
+	assert.Nil(t, err)
+	err = store.UpdateAdminNotificationRoles("unknown-subject", []string{"role-a"})
+	assert.Nil(t, err)
+	settings, err = store.GetAdminNotificationSettings("admin-subject")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"role-a", "role-b"}, settings.Roles)
+
+	allSettings, err := store.GetAllAdminNotificationSettings()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(allSettings))
+
+	// turning notifications off removes the settings
+	err = store.SaveAdminNotificationSettings("admin-subject", "", domain.AdminNotificationsOff, []string{"role-a"})
+	assert.Nil(t, err)
+	_, err = store.GetAdminNotificationSettings("admin-subject")
+	assert.ErrorIs(t, err, lang.ErrNotFound)
+	allSettings, err = store.GetAllAdminNotificationSettings()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(allSettings))
+	assert.Equal(t, "other-subject", allSettings[0].AdminId)
+}
+
+func TestAdminDigestComments(t *testing.T) {
+	store := createTestStore(t)
+	err := store.InitAndVerifyDb(CreateInMemoryDbUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	err = store.SaveAdminNotificationSettings("admin-subject", "admin@example.com", domain.AdminNotificationsHourly, []string{domain.DefaultServiceAdminRole})
+	assert.Nil(t, err)
+	settings, err := store.GetAdminNotificationSettings("admin-subject")
+	assert.Nil(t, err)
+	assert.Nil(t, store.AddAdminDigestComment(settings.Id, 2))
+	assert.Nil(t, store.AddAdminDigestComment(settings.Id, 1))
+	assert.Nil(t, store.AddAdminDigestComment(settings.Id, 2))
+
+	// reading the comments does not remove them, a digest that fails to send is tried again
+	commentIds, err := store.GetAdminDigestComments(settings.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{1, 2}, commentIds)
+	commentIds, err = store.GetAdminDigestComments(settings.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{1, 2}, commentIds)
+	settings, err = store.GetAdminNotificationSettings("admin-subject")
+	assert.Nil(t, err)
+	assert.True(t, settings.LastDigestAt.IsZero())
+
+	// comments added while the digest was sent wait for the next one
+	assert.Nil(t, store.AddAdminDigestComment(settings.Id, 3))
+	sentAt := time.Unix(1_700_000_000, 0)
+	assert.Nil(t, store.CompleteAdminDigest(settings.Id, commentIds, sentAt))
+	commentIds, err = store.GetAdminDigestComments(settings.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{3}, commentIds)
+	settings, err = store.GetAdminNotificationSettings("admin-subject")
+	assert.Nil(t, err)
+	assert.Equal(t, sentAt, settings.LastDigestAt)
+
+	// an empty digest is not recorded as sent
+	assert.Nil(t, store.CompleteAdminDigest(settings.Id, []int{}, sentAt.Add(time.Hour)))
+	settings, err = store.GetAdminNotificationSettings("admin-subject")
+	assert.Nil(t, err)
+	assert.Equal(t, sentAt, settings.LastDigestAt)
+
+	// changing the mode keeps the time of the last digest, dropping digests drops the waiting comments
+	err = store.SaveAdminNotificationSettings("admin-subject", "admin@example.com", domain.AdminNotificationsDaily, []string{domain.DefaultServiceAdminRole})
+	assert.Nil(t, err)
+	settings, err = store.GetAdminNotificationSettings("admin-subject")
+	assert.Nil(t, err)
+	assert.Equal(t, sentAt, settings.LastDigestAt)
+	err = store.SaveAdminNotificationSettings("admin-subject", "admin@example.com", domain.AdminNotificationsImmediate, []string{domain.DefaultServiceAdminRole})
+	assert.Nil(t, err)
+	commentIds, err = store.GetAdminDigestComments(settings.Id)
+	assert.Nil(t, err)
+	assert.Empty(t, commentIds)
+}
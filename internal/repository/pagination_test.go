@@ -0,0 +1,194 @@
+package repository
+
+import (
+	"aggregat4/go-commentservice/internal/domain"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func createPostComment(t *testing.T, store *Store, serviceId int, userId int, postKey string, status domain.CommentStatus, content string, parentCommentId int) int {
+	commentId, err := store.CreateComment(status, serviceId, "blog", userId, postKey, content, "", "", "", parentCommentId, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return commentId
+}
+
+func setCommentCreatedAt(t *testing.T, store *Store, commentId int, createdAt time.Time) {
+	_, err := store.db.Exec("UPDATE comments SET created_at = ? WHERE id = ?", createdAt.Unix(), commentId)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetCommentsPagesThroughAllComments(t *testing.T) {
+	store := createTestStore(t)
+	err := store.InitAndVerifyDb(CreateInMemoryDbUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	// all comments are created in the same second, the id keeps their order stable
+	serviceId := createServiceWithComments(t, store, "blog", domain.CommentStatusApproved, domain.CommentStatusApproved, domain.CommentStatusPendingApproval, domain.CommentStatusRejected, domain.CommentStatusApproved)
+	filter := domain.CommentFilter{ServiceIds: []int{serviceId}}
+	seen := []int{}
+	cursor := domain.CommentCursor{}
+	for _, expectedSize := range []int{2, 2, 1} {
+		page, err := store.GetComments(filter, cursor, 2)
+		assert.Nil(t, err)
+		assert.Equal(t, expectedSize, len(page.Comments))
+		for _, comment := range page.Comments {
+			seen = append(seen, comment.Id)
+		}
+		cursor = page.NextCursor
+	}
+	assert.False(t, cursor.IsValid(), "The last page has no next cursor")
+	assert.Equal(t, 5, len(seen))
+	for i := 1; i < len(seen); i++ {
+		assert.Greater(t, seen[i-1], seen[i], "Comments are ordered newest first")
+	}
+
+	filter.Statuses = []domain.CommentStatus{domain.CommentStatusApproved}
+	page, err := store.GetComments(filter, domain.CommentCursor{}, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(page.Comments))
+}
+
+func TestGetCommentsFilters(t *testing.T) {
+	store := createTestStore(t)
+	err := store.InitAndVerifyDb(CreateInMemoryDbUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	serviceId, err := store.CreateService("blog", []string{"https://blog.example.com"}, domain.DefaultServiceAdminRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, err := store.CreateUserByEmail("alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := store.CreateUserByEmail("bob@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	january := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	february := time.Date(2024, 2, 15, 12, 0, 0, 0, time.UTC)
+	aliceFirst := createPostComment(t, store, serviceId, alice, "first", domain.CommentStatusApproved, "Alice on first", 0)
+	setCommentCreatedAt(t, store, aliceFirst, january)
+	aliceSecond := createPostComment(t, store, serviceId, alice, "second", domain.CommentStatusApproved, "Alice on second", 0)
+	setCommentCreatedAt(t, store, aliceSecond, february)
+	bobFirst := createPostComment(t, store, serviceId, bob, "first", domain.CommentStatusApproved, "Bob on first", 0)
+	setCommentCreatedAt(t, store, bobFirst, february)
+
+	commentIds := func(filter domain.CommentFilter) []int {
+		filter.ServiceIds = []int{serviceId}
+		page, err := store.GetComments(filter, domain.CommentCursor{}, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := []int{}
+		for _, comment := range page.Comments {
+			ids = append(ids, comment.Id)
+		}
+		return ids
+	}
+	assert.Equal(t, []int{bobFirst, aliceFirst}, commentIds(domain.CommentFilter{PostKey: "first"}))
+	assert.Equal(t, []int{aliceSecond, aliceFirst}, commentIds(domain.CommentFilter{AuthorEmail: " Alice@Example.com"}))
+	assert.Equal(t, []int{}, commentIds(domain.CommentFilter{AuthorEmail: "nobody@example.com"}))
+	assert.Equal(t, []int{aliceFirst}, commentIds(domain.CommentFilter{To: january.AddDate(0, 0, 1)}))
+	assert.Equal(t, []int{bobFirst, aliceSecond}, commentIds(domain.CommentFilter{From: february}))
+	assert.Equal(t, []int{bobFirst}, commentIds(domain.CommentFilter{From: february, PostKey: "first", AuthorEmail: "bob@example.com"}))
+}
+
+func TestGetCommentThreadsForPostPagesByThread(t *testing.T) {
+	store := createTestStore(t)
+	err := store.InitAndVerifyDb(CreateInMemoryDbUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	serviceId, err := store.CreateService("blog", []string{"https://blog.example.com"}, domain.DefaultServiceAdminRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userId, err := store.CreateUserByEmail("alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := createPostComment(t, store, serviceId, userId, "post", domain.CommentStatusApproved, "First", 0)
+	second := createPostComment(t, store, serviceId, userId, "post", domain.CommentStatusApproved, "Second", 0)
+	reply := createPostComment(t, store, serviceId, userId, "post", domain.CommentStatusApproved, "Reply to first", first)
+	nestedReply := createPostComment(t, store, serviceId, userId, "post", domain.CommentStatusApproved, "Reply to reply", reply)
+	createPostComment(t, store, serviceId, userId, "post", domain.CommentStatusPendingApproval, "Pending reply to first", first)
+	rejected := createPostComment(t, store, serviceId, userId, "post", domain.CommentStatusRejected, "Rejected", 0)
+	orphan := createPostComment(t, store, serviceId, userId, "post", domain.CommentStatusApproved, "Reply to rejected", rejected)
+	createPostComment(t, store, serviceId, userId, "otherpost", domain.CommentStatusApproved, "Other post", 0)
+
+	pages := [][]int{}
+	cursor := domain.CommentCursor{}
+	for {
+		page, err := store.GetCommentThreadsForPost(serviceId, "post", cursor, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := []int{}
+		for _, comment := range page.Comments {
+			ids = append(ids, comment.Id)
+		}
+		pages = append(pages, ids)
+		if !page.NextCursor.IsValid() {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	assert.Equal(t, [][]int{{first, reply, nestedReply}, {second}, {orphan}}, pages)
+}
+
+func TestGetCommentThreadsForPostKeepsRepliesToHiddenCommentsInTheirThread(t *testing.T) {
+	store := createTestStore(t)
+	err := store.InitAndVerifyDb(CreateInMemoryDbUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	serviceId, err := store.CreateService("blog", []string{"https://blog.example.com"}, domain.DefaultServiceAdminRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userId, err := store.CreateUserByEmail("alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	approved := createPostComment(t, store, serviceId, userId, "post", domain.CommentStatusApproved, "Approved", 0)
+	rejected := createPostComment(t, store, serviceId, userId, "post", domain.CommentStatusRejected, "Rejected reply", approved)
+	nestedReply := createPostComment(t, store, serviceId, userId, "post", domain.CommentStatusApproved, "Reply to the rejected reply", rejected)
+	second := createPostComment(t, store, serviceId, userId, "post", domain.CommentStatusApproved, "Second", 0)
+
+	page, err := store.GetCommentThreadsForPost(serviceId, "post", domain.CommentCursor{}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []int{}
+	for _, comment := range page.Comments {
+		ids = append(ids, comment.Id)
+	}
+	assert.Equal(t, []int{approved, nestedReply}, ids, "The reply belongs to the thread of the approved comment")
+	assert.Equal(t, map[int]int{rejected: approved}, page.HiddenParents)
+	threads := domain.BuildCommentThreads(page.Comments, page.HiddenParents)
+	assert.Equal(t, 1, len(threads))
+	assert.Equal(t, rejected, threads[0].Replies[0].Comment.Id)
+	assert.False(t, threads[0].Replies[0].Available)
+	assert.Equal(t, nestedReply, threads[0].Replies[0].Replies[0].Comment.Id)
+
+	page, err = store.GetCommentThreadsForPost(serviceId, "post", page.NextCursor, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(page.Comments))
+	assert.Equal(t, second, page.Comments[0].Id)
+	assert.False(t, page.NextCursor.IsValid())
+}
@@ -2,21 +2,20 @@ package repository
 
 import (
 	"aggregat4/go-commentservice/internal/domain"
-	"crypto/cipher"
 	"database/sql"
 	"errors"
 	"fmt"
 	"strings"
 	"time"
 
-	"github.com/aggregat4/go-baselib/crypto"
 	"github.com/aggregat4/go-baselib/lang"
 	"github.com/aggregat4/go-baselib/migrations"
 )
 
 type Store struct {
-	db     *sql.DB
-	Cipher cipher.AEAD
+	db *sql.DB
+	// Keys encrypts all personal data, see Keyring
+	Keys *Keyring
 }
 
 func CreateFileDbUrl(dbName string) string {
@@ -33,7 +32,19 @@ func (store *Store) InitAndVerifyDb(dbUrl string) error {
 	if err != nil {
 		return err
 	}
-	return migrations.MigrateSchema(store.db, mymigrations)
+	if dbUrl == CreateInMemoryDbUrl() {
+		// every connection to an in-memory database gets its own empty database, make sure that
+		// background workers use the same connection as everybody else
+		store.db.SetMaxOpenConns(1)
+	}
+	if store.Keys == nil {
+		return errors.New("a keyring is required")
+	}
+	err = migrations.MigrateSchema(store.db, mymigrations)
+	if err != nil {
+		return err
+	}
+	return store.encryptUserEmails()
 }
 
 func (store *Store) Close() error {
@@ -41,47 +52,103 @@ func (store *Store) Close() error {
 }
 
 func (store *Store) GetServiceForKey(serviceKey string) (*domain.Service, error) {
-	rows, err := store.db.Query("SELECT id, origin FROM services WHERE service_key = ?", serviceKey)
+	rows, err := store.db.Query("SELECT "+serviceColumns+" FROM services s WHERE s.service_key = ?", serviceKey)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	if rows.Next() {
-		var serviceId int
-		var origin string
-		err = rows.Scan(&serviceId, &origin)
+		service, err := mapService(rows)
 		if err != nil {
 			return nil, err
 		}
-		return &domain.Service{Id: serviceId, ServiceKey: serviceKey, Origin: origin}, nil
+		return &service, nil
 	} else {
 		return nil, lang.ErrNotFound
 	}
 }
 
 func (store *Store) FindServiceById(serviceId int) (domain.Service, error) {
-	rows, err := store.db.Query("SELECT service_key, origin FROM services WHERE id = ?", serviceId)
+	rows, err := store.db.Query("SELECT "+serviceColumns+" FROM services s WHERE s.id = ?", serviceId)
 	if err != nil {
 		return domain.Service{}, err
 	}
 	defer rows.Close()
 	if rows.Next() {
-		var serviceKey string
-		var origin string
-		err = rows.Scan(&serviceKey, &origin)
-		if err != nil {
-			return domain.Service{}, err
-		}
-		return domain.Service{Id: serviceId, ServiceKey: serviceKey, Origin: origin}, nil
+		return mapService(rows)
 	} else {
 		return domain.Service{}, lang.ErrNotFound
 	}
 }
 
-func mapComments(rows *sql.Rows, cipher cipher.AEAD) ([]domain.Comment, error) {
+func (store *Store) GetServices() ([]domain.Service, error) {
+	rows, err := store.db.Query("SELECT " + serviceColumns + " FROM services s ORDER BY s.service_key ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return mapServices(rows)
+}
+
+// FindServicesByAdminRoles returns the services that can be administered with any of the roles
+func (store *Store) FindServicesByAdminRoles(roles []string) ([]domain.Service, error) {
+	if len(roles) == 0 {
+		return []domain.Service{}, nil
+	}
+	params := make([]interface{}, len(roles))
+	for i, role := range roles {
+		params[i] = role
+	}
+	rows, err := store.db.Query(
+		"SELECT "+serviceColumns+" FROM services s WHERE s.admin_role IN ("+strings.TrimSuffix(strings.Repeat("?,", len(roles)), ",")+") ORDER BY s.service_key ASC",
+		params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return mapServices(rows)
+}
+
+// serviceColumns selects a service from "services s" with its origins as a space separated list, origins never
+// contain whitespace
+const serviceColumns = "s.id, s.service_key, (SELECT GROUP_CONCAT(o.origin, ' ') FROM service_origins o WHERE o.service_id = s.id), s.admin_role, s.edit_policy, s.edit_grace_window_seconds"
+
+func mapService(rows *sql.Rows, extraColumns ...interface{}) (domain.Service, error) {
+	var service domain.Service
+	var origins sql.NullString
+	var editGraceWindowSeconds int64
+	err := rows.Scan(append([]interface{}{&service.Id, &service.ServiceKey, &origins, &service.AdminRole, &service.EditPolicy, &editGraceWindowSeconds}, extraColumns...)...)
+	service.Origins = strings.Fields(origins.String)
+	service.EditGraceWindow = time.Duration(editGraceWindowSeconds) * time.Second
+	return service, err
+}
+
+func insertServiceOrigins(tx *sql.Tx, serviceId int, origins []string) error {
+	for _, origin := range origins {
+		_, err := tx.Exec("INSERT INTO service_origins (service_id, origin) VALUES (?, ?)", serviceId, origin)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func mapServices(rows *sql.Rows) ([]domain.Service, error) {
+	services := make([]domain.Service, 0)
+	for rows.Next() {
+		service, err := mapService(rows)
+		if err != nil {
+			return nil, err
+		}
+		services = append(services, service)
+	}
+	return services, rows.Err()
+}
+
+func mapComments(rows *sql.Rows, keys *Keyring) ([]domain.Comment, error) {
 	comments := make([]domain.Comment, 0)
 	for rows.Next() {
-		comment, err := mapComment(rows, cipher)
+		comment, err := mapComment(rows, keys)
 		if err != nil {
 			return nil, err
 		}
@@ -90,80 +157,296 @@ func mapComments(rows *sql.Rows, cipher cipher.AEAD) ([]domain.Comment, error) {
 	return comments, nil
 }
 
-func mapComment(rows *sql.Rows, cipher cipher.AEAD) (domain.Comment, error) {
+const commentColumns = "id, status, user_id, service_id, service_key, post_key, comment_encrypted, name_encrypted, website_encrypted, parent_url_encrypted, parent_comment_id, edited, created_at, rejection_reason_encrypted, spam_score"
+
+func mapComment(rows *sql.Rows, keys *Keyring) (domain.Comment, error) {
 	var comment domain.Comment
-	var commentEncrypted, nameEncrypted, websiteEncrypted, parentUrlEncrypted []byte
+	var commentEncrypted, nameEncrypted, websiteEncrypted, parentUrlEncrypted, rejectionReasonEncrypted []byte
+	var parentCommentId sql.NullInt64
 	var edited int
 	var createdAt int64
-	var err = rows.Scan(&comment.Id, &comment.Status, &comment.UserId, &comment.ServiceId, &comment.ServiceKey, &comment.PostKey, &commentEncrypted, &nameEncrypted, &websiteEncrypted, &parentUrlEncrypted, &edited, &createdAt)
+	var err = rows.Scan(&comment.Id, &comment.Status, &comment.UserId, &comment.ServiceId, &comment.ServiceKey, &comment.PostKey, &commentEncrypted, &nameEncrypted, &websiteEncrypted, &parentUrlEncrypted, &parentCommentId, &edited, &createdAt, &rejectionReasonEncrypted, &comment.SpamScore)
 	if err != nil {
 		return domain.Comment{}, err
 	}
 	comment.CreatedAt = time.Unix(createdAt, 0)
-	comment.Comment, err = crypto.DecryptAes256(commentEncrypted, cipher)
+	comment.Comment, err = keys.Decrypt(commentEncrypted)
 	if err != nil {
 		return domain.Comment{}, err
 	}
-	comment.Name, err = crypto.DecryptAes256(nameEncrypted, cipher)
+	comment.Name, err = keys.Decrypt(nameEncrypted)
 	if err != nil {
 		return domain.Comment{}, err
 	}
-	comment.Website, err = crypto.DecryptAes256(websiteEncrypted, cipher)
+	comment.Website, err = keys.Decrypt(websiteEncrypted)
 	if err != nil {
 		return domain.Comment{}, err
 	}
 	if len(parentUrlEncrypted) > 0 {
-		comment.ParentUrl, err = crypto.DecryptAes256(parentUrlEncrypted, cipher)
+		comment.ParentUrl, err = keys.Decrypt(parentUrlEncrypted)
 		if err != nil {
 			return domain.Comment{}, err
 		}
 	} else {
 		comment.ParentUrl = ""
 	}
+	if parentCommentId.Valid {
+		comment.ParentCommentId = int(parentCommentId.Int64)
+	}
+	if len(rejectionReasonEncrypted) > 0 {
+		comment.RejectionReason, err = keys.Decrypt(rejectionReasonEncrypted)
+		if err != nil {
+			return domain.Comment{}, err
+		}
+	}
 	comment.Edited = edited == 1
 	return comment, nil
 }
 
-func (store *Store) GetCommentsForPost(serviceId int, postKey string) ([]domain.Comment, error) {
-	rows, err := store.db.Query("SELECT id, status, user_id, service_id, service_key, post_key, comment_encrypted, name_encrypted, website_encrypted, parent_url_encrypted, edited, created_at FROM comments WHERE service_id = ? AND post_key = ? AND status = ?", serviceId, postKey, domain.CommentStatusApproved)
+// GetCommentThreadsForPost returns a page of the approved comment threads of a post, oldest first. A page holds up
+// to limit threads together with all their approved replies, in chronological order, also the replies to comments
+// that are not approved. Only approved comments without an approved ancestor start a thread. The comments that are
+// not approved but have approved replies on the page are returned as HiddenParents, see domain.BuildCommentThreads.
+func (store *Store) GetCommentThreadsForPost(serviceId int, postKey string, after domain.CommentCursor, limit int) (domain.CommentPage, error) {
+	// ancestors pairs every approved comment with the parents up its chain of ancestors that are not approved
+	query := `WITH RECURSIVE ancestors(id, parent_id) AS (
+			SELECT id, parent_comment_id FROM comments WHERE service_id = ? AND post_key = ? AND status = ?
+			UNION
+			SELECT a.id, p.parent_comment_id FROM ancestors a JOIN comments p ON p.id = a.parent_id WHERE p.status != ?
+		)
+		SELECT id, created_at FROM comments c
+		WHERE service_id = ? AND post_key = ? AND status = ?
+		AND NOT EXISTS (SELECT 1 FROM ancestors a JOIN comments p ON p.id = a.parent_id WHERE a.id = c.id AND p.status = ?)`
+	params := []interface{}{
+		serviceId, postKey, domain.CommentStatusApproved, domain.CommentStatusApproved,
+		serviceId, postKey, domain.CommentStatusApproved, domain.CommentStatusApproved,
+	}
+	if after.IsValid() {
+		query += " AND (created_at > ? OR (created_at = ? AND id > ?))"
+		params = append(params, after.CreatedAt.Unix(), after.CreatedAt.Unix(), after.Id)
+	}
+	query += " ORDER BY created_at ASC, id ASC LIMIT ?"
+	params = append(params, limit+1)
+	rows, err := store.db.Query(query, params...)
+	if err != nil {
+		return domain.CommentPage{}, err
+	}
+	roots := make([]domain.CommentCursor, 0, limit+1)
+	for rows.Next() {
+		var root domain.CommentCursor
+		var createdAt int64
+		err = rows.Scan(&root.Id, &createdAt)
+		if err != nil {
+			rows.Close()
+			return domain.CommentPage{}, err
+		}
+		root.CreatedAt = time.Unix(createdAt, 0)
+		roots = append(roots, root)
+	}
+	err = rows.Close()
+	if err != nil {
+		return domain.CommentPage{}, err
+	}
+	page := domain.CommentPage{Comments: []domain.Comment{}}
+	if len(roots) > limit {
+		roots = roots[:limit]
+		page.NextCursor = roots[limit-1]
+	}
+	if len(roots) == 0 {
+		return page, nil
+	}
+	params = make([]interface{}, 0, len(roots)+1)
+	for _, root := range roots {
+		params = append(params, root.Id)
+	}
+	params = append(params, domain.CommentStatusApproved)
+	// the replies are found through comments that are not approved as well, only the approved ones are returned
+	rows, err = store.db.Query(
+		`WITH RECURSIVE thread(id) AS (
+			SELECT id FROM comments WHERE id IN (`+strings.TrimSuffix(strings.Repeat("?,", len(roots)), ",")+`)
+			UNION
+			SELECT c.id FROM comments c JOIN thread t ON c.parent_comment_id = t.id
+		)
+		SELECT `+commentColumns+` FROM comments WHERE id IN (SELECT id FROM thread) AND status = ? ORDER BY created_at ASC, id ASC`,
+		params...)
+	if err != nil {
+		return domain.CommentPage{}, err
+	}
+	page.Comments, err = mapComments(rows, store.Keys)
+	rows.Close()
+	if err != nil {
+		return domain.CommentPage{}, err
+	}
+	page.HiddenParents, err = store.getHiddenParents(page.Comments)
+	return page, err
+}
+
+// getHiddenParents returns the parent comment ids of the missing parents of the comments and of their ancestors up to
+// the first comment that is part of the list, 0 for top level comments. Comments that were deleted are not found.
+func (store *Store) getHiddenParents(comments []domain.Comment) (map[int]int, error) {
+	hiddenParents := make(map[int]int)
+	shown := make(map[int]bool, len(comments))
+	for _, comment := range comments {
+		shown[comment.Id] = true
+	}
+	params := make([]interface{}, 0)
+	for _, comment := range comments {
+		if comment.ParentCommentId != 0 && !shown[comment.ParentCommentId] {
+			params = append(params, comment.ParentCommentId)
+		}
+	}
+	if len(params) == 0 {
+		return hiddenParents, nil
+	}
+	params = append(params, domain.CommentStatusApproved)
+	rows, err := store.db.Query(
+		`WITH RECURSIVE hidden(id, parent_id) AS (
+			SELECT id, parent_comment_id FROM comments WHERE id IN (`+strings.TrimSuffix(strings.Repeat("?,", len(params)-1), ",")+`)
+			UNION
+			SELECT p.id, p.parent_comment_id FROM comments p JOIN hidden h ON p.id = h.parent_id WHERE p.status != ?
+		)
+		SELECT id, COALESCE(parent_id, 0) FROM hidden`,
+		params...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	return mapComments(rows, store.Cipher)
+	for rows.Next() {
+		var id, parentId int
+		err = rows.Scan(&id, &parentId)
+		if err != nil {
+			return nil, err
+		}
+		hiddenParents[id] = parentId
+	}
+	return hiddenParents, rows.Err()
 }
 
 func (store *Store) GetCommentsForUser(userId int) ([]domain.Comment, error) {
-	rows, err := store.db.Query("SELECT id, status, user_id, service_id, service_key, post_key, comment_encrypted, name_encrypted, website_encrypted, parent_url_encrypted, edited, created_at FROM comments WHERE user_id = ?", userId)
+	rows, err := store.db.Query("SELECT "+commentColumns+" FROM comments WHERE user_id = ?", userId)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	return mapComments(rows, store.Cipher)
+	return mapComments(rows, store.Keys)
 }
 
-func (store *Store) GetCommentsByStatus(statuses []domain.CommentStatus) ([]domain.Comment, error) {
-	query := "SELECT id, status, user_id, service_id, service_key, post_key, comment_encrypted, name_encrypted, website_encrypted, parent_url_encrypted, edited, created_at FROM comments"
-	if len(statuses) > 0 {
-		query += " WHERE status IN ("
-		query += strings.TrimSuffix(strings.Repeat("?,", len(statuses)), ",")
-		query += ")"
+// countApprovedCommentsQuery is answered from the comments_post_idx index alone
+const countApprovedCommentsQuery = "SELECT post_key, COUNT(*) FROM comments WHERE service_id = ? AND status = ? AND post_key IN (%s) GROUP BY post_key"
+
+// CountApprovedComments returns the number of approved comments of every given post, posts without comments are
+// counted with 0
+func (store *Store) CountApprovedComments(serviceId int, postKeys []string) (map[string]int, error) {
+	counts := make(map[string]int, len(postKeys))
+	if len(postKeys) == 0 {
+		return counts, nil
+	}
+	params := []interface{}{serviceId, int(domain.CommentStatusApproved)}
+	for _, postKey := range postKeys {
+		counts[postKey] = 0
+		params = append(params, postKey)
+	}
+	rows, err := store.db.Query(fmt.Sprintf(countApprovedCommentsQuery, strings.TrimSuffix(strings.Repeat("?,", len(postKeys)), ",")), params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var postKey string
+		var count int
+		err = rows.Scan(&postKey, &count)
+		if err != nil {
+			return nil, err
+		}
+		counts[postKey] = count
 	}
-	query += " ORDER BY created_at DESC"
-	statusInts := make([]interface{}, len(statuses))
-	for i, status := range statuses {
-		statusInts[i] = int(status)
+	return counts, rows.Err()
+}
+
+// GetComments returns a page of the comments matching the filter, newest first. The page starts right after the
+// cursor, or with the newest comment when the cursor is not valid.
+func (store *Store) GetComments(filter domain.CommentFilter, after domain.CommentCursor, limit int) (domain.CommentPage, error) {
+	if len(filter.ServiceIds) == 0 {
+		return domain.CommentPage{Comments: []domain.Comment{}}, nil
+	}
+	query := "SELECT " + commentColumns + " FROM comments"
+	query += " WHERE service_id IN ("
+	query += strings.TrimSuffix(strings.Repeat("?,", len(filter.ServiceIds)), ",")
+	query += ")"
+	params := make([]interface{}, 0, len(filter.ServiceIds)+len(filter.Statuses)+8)
+	for _, serviceId := range filter.ServiceIds {
+		params = append(params, serviceId)
+	}
+	if len(filter.Statuses) > 0 {
+		query += " AND status IN ("
+		query += strings.TrimSuffix(strings.Repeat("?,", len(filter.Statuses)), ",")
+		query += ")"
+		for _, status := range filter.Statuses {
+			params = append(params, int(status))
+		}
 	}
-	rows, err := store.db.Query(query, statusInts...)
+	if filter.CommentId != 0 {
+		query += " AND id = ?"
+		params = append(params, filter.CommentId)
+	}
+	if filter.PostKey != "" {
+		query += " AND post_key = ?"
+		params = append(params, filter.PostKey)
+	}
+	if filter.AuthorEmail != "" {
+		// names are encrypted and can not be searched, authors are found by the blind index of their email address
+		emailHashes := store.emailBlindIndexes(filter.AuthorEmail)
+		query += " AND user_id IN (SELECT id FROM users WHERE email_hash IN ("
+		query += strings.TrimSuffix(strings.Repeat("?,", len(emailHashes)), ",")
+		query += "))"
+		params = append(params, emailHashes...)
+	}
+	if !filter.From.IsZero() {
+		query += " AND created_at >= ?"
+		params = append(params, filter.From.Unix())
+	}
+	if !filter.To.IsZero() {
+		query += " AND created_at < ?"
+		params = append(params, filter.To.Unix())
+	}
+	if after.IsValid() {
+		query += " AND (created_at < ? OR (created_at = ? AND id < ?))"
+		params = append(params, after.CreatedAt.Unix(), after.CreatedAt.Unix(), after.Id)
+	}
+	query += " ORDER BY created_at DESC, id DESC LIMIT ?"
+	params = append(params, limit+1)
+	rows, err := store.db.Query(query, params...)
 	if err != nil {
-		return nil, err
+		return domain.CommentPage{}, err
 	}
 	defer rows.Close()
-	return mapComments(rows, store.Cipher)
+	comments, err := mapComments(rows, store.Keys)
+	if err != nil {
+		return domain.CommentPage{}, err
+	}
+	page := domain.CommentPage{Comments: comments}
+	if len(comments) > limit {
+		page.Comments = comments[:limit]
+		page.NextCursor = domain.CursorAfter(comments[limit-1])
+	}
+	return page, nil
 }
 
-func (store *Store) CreateService(serviceKey string, serviceOrigin string) (int, error) {
-	result, err := store.db.Exec("INSERT INTO services (service_key, origin) VALUES (?, ?)", serviceKey, serviceOrigin)
+func (store *Store) CreateService(serviceKey string, origins []string, adminRole string) (int, error) {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return -1, err
+	}
+	//nolint:errcheck
+	defer tx.Rollback()
+	taken, err := serviceKeyExists(tx, serviceKey)
+	if err != nil {
+		return -1, err
+	}
+	if taken {
+		return -1, ErrServiceKeyTaken
+	}
+	result, err := tx.Exec("INSERT INTO services (service_key, admin_role) VALUES (?, ?)", serviceKey, adminRole)
 	if err != nil {
 		return -1, err
 	}
@@ -171,13 +454,21 @@ func (store *Store) CreateService(serviceKey string, serviceOrigin string) (int,
 	if err != nil {
 		return -1, err
 	}
-	return int(lastInsertId), nil
+	err = insertServiceOrigins(tx, int(lastInsertId), origins)
+	if err != nil {
+		return -1, err
+	}
+	return int(lastInsertId), tx.Commit()
 }
 
 func (store *Store) CreateUserByEmail(email string) (int, error) {
+	emailEncrypted, err := store.Keys.Encrypt(email)
+	if err != nil {
+		return -1, err
+	}
 	result, err := store.db.Exec(
-		"INSERT INTO users (email, auth_token_created_at, auth_token_sent_to_client) VALUES (?, 0, 0)",
-		email)
+		"INSERT INTO users (email_encrypted, email_hash, auth_token_created_at, auth_token_sent_to_client) VALUES (?, ?, 0, 0)",
+		emailEncrypted, store.emailBlindIndex(email))
 	if err != nil {
 		return -1, err
 	}
@@ -203,20 +494,22 @@ func (store *Store) CreateComment(
 	author string,
 	website string,
 	parentUrl string,
+	parentCommentId int,
+	spamScore float64,
 ) (int, error) {
-	commentEncrypted, err := crypto.EncryptAes256(comment, store.Cipher)
+	commentEncrypted, err := store.Keys.Encrypt(comment)
 	if err != nil {
 		return -1, err
 	}
-	authorEncrypted, err := crypto.EncryptAes256(author, store.Cipher)
+	authorEncrypted, err := store.Keys.Encrypt(author)
 	if err != nil {
 		return -1, err
 	}
-	websiteEncrypted, err := crypto.EncryptAes256(website, store.Cipher)
+	websiteEncrypted, err := store.Keys.Encrypt(website)
 	if err != nil {
 		return -1, err
 	}
-	parentUrlEncrypted, err := crypto.EncryptAes256(parentUrl, store.Cipher)
+	parentUrlEncrypted, err := store.Keys.Encrypt(parentUrl)
 	if err != nil {
 		return -1, err
 	}
@@ -225,11 +518,11 @@ func (store *Store) CreateComment(
 		`INSERT INTO comments (
 			status, service_id, service_key, user_id, post_key, 
 			comment_encrypted, name_encrypted, website_encrypted, 
-			parent_url_encrypted, edited
-		) VALUES (?,?,?,?,?,?,?,?,?,?)`,
+			parent_url_encrypted, parent_comment_id, edited, spam_score
+		) VALUES (?,?,?,?,?,?,?,?,?,?,?,?)`,
 		int(status), serviceId, serviceKey, userId, postkey,
 		commentEncrypted, authorEncrypted, websiteEncrypted,
-		parentUrlEncrypted, 0)
+		parentUrlEncrypted, sql.NullInt64{Int64: int64(parentCommentId), Valid: parentCommentId > 0}, 0, spamScore)
 	if err != nil {
 		return -1, err
 	}
@@ -240,32 +533,54 @@ func (store *Store) CreateComment(
 	return int(lastInsertId), nil
 }
 
+// UpdateComment changes the contents of a comment and marks it as edited, the previous version is kept as a revision.
+// The comment gets the given status, see Service.StatusAfterEdit. A status change is recorded in the moderation log.
 func (store *Store) UpdateComment(
 	commentId int,
-	previousStatus domain.CommentStatus,
+	status domain.CommentStatus,
 	comment string,
 	author string,
 	website string,
 	parentUrl string,
+	actor domain.Actor,
 ) error {
-	commentEncrypted, err := crypto.EncryptAes256(comment, store.Cipher)
+	commentEncrypted, err := store.Keys.Encrypt(comment)
 	if err != nil {
 		return err
 	}
-	authorEncrypted, err := crypto.EncryptAes256(author, store.Cipher)
+	authorEncrypted, err := store.Keys.Encrypt(author)
 	if err != nil {
 		return err
 	}
-	websiteEncrypted, err := crypto.EncryptAes256(website, store.Cipher)
+	websiteEncrypted, err := store.Keys.Encrypt(website)
 	if err != nil {
 		return err
 	}
-	parentUrlEncrypted, err := crypto.EncryptAes256(parentUrl, store.Cipher)
+	parentUrlEncrypted, err := store.Keys.Encrypt(parentUrl)
 	if err != nil {
 		return err
 	}
-
-	_, err = store.db.Exec(
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	//nolint:errcheck
+	defer tx.Rollback()
+	err = store.logModeration(tx, actor, status, "", "id = ?", commentId)
+	if err != nil {
+		return err
+	}
+	err = saveCommentRevision(tx, commentId)
+	if err != nil {
+		return err
+	}
+	if !learnsSpamTokens(status) {
+		err = unlearnSpamTokens(tx, "id = ?", commentId)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(
 		`UPDATE comments SET 
 			status = ?, 
 			comment_encrypted = ?, 
@@ -274,21 +589,28 @@ func (store *Store) UpdateComment(
 			parent_url_encrypted = ?,
 			edited = 1 
 		WHERE id = ?`,
-		lang.IfElse(previousStatus == domain.CommentStatusPendingAuthentication,
-			domain.CommentStatusPendingApproval, previousStatus),
+		status,
 		commentEncrypted,
 		authorEncrypted,
 		websiteEncrypted,
 		parentUrlEncrypted,
 		commentId)
-	return err
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func mapOptionalUser(rows *sql.Rows) (domain.User, error) {
+func mapOptionalUser(rows *sql.Rows, keys *Keyring) (domain.User, error) {
 	if rows.Next() {
 		var user domain.User
+		var emailEncrypted []byte
 		var authTokenCreatedAt int64
-		err := rows.Scan(&user.Id, &user.Email, &user.AuthToken, &authTokenCreatedAt, &user.AuthTokenSentToClient)
+		err := rows.Scan(&user.Id, &emailEncrypted, &user.AuthToken, &authTokenCreatedAt, &user.AuthTokenSentToClient)
+		if err != nil {
+			return domain.User{}, err
+		}
+		user.Email, err = keys.Decrypt(emailEncrypted)
 		if err != nil {
 			return domain.User{}, err
 		}
@@ -300,48 +622,50 @@ func mapOptionalUser(rows *sql.Rows) (domain.User, error) {
 }
 
 func (store *Store) FindUserByEmail(email string) (domain.User, error) {
+	emailBlindIndexes := store.emailBlindIndexes(email)
 	rows, err := store.db.Query(
-		"SELECT id, email, COALESCE(auth_token, ''), auth_token_created_at, auth_token_sent_to_client FROM users WHERE email = ?",
-		email)
+		"SELECT id, email_encrypted, COALESCE(auth_token, ''), auth_token_created_at, auth_token_sent_to_client FROM users WHERE email_hash IN ("+
+			strings.TrimSuffix(strings.Repeat("?,", len(emailBlindIndexes)), ",")+") ORDER BY id LIMIT 1",
+		emailBlindIndexes...)
 	if err != nil {
 		return domain.User{}, err
 	}
 	defer rows.Close()
-	return mapOptionalUser(rows)
+	return mapOptionalUser(rows, store.Keys)
 }
 
 func (store *Store) FindUserById(userId int) (domain.User, error) {
 	rows, err := store.db.Query(
-		"SELECT id, email, COALESCE(auth_token, ''), auth_token_created_at, auth_token_sent_to_client FROM users WHERE id = ?",
+		"SELECT id, email_encrypted, COALESCE(auth_token, ''), auth_token_created_at, auth_token_sent_to_client FROM users WHERE id = ?",
 		userId)
 	if err != nil {
 		return domain.User{}, err
 	}
 	defer rows.Close()
-	return mapOptionalUser(rows)
+	return mapOptionalUser(rows, store.Keys)
 }
 
 func (store *Store) FindUserByAuthToken(token string) (domain.User, error) {
 	rows, err := store.db.Query(
-		"SELECT id, email, COALESCE(auth_token, ''), auth_token_created_at, auth_token_sent_to_client FROM users WHERE auth_token = ?",
+		"SELECT id, email_encrypted, COALESCE(auth_token, ''), auth_token_created_at, auth_token_sent_to_client FROM users WHERE auth_token = ?",
 		token)
 	if err != nil {
 		return domain.User{}, err
 	}
 	defer rows.Close()
-	return mapOptionalUser(rows)
+	return mapOptionalUser(rows, store.Keys)
 }
 
 func (store *Store) GetComment(commentId int) (domain.Comment, error) {
 	rows, err := store.db.Query(
-		"SELECT id, status, user_id, service_id, service_key, post_key, comment_encrypted, name_encrypted, website_encrypted, parent_url_encrypted, edited, created_at FROM comments WHERE id = ?",
+		"SELECT "+commentColumns+" FROM comments WHERE id = ?",
 		commentId)
 	if err != nil {
 		return domain.Comment{}, err
 	}
 	defer rows.Close()
 	if rows.Next() {
-		comment, err := mapComment(rows, store.Cipher)
+		comment, err := mapComment(rows, store.Keys)
 		if err != nil {
 			return domain.Comment{}, err
 		}
@@ -351,19 +675,228 @@ func (store *Store) GetComment(commentId int) (domain.Comment, error) {
 	}
 }
 
-func (store *Store) DeleteComment(commentId int) error {
-	result, err := store.db.Exec("DELETE FROM comments WHERE id = ?", commentId)
+func (store *Store) EnqueueEmail(kind string, recipient string, payload string, notBefore time.Time) (int, error) {
+	recipientEncrypted, err := store.Keys.Encrypt(recipient)
+	if err != nil {
+		return -1, err
+	}
+	payloadEncrypted, err := store.Keys.Encrypt(payload)
+	if err != nil {
+		return -1, err
+	}
+	result, err := store.db.Exec(
+		"INSERT INTO email_outbox (kind, recipient_encrypted, payload_encrypted, status, attempts, next_attempt_at) VALUES (?, ?, ?, ?, 0, ?)",
+		kind, recipientEncrypted, payloadEncrypted, int(domain.OutboxStatusPending), notBefore.Unix())
+	if err != nil {
+		return -1, err
+	}
+	lastInsertId, err := result.LastInsertId()
+	if err != nil {
+		return -1, err
+	}
+	return int(lastInsertId), nil
+}
+
+func mapOutboxEmails(rows *sql.Rows, keys *Keyring) ([]domain.OutboxEmail, error) {
+	emails := make([]domain.OutboxEmail, 0)
+	for rows.Next() {
+		var email domain.OutboxEmail
+		var recipientEncrypted, payloadEncrypted, lastErrorEncrypted []byte
+		var nextAttemptAt, createdAt int64
+		var sentAt sql.NullInt64
+		err := rows.Scan(&email.Id, &email.Kind, &recipientEncrypted, &payloadEncrypted, &email.Status, &email.Attempts, &nextAttemptAt, &lastErrorEncrypted, &createdAt, &sentAt)
+		if err != nil {
+			return nil, err
+		}
+		email.Recipient, err = keys.Decrypt(recipientEncrypted)
+		if err != nil {
+			return nil, err
+		}
+		email.Payload, err = keys.Decrypt(payloadEncrypted)
+		if err != nil {
+			return nil, err
+		}
+		if len(lastErrorEncrypted) > 0 {
+			email.LastError, err = keys.Decrypt(lastErrorEncrypted)
+			if err != nil {
+				return nil, err
+			}
+		}
+		email.NextAttemptAt = time.Unix(nextAttemptAt, 0)
+		email.CreatedAt = time.Unix(createdAt, 0)
+		if sentAt.Valid {
+			email.SentAt = time.Unix(sentAt.Int64, 0)
+		}
+		emails = append(emails, email)
+	}
+	return emails, nil
+}
+
+// FindDueEmails returns pending emails whose next delivery attempt is due, oldest first
+func (store *Store) FindDueEmails(now time.Time, limit int) ([]domain.OutboxEmail, error) {
+	rows, err := store.db.Query(
+		"SELECT id, kind, recipient_encrypted, payload_encrypted, status, attempts, next_attempt_at, last_error_encrypted, created_at, sent_at FROM email_outbox WHERE status = ? AND next_attempt_at <= ? ORDER BY next_attempt_at ASC, id ASC LIMIT ?",
+		int(domain.OutboxStatusPending), now.Unix(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return mapOutboxEmails(rows, store.Keys)
+}
+
+// GetOutboxEmails returns the most recent emails in the outbox, optionally filtered by status
+func (store *Store) GetOutboxEmails(statuses []domain.OutboxStatus, limit int) ([]domain.OutboxEmail, error) {
+	query := "SELECT id, kind, recipient_encrypted, payload_encrypted, status, attempts, next_attempt_at, last_error_encrypted, created_at, sent_at FROM email_outbox"
+	if len(statuses) > 0 {
+		query += " WHERE status IN ("
+		query += strings.TrimSuffix(strings.Repeat("?,", len(statuses)), ",")
+		query += ")"
+	}
+	query += " ORDER BY created_at DESC, id DESC LIMIT ?"
+	params := make([]interface{}, 0, len(statuses)+1)
+	for _, status := range statuses {
+		params = append(params, int(status))
+	}
+	params = append(params, limit)
+	rows, err := store.db.Query(query, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return mapOutboxEmails(rows, store.Keys)
+}
+
+func (store *Store) MarkEmailSent(emailId int, attempts int, sentAt time.Time) error {
+	_, err := store.db.Exec(
+		"UPDATE email_outbox SET status = ?, attempts = ?, sent_at = ?, last_error_encrypted = NULL WHERE id = ?",
+		int(domain.OutboxStatusSent), attempts, sentAt.Unix(), emailId)
+	return err
+}
+
+// MarkEmailFailed records a failed delivery attempt. The email is either rescheduled for nextAttemptAt or,
+// when deadLetter is set, moved to the dead letter state where it is not retried anymore.
+func (store *Store) MarkEmailFailed(emailId int, attempts int, nextAttemptAt time.Time, lastError string, deadLetter bool) error {
+	lastErrorEncrypted, err := store.Keys.Encrypt(lastError)
+	if err != nil {
+		return err
+	}
+	_, err = store.db.Exec(
+		"UPDATE email_outbox SET status = ?, attempts = ?, next_attempt_at = ?, last_error_encrypted = ? WHERE id = ?",
+		int(lang.IfElse(deadLetter, domain.OutboxStatusDeadLetter, domain.OutboxStatusPending)), attempts, nextAttemptAt.Unix(), lastErrorEncrypted, emailId)
+	return err
+}
+
+// DeleteSentEmailsBefore removes delivered emails so that we do not keep personal data around longer than needed
+func (store *Store) DeleteSentEmailsBefore(before time.Time) error {
+	_, err := store.db.Exec("DELETE FROM email_outbox WHERE status = ? AND sent_at < ?", int(domain.OutboxStatusSent), before.Unix())
+	return err
+}
+
+// DeleteUser erases the user and everything we store about them: their comments and any emails addressed to them
+// that are still in the outbox. Only an anonymous tombstone is kept so that deletions can be accounted for. It
+// returns the number of comments that were deleted.
+func (store *Store) DeleteUser(userId int) (int, error) {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	//nolint:errcheck
+	defer tx.Rollback()
+	var emailEncrypted []byte
+	err = tx.QueryRow("SELECT email_encrypted FROM users WHERE id = ?", userId).Scan(&emailEncrypted)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, lang.ErrNotFound
+		}
+		return 0, err
+	}
+	email, err := store.Keys.Decrypt(emailEncrypted)
+	if err != nil {
+		return 0, err
+	}
+	err = store.logModeration(tx, domain.UserActor(userId), commentDeleted, "", "user_id = ?", userId)
+	if err != nil {
+		return 0, err
+	}
+	// we do not rely on ON DELETE CASCADE since foreign keys are not enforced on every connection
+	err = deleteCommentRevisions(tx, "user_id = ?", userId)
+	if err != nil {
+		return 0, err
+	}
+	err = unlearnSpamTokens(tx, "user_id = ?", userId)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec("DELETE FROM subscriptions WHERE user_id = ?", userId)
+	if err != nil {
+		return 0, err
+	}
+	result, err := tx.Exec("DELETE FROM comments WHERE user_id = ?", userId)
+	if err != nil {
+		return 0, err
+	}
+	deletedComments, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	err = deleteOutboxEmailsForRecipient(tx, store.Keys, email)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec("DELETE FROM users WHERE id = ?", userId)
+	if err != nil {
+		return 0, err
+	}
+	// only the day is recorded so that the tombstone can not be correlated with other timestamps
+	deletedOn := time.Now().UTC().Truncate(24 * time.Hour)
+	_, err = tx.Exec("INSERT INTO account_deletions (deleted_on, deleted_comments) VALUES (?, ?)", deletedOn.Unix(), deletedComments)
+	if err != nil {
+		return 0, err
+	}
+	return int(deletedComments), tx.Commit()
+}
+
+// deleteOutboxEmailsForRecipient removes all emails for the recipient, since recipients are encrypted every
+// email in the outbox has to be decrypted to find them
+func deleteOutboxEmailsForRecipient(tx *sql.Tx, keys *Keyring, recipient string) error {
+	rows, err := tx.Query("SELECT id, recipient_encrypted FROM email_outbox")
 	if err != nil {
 		return err
 	}
-	rowsAffected, err := result.RowsAffected()
+	emailIds := make([]int, 0)
+	for rows.Next() {
+		var emailId int
+		var recipientEncrypted []byte
+		err = rows.Scan(&emailId, &recipientEncrypted)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		emailRecipient, err := keys.Decrypt(recipientEncrypted)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		if strings.EqualFold(emailRecipient, recipient) {
+			emailIds = append(emailIds, emailId)
+		}
+	}
+	err = rows.Close()
 	if err != nil {
 		return err
-	} else if rowsAffected == 0 {
-		return lang.ErrNotFound
-	} else if rowsAffected > 1 {
-		return errors.New("more than one row was affected by the delete operation")
-	} else {
-		return nil
 	}
+	for _, emailId := range emailIds {
+		_, err = tx.Exec("DELETE FROM email_outbox WHERE id = ?", emailId)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetAccountDeletionStatistics returns how many accounts have been deleted and how many comments were deleted with them
+func (store *Store) GetAccountDeletionStatistics() (domain.AccountDeletionStatistics, error) {
+	var statistics domain.AccountDeletionStatistics
+	err := store.db.QueryRow("SELECT COUNT(*), COALESCE(SUM(deleted_comments), 0) FROM account_deletions").Scan(&statistics.DeletedAccounts, &statistics.DeletedComments)
+	return statistics, err
 }
@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"aggregat4/go-commentservice/internal/domain"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/aggregat4/go-baselib/lang"
+)
+
+// saveCommentRevision keeps the current contents of the comment as its next revision. It has to run in the
+// transaction that edits the comment and before the edit. The encrypted values are copied as they are.
+func saveCommentRevision(tx *sql.Tx, commentId int) error {
+	_, err := tx.Exec(
+		`INSERT INTO comment_revisions (comment_id, revision, comment_encrypted, name_encrypted, website_encrypted)
+		SELECT id, (SELECT COALESCE(MAX(revision), 0) + 1 FROM comment_revisions WHERE comment_id = comments.id),
+			comment_encrypted, name_encrypted, website_encrypted
+		FROM comments WHERE id = ?`,
+		commentId)
+	return err
+}
+
+// deleteCommentRevisions removes the revisions of the comments matching the where clause, it has to run in the
+// transaction that deletes the comments
+func deleteCommentRevisions(tx *sql.Tx, where string, params ...interface{}) error {
+	_, err := tx.Exec("DELETE FROM comment_revisions WHERE comment_id IN (SELECT id FROM comments WHERE "+where+")", params...)
+	return err
+}
+
+// GetCommentRevisions returns the earlier versions of a comment ordered by revision, it is empty for comments that
+// were never edited
+func (store *Store) GetCommentRevisions(commentId int) ([]domain.CommentRevision, error) {
+	revisions, err := store.getCommentRevisions("r.comment_id = ?", commentId)
+	if err != nil {
+		return nil, err
+	}
+	return lang.IfElse(revisions[commentId] == nil, []domain.CommentRevision{}, revisions[commentId]), nil
+}
+
+// GetCommentRevisionsForUser returns the earlier versions of all comments of the user by comment id
+func (store *Store) GetCommentRevisionsForUser(userId int) (map[int][]domain.CommentRevision, error) {
+	return store.getCommentRevisions("c.user_id = ?", userId)
+}
+
+// GetCommentsEditedAt returns when each of the comments was last edited, comments that were never edited are
+// missing from the result
+func (store *Store) GetCommentsEditedAt(commentIds []int) (map[int]time.Time, error) {
+	editedAt := make(map[int]time.Time)
+	if len(commentIds) == 0 {
+		return editedAt, nil
+	}
+	params := make([]interface{}, 0, len(commentIds))
+	for _, commentId := range commentIds {
+		params = append(params, commentId)
+	}
+	rows, err := store.db.Query(
+		"SELECT comment_id, MAX(replaced_at) FROM comment_revisions WHERE comment_id IN ("+
+			strings.TrimSuffix(strings.Repeat("?,", len(commentIds)), ",")+") GROUP BY comment_id",
+		params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var commentId int
+		var replacedAt int64
+		err = rows.Scan(&commentId, &replacedAt)
+		if err != nil {
+			return nil, err
+		}
+		editedAt[commentId] = time.Unix(replacedAt, 0)
+	}
+	return editedAt, rows.Err()
+}
+
+func (store *Store) getCommentRevisions(where string, params ...interface{}) (map[int][]domain.CommentRevision, error) {
+	rows, err := store.db.Query(
+		`SELECT r.comment_id, r.revision, r.comment_encrypted, r.name_encrypted, r.website_encrypted, r.replaced_at,
+			c.created_at
+		FROM comment_revisions r JOIN comments c ON c.id = r.comment_id
+		WHERE `+where+` ORDER BY r.comment_id ASC, r.revision ASC`,
+		params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	revisionsByComment := make(map[int][]domain.CommentRevision)
+	for rows.Next() {
+		var commentId int
+		var revision domain.CommentRevision
+		var commentEncrypted, nameEncrypted, websiteEncrypted []byte
+		var replacedAt, commentCreatedAt int64
+		err = rows.Scan(&commentId, &revision.Revision, &commentEncrypted, &nameEncrypted, &websiteEncrypted, &replacedAt, &commentCreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		revision.Comment, err = store.Keys.Decrypt(commentEncrypted)
+		if err != nil {
+			return nil, err
+		}
+		revision.Name, err = store.Keys.Decrypt(nameEncrypted)
+		if err != nil {
+			return nil, err
+		}
+		revision.Website, err = store.Keys.Decrypt(websiteEncrypted)
+		if err != nil {
+			return nil, err
+		}
+		revision.ReplacedAt = time.Unix(replacedAt, 0)
+		// a version was written when the comment was created or when the version before it was replaced
+		revisions := revisionsByComment[commentId]
+		if len(revisions) == 0 {
+			revision.CreatedAt = time.Unix(commentCreatedAt, 0)
+		} else {
+			revision.CreatedAt = revisions[len(revisions)-1].ReplacedAt
+		}
+		revisionsByComment[commentId] = append(revisions, revision)
+	}
+	return revisionsByComment, rows.Err()
+}
@@ -0,0 +1,62 @@
+package repository
+
+import (
+	"aggregat4/go-commentservice/internal/domain"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateCommentKeepsRevisions(t *testing.T) {
+	store := createTestStore(t)
+	err := store.InitAndVerifyDb(CreateInMemoryDbUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	serviceId, err := store.CreateService("blog", []string{"https://blog.example.com"}, domain.DefaultServiceAdminRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userId, err := store.CreateUserByEmail("alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := domain.UserActor(userId)
+	commentId := createPostComment(t, store, serviceId, userId, "post", domain.CommentStatusApproved, "First version", 0)
+	other := createPostComment(t, store, serviceId, userId, "post", domain.CommentStatusApproved, "Never edited", 0)
+
+	assert.Nil(t, store.UpdateComment(commentId, domain.CommentStatusApproved, "Second version", "Alice", "", "", user))
+	assert.Nil(t, store.UpdateComment(commentId, domain.CommentStatusApproved, "Third version", "Alice", "https://alice.example.com", "", user))
+
+	revisions, err := store.GetCommentRevisions(commentId)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(revisions))
+	assert.Equal(t, 1, revisions[0].Revision)
+	assert.Equal(t, "First version", revisions[0].Comment)
+	assert.Equal(t, "", revisions[0].Name)
+	assert.Equal(t, 2, revisions[1].Revision)
+	assert.Equal(t, "Second version", revisions[1].Comment)
+	assert.Equal(t, "Alice", revisions[1].Name)
+	assert.Equal(t, revisions[0].ReplacedAt, revisions[1].CreatedAt)
+	comment, err := store.GetComment(commentId)
+	assert.Nil(t, err)
+	assert.Equal(t, "Third version", comment.Comment)
+	assert.True(t, comment.Edited)
+
+	revisions, err = store.GetCommentRevisions(other)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(revisions))
+
+	// revisions are stored encrypted
+	var plainTextRevisions int
+	err = store.db.QueryRow("SELECT COUNT(*) FROM comment_revisions WHERE comment_encrypted LIKE '%version%'").Scan(&plainTextRevisions)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, plainTextRevisions)
+
+	assert.Nil(t, store.DeleteComment(commentId, testAdmin))
+	var remainingRevisions int
+	err = store.db.QueryRow("SELECT COUNT(*) FROM comment_revisions").Scan(&remainingRevisions)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, remainingRevisions, "Revisions are deleted with their comment")
+}
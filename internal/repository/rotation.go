@@ -0,0 +1,154 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// encryptedTable describes a table with encrypted columns that have to be re-encrypted when rotating keys
+type encryptedTable struct {
+	name    string
+	columns []string
+	// blindIndexColumn is recomputed from the first encrypted column since the blind index key changes with the key
+	blindIndexColumn string
+}
+
+var encryptedTables = []encryptedTable{
+	{name: "comments", columns: []string{"comment_encrypted", "name_encrypted", "website_encrypted", "parent_url_encrypted", "rejection_reason_encrypted"}},
+	{name: "users", columns: []string{"email_encrypted"}, blindIndexColumn: "email_hash"},
+	{name: "email_outbox", columns: []string{"recipient_encrypted", "payload_encrypted", "last_error_encrypted"}},
+	{name: "comment_revisions", columns: []string{"comment_encrypted", "name_encrypted", "website_encrypted"}},
+	{name: "moderation_log", columns: []string{"actor_encrypted", "reason_encrypted"}, blindIndexColumn: "actor_hash"},
+	{name: "admin_notification_settings", columns: []string{"admin_encrypted", "email_encrypted"}, blindIndexColumn: "admin_hash"},
+}
+
+// EncryptedTables returns the names of all tables that contain encrypted data, in the order they should be rotated
+func EncryptedTables() []string {
+	names := make([]string, 0, len(encryptedTables))
+	for _, table := range encryptedTables {
+		names = append(names, table.name)
+	}
+	return names
+}
+
+func findEncryptedTable(name string) (encryptedTable, error) {
+	for _, table := range encryptedTables {
+		if table.name == name {
+			return table, nil
+		}
+	}
+	return encryptedTable{}, fmt.Errorf("%s is not a table with encrypted data", name)
+}
+
+// KeyRotationBatch reports on one batch of rows that was checked and, where necessary, re-encrypted
+type KeyRotationBatch struct {
+	// LastId is the id of the last row in the batch, the next batch continues after it
+	LastId    int
+	Processed int
+	Rotated   int
+	Done      bool
+}
+
+func (store *Store) CountRows(tableName string) (int, error) {
+	table, err := findEncryptedTable(tableName)
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	err = store.db.QueryRow("SELECT COUNT(*) FROM " + table.name).Scan(&count)
+	return count, err
+}
+
+// RotateKeysInBatch re-encrypts up to batchSize rows with an id larger than afterId with the current key. Rows that
+// are already encrypted with the current key are left alone, so running it again after an interruption only does
+// the remaining work. Every batch is committed on its own.
+func (store *Store) RotateKeysInBatch(tableName string, afterId int, batchSize int) (KeyRotationBatch, error) {
+	table, err := findEncryptedTable(tableName)
+	if err != nil {
+		return KeyRotationBatch{}, err
+	}
+	tx, err := store.db.Begin()
+	if err != nil {
+		return KeyRotationBatch{}, err
+	}
+	//nolint:errcheck
+	defer tx.Rollback()
+	rows, err := tx.Query(
+		"SELECT id, "+strings.Join(table.columns, ", ")+" FROM "+table.name+" WHERE id > ? ORDER BY id ASC LIMIT ?",
+		afterId, batchSize)
+	if err != nil {
+		return KeyRotationBatch{}, err
+	}
+	type encryptedRow struct {
+		id     int
+		values [][]byte
+	}
+	batchRows := make([]encryptedRow, 0, batchSize)
+	for rows.Next() {
+		row := encryptedRow{values: make([][]byte, len(table.columns))}
+		scanTargets := []interface{}{&row.id}
+		for i := range row.values {
+			scanTargets = append(scanTargets, &row.values[i])
+		}
+		err = rows.Scan(scanTargets...)
+		if err != nil {
+			rows.Close()
+			return KeyRotationBatch{}, err
+		}
+		batchRows = append(batchRows, row)
+	}
+	err = rows.Close()
+	if err != nil {
+		return KeyRotationBatch{}, err
+	}
+
+	batch := KeyRotationBatch{LastId: afterId, Processed: len(batchRows), Done: len(batchRows) < batchSize}
+	for _, row := range batchRows {
+		batch.LastId = row.id
+		rotated, err := store.rotateRow(tx, table, row.id, row.values)
+		if err != nil {
+			return KeyRotationBatch{}, fmt.Errorf("rotating %s row %d: %w", table.name, row.id, err)
+		}
+		if rotated {
+			batch.Rotated++
+		}
+	}
+	return batch, tx.Commit()
+}
+
+func (store *Store) rotateRow(tx *sql.Tx, table encryptedTable, id int, values [][]byte) (bool, error) {
+	upToDate := true
+	for _, value := range values {
+		if len(value) > 0 && !store.Keys.IsCurrent(value) {
+			upToDate = false
+		}
+	}
+	if upToDate {
+		return false, nil
+	}
+	assignments := make([]string, 0, len(table.columns)+1)
+	params := make([]interface{}, 0, len(table.columns)+2)
+	for i, value := range values {
+		if len(value) == 0 {
+			continue
+		}
+		plaintext, err := store.Keys.Decrypt(value)
+		if err != nil {
+			return false, err
+		}
+		reencrypted, err := store.Keys.Encrypt(plaintext)
+		if err != nil {
+			return false, err
+		}
+		assignments = append(assignments, table.columns[i]+" = ?")
+		params = append(params, reencrypted)
+		if i == 0 && table.blindIndexColumn != "" {
+			assignments = append(assignments, table.blindIndexColumn+" = ?")
+			params = append(params, store.emailBlindIndex(plaintext))
+		}
+	}
+	params = append(params, id)
+	_, err := tx.Exec("UPDATE "+table.name+" SET "+strings.Join(assignments, ", ")+" WHERE id = ?", params...)
+	return err == nil, err
+}
@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"aggregat4/go-commentservice/internal/domain"
+	"testing"
+	"time"
+
+	"github.com/aggregat4/go-baselib/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func rotateAll(t *testing.T, store *Store, batchSize int) int {
+	rotated := 0
+	for _, table := range EncryptedTables() {
+		afterId := 0
+		for {
+			batch, err := store.RotateKeysInBatch(table, afterId, batchSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rotated += batch.Rotated
+			afterId = batch.LastId
+			if batch.Done {
+				break
+			}
+		}
+	}
+	return rotated
+}
+
+func TestRotateKeys(t *testing.T) {
+	store := createTestStore(t)
+	err := store.InitAndVerifyDb(CreateInMemoryDbUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	serviceId, err := store.CreateService("service", []string{"https://example.com"}, domain.DefaultServiceAdminRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userId, err := store.CreateUserByEmail("user@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	commentIds := make([]int, 0)
+	for i := 0; i < 5; i++ {
+		commentId, err := store.CreateComment(1, serviceId, "service", userId, "post", "comment", "name", "", "https://example.com/post", 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		commentIds = append(commentIds, commentId)
+	}
+	// a comment from before ciphertexts were versioned
+	legacyCipher, err := crypto.CreateAes256GcmAead([]byte(TEST_ENCRYPTIONKEY))
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacyComment, err := crypto.EncryptAes256("legacy comment", legacyCipher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.db.Exec("UPDATE comments SET comment_encrypted = ? WHERE id = ?", legacyComment, commentIds[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.EnqueueEmail("authentication-code", "user@example.com", "{}", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.UpdateCommentStatus(commentIds[1], domain.CommentStatusRejected, "Spam", testAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.UpdateComment(commentIds[2], domain.CommentStatusPendingApproval, "edited comment", "name", "", "https://example.com/post", domain.UserActor(userId))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the new key becomes current, the old one is still needed to read existing data
+	newKeys := createTestKeyring(t, 2, TEST_ENCRYPTIONKEY2)
+	assert.Nil(t, newKeys.AddPreviousKey(1, []byte(TEST_ENCRYPTIONKEY)))
+	store.Keys = newKeys
+	user, err := store.FindUserByEmail("user@example.com")
+	assert.Nil(t, err, "Users should be found before their email has been rotated")
+	assert.Equal(t, userId, user.Id)
+
+	// 5 comments, 1 user, 1 email, 1 comment revision and 2 moderation log entries
+	assert.Equal(t, 10, rotateAll(t, store, 2))
+	assert.Equal(t, 0, rotateAll(t, store, 2), "Rotating again should not do anything")
+
+	// the old key is not needed anymore
+	store.Keys = createTestKeyring(t, 2, TEST_ENCRYPTIONKEY2)
+	comment, err := store.GetComment(commentIds[0])
+	assert.Nil(t, err)
+	assert.Equal(t, "legacy comment", comment.Comment)
+	comments, err := store.GetCommentsForUser(userId)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(comments))
+	assert.Equal(t, "https://example.com/post", comments[1].ParentUrl)
+	user, err = store.FindUserByEmail("user@example.com")
+	assert.Nil(t, err, "The blind index should have been recomputed with the new key")
+	assert.Equal(t, userId, user.Id)
+	emails, err := store.FindDueEmails(time.Now(), 10)
+	assert.Nil(t, err)
+	assert.Equal(t, "user@example.com", emails[0].Recipient)
+	moderationLog, err := store.GetModerationLog(domain.ModerationLogFilter{ServiceIds: []int{serviceId}, Actor: testAdmin}, 0, 10)
+	assert.Nil(t, err, "The blind index of the actor should have been recomputed with the new key")
+	assert.Equal(t, 1, len(moderationLog.Entries))
+	assert.Equal(t, "Spam", moderationLog.Entries[0].Reason)
+	revisions, err := store.GetCommentRevisions(commentIds[2])
+	assert.Nil(t, err)
+	assert.Equal(t, "comment", revisions[0].Comment)
+}
+
+func TestRotateKeysInBatchRejectsUnknownTables(t *testing.T) {
+	store := createTestStore(t)
+	err := store.InitAndVerifyDb(CreateInMemoryDbUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	_, err = store.RotateKeysInBatch("services; DROP TABLE users", 0, 10)
+	assert.NotNil(t, err)
+}
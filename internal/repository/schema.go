@@ -49,4 +49,245 @@ var mymigrations = []migrations.Migration{
 		ALTER TABLE comments ADD COLUMN parent_url_encrypted BLOB;
 		`,
 	},
+	{
+		SequenceId: 3,
+		Sql: `
+		-- Replies reference the comment they answer. This is deliberately not a foreign key: when a parent
+		-- is deleted the replies keep pointing at it so that the thread structure can still be rendered.
+		ALTER TABLE comments ADD COLUMN parent_comment_id INTEGER;
+		`,
+	},
+	{
+		SequenceId: 4,
+		Sql: `
+		-- Emails are persisted before they are sent so that they survive restarts and can be retried
+		-- Status can be:
+		-- 1: pending, 2: sent, 3: dead letter (gave up after too many attempts)
+		CREATE TABLE IF NOT EXISTS email_outbox (
+			id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+			kind TEXT NOT NULL,
+			recipient_encrypted BLOB NOT NULL,
+			payload_encrypted BLOB NOT NULL,
+			status INTEGER NOT NULL DEFAULT 1,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			next_attempt_at INTEGER NOT NULL,
+			last_error_encrypted BLOB,
+			created_at INTEGER NOT NULL DEFAULT (unixepoch()),
+			sent_at INTEGER
+		);
+		CREATE INDEX IF NOT EXISTS email_outbox_status_next_attempt_idx ON email_outbox(status, next_attempt_at);
+		`,
+	},
+	{
+		SequenceId: 5,
+		Sql: `
+		-- When users delete their account we only remember that an account was deleted, on which day and how
+		-- many comments went with it. Nothing in here may identify the user.
+		CREATE TABLE IF NOT EXISTS account_deletions (
+			id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+			deleted_on INTEGER NOT NULL,
+			deleted_comments INTEGER NOT NULL
+		);
+		`,
+	},
+	{
+		SequenceId: 6,
+		Sql: `
+		-- Email addresses are encrypted like all other personal data. Since the ciphertext can not be searched
+		-- we also store a keyed hash (blind index) of the normalized address for lookups. Existing rows are
+		-- converted and the plain text email column is dropped by Store.encryptUserEmails after this migration.
+		ALTER TABLE users ADD COLUMN email_encrypted BLOB;
+		ALTER TABLE users ADD COLUMN email_hash BLOB;
+		CREATE INDEX IF NOT EXISTS users_email_hash_idx ON users(email_hash);
+		`,
+	},
+	{
+		SequenceId: 7,
+		Sql: `
+		-- Admins whose roles claim contains the admin_role of a service may moderate its comments. Existing
+		-- services keep the single role that used to grant access to all services.
+		ALTER TABLE services ADD COLUMN admin_role TEXT NOT NULL DEFAULT 'service-admin';
+		`,
+	},
+	{
+		SequenceId: 8,
+		Sql: `
+		-- Service keys identify services in URLs, now that they can be changed they have to stay unique
+		CREATE UNIQUE INDEX IF NOT EXISTS services_service_key_idx ON services(service_key);
+		CREATE INDEX IF NOT EXISTS comments_service_id_idx ON comments(service_id);
+		`,
+	},
+	{
+		SequenceId: 9,
+		Sql: `
+		-- A service can be embedded by several origins, e.g. the apex domain, www and a staging host
+		CREATE TABLE IF NOT EXISTS service_origins (
+			id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+			service_id INTEGER NOT NULL,
+			origin TEXT NOT NULL,
+			FOREIGN KEY (service_id) REFERENCES services(id) ON DELETE CASCADE
+		);
+		CREATE INDEX IF NOT EXISTS service_origins_service_id_idx ON service_origins(service_id);
+		INSERT INTO service_origins (service_id, origin) SELECT id, origin FROM services;
+		ALTER TABLE services DROP COLUMN origin;
+		`,
+	},
+	{
+		SequenceId: 10,
+		Sql: `
+		ALTER TABLE comments ADD COLUMN rejection_reason_encrypted BLOB;
+		`,
+	},
+	{
+		SequenceId: 11,
+		Sql: `
+		-- Comments are paginated with a cursor on (created_at, id), these indexes serve the dashboard, the
+		-- threads of a post and the filter by author
+		CREATE INDEX IF NOT EXISTS comments_service_created_at_idx ON comments(service_id, created_at, id);
+		CREATE INDEX IF NOT EXISTS comments_post_idx ON comments(service_id, post_key, status, created_at, id);
+		CREATE INDEX IF NOT EXISTS comments_parent_comment_id_idx ON comments(parent_comment_id);
+		CREATE INDEX IF NOT EXISTS comments_user_id_idx ON comments(user_id);
+		`,
+	},
+	{
+		SequenceId: 12,
+		Sql: `
+		-- Every status change and deletion of a comment is recorded. The actor is the OIDC subject of an admin
+		-- (actor_kind 2) or the id of a user (actor_kind 1), it is encrypted and has a blind index for filtering.
+		-- new_status is NULL for deletions. The log outlives the comments and services it refers to.
+		CREATE TABLE IF NOT EXISTS moderation_log (
+			id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+			comment_id INTEGER NOT NULL,
+			service_id INTEGER NOT NULL,
+			service_key TEXT NOT NULL,
+			post_key TEXT NOT NULL,
+			actor_kind INTEGER NOT NULL,
+			actor_encrypted BLOB NOT NULL,
+			actor_hash BLOB NOT NULL,
+			old_status INTEGER NOT NULL,
+			new_status INTEGER,
+			reason_encrypted BLOB,
+			created_at INTEGER NOT NULL DEFAULT (unixepoch())
+		);
+		CREATE INDEX IF NOT EXISTS moderation_log_service_id_idx ON moderation_log(service_id, id);
+		CREATE INDEX IF NOT EXISTS moderation_log_comment_id_idx ON moderation_log(comment_id);
+		CREATE INDEX IF NOT EXISTS moderation_log_actor_hash_idx ON moderation_log(actor_hash);
+		-- The log is append-only, only key rotation may re-encrypt the encrypted columns
+		CREATE TRIGGER IF NOT EXISTS moderation_log_no_delete BEFORE DELETE ON moderation_log
+		BEGIN
+			SELECT RAISE(ABORT, 'the moderation log is append-only');
+		END;
+		CREATE TRIGGER IF NOT EXISTS moderation_log_no_update
+		BEFORE UPDATE OF id, comment_id, service_id, service_key, post_key, actor_kind, old_status, new_status, created_at ON moderation_log
+		BEGIN
+			SELECT RAISE(ABORT, 'the moderation log is append-only');
+		END;
+		`,
+	},
+	{
+		SequenceId: 13,
+		Sql: `
+		-- Editing a comment keeps the version it replaces as a revision, the contents stay encrypted
+		CREATE TABLE IF NOT EXISTS comment_revisions (
+			id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+			comment_id INTEGER NOT NULL,
+			revision INTEGER NOT NULL,
+			comment_encrypted BLOB NOT NULL,
+			name_encrypted BLOB,
+			website_encrypted BLOB,
+			replaced_at INTEGER NOT NULL DEFAULT (unixepoch()),
+			UNIQUE (comment_id, revision),
+			FOREIGN KEY (comment_id) REFERENCES comments(id) ON DELETE CASCADE
+		);
+		`,
+	},
+	{
+		SequenceId: 14,
+		Sql: `
+		-- Whether approved comments can be edited: 1 forbid, 2 within the grace window, 3 with re-moderation
+		ALTER TABLE services ADD COLUMN edit_policy INTEGER NOT NULL DEFAULT 1;
+		ALTER TABLE services ADD COLUMN edit_grace_window_seconds INTEGER NOT NULL DEFAULT 900;
+		`,
+	},
+	{
+		SequenceId: 15,
+		Sql: `
+		-- Spam score between 0 and 1 as rated by the spam filters when the comment was submitted
+		ALTER TABLE comments ADD COLUMN spam_score REAL NOT NULL DEFAULT 0;
+		-- How the Bayesian spam classifier learned the comment: 0 not at all, 1 as ham, 2 as spam
+		ALTER TABLE comments ADD COLUMN spam_learned INTEGER NOT NULL DEFAULT 0;
+		-- Tokens are keyed hashes of words and host names, the counts are the number of comments they occurred in
+		CREATE TABLE IF NOT EXISTS spam_tokens (
+			token TEXT PRIMARY KEY,
+			spam_count INTEGER NOT NULL DEFAULT 0,
+			ham_count INTEGER NOT NULL DEFAULT 0
+		);
+		-- The number of comments the classifier learned, there is only one row
+		CREATE TABLE IF NOT EXISTS spam_totals (
+			id INTEGER PRIMARY KEY CHECK (id = 1),
+			spam_count INTEGER NOT NULL DEFAULT 0,
+			ham_count INTEGER NOT NULL DEFAULT 0
+		);
+		INSERT OR IGNORE INTO spam_totals (id) VALUES (1);
+		`,
+	},
+	{
+		SequenceId: 16,
+		Sql: `
+		-- How admins are notified about comments pending approval: 1 immediately, 2 hourly digest, 3 daily digest.
+		-- Admins are identified by the blind index of their OIDC subject, the roles are those of their last login.
+		CREATE TABLE IF NOT EXISTS admin_notification_settings (
+			id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+			admin_encrypted BLOB NOT NULL,
+			admin_hash BLOB NOT NULL UNIQUE,
+			email_encrypted BLOB NOT NULL,
+			mode INTEGER NOT NULL,
+			roles TEXT NOT NULL,
+			last_digest_at INTEGER NOT NULL DEFAULT 0
+		);
+		-- Comments that wait for the next digest of an admin
+		CREATE TABLE IF NOT EXISTS admin_digest_comments (
+			settings_id INTEGER NOT NULL,
+			comment_id INTEGER NOT NULL,
+			PRIMARY KEY (settings_id, comment_id)
+		);
+		`,
+	},
+	{
+		SequenceId: 17,
+		Sql: `
+		-- Commenters can subscribe to the moderation of their comment (kind 1) or to new approved comments on a post
+		-- (kind 2). Post subscriptions have comment_id 0. Subscriptions are only active once the email address of the
+		-- user has been confirmed with the comment they were made with.
+		CREATE TABLE IF NOT EXISTS subscriptions (
+			id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			kind INTEGER NOT NULL,
+			service_id INTEGER NOT NULL,
+			post_key TEXT NOT NULL,
+			comment_id INTEGER NOT NULL DEFAULT 0,
+			active INTEGER NOT NULL DEFAULT 0,
+			created_at INTEGER NOT NULL DEFAULT (unixepoch()),
+			UNIQUE (user_id, kind, service_id, post_key, comment_id)
+		);
+		CREATE INDEX IF NOT EXISTS subscriptions_post_idx ON subscriptions(service_id, post_key);
+		`,
+	},
+	{
+		SequenceId: 18,
+		Sql: `
+		-- The tokens a comment was learned with, so that they can be unlearned exactly when the comment is
+		-- moderated again, edited or deleted. Tokens are keyed hashes like in spam_tokens.
+		CREATE TABLE IF NOT EXISTS spam_learned_tokens (
+			comment_id INTEGER NOT NULL,
+			token TEXT NOT NULL,
+			PRIMARY KEY (comment_id, token)
+		);
+		CREATE INDEX IF NOT EXISTS spam_learned_tokens_token_idx ON spam_learned_tokens(token);
+		-- Tokens used to be hashed without a key and can not be converted, the classifier starts over
+		DELETE FROM spam_tokens;
+		UPDATE spam_totals SET spam_count = 0, ham_count = 0;
+		UPDATE comments SET spam_learned = 0;
+		`,
+	},
 }
@@ -0,0 +1,192 @@
+package repository
+
+import (
+	"aggregat4/go-commentservice/internal/domain"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/aggregat4/go-baselib/lang"
+)
+
+var ErrServiceKeyTaken = errors.New("the service key is already in use")
+
+const serviceOverviewQuery = "SELECT " + serviceColumns + `,
+	COUNT(c.id), COALESCE(SUM(CASE WHEN c.status = ? THEN 1 ELSE 0 END), 0)
+	FROM services s LEFT JOIN comments c ON c.service_id = s.id`
+
+func (store *Store) GetServiceOverviews() ([]domain.ServiceOverview, error) {
+	rows, err := store.db.Query(serviceOverviewQuery+" GROUP BY s.id ORDER BY s.service_key ASC", domain.CommentStatusPendingApproval)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	overviews := make([]domain.ServiceOverview, 0)
+	for rows.Next() {
+		overview, err := mapServiceOverview(rows)
+		if err != nil {
+			return nil, err
+		}
+		overviews = append(overviews, overview)
+	}
+	return overviews, rows.Err()
+}
+
+func (store *Store) GetServiceOverview(serviceId int) (domain.ServiceOverview, error) {
+	rows, err := store.db.Query(serviceOverviewQuery+" WHERE s.id = ? GROUP BY s.id", domain.CommentStatusPendingApproval, serviceId)
+	if err != nil {
+		return domain.ServiceOverview{}, err
+	}
+	defer rows.Close()
+	if rows.Next() {
+		return mapServiceOverview(rows)
+	}
+	return domain.ServiceOverview{}, lang.ErrNotFound
+}
+
+func mapServiceOverview(rows *sql.Rows) (domain.ServiceOverview, error) {
+	var overview domain.ServiceOverview
+	service, err := mapService(rows, &overview.Comments, &overview.PendingApproval)
+	overview.Service = service
+	return overview, err
+}
+
+func serviceKeyExists(tx *sql.Tx, serviceKey string) (bool, error) {
+	var count int
+	err := tx.QueryRow("SELECT COUNT(*) FROM services WHERE service_key = ?", serviceKey).Scan(&count)
+	return count > 0, err
+}
+
+// UpdateService changes the settings of a service, the origins replace all existing origins
+func (store *Store) UpdateService(serviceId int, origins []string, adminRole string) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	//nolint:errcheck
+	defer tx.Rollback()
+	result, err := tx.Exec("UPDATE services SET admin_role = ? WHERE id = ?", adminRole, serviceId)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return lang.ErrNotFound
+	}
+	_, err = tx.Exec("DELETE FROM service_origins WHERE service_id = ?", serviceId)
+	if err != nil {
+		return err
+	}
+	err = insertServiceOrigins(tx, serviceId, origins)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpdateServiceEditPolicy changes whether the authors of approved comments can still edit them
+func (store *Store) UpdateServiceEditPolicy(serviceId int, editPolicy domain.EditPolicy, editGraceWindow time.Duration) error {
+	result, err := store.db.Exec(
+		"UPDATE services SET edit_policy = ?, edit_grace_window_seconds = ? WHERE id = ?",
+		int(editPolicy), int64(editGraceWindow/time.Second), serviceId)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return lang.ErrNotFound
+	}
+	return nil
+}
+
+// ChangeServiceKey gives a service a new key. Comments store the key of their service as well, they are updated in
+// the same transaction.
+func (store *Store) ChangeServiceKey(serviceId int, newServiceKey string) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	//nolint:errcheck
+	defer tx.Rollback()
+	taken, err := serviceKeyExists(tx, newServiceKey)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrServiceKeyTaken
+	}
+	result, err := tx.Exec("UPDATE services SET service_key = ? WHERE id = ?", newServiceKey, serviceId)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return lang.ErrNotFound
+	}
+	_, err = tx.Exec("UPDATE comments SET service_key = ? WHERE service_id = ?", newServiceKey, serviceId)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteService deletes a service with its origins and all of its comments and returns the number of deleted
+// comments. They are deleted explicitly since foreign keys are not enforced on every connection. The deletions are
+// recorded in the moderation log.
+func (store *Store) DeleteService(serviceId int, actor domain.Actor) (int, error) {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	//nolint:errcheck
+	defer tx.Rollback()
+	err = store.logModeration(tx, actor, commentDeleted, "", "service_id = ?", serviceId)
+	if err != nil {
+		return 0, err
+	}
+	err = deleteCommentRevisions(tx, "service_id = ?", serviceId)
+	if err != nil {
+		return 0, err
+	}
+	err = unlearnSpamTokens(tx, "service_id = ?", serviceId)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec("DELETE FROM subscriptions WHERE service_id = ?", serviceId)
+	if err != nil {
+		return 0, err
+	}
+	result, err := tx.Exec("DELETE FROM comments WHERE service_id = ?", serviceId)
+	if err != nil {
+		return 0, err
+	}
+	deletedComments, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec("DELETE FROM service_origins WHERE service_id = ?", serviceId)
+	if err != nil {
+		return 0, err
+	}
+	result, err = tx.Exec("DELETE FROM services WHERE id = ?", serviceId)
+	if err != nil {
+		return 0, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if rowsAffected == 0 {
+		return 0, lang.ErrNotFound
+	}
+	return int(deletedComments), tx.Commit()
+}
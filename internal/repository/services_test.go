@@ -0,0 +1,179 @@
+package repository
+
+import (
+	"aggregat4/go-commentservice/internal/domain"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/aggregat4/go-baselib/lang"
+	"github.com/aggregat4/go-baselib/migrations"
+	"github.com/stretchr/testify/assert"
+)
+
+func createServiceWithComments(t *testing.T, store *Store, serviceKey string, statuses ...domain.CommentStatus) int {
+	serviceId, err := store.CreateService(serviceKey, []string{"https://" + serviceKey + ".example.com"}, domain.DefaultServiceAdminRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userId, err := store.CreateUserByEmail(serviceKey + "@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		_, err = store.CreateComment(status, serviceId, serviceKey, userId, "post", "A comment", "", "", "", 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return serviceId
+}
+
+func getAllComments(t *testing.T, store *Store, serviceId int) []domain.Comment {
+	page, err := store.GetComments(domain.CommentFilter{ServiceIds: []int{serviceId}}, domain.CommentCursor{}, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return page.Comments
+}
+
+func TestServiceOverviewsCountComments(t *testing.T) {
+	store := createTestStore(t)
+	err := store.InitAndVerifyDb(CreateInMemoryDbUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	createServiceWithComments(t, store, "blog", domain.CommentStatusApproved, domain.CommentStatusPendingApproval, domain.CommentStatusPendingApproval)
+	createServiceWithComments(t, store, "empty")
+	overviews, err := store.GetServiceOverviews()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(overviews))
+	assert.Equal(t, "blog", overviews[0].ServiceKey)
+	assert.Equal(t, 3, overviews[0].Comments)
+	assert.Equal(t, 2, overviews[0].PendingApproval)
+	assert.Equal(t, "empty", overviews[1].ServiceKey)
+	assert.Equal(t, 0, overviews[1].Comments)
+}
+
+func TestServiceKeysAreUnique(t *testing.T) {
+	store := createTestStore(t)
+	err := store.InitAndVerifyDb(CreateInMemoryDbUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	createServiceWithComments(t, store, "blog")
+	serviceId := createServiceWithComments(t, store, "other")
+	_, err = store.CreateService("blog", []string{"https://example.com"}, domain.DefaultServiceAdminRole)
+	assert.ErrorIs(t, err, ErrServiceKeyTaken)
+	assert.ErrorIs(t, store.ChangeServiceKey(serviceId, "blog"), ErrServiceKeyTaken)
+}
+
+func TestChangeServiceKeyUpdatesComments(t *testing.T) {
+	store := createTestStore(t)
+	err := store.InitAndVerifyDb(CreateInMemoryDbUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	serviceId := createServiceWithComments(t, store, "blog", domain.CommentStatusApproved)
+	assert.Nil(t, store.ChangeServiceKey(serviceId, "newblog"))
+	_, err = store.GetServiceForKey("blog")
+	assert.ErrorIs(t, err, lang.ErrNotFound)
+	service, err := store.GetServiceForKey("newblog")
+	assert.Nil(t, err)
+	page, err := store.GetCommentThreadsForPost(service.Id, "post", domain.CommentCursor{}, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(page.Comments))
+	assert.Equal(t, "newblog", page.Comments[0].ServiceKey)
+}
+
+func TestDeleteServiceDeletesComments(t *testing.T) {
+	store := createTestStore(t)
+	err := store.InitAndVerifyDb(CreateInMemoryDbUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	serviceId := createServiceWithComments(t, store, "blog", domain.CommentStatusApproved, domain.CommentStatusRejected)
+	otherServiceId := createServiceWithComments(t, store, "other", domain.CommentStatusApproved)
+	deletedComments, err := store.DeleteService(serviceId, testAdmin)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, deletedComments)
+	_, err = store.FindServiceById(serviceId)
+	assert.ErrorIs(t, err, lang.ErrNotFound)
+	page, err := store.GetComments(domain.CommentFilter{ServiceIds: []int{serviceId, otherServiceId}}, domain.CommentCursor{}, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(page.Comments))
+	_, err = store.DeleteService(serviceId, testAdmin)
+	assert.ErrorIs(t, err, lang.ErrNotFound)
+}
+
+func TestSingleOriginIsMigratedToServiceOrigins(t *testing.T) {
+	store := createTestStore(t)
+	// simulate a database from before services could have several origins
+	db, err := sql.Open("sqlite3", CreateInMemoryDbUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	err = migrations.MigrateSchema(db, mymigrations[:8])
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec("INSERT INTO services (service_key, origin) VALUES ('blog', 'https://example.com')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.db = db
+	defer store.Close()
+	err = migrations.MigrateSchema(db, mymigrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, err := store.GetServiceForKey("blog")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"https://example.com"}, service.Origins)
+}
+
+func TestUpdateServiceReplacesOrigins(t *testing.T) {
+	store := createTestStore(t)
+	err := store.InitAndVerifyDb(CreateInMemoryDbUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	serviceId := createServiceWithComments(t, store, "blog")
+	otherServiceId := createServiceWithComments(t, store, "other")
+	assert.Nil(t, store.UpdateService(serviceId, []string{"https://example.com", "https://*.example.com"}, "blog-admin"))
+	service, err := store.FindServiceById(serviceId)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"https://example.com", "https://*.example.com"}, service.Origins)
+	assert.Equal(t, "blog-admin", service.AdminRole)
+	otherService, err := store.FindServiceById(otherServiceId)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"https://other.example.com"}, otherService.Origins)
+	assert.ErrorIs(t, store.UpdateService(999, []string{"https://example.com"}, "blog-admin"), lang.ErrNotFound)
+}
+
+func TestUpdateServiceEditPolicy(t *testing.T) {
+	store := createTestStore(t)
+	err := store.InitAndVerifyDb(CreateInMemoryDbUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	serviceId := createServiceWithComments(t, store, "blog")
+	service, err := store.FindServiceById(serviceId)
+	assert.Nil(t, err)
+	assert.Equal(t, domain.EditPolicyForbid, service.EditPolicy, "New services do not allow editing approved comments")
+	assert.Equal(t, domain.DefaultEditGraceWindow, service.EditGraceWindow)
+
+	assert.Nil(t, store.UpdateServiceEditPolicy(serviceId, domain.EditPolicyGraceWindow, time.Hour))
+	service, err = store.FindServiceById(serviceId)
+	assert.Nil(t, err)
+	assert.Equal(t, domain.EditPolicyGraceWindow, service.EditPolicy)
+	assert.Equal(t, time.Hour, service.EditGraceWindow)
+	assert.ErrorIs(t, store.UpdateServiceEditPolicy(999, domain.EditPolicyRemoderate, time.Hour), lang.ErrNotFound)
+}
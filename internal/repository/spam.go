@@ -0,0 +1,192 @@
+package repository
+
+import (
+	"aggregat4/go-commentservice/internal/domain"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"github.com/aggregat4/go-baselib/lang"
+)
+
+// how the Bayesian spam classifier learned a comment, see the spam_learned column
+const (
+	spamLearnedNot  = 0
+	spamLearnedHam  = 1
+	spamLearnedSpam = 2
+)
+
+// deriveSpamTokenKey derives the key that hashes the tokens of the spam classifier from an encryption key, like the
+// blind index key. Without the key the words of comments can not be recovered from their hashes with a dictionary.
+func deriveSpamTokenKey(encryptionKey []byte) []byte {
+	mac := hmac.New(sha256.New, encryptionKey)
+	mac.Write([]byte("go-commentservice spam tokens"))
+	return mac.Sum(nil)
+}
+
+func spamTokenHash(spamTokenKey []byte, token string) string {
+	mac := hmac.New(sha256.New, spamTokenKey)
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// learnsSpamTokens returns whether comments with the status are learned by the spam classifier, comments are
+// unlearned when they change to any other status
+func learnsSpamTokens(status domain.CommentStatus) bool {
+	return status == domain.CommentStatusApproved || status == domain.CommentStatusRejected
+}
+
+// GetSpamTokenCounts returns the counts of the Bayesian spam classifier for the tokens it knows and the number of
+// comments it learned. Tokens learned with a previous key are counted as well.
+func (store *Store) GetSpamTokenCounts(tokens []string) (map[string]domain.SpamTokenCounts, domain.SpamTokenCounts, error) {
+	var totals domain.SpamTokenCounts
+	err := store.db.QueryRow("SELECT spam_count, ham_count FROM spam_totals WHERE id = 1").Scan(&totals.Spam, &totals.Ham)
+	if err != nil {
+		return nil, domain.SpamTokenCounts{}, err
+	}
+	counts := make(map[string]domain.SpamTokenCounts, len(tokens))
+	if len(tokens) == 0 {
+		return counts, totals, nil
+	}
+	tokensByHash := make(map[string]string)
+	params := make([]interface{}, 0)
+	for _, key := range store.Keys.allKeys() {
+		for _, token := range tokens {
+			hash := spamTokenHash(key.spamTokenKey, token)
+			tokensByHash[hash] = token
+			params = append(params, hash)
+		}
+	}
+	rows, err := store.db.Query(
+		"SELECT token, spam_count, ham_count FROM spam_tokens WHERE token IN ("+strings.TrimSuffix(strings.Repeat("?,", len(params)), ",")+")",
+		params...)
+	if err != nil {
+		return nil, domain.SpamTokenCounts{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var hash string
+		var hashCounts domain.SpamTokenCounts
+		err = rows.Scan(&hash, &hashCounts.Spam, &hashCounts.Ham)
+		if err != nil {
+			return nil, domain.SpamTokenCounts{}, err
+		}
+		tokenCounts := counts[tokensByHash[hash]]
+		tokenCounts.Spam += hashCounts.Spam
+		tokenCounts.Ham += hashCounts.Ham
+		counts[tokensByHash[hash]] = tokenCounts
+	}
+	return counts, totals, rows.Err()
+}
+
+// LearnSpamTokens counts the tokens of the comment as spam or ham. Every comment is only counted once, when an admin
+// changes their mind the comment is moved over to the other side. The hashes of the tokens are kept with the comment
+// so that exactly these tokens are unlearned later, even when the comment was edited or the key was rotated since.
+func (store *Store) LearnSpamTokens(commentId int, tokens []string, spam bool) error {
+	learned := lang.IfElse(spam, spamLearnedSpam, spamLearnedHam)
+	column := lang.IfElse(spam, "spam_count", "ham_count")
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	//nolint:errcheck
+	defer tx.Rollback()
+	var previouslyLearned int
+	err = tx.QueryRow("SELECT spam_learned FROM comments WHERE id = ?", commentId).Scan(&previouslyLearned)
+	if errors.Is(err, sql.ErrNoRows) {
+		return lang.ErrNotFound
+	} else if err != nil {
+		return err
+	}
+	if previouslyLearned == learned {
+		return nil
+	}
+	err = unlearnSpamTokens(tx, "id = ?", commentId)
+	if err != nil {
+		return err
+	}
+	hashes := make([]string, 0, len(tokens))
+	seen := make(map[string]bool, len(tokens))
+	for _, token := range tokens {
+		hash := spamTokenHash(store.Keys.current.spamTokenKey, token)
+		if !seen[hash] {
+			seen[hash] = true
+			hashes = append(hashes, hash)
+		}
+	}
+	err = execForTokens(tx, "INSERT INTO spam_tokens (token, "+column+") VALUES (?, 1) ON CONFLICT (token) DO UPDATE SET "+column+" = "+column+" + 1", hashes)
+	if err != nil {
+		return err
+	}
+	err = execForTokens(tx, "INSERT INTO spam_learned_tokens (comment_id, token) VALUES (?, ?)", hashes, commentId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE spam_totals SET " + column + " = " + column + " + 1 WHERE id = 1")
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE comments SET spam_learned = ? WHERE id = ?", learned, commentId)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// unlearnSpamTokens removes the comments matching the where clause from the statistics of the spam classifier. It
+// has to run in the transaction that changes the status of the comments or deletes them.
+func unlearnSpamTokens(tx *sql.Tx, where string, params ...interface{}) error {
+	for _, learned := range []int{spamLearnedHam, spamLearnedSpam} {
+		column := lang.IfElse(learned == spamLearnedSpam, "spam_count", "ham_count")
+		learnedComments := "SELECT id FROM comments WHERE spam_learned = ? AND " + where
+		learnedParams := append([]interface{}{learned}, params...)
+		// every comment learned a token only once, so the token is decremented once per learned comment
+		_, err := tx.Exec(
+			"UPDATE spam_tokens SET "+column+" = MAX("+column+" - "+
+				"(SELECT COUNT(*) FROM spam_learned_tokens l WHERE l.token = spam_tokens.token AND l.comment_id IN ("+learnedComments+")), 0) "+
+				"WHERE token IN (SELECT token FROM spam_learned_tokens WHERE comment_id IN ("+learnedComments+"))",
+			append(learnedParams, learnedParams...)...)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(
+			"UPDATE spam_totals SET "+column+" = MAX("+column+" - (SELECT COUNT(*) FROM comments WHERE spam_learned = ? AND "+where+"), 0) WHERE id = 1",
+			learnedParams...)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := tx.Exec(
+		"DELETE FROM spam_tokens WHERE spam_count = 0 AND ham_count = 0 AND token IN "+
+			"(SELECT token FROM spam_learned_tokens WHERE comment_id IN (SELECT id FROM comments WHERE "+where+"))",
+		params...)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM spam_learned_tokens WHERE comment_id IN (SELECT id FROM comments WHERE "+where+")", params...)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE comments SET spam_learned = ? WHERE spam_learned != ? AND "+where,
+		append([]interface{}{spamLearnedNot, spamLearnedNot}, params...)...)
+	return err
+}
+
+// execForTokens runs the query once for every token, the token is the last parameter
+func execForTokens(tx *sql.Tx, query string, tokens []string, params ...interface{}) error {
+	statement, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	for _, token := range tokens {
+		_, err = statement.Exec(append(params, token)...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
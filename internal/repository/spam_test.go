@@ -0,0 +1,145 @@
+package repository
+
+import (
+	"aggregat4/go-commentservice/internal/domain"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"testing"
+
+	"github.com/aggregat4/go-baselib/lang"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommentsKeepTheirSpamScore(t *testing.T) {
+	store := createTestStore(t)
+	err := store.InitAndVerifyDb(CreateInMemoryDbUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	serviceId := createServiceWithComments(t, store, "blog")
+	userId, err := store.CreateUserByEmail("author@example.com")
+	assert.Nil(t, err)
+	commentId, err := store.CreateComment(domain.CommentStatusPendingApproval, serviceId, "blog", userId, "post", "Cheap watches", "", "", "", 0, 0.8)
+	assert.Nil(t, err)
+	comment, err := store.GetComment(commentId)
+	assert.Nil(t, err)
+	assert.Equal(t, 0.8, comment.SpamScore)
+}
+
+func TestLearnSpamTokensCountsEveryCommentOnce(t *testing.T) {
+	store := createTestStore(t)
+	err := store.InitAndVerifyDb(CreateInMemoryDbUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	serviceId := createServiceWithComments(t, store, "blog", domain.CommentStatusPendingApproval, domain.CommentStatusPendingApproval)
+	comments := getAllComments(t, store, serviceId)
+
+	counts, totals, err := store.GetSpamTokenCounts([]string{"a", "b"})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(counts))
+	assert.Equal(t, domain.SpamTokenCounts{}, totals)
+
+	assert.Nil(t, store.LearnSpamTokens(comments[0].Id, []string{"a", "b"}, true))
+	assert.Nil(t, store.LearnSpamTokens(comments[0].Id, []string{"a", "b"}, true))
+	assert.Nil(t, store.LearnSpamTokens(comments[1].Id, []string{"b", "c"}, false))
+	counts, totals, err = store.GetSpamTokenCounts([]string{"a", "b", "c", "d"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]domain.SpamTokenCounts{
+		"a": {Spam: 1},
+		"b": {Spam: 1, Ham: 1},
+		"c": {Ham: 1},
+	}, counts)
+	assert.Equal(t, domain.SpamTokenCounts{Spam: 1, Ham: 1}, totals)
+
+	// an admin changes their mind and approves the first comment after all
+	assert.Nil(t, store.LearnSpamTokens(comments[0].Id, []string{"a", "b"}, false))
+	counts, totals, err = store.GetSpamTokenCounts([]string{"a", "b", "c"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]domain.SpamTokenCounts{
+		"a": {Ham: 1},
+		"b": {Ham: 2},
+		"c": {Ham: 1},
+	}, counts)
+	assert.Equal(t, domain.SpamTokenCounts{Ham: 2}, totals)
+
+	assert.ErrorIs(t, store.LearnSpamTokens(12345, []string{"a"}, true), lang.ErrNotFound)
+}
+
+func TestSpamTokensAreStoredAsKeyedHashes(t *testing.T) {
+	store := createTestStore(t)
+	err := store.InitAndVerifyDb(CreateInMemoryDbUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	serviceId := createServiceWithComments(t, store, "blog", domain.CommentStatusRejected)
+	comments := getAllComments(t, store, serviceId)
+
+	assert.Nil(t, store.LearnSpamTokens(comments[0].Id, []string{"word:watches"}, true))
+	var token string
+	assert.Nil(t, store.db.QueryRow("SELECT token FROM spam_tokens").Scan(&token))
+	assert.NotContains(t, token, "watches")
+	unkeyedHash := sha256.Sum256([]byte("word:watches"))
+	assert.NotEqual(t, hex.EncodeToString(unkeyedHash[:8]), token, "Tokens must not be hashed without a key")
+
+	// after rotating the key the tokens learned with the previous key are still counted
+	newKeys := createTestKeyring(t, 2, TEST_ENCRYPTIONKEY2)
+	assert.Nil(t, newKeys.AddPreviousKey(1, []byte(TEST_ENCRYPTIONKEY)))
+	store.Keys = newKeys
+	counts, _, err := store.GetSpamTokenCounts([]string{"word:watches"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]domain.SpamTokenCounts{"word:watches": {Spam: 1}}, counts)
+}
+
+func TestSpamTokensAreUnlearnedWhenCommentsAreModeratedAgainOrDeleted(t *testing.T) {
+	store := createTestStore(t)
+	err := store.InitAndVerifyDb(CreateInMemoryDbUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	serviceId := createServiceWithComments(t, store, "blog", domain.CommentStatusApproved, domain.CommentStatusApproved, domain.CommentStatusApproved)
+	comments := getAllComments(t, store, serviceId)
+	for _, comment := range comments {
+		assert.Nil(t, store.LearnSpamTokens(comment.Id, []string{"word:" + strconv.Itoa(comment.Id), "word:shared"}, false))
+	}
+	assertSpamTokens := func(expectedHam int, expectedShared int) {
+		counts, totals, err := store.GetSpamTokenCounts([]string{"word:shared"})
+		assert.Nil(t, err)
+		assert.Equal(t, domain.SpamTokenCounts{Ham: expectedHam}, totals)
+		assert.Equal(t, expectedShared, counts["word:shared"].Ham)
+	}
+	assertSpamTokens(3, 3)
+
+	// the edit sends the comment back to moderation, the tokens it was learned with are unlearned and not the
+	// tokens of the edited text
+	assert.Nil(t, store.UpdateComment(comments[0].Id, domain.CommentStatusPendingApproval, "Edited", "", "", "", domain.UserActor(comments[0].UserId)))
+	assertSpamTokens(2, 2)
+	counts, _, err := store.GetSpamTokenCounts([]string{"word:" + strconv.Itoa(comments[0].Id)})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(counts), "Tokens that no comment was learned with anymore should be removed")
+
+	assert.Nil(t, store.UpdateCommentStatus(comments[1].Id, domain.CommentStatusPendingApproval, "", testAdmin))
+	assertSpamTokens(1, 1)
+
+	// learning the comment again after it was unlearned counts it once more
+	assert.Nil(t, store.LearnSpamTokens(comments[1].Id, []string{"word:shared"}, true))
+	counts, totals, err := store.GetSpamTokenCounts([]string{"word:shared"})
+	assert.Nil(t, err)
+	assert.Equal(t, domain.SpamTokenCounts{Spam: 1, Ham: 1}, totals)
+	assert.Equal(t, domain.SpamTokenCounts{Spam: 1, Ham: 1}, counts["word:shared"])
+
+	_, err = store.DeleteUser(comments[0].UserId)
+	assert.Nil(t, err)
+	counts, totals, err = store.GetSpamTokenCounts([]string{"word:shared"})
+	assert.Nil(t, err)
+	assert.Equal(t, domain.SpamTokenCounts{}, totals)
+	assert.Equal(t, 0, len(counts))
+	var remaining int
+	assert.Nil(t, store.db.QueryRow("SELECT (SELECT COUNT(*) FROM spam_tokens) + (SELECT COUNT(*) FROM spam_learned_tokens)").Scan(&remaining))
+	assert.Equal(t, 0, remaining, "Deleting the account should remove everything learned from its comments")
+}
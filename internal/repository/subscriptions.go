@@ -0,0 +1,160 @@
+package repository
+
+import (
+	"aggregat4/go-commentservice/internal/domain"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	"github.com/aggregat4/go-baselib/lang"
+)
+
+const subscriptionColumns = "s.id, s.user_id, s.kind, s.service_id, COALESCE(services.service_key, ''), s.post_key, s.comment_id, s.active, s.created_at"
+
+const subscriptionTables = "subscriptions s LEFT JOIN services ON services.id = s.service_id"
+
+// deriveUnsubscribeKey derives the key that signs unsubscribe links from an encryption key, like the blind index key
+// it needs no additional secret
+func deriveUnsubscribeKey(encryptionKey []byte) []byte {
+	mac := hmac.New(sha256.New, encryptionKey)
+	mac.Write([]byte("go-commentservice unsubscribe links"))
+	return mac.Sum(nil)
+}
+
+func unsubscribeToken(unsubscribeKey []byte, subscriptionId int) []byte {
+	mac := hmac.New(sha256.New, unsubscribeKey)
+	mac.Write([]byte("subscription:" + strconv.Itoa(subscriptionId)))
+	return mac.Sum(nil)
+}
+
+// UnsubscribeToken signs the subscription id so that the subscription can be cancelled from an email without logging in
+func (store *Store) UnsubscribeToken(subscriptionId int) string {
+	return hex.EncodeToString(unsubscribeToken(store.Keys.current.unsubscribeKey, subscriptionId))
+}
+
+// VerifyUnsubscribeToken tells whether the token was created by UnsubscribeToken for the subscription with any of the
+// known keys, links in emails sent before a key rotation keep working until the previous key is removed
+func (store *Store) VerifyUnsubscribeToken(subscriptionId int, token string) bool {
+	decodedToken, err := hex.DecodeString(token)
+	if err != nil {
+		return false
+	}
+	for _, key := range store.Keys.allKeys() {
+		if hmac.Equal(decodedToken, unsubscribeToken(key.unsubscribeKey, subscriptionId)) {
+			return true
+		}
+	}
+	return false
+}
+
+func mapSubscription(rows *sql.Rows) (domain.Subscription, error) {
+	var subscription domain.Subscription
+	var createdAt int64
+	err := rows.Scan(&subscription.Id, &subscription.UserId, &subscription.Kind, &subscription.ServiceId, &subscription.ServiceKey,
+		&subscription.PostKey, &subscription.CommentId, &subscription.Active, &createdAt)
+	if err != nil {
+		return domain.Subscription{}, err
+	}
+	subscription.CreatedAt = time.Unix(createdAt, 0)
+	return subscription, nil
+}
+
+func (store *Store) getSubscriptions(where string, params ...interface{}) ([]domain.Subscription, error) {
+	rows, err := store.db.Query("SELECT "+subscriptionColumns+" FROM "+subscriptionTables+" WHERE "+where+" ORDER BY s.id ASC", params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	subscriptions := make([]domain.Subscription, 0)
+	for rows.Next() {
+		subscription, err := mapSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, rows.Err()
+}
+
+// CreateSubscription subscribes the user to the moderation of a comment or to a post, the comment id has to be 0 for
+// post subscriptions. Subscribing again does nothing, except that it activates an inactive subscription when the
+// new one is active.
+func (store *Store) CreateSubscription(userId int, kind domain.SubscriptionKind, serviceId int, postKey string, commentId int, active bool) error {
+	_, err := store.db.Exec(
+		`INSERT INTO subscriptions (user_id, kind, service_id, post_key, comment_id, active) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (user_id, kind, service_id, post_key, comment_id) DO UPDATE SET active = MAX(active, excluded.active)`,
+		userId, int(kind), serviceId, postKey, commentId, active)
+	return err
+}
+
+// ActivateSubscriptions activates the subscriptions that were made with the comment, it is called when the author
+// confirms the comment
+func (store *Store) ActivateSubscriptions(comment domain.Comment) error {
+	_, err := store.db.Exec(
+		`UPDATE subscriptions SET active = 1 WHERE user_id = ? AND (
+			(kind = ? AND comment_id = ?) OR (kind = ? AND service_id = ? AND post_key = ?))`,
+		comment.UserId, int(domain.SubscriptionModeration), comment.Id, int(domain.SubscriptionPost), comment.ServiceId, comment.PostKey)
+	return err
+}
+
+// GetSubscription returns the subscription with the id, lang.ErrNotFound when it does not exist
+func (store *Store) GetSubscription(subscriptionId int) (domain.Subscription, error) {
+	subscriptions, err := store.getSubscriptions("s.id = ?", subscriptionId)
+	if err != nil {
+		return domain.Subscription{}, err
+	}
+	if len(subscriptions) == 0 {
+		return domain.Subscription{}, lang.ErrNotFound
+	}
+	return subscriptions[0], nil
+}
+
+// GetSubscriptionsForUser returns all subscriptions of the user, active or not
+func (store *Store) GetSubscriptionsForUser(userId int) ([]domain.Subscription, error) {
+	return store.getSubscriptions("s.user_id = ?", userId)
+}
+
+// FindModerationSubscription returns the active subscription to the moderation of the comment, lang.ErrNotFound when
+// its author did not subscribe
+func (store *Store) FindModerationSubscription(commentId int) (domain.Subscription, error) {
+	subscriptions, err := store.getSubscriptions("s.kind = ? AND s.comment_id = ? AND s.active = 1", int(domain.SubscriptionModeration), commentId)
+	if err != nil {
+		return domain.Subscription{}, err
+	}
+	if len(subscriptions) == 0 {
+		return domain.Subscription{}, lang.ErrNotFound
+	}
+	return subscriptions[0], nil
+}
+
+// GetPostSubscriptions returns the active subscriptions to new comments on the post
+func (store *Store) GetPostSubscriptions(serviceId int, postKey string) ([]domain.Subscription, error) {
+	return store.getSubscriptions("s.kind = ? AND s.service_id = ? AND s.post_key = ? AND s.active = 1", int(domain.SubscriptionPost), serviceId, postKey)
+}
+
+// DeleteSubscription cancels the subscription, lang.ErrNotFound when it does not exist
+func (store *Store) DeleteSubscription(subscriptionId int) error {
+	result, err := store.db.Exec("DELETE FROM subscriptions WHERE id = ?", subscriptionId)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return lang.ErrNotFound
+	}
+	return nil
+}
+
+// deleteCommentSubscriptions removes the moderation subscriptions of the comments matching the where clause, it has
+// to run in the transaction that deletes the comments
+func deleteCommentSubscriptions(tx *sql.Tx, where string, params ...interface{}) error {
+	_, err := tx.Exec("DELETE FROM subscriptions WHERE kind = ? AND comment_id IN (SELECT id FROM comments WHERE "+where+")",
+		append([]interface{}{int(domain.SubscriptionModeration)}, params...)...)
+	return err
+}
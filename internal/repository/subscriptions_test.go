@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"aggregat4/go-commentservice/internal/domain"
+	"testing"
+
+	"github.com/aggregat4/go-baselib/lang"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscriptions(t *testing.T) {
+	store := createTestStore(t)
+	err := store.InitAndVerifyDb(CreateInMemoryDbUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	serviceId, err := store.CreateService("blog", []string{"https://blog.example.com"}, domain.DefaultServiceAdminRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, err := store.CreateUserByEmail("alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := store.CreateUserByEmail("bob@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	aliceCommentId := createPostComment(t, store, serviceId, alice, "post", domain.CommentStatusPendingAuthentication, "Alice", 0)
+	assert.Nil(t, store.CreateSubscription(alice, domain.SubscriptionModeration, serviceId, "post", aliceCommentId, false))
+	assert.Nil(t, store.CreateSubscription(alice, domain.SubscriptionPost, serviceId, "post", 0, false))
+	assert.Nil(t, store.CreateSubscription(bob, domain.SubscriptionPost, serviceId, "post", 0, true))
+	assert.Nil(t, store.CreateSubscription(bob, domain.SubscriptionPost, serviceId, "post", 0, false), "Subscribing again is allowed")
+
+	// only confirmed subscriptions get emails
+	_, err = store.FindModerationSubscription(aliceCommentId)
+	assert.ErrorIs(t, err, lang.ErrNotFound)
+	subscriptions, err := store.GetPostSubscriptions(serviceId, "post")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(subscriptions))
+	assert.Equal(t, bob, subscriptions[0].UserId)
+	assert.Equal(t, "blog", subscriptions[0].ServiceKey)
+
+	aliceComment, err := store.GetComment(aliceCommentId)
+	assert.Nil(t, err)
+	assert.Nil(t, store.ActivateSubscriptions(aliceComment))
+	subscription, err := store.FindModerationSubscription(aliceCommentId)
+	assert.Nil(t, err)
+	assert.Equal(t, alice, subscription.UserId)
+	subscriptions, err = store.GetPostSubscriptions(serviceId, "post")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(subscriptions))
+	subscriptions, err = store.GetPostSubscriptions(serviceId, "other")
+	assert.Nil(t, err)
+	assert.Empty(t, subscriptions)
+
+	subscriptions, err = store.GetSubscriptionsForUser(alice)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(subscriptions))
+	assert.True(t, subscriptions[0].Active)
+
+	// deleting the comment deletes the subscription to its moderation
+	assert.Nil(t, store.DeleteComment(aliceCommentId, domain.UserActor(alice)))
+	subscriptions, err = store.GetSubscriptionsForUser(alice)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(subscriptions))
+	assert.Equal(t, domain.SubscriptionPost, subscriptions[0].Kind)
+
+	assert.Nil(t, store.DeleteSubscription(subscriptions[0].Id))
+	assert.ErrorIs(t, store.DeleteSubscription(subscriptions[0].Id), lang.ErrNotFound)
+	_, err = store.DeleteUser(bob)
+	assert.Nil(t, err)
+	subscriptions, err = store.GetPostSubscriptions(serviceId, "post")
+	assert.Nil(t, err)
+	assert.Empty(t, subscriptions)
+}
+
+func TestUnsubscribeTokens(t *testing.T) {
+	store := createTestStore(t)
+	token := store.UnsubscribeToken(42)
+	assert.True(t, store.VerifyUnsubscribeToken(42, token))
+	assert.False(t, store.VerifyUnsubscribeToken(43, token))
+	assert.False(t, store.VerifyUnsubscribeToken(42, ""))
+	assert.False(t, store.VerifyUnsubscribeToken(42, "not hex"))
+
+	// tokens signed with a previous key stay valid until the key is removed
+	rotatedStore := &Store{Keys: createTestKeyring(t, 2, TEST_ENCRYPTIONKEY2)}
+	assert.False(t, rotatedStore.VerifyUnsubscribeToken(42, token))
+	assert.Nil(t, rotatedStore.Keys.AddPreviousKey(1, []byte(TEST_ENCRYPTIONKEY)))
+	assert.True(t, rotatedStore.VerifyUnsubscribeToken(42, token))
+	assert.NotEqual(t, token, rotatedStore.UnsubscribeToken(42))
+}
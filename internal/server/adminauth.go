@@ -0,0 +1,119 @@
+package server
+
+import (
+	"aggregat4/go-commentservice/internal/domain"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/aggregat4/go-baselib/lang"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+// rolesFromIdToken reads the admin's roles from the configured claim of the ID token
+func rolesFromIdToken(idToken *oidc.IDToken, claimName string) ([]string, error) {
+	var claims map[string]interface{}
+	err := idToken.Claims(&claims)
+	if err != nil {
+		return nil, err
+	}
+	return rolesFromClaims(claims, claimName)
+}
+
+// rolesFromClaims supports claims that are a list of strings or a single space separated string. Nested claims, as
+// used by some identity providers, are addressed with a dotted path like "realm_access.roles". A missing claim means
+// that the user has no roles.
+func rolesFromClaims(claims map[string]interface{}, claimName string) ([]string, error) {
+	var value interface{} = claims
+	for _, pathElement := range strings.Split(claimName, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return []string{}, nil
+		}
+		value, ok = object[pathElement]
+		if !ok {
+			return []string{}, nil
+		}
+	}
+	switch typedValue := value.(type) {
+	case string:
+		return strings.Fields(typedValue), nil
+	case []interface{}:
+		roles := make([]string, 0, len(typedValue))
+		for _, role := range typedValue {
+			roleString, ok := role.(string)
+			if !ok {
+				return nil, fmt.Errorf("claim %s contains a role that is not a string", claimName)
+			}
+			roles = append(roles, roleString)
+		}
+		return roles, nil
+	default:
+		return nil, fmt.Errorf("claim %s is neither a string nor a list of strings", claimName)
+	}
+}
+
+func getAdminRolesFromSession(c echo.Context) ([]string, error) {
+	sess, err := session.Get(authenticatedUserCookieName, c)
+	if err != nil {
+		return nil, err
+	}
+	if roles, ok := sess.Values["adminroles"].([]string); ok {
+		return roles, nil
+	}
+	return nil, lang.ErrNotFound
+}
+
+// getAdminUser resolves the logged in admin together with the services they administer
+func (controller *Controller) getAdminUser(c echo.Context) (domain.AdminUser, error) {
+	adminUserId, err := getAdminUserIdFromSession(c)
+	if err != nil {
+		return domain.AdminUser{}, err
+	}
+	roles, err := getAdminRolesFromSession(c)
+	if err != nil {
+		return domain.AdminUser{}, err
+	}
+	superadmin := controller.Config.SuperadminRole != "" && slices.Contains(roles, controller.Config.SuperadminRole)
+	var services []domain.Service
+	if superadmin {
+		services, err = controller.Store.GetServices()
+	} else {
+		services, err = controller.Store.FindServicesByAdminRoles(roles)
+	}
+	if err != nil {
+		return domain.AdminUser{}, err
+	}
+	return domain.AdminUser{UserId: adminUserId, Superadmin: superadmin, Services: services}, nil
+}
+
+// requireAdmin returns the logged in admin when they administer at least one service, otherwise it sends the
+// appropriate response and returns an admin without id
+func (controller *Controller) requireAdmin(c echo.Context) (domain.AdminUser, error) {
+	adminUser, err := controller.getAdminUser(c)
+	if err != nil && !errors.Is(err, lang.ErrNotFound) {
+		return domain.AdminUser{}, sendInternalError(c, err)
+	} else if err != nil {
+		return domain.AdminUser{}, c.Redirect(http.StatusUnauthorized, "/adminlogin/")
+	}
+	if !adminUser.Superadmin && len(adminUser.Services) == 0 {
+		return domain.AdminUser{}, renderUnauthorized(c)
+	}
+	return adminUser, nil
+}
+
+// requireSuperadmin is like requireAdmin but only lets superadmins through
+func (controller *Controller) requireSuperadmin(c echo.Context) (domain.AdminUser, error) {
+	adminUser, err := controller.requireAdmin(c)
+	if err != nil || !adminUser.IsValid() {
+		return domain.AdminUser{}, err
+	}
+	if !adminUser.Superadmin {
+		return domain.AdminUser{}, renderUnauthorized(c)
+	}
+	return adminUser, nil
+}
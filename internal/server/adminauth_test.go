@@ -0,0 +1,34 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRolesFromClaims(t *testing.T) {
+	claims := map[string]interface{}{
+		"roles":        []interface{}{"service-admin", "superadmin"},
+		"scope":        "openid comment-admin",
+		"realm_access": map[string]interface{}{"roles": []interface{}{"nested-admin"}},
+		"broken":       []interface{}{"service-admin", 42},
+	}
+	roles, err := rolesFromClaims(claims, "roles")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"service-admin", "superadmin"}, roles)
+
+	roles, err = rolesFromClaims(claims, "scope")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"openid", "comment-admin"}, roles)
+
+	roles, err = rolesFromClaims(claims, "realm_access.roles")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"nested-admin"}, roles)
+
+	roles, err = rolesFromClaims(claims, "groups")
+	assert.Nil(t, err)
+	assert.Empty(t, roles, "A missing claim means no roles")
+
+	_, err = rolesFromClaims(claims, "broken")
+	assert.NotNil(t, err)
+}
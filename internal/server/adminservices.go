@@ -0,0 +1,292 @@
+package server
+
+import (
+	"aggregat4/go-commentservice/internal/domain"
+	"aggregat4/go-commentservice/internal/repository"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	baseliboidc "github.com/aggregat4/go-baselib-services/v3/oidc"
+	"github.com/aggregat4/go-baselib/lang"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+// Service keys are part of the URLs that embed the comments
+var serviceKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func validateAdminRole(adminRole string) string {
+	if adminRole == "" || strings.ContainsAny(adminRole, " \t") {
+		return "The admin role must not be empty and must not contain spaces."
+	}
+	return ""
+}
+
+func validateServiceKey(serviceKey string) string {
+	if !serviceKeyPattern.MatchString(serviceKey) {
+		return "The service key may only contain letters, digits, dashes and underscores."
+	}
+	return ""
+}
+
+// parseOriginsForm reads the origins textarea, it returns a validation message when an origin is invalid
+func parseOriginsForm(c echo.Context) ([]string, string) {
+	origins, err := domain.ParseOrigins(c.FormValue("origins"))
+	if err != nil {
+		return nil, err.Error()
+	}
+	return origins, ""
+}
+
+// requireServiceFromPath returns the service with the serviceId in the path, otherwise it renders the appropriate
+// error and returns a service without id
+func (controller *Controller) requireServiceFromPath(c echo.Context) (domain.ServiceOverview, error) {
+	serviceId, err := strconv.Atoi(c.Param("serviceId"))
+	if err != nil {
+		return domain.ServiceOverview{}, renderBadRequest(c)
+	}
+	service, err := controller.Store.GetServiceOverview(serviceId)
+	if err != nil {
+		return domain.ServiceOverview{}, handleCommonErrors(c, err)
+	}
+	return service, nil
+}
+
+func serviceUrl(serviceId int) string {
+	return "/admin/services/" + strconv.Itoa(serviceId)
+}
+
+func (controller *Controller) GetAdminServices(c echo.Context) error {
+	adminUser, err := controller.requireSuperadmin(c)
+	if err != nil || !adminUser.IsValid() {
+		return err
+	}
+	services, err := controller.Store.GetServiceOverviews()
+	if err != nil {
+		return sendInternalError(c, err)
+	}
+	successFlashes, errorFlashes, err := baseliboidc.GetFlashes(c)
+	if err != nil {
+		return sendInternalError(c, err)
+	}
+	return c.Render(http.StatusOK, "admin-services", domain.AdminServicesPage{
+		BasePage: domain.BasePage{
+			Stylesheets: templateStylesheets,
+			Scripts:     templateScripts,
+			Error:       errorFlashes,
+			Success:     successFlashes,
+		},
+		AdminUser: adminUser,
+		Services:  services,
+	})
+}
+
+func (controller *Controller) CreateAdminService(c echo.Context) error {
+	adminUser, err := controller.requireSuperadmin(c)
+	if err != nil || !adminUser.IsValid() {
+		return err
+	}
+	serviceKey := strings.TrimSpace(c.FormValue("serviceKey"))
+	adminRole := strings.TrimSpace(c.FormValue("adminRole"))
+	if adminRole == "" {
+		adminRole = domain.DefaultServiceAdminRole
+	}
+	origins, validationError := parseOriginsForm(c)
+	if validationError == "" {
+		validationError = validateServiceKey(serviceKey)
+	}
+	if validationError == "" {
+		validationError = validateAdminRole(adminRole)
+	}
+	if validationError != "" {
+		//nolint:errcheck
+		baseliboidc.SetFlash(c, "error", validationError)
+		return c.Redirect(http.StatusFound, "/admin/services")
+	}
+	serviceId, err := controller.Store.CreateService(serviceKey, origins, adminRole)
+	if errors.Is(err, repository.ErrServiceKeyTaken) {
+		//nolint:errcheck
+		baseliboidc.SetFlash(c, "error", "There already is a service with the key "+serviceKey+".")
+		return c.Redirect(http.StatusFound, "/admin/services")
+	} else if err != nil {
+		return sendInternalError(c, err)
+	}
+	logger.Info("Created service", "serviceKey", serviceKey, "admin", adminUser.UserId)
+	//nolint:errcheck
+	baseliboidc.SetFlash(c, "success", "The service "+serviceKey+" has been created.")
+	return c.Redirect(http.StatusFound, serviceUrl(serviceId))
+}
+
+func (controller *Controller) GetAdminService(c echo.Context) error {
+	adminUser, err := controller.requireSuperadmin(c)
+	if err != nil || !adminUser.IsValid() {
+		return err
+	}
+	service, err := controller.requireServiceFromPath(c)
+	if err != nil || service.Id == 0 {
+		return err
+	}
+	successFlashes, errorFlashes, err := baseliboidc.GetFlashes(c)
+	if err != nil {
+		return sendInternalError(c, err)
+	}
+	return c.Render(http.StatusOK, "admin-service", domain.AdminServicePage{
+		BasePage: domain.BasePage{
+			Stylesheets: templateStylesheets,
+			Scripts:     templateScripts,
+			Error:       errorFlashes,
+			Success:     successFlashes,
+		},
+		AdminUser:     adminUser,
+		Service:       service,
+		NewServiceKey: uuid.New().String(),
+	})
+}
+
+func (controller *Controller) UpdateAdminService(c echo.Context) error {
+	adminUser, err := controller.requireSuperadmin(c)
+	if err != nil || !adminUser.IsValid() {
+		return err
+	}
+	service, err := controller.requireServiceFromPath(c)
+	if err != nil || service.Id == 0 {
+		return err
+	}
+	adminRole := strings.TrimSpace(c.FormValue("adminRole"))
+	origins, validationError := parseOriginsForm(c)
+	if validationError == "" {
+		validationError = validateAdminRole(adminRole)
+	}
+	if validationError != "" {
+		//nolint:errcheck
+		baseliboidc.SetFlash(c, "error", validationError)
+		return c.Redirect(http.StatusFound, serviceUrl(service.Id))
+	}
+	err = controller.Store.UpdateService(service.Id, origins, adminRole)
+	if err != nil {
+		return handleCommonErrors(c, err)
+	}
+	logger.Info("Updated service", "serviceKey", service.ServiceKey, "admin", adminUser.UserId)
+	//nolint:errcheck
+	baseliboidc.SetFlash(c, "success", "The service has been updated.")
+	return c.Redirect(http.StatusFound, serviceUrl(service.Id))
+}
+
+// UpdateAdminServiceEditPolicy changes whether authors can edit their comments once they have been approved, the grace
+// window is given in minutes
+func (controller *Controller) UpdateAdminServiceEditPolicy(c echo.Context) error {
+	adminUser, err := controller.requireSuperadmin(c)
+	if err != nil || !adminUser.IsValid() {
+		return err
+	}
+	service, err := controller.requireServiceFromPath(c)
+	if err != nil || service.Id == 0 {
+		return err
+	}
+	editPolicy, err := domain.ParseEditPolicy(c.FormValue("editPolicy"))
+	if err != nil {
+		return renderBadRequest(c)
+	}
+	editGraceWindow := service.EditGraceWindow
+	if editPolicy == domain.EditPolicyGraceWindow {
+		minutes, err := strconv.Atoi(strings.TrimSpace(c.FormValue("editGraceWindowMinutes")))
+		editGraceWindow = time.Duration(minutes) * time.Minute
+		if err != nil || editGraceWindow <= 0 || editGraceWindow > domain.MaxEditGraceWindow {
+			//nolint:errcheck
+			baseliboidc.SetFlash(c, "error", fmt.Sprintf("The grace window must be between 1 and %d minutes.", int(domain.MaxEditGraceWindow/time.Minute)))
+			return c.Redirect(http.StatusFound, serviceUrl(service.Id))
+		}
+	}
+	err = controller.Store.UpdateServiceEditPolicy(service.Id, editPolicy, editGraceWindow)
+	if err != nil {
+		return handleCommonErrors(c, err)
+	}
+	logger.Info("Updated edit policy", "serviceKey", service.ServiceKey, "editPolicy", editPolicy.String(), "admin", adminUser.UserId)
+	//nolint:errcheck
+	baseliboidc.SetFlash(c, "success", "The edit policy has been updated.")
+	return c.Redirect(http.StatusFound, serviceUrl(service.Id))
+}
+
+func (controller *Controller) RotateAdminServiceKey(c echo.Context) error {
+	adminUser, err := controller.requireSuperadmin(c)
+	if err != nil || !adminUser.IsValid() {
+		return err
+	}
+	service, err := controller.requireServiceFromPath(c)
+	if err != nil || service.Id == 0 {
+		return err
+	}
+	newServiceKey := strings.TrimSpace(c.FormValue("serviceKey"))
+	if validationError := validateServiceKey(newServiceKey); validationError != "" {
+		//nolint:errcheck
+		baseliboidc.SetFlash(c, "error", validationError)
+		return c.Redirect(http.StatusFound, serviceUrl(service.Id))
+	}
+	err = controller.Store.ChangeServiceKey(service.Id, newServiceKey)
+	if errors.Is(err, repository.ErrServiceKeyTaken) {
+		//nolint:errcheck
+		baseliboidc.SetFlash(c, "error", "There already is a service with the key "+newServiceKey+".")
+		return c.Redirect(http.StatusFound, serviceUrl(service.Id))
+	} else if err != nil {
+		return handleCommonErrors(c, err)
+	}
+	logger.Info("Changed service key", "oldServiceKey", service.ServiceKey, "newServiceKey", newServiceKey, "admin", adminUser.UserId)
+	//nolint:errcheck
+	baseliboidc.SetFlash(c, "success", "The service key is now "+newServiceKey+". Update the pages that embed the comments.")
+	return c.Redirect(http.StatusFound, serviceUrl(service.Id))
+}
+
+func (controller *Controller) GetAdminDeleteServiceForm(c echo.Context) error {
+	adminUser, err := controller.requireSuperadmin(c)
+	if err != nil || !adminUser.IsValid() {
+		return err
+	}
+	service, err := controller.requireServiceFromPath(c)
+	if err != nil || service.Id == 0 {
+		return err
+	}
+	successFlashes, errorFlashes, err := baseliboidc.GetFlashes(c)
+	if err != nil {
+		return sendInternalError(c, err)
+	}
+	return c.Render(http.StatusOK, "admin-deleteservice", domain.AdminDeleteServicePage{
+		BasePage: domain.BasePage{
+			Stylesheets: templateStylesheets,
+			Scripts:     templateScripts,
+			Error:       errorFlashes,
+			Success:     successFlashes,
+		},
+		AdminUser: adminUser,
+		Service:   service,
+	})
+}
+
+func (controller *Controller) DeleteAdminService(c echo.Context) error {
+	adminUser, err := controller.requireSuperadmin(c)
+	if err != nil || !adminUser.IsValid() {
+		return err
+	}
+	service, err := controller.requireServiceFromPath(c)
+	if err != nil || service.Id == 0 {
+		return err
+	}
+	// deleting a service deletes all of its comments, so we require the key to be typed to prevent accidents
+	if c.FormValue("confirmServiceKey") != service.ServiceKey {
+		//nolint:errcheck
+		baseliboidc.SetFlash(c, "error", "Please enter the service key to confirm that the service and all its comments should be deleted.")
+		return c.Redirect(http.StatusFound, serviceUrl(service.Id)+"/delete")
+	}
+	deletedComments, err := controller.Store.DeleteService(service.Id, domain.AdminActor(adminUser))
+	if err != nil && !errors.Is(err, lang.ErrNotFound) {
+		return sendInternalError(c, err)
+	}
+	logger.Info("Deleted service", "serviceKey", service.ServiceKey, "deletedComments", deletedComments, "admin", adminUser.UserId)
+	//nolint:errcheck
+	baseliboidc.SetFlash(c, "success", "The service "+service.ServiceKey+" and its "+strconv.Itoa(deletedComments)+" comments have been deleted.")
+	return c.Redirect(http.StatusFound, "/admin/services")
+}
@@ -0,0 +1,229 @@
+package server
+
+import (
+	"aggregat4/go-commentservice/internal/domain"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aggregat4/go-baselib/lang"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminServicesRequireSuperadmin(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	loginAdmin(t, client, domain.DefaultServiceAdminRole)
+	res, err := client.Get(createServerUrl(serverConfig.Port, "/admin/services"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	res = postServiceForm(t, client, "/admin/services", url.Values{"serviceKey": {"newservice"}, "origins": {"https://new.example.com"}})
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	_, err = controller.Store.GetServiceForKey("newservice")
+	assert.ErrorIs(t, err, lang.ErrNotFound)
+}
+
+func TestAdminListServices(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	loginAdmin(t, client, serverConfig.SuperadminRole)
+	res, err := client.Get(createServerUrl(serverConfig.Port, "/admin/services"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	body := readBody(res)
+	assert.Contains(t, body, TEST_SERVICE)
+	assert.Contains(t, body, "<td>4</td>", "All comments of the test service should be counted")
+}
+
+func TestAdminCreateService(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	loginAdmin(t, client, serverConfig.SuperadminRole)
+	res := postServiceForm(t, client, "/admin/services", url.Values{"serviceKey": {"newservice"}, "origins": {"https://new.example.com"}})
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	service, err := controller.Store.GetServiceForKey("newservice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "/admin/services/"+strconv.Itoa(service.Id), res.Header.Get("Location"))
+	assert.Equal(t, []string{"https://new.example.com"}, service.Origins)
+	assert.Equal(t, domain.DefaultServiceAdminRole, service.AdminRole)
+
+	res = postServiceForm(t, client, "/admin/services", url.Values{"serviceKey": {"newservice"}, "origins": {"https://other.example.com"}})
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	assert.Equal(t, "/admin/services", res.Header.Get("Location"), "Duplicate service keys are rejected")
+	res = postServiceForm(t, client, "/admin/services", url.Values{"serviceKey": {"no spaces/allowed"}, "origins": {"https://other.example.com"}})
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	_, err = controller.Store.GetServiceForKey("no spaces/allowed")
+	assert.ErrorIs(t, err, lang.ErrNotFound)
+}
+
+func TestAdminUpdateService(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	loginAdmin(t, client, serverConfig.SuperadminRole)
+	service := getTestService(t, controller)
+	res, err := client.Get(createServerUrl(serverConfig.Port, "/admin/services/"+strconv.Itoa(service.Id)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Contains(t, readBody(res), service.Origins[0])
+	res = postServiceForm(t, client, "/admin/services/"+strconv.Itoa(service.Id), url.Values{"origins": {"https://moved.example.com\r\nhttps://*.moved.example.com"}, "adminRole": {"blog-admin"}})
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	updatedService := getTestService(t, controller)
+	assert.Equal(t, []string{"https://moved.example.com", "https://*.moved.example.com"}, updatedService.Origins)
+	assert.Equal(t, "blog-admin", updatedService.AdminRole)
+}
+
+func TestAdminUpdateServiceRejectsInvalidOrigins(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	loginAdmin(t, client, serverConfig.SuperadminRole)
+	service := getTestService(t, controller)
+	for _, origins := range []string{"", "https://example.com; script-src *", "'self'"} {
+		res := postServiceForm(t, client, "/admin/services/"+strconv.Itoa(service.Id), url.Values{"origins": {origins}, "adminRole": {"blog-admin"}})
+		assert.Equal(t, http.StatusFound, res.StatusCode)
+		assert.Equal(t, service, getTestService(t, controller), "Invalid origins %q should not change the service", origins)
+	}
+}
+
+func TestCommentsAllowAllOriginsToEmbed(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	service := getTestService(t, controller)
+	err := controller.Store.UpdateService(service.Id, []string{"https://example.com", "https://*.example.com"}, service.AdminRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := createTestHttpClient(false)
+	for _, path := range []string{"/services/" + TEST_SERVICE + "/posts/" + TEST_POSTKEY1 + "/comments/", "/services/" + TEST_SERVICE + "/posts/" + TEST_POSTKEY1 + "/commentform"} {
+		res, err := client.Get(createServerUrl(serverConfig.Port, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, "frame-ancestors https://example.com https://*.example.com", res.Header.Get("Content-Security-Policy"), path)
+	}
+}
+
+func TestAdminRotateServiceKey(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	loginAdmin(t, client, serverConfig.SuperadminRole)
+	service := getTestService(t, controller)
+	res := postServiceForm(t, client, "/admin/services/"+strconv.Itoa(service.Id)+"/rotatekey", url.Values{"serviceKey": {"ROTATEDKEY"}})
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	_, err := controller.Store.GetServiceForKey(TEST_SERVICE)
+	assert.ErrorIs(t, err, lang.ErrNotFound)
+	comment, err := controller.Store.GetComment(findCommentByContent(TEST_COMMENTS, TEST_COMMENT_APPROVED).Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "ROTATEDKEY", comment.ServiceKey)
+	body := getPostCommentsBodyForService(t, "ROTATEDKEY", TEST_POSTKEY1)
+	assert.Contains(t, body, TEST_COMMENT_APPROVED)
+}
+
+func TestAdminDeleteServiceRequiresConfirmation(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	loginAdmin(t, client, serverConfig.SuperadminRole)
+	service := getTestService(t, controller)
+	res, err := client.Get(createServerUrl(serverConfig.Port, "/admin/services/"+strconv.Itoa(service.Id)+"/delete"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	res = postServiceForm(t, client, "/admin/services/"+strconv.Itoa(service.Id)+"/delete", url.Values{"confirmServiceKey": {"wrong"}})
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	assert.Equal(t, "/admin/services/"+strconv.Itoa(service.Id)+"/delete", res.Header.Get("Location"))
+	getTestService(t, controller)
+}
+
+func TestAdminDeleteService(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	loginAdmin(t, client, serverConfig.SuperadminRole)
+	service := getTestService(t, controller)
+	res := postServiceForm(t, client, "/admin/services/"+strconv.Itoa(service.Id)+"/delete", url.Values{"confirmServiceKey": {TEST_SERVICE}})
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	assert.Equal(t, "/admin/services", res.Header.Get("Location"))
+	_, err := controller.Store.GetServiceForKey(TEST_SERVICE)
+	assert.ErrorIs(t, err, lang.ErrNotFound)
+	for _, content := range []string{TEST_COMMENT_PENDING_AUTHENTICATION, TEST_COMMENT_PENDING_APPROVAL, TEST_COMMENT_APPROVED, TEST_COMMENT_REJECTED} {
+		_, err = controller.Store.GetComment(findCommentByContent(TEST_COMMENTS, content).Id)
+		assert.ErrorIs(t, err, lang.ErrNotFound)
+	}
+}
+
+func getTestService(t *testing.T, controller Controller) *domain.Service {
+	service, err := controller.Store.GetServiceForKey(TEST_SERVICE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return service
+}
+
+func TestAdminUpdateServiceEditPolicy(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	loginAdmin(t, client, serverConfig.SuperadminRole)
+	service := getTestService(t, controller)
+	editPolicyUrl := "/admin/services/" + strconv.Itoa(service.Id) + "/editpolicy"
+	res := postServiceForm(t, client, editPolicyUrl, url.Values{"editPolicy": {"grace-window"}, "editGraceWindowMinutes": {"30"}})
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	updatedService := getTestService(t, controller)
+	assert.Equal(t, domain.EditPolicyGraceWindow, updatedService.EditPolicy)
+	assert.Equal(t, 30*time.Minute, updatedService.EditGraceWindow)
+
+	res = postServiceForm(t, client, editPolicyUrl, url.Values{"editPolicy": {"remoderate"}})
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	updatedService = getTestService(t, controller)
+	assert.Equal(t, domain.EditPolicyRemoderate, updatedService.EditPolicy)
+	assert.Equal(t, 30*time.Minute, updatedService.EditGraceWindow, "The grace window is kept for later")
+
+	res = postServiceForm(t, client, editPolicyUrl, url.Values{"editPolicy": {"grace-window"}, "editGraceWindowMinutes": {"0"}})
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	assert.Equal(t, domain.EditPolicyRemoderate, getTestService(t, controller).EditPolicy, "Invalid grace windows are rejected")
+	res = postServiceForm(t, client, editPolicyUrl, url.Values{"editPolicy": {"sometimes"}})
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
+
+func postServiceForm(t *testing.T, client *http.Client, path string, formParams url.Values) *http.Response {
+	return postWithOrigin(t, client, createServerUrl(serverConfig.Port, path), "application/x-www-form-urlencoded", strings.NewReader(formParams.Encode()))
+}
+
+func getPostCommentsBodyForService(t *testing.T, serviceKey string, postKey string) string {
+	res, err := http.Get(createServerUrl(serverConfig.Port, "/services/"+serviceKey+"/posts/"+postKey+"/comments/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	return readBody(res)
+}
@@ -0,0 +1,492 @@
+package server
+
+import (
+	"aggregat4/go-commentservice/internal/domain"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/aggregat4/go-baselib/lang"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+// The /api/v1 endpoints mirror the HTML endpoints for clients that want to render comments themselves. They use the
+// same session cookies for users and admins, but they answer with JSON and status codes instead of pages and
+// redirects. Besides this server the origins of a service may use the endpoints about its comments from the
+// browser, see apiOriginMiddleware.
+const apiPrefix = "/api/v1"
+
+func isApiRequest(c echo.Context) bool {
+	return strings.HasPrefix(c.Request().URL.Path, "/api/")
+}
+
+func registerApiEndpoints(e *echo.Echo, controller *Controller) {
+	// counts are public and cacheable so that static sites can show them next to their posts, any origin may read
+	// them without credentials, see setPublicCacheHeaders
+	e.GET(apiPrefix+"/services/:serviceKey/commentcounts", controller.ApiGetCommentCounts)
+	api := e.Group(apiPrefix, controller.apiOriginMiddleware)
+	// ---- UNAUTHENTICATED
+	api.GET("/services/:serviceKey/posts/:postKey/comments", controller.ApiGetComments)
+	// comments of unauthenticated users are pending authentication, like with the HTML form
+	api.POST("/services/:serviceKey/posts/:postKey/comments", controller.ApiPostComment)
+	// ---- AUTHENTICATED WITH AUTH TOKEN (normal user)
+	api.GET("/users/:userId/comments", controller.ApiGetCommentsForUser)
+	api.PUT("/users/:userId/comments/:commentId", controller.ApiUpdateUserComment)
+	api.DELETE("/users/:userId/comments/:commentId", controller.ApiDeleteUserComment)
+	api.POST("/users/:userId/comments/:commentId/confirm", controller.ApiConfirmUserComment)
+	// browsers ask before sending modifying requests from the origins of a service
+	api.OPTIONS("/services/:serviceKey/posts/:postKey/comments", controller.ApiPreflight)
+	api.OPTIONS("/users/:userId/comments/:commentId", controller.ApiPreflight)
+	api.OPTIONS("/users/:userId/comments/:commentId/confirm", controller.ApiPreflight)
+	// ---- AUTHENTICATED WITH OIDC (administrator), the login itself happens through /adminlogin
+	api.GET("/admin/comments", controller.ApiGetAdminComments)
+	api.POST("/admin/comments/:commentId/approve", controller.ApiAdminApproveComment)
+	api.POST("/admin/comments/:commentId/reject", controller.ApiAdminRejectComment)
+	api.POST("/admin/comments/:commentId/unapprove", controller.ApiAdminUnapproveComment)
+	api.POST("/admin/comments/:commentId/unreject", controller.ApiAdminUnrejectComment)
+	api.DELETE("/admin/comments/:commentId", controller.ApiAdminDeleteComment)
+}
+
+// apiOriginMiddleware replaces the CSRF check for the API. Modifying requests need an Origin that is either this
+// server or one of the origins of the service whose comments the request is about. The latter also get CORS headers
+// so that they can read the responses, for safe requests from other origins the browser hides the response. Admin
+// endpoints are only available from this server.
+func (controller *Controller) apiOriginMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		method := c.Request().Method
+		safe := method == http.MethodGet || method == http.MethodHead
+		origin := c.Request().Header.Get("Origin")
+		c.Response().Header().Add(echo.HeaderVary, echo.HeaderOrigin)
+		if origin == "" {
+			if !safe {
+				return sendApiError(c, http.StatusForbidden, "the Origin header is required")
+			}
+			return next(c)
+		}
+		if isSameOrigin(c, origin) {
+			return next(c)
+		}
+		allowed, err := controller.apiOriginAllowed(c, origin)
+		if err != nil {
+			return handleCommonApiErrors(c, err)
+		}
+		if allowed {
+			c.Response().Header().Set(echo.HeaderAccessControlAllowOrigin, origin)
+			c.Response().Header().Set(echo.HeaderAccessControlAllowCredentials, "true")
+		} else if !safe {
+			logger.Info("API request from an origin that the service does not allow", "origin", origin, "path", c.Path())
+			return sendApiError(c, http.StatusForbidden, "the origin is not allowed")
+		}
+		return next(c)
+	}
+}
+
+// apiOriginAllowed tells whether the origin is one of the origins of the service that the request is about, either
+// by its key or by one of its comments
+func (controller *Controller) apiOriginAllowed(c echo.Context, origin string) (bool, error) {
+	if strings.HasPrefix(c.Path(), apiPrefix+"/admin/") {
+		return false, nil
+	}
+	if serviceKey := c.Param("serviceKey"); serviceKey != "" {
+		service, err := controller.Store.GetServiceForKey(serviceKey)
+		if err != nil {
+			return false, err
+		}
+		return domain.OriginAllowed(service.Origins, origin), nil
+	}
+	if commentIdString := c.Param("commentId"); commentIdString != "" {
+		commentId, err := strconv.Atoi(commentIdString)
+		if err != nil {
+			return false, ErrIllegalArgument
+		}
+		comment, err := controller.Store.GetComment(commentId)
+		if err != nil {
+			return false, err
+		}
+		service, err := controller.Store.FindServiceById(comment.ServiceId)
+		if err != nil {
+			return false, err
+		}
+		return domain.OriginAllowed(service.Origins, origin), nil
+	}
+	return false, nil
+}
+
+// ApiPreflight answers CORS preflight requests, apiOriginMiddleware has already checked the origin
+func (controller *Controller) ApiPreflight(c echo.Context) error {
+	c.Response().Header().Set(echo.HeaderAccessControlAllowMethods, "GET, POST, PUT, DELETE")
+	c.Response().Header().Set(echo.HeaderAccessControlAllowHeaders, echo.HeaderContentType)
+	c.Response().Header().Set(echo.HeaderAccessControlMaxAge, "600")
+	return c.NoContent(http.StatusNoContent)
+}
+
+func sendApiError(c echo.Context, status int, message string) error {
+	return c.JSON(status, domain.ApiError{Status: status, Message: message})
+}
+
+func sendApiInternalError(c echo.Context, err error) error {
+	wrappedErr := errors.WithStack(err)
+	logger.Error("Internal server error",
+		"error", wrappedErr,
+		"stack", fmt.Sprintf("%+v", wrappedErr))
+	return sendApiError(c, http.StatusInternalServerError, "internal server error")
+}
+
+// handleCommonApiErrors is the JSON counterpart of handleCommonErrors
+func handleCommonApiErrors(c echo.Context, err error) error {
+	if errors.Is(err, lang.ErrNotFound) {
+		return sendApiError(c, http.StatusNotFound, "not found")
+	} else if errors.Is(err, ErrIllegalArgument) {
+		return sendApiError(c, http.StatusBadRequest, "invalid request")
+	} else {
+		return sendApiInternalError(c, err)
+	}
+}
+
+// apiRequireUserFromPath returns the authenticated user when it matches the userId in the path, otherwise it sends
+// the appropriate error and returns an invalid user
+func (controller *Controller) apiRequireUserFromPath(c echo.Context) (domain.User, error) {
+	userId, err := strconv.Atoi(c.Param("userId"))
+	if err != nil {
+		return domain.User{}, sendApiError(c, http.StatusBadRequest, "invalid user id")
+	}
+	user, err := getUserFromSession(c, controller)
+	if errors.Is(err, lang.ErrNotFound) {
+		return domain.User{}, sendApiError(c, http.StatusUnauthorized, "authentication required")
+	} else if err != nil {
+		return domain.User{}, sendApiInternalError(c, err)
+	}
+	if user.Id != userId {
+		return domain.User{}, sendApiError(c, http.StatusForbidden, "forbidden")
+	}
+	return user, nil
+}
+
+// apiRequireUserAndComment additionally resolves the comment in the path and verifies that it belongs to the user
+func (controller *Controller) apiRequireUserAndComment(c echo.Context) (domain.User, domain.Comment, error) {
+	user, err := controller.apiRequireUserFromPath(c)
+	if err != nil || !user.IsValid() {
+		return domain.User{}, domain.Comment{}, err
+	}
+	comment, err := controller.requireCommentAndRetrieve(c)
+	if err != nil {
+		return domain.User{}, domain.Comment{}, handleCommonApiErrors(c, err)
+	}
+	if comment.UserId != user.Id {
+		return domain.User{}, domain.Comment{}, sendApiError(c, http.StatusForbidden, "forbidden")
+	}
+	return user, comment, nil
+}
+
+// apiRequireAdmin returns the logged in admin when they administer at least one service, otherwise it sends the
+// appropriate error and returns an admin without id
+func (controller *Controller) apiRequireAdmin(c echo.Context) (domain.AdminUser, error) {
+	adminUser, err := controller.getAdminUser(c)
+	if errors.Is(err, lang.ErrNotFound) {
+		return domain.AdminUser{}, sendApiError(c, http.StatusUnauthorized, "admin authentication required")
+	} else if err != nil {
+		return domain.AdminUser{}, sendApiInternalError(c, err)
+	}
+	if !adminUser.Superadmin && len(adminUser.Services) == 0 {
+		return domain.AdminUser{}, sendApiError(c, http.StatusForbidden, "not an admin of any service")
+	}
+	return adminUser, nil
+}
+
+// apiRequireAdministeredComment resolves the comment in the path and verifies that the admin may moderate it
+func (controller *Controller) apiRequireAdministeredComment(c echo.Context) (domain.AdminUser, domain.Comment, error) {
+	adminUser, err := controller.apiRequireAdmin(c)
+	if err != nil || !adminUser.IsValid() {
+		return domain.AdminUser{}, domain.Comment{}, err
+	}
+	comment, err := controller.requireCommentAndRetrieve(c)
+	if err != nil {
+		return domain.AdminUser{}, domain.Comment{}, handleCommonApiErrors(c, err)
+	}
+	if !adminUser.CanAdminister(comment.ServiceId) {
+		return domain.AdminUser{}, domain.Comment{}, sendApiError(c, http.StatusForbidden, "forbidden")
+	}
+	return adminUser, comment, nil
+}
+
+func bindCommentRequest(c echo.Context) (domain.ApiCommentRequest, error) {
+	request := domain.ApiCommentRequest{}
+	if !strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), echo.MIMEApplicationJSON) {
+		return request, ErrIllegalArgument
+	}
+	err := c.Bind(&request)
+	if err != nil {
+		return request, ErrIllegalArgument
+	}
+	return request, nil
+}
+
+func (controller *Controller) ApiGetComments(c echo.Context) error {
+	service, err := controller.Store.GetServiceForKey(c.Param("serviceKey"))
+	if err != nil {
+		return handleCommonApiErrors(c, err)
+	}
+	cursor, limit, err := parsePageParams(c)
+	if err != nil {
+		return sendApiError(c, http.StatusBadRequest, err.Error())
+	}
+	page, err := controller.Store.GetCommentThreadsForPost(service.Id, c.Param("postKey"), cursor, limit)
+	if err != nil {
+		return sendApiInternalError(c, err)
+	}
+	comments := domain.NewApiCommentList(page.Comments)
+	comments.NextCursor = page.NextCursor.String()
+	return c.JSON(http.StatusOK, comments)
+}
+
+// maxCountedPosts limits how many posts can be counted with one request, this keeps the URLs short enough for
+// caches and proxies
+const maxCountedPosts = 100
+
+// setPublicCacheHeaders allows browsers and CDNs to cache the response for a minute and to keep serving it for a
+// while longer when the service is slow, sites on other origins may read it
+func setPublicCacheHeaders(c echo.Context) {
+	c.Response().Header().Set("Cache-Control", "public, max-age=60, stale-while-revalidate=300")
+	c.Response().Header().Set("Access-Control-Allow-Origin", "*")
+}
+
+// ApiGetCommentCounts returns the number of approved comments of the posts given as postKey query parameters
+func (controller *Controller) ApiGetCommentCounts(c echo.Context) error {
+	service, err := controller.Store.GetServiceForKey(c.Param("serviceKey"))
+	if err != nil {
+		return handleCommonApiErrors(c, err)
+	}
+	postKeys := c.QueryParams()["postKey"]
+	if len(postKeys) == 0 || len(postKeys) > maxCountedPosts {
+		return sendApiError(c, http.StatusBadRequest, fmt.Sprintf("between 1 and %d postKey parameters are required", maxCountedPosts))
+	}
+	counts, err := controller.Store.CountApprovedComments(service.Id, postKeys)
+	if err != nil {
+		return sendApiInternalError(c, err)
+	}
+	setPublicCacheHeaders(c)
+	return c.JSON(http.StatusOK, domain.ApiCommentCounts{Counts: counts})
+}
+
+const defaultApiPageSize = 50
+const maxApiPageSize = 200
+
+// parsePageParams reads the cursor and limit query parameters of paginated endpoints
+func parsePageParams(c echo.Context) (domain.CommentCursor, int, error) {
+	cursor, err := domain.ParseCommentCursor(c.QueryParam("cursor"))
+	if err != nil {
+		return domain.CommentCursor{}, 0, err
+	}
+	limit := defaultApiPageSize
+	if limitParam := c.QueryParam("limit"); limitParam != "" {
+		limit, err = strconv.Atoi(limitParam)
+		if err != nil || limit < 1 || limit > maxApiPageSize {
+			return domain.CommentCursor{}, 0, fmt.Errorf("the limit must be between 1 and %d", maxApiPageSize)
+		}
+	}
+	return cursor, limit, nil
+}
+
+func (controller *Controller) ApiPostComment(c echo.Context) error {
+	user, userSessionError := getUserFromSession(c, controller)
+	if userSessionError != nil && !errors.Is(userSessionError, lang.ErrNotFound) {
+		return sendApiInternalError(c, userSessionError)
+	}
+	userAuthenticated := userSessionError == nil
+	request, err := bindCommentRequest(c)
+	if err != nil {
+		return sendApiError(c, http.StatusBadRequest, "the request body must be a JSON comment")
+	}
+	if request.Comment == "" {
+		return sendApiError(c, http.StatusBadRequest, "the comment must not be empty")
+	}
+	if !userAuthenticated && request.Email == "" {
+		return sendApiError(c, http.StatusBadRequest, "an email address is required")
+	}
+	service, err := controller.Store.GetServiceForKey(c.Param("serviceKey"))
+	if err != nil {
+		return handleCommonApiErrors(c, err)
+	}
+	parentCommentIdString := ""
+	if request.ParentCommentId != 0 {
+		parentCommentIdString = strconv.Itoa(request.ParentCommentId)
+	}
+	comment, err := controller.createComment(service, user, userAuthenticated, request.Email, parentCommentIdString, domain.Comment{
+		PostKey:   c.Param("postKey"),
+		Comment:   request.Comment,
+		Name:      request.Name,
+		Website:   request.Website,
+		ParentUrl: request.ParentUrl,
+	}, nil)
+	if errors.Is(err, lang.ErrNotFound) || errors.Is(err, ErrIllegalArgument) {
+		return sendApiError(c, http.StatusBadRequest, "the parent comment does not accept replies")
+	} else if errors.Is(err, ErrSpam) {
+		return sendApiError(c, http.StatusBadRequest, ErrSpam.Error())
+	} else if err != nil {
+		return sendApiInternalError(c, err)
+	}
+	// read the comment back so that the response contains the creation time
+	comment, err = controller.Store.GetComment(comment.Id)
+	if err != nil {
+		return sendApiInternalError(c, err)
+	}
+	return c.JSON(http.StatusCreated, domain.NewApiManagedComment(comment))
+}
+
+func (controller *Controller) ApiGetCommentsForUser(c echo.Context) error {
+	user, err := controller.apiRequireUserFromPath(c)
+	if err != nil || !user.IsValid() {
+		return err
+	}
+	comments, err := controller.Store.GetCommentsForUser(user.Id)
+	if err != nil {
+		return sendApiInternalError(c, err)
+	}
+	return c.JSON(http.StatusOK, domain.NewApiManagedCommentList(comments))
+}
+
+func (controller *Controller) ApiUpdateUserComment(c echo.Context) error {
+	user, comment, err := controller.apiRequireUserAndComment(c)
+	if err != nil || !user.IsValid() {
+		return err
+	}
+	request, err := bindCommentRequest(c)
+	if err != nil {
+		return sendApiError(c, http.StatusBadRequest, "the request body must be a JSON comment")
+	}
+	if request.Comment == "" {
+		return sendApiError(c, http.StatusBadRequest, "the comment must not be empty")
+	}
+	_, err = controller.updateOwnComment(comment, request.Comment, request.Name, request.Website, request.ParentUrl)
+	if errors.Is(err, ErrCommentNotEditable) {
+		return sendApiError(c, http.StatusForbidden, ErrCommentNotEditable.Error())
+	} else if err != nil {
+		return handleCommonApiErrors(c, err)
+	}
+	comment, err = controller.Store.GetComment(comment.Id)
+	if err != nil {
+		return handleCommonApiErrors(c, err)
+	}
+	return c.JSON(http.StatusOK, domain.NewApiManagedComment(comment))
+}
+
+func (controller *Controller) ApiDeleteUserComment(c echo.Context) error {
+	user, comment, err := controller.apiRequireUserAndComment(c)
+	if err != nil || !user.IsValid() {
+		return err
+	}
+	err = controller.Store.DeleteComment(comment.Id, domain.UserActor(user.Id))
+	if err != nil {
+		return handleCommonApiErrors(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (controller *Controller) ApiConfirmUserComment(c echo.Context) error {
+	user, comment, err := controller.apiRequireUserAndComment(c)
+	if err != nil || !user.IsValid() {
+		return err
+	}
+	if comment.Status != domain.CommentStatusPendingAuthentication {
+		return sendApiError(c, http.StatusConflict, "the comment is not pending authentication")
+	}
+	// confirming only changes the status, the comment is not edited
+	err = controller.Store.UpdateCommentStatus(comment.Id, domain.CommentStatusPendingApproval, "", domain.UserActor(user.Id))
+	if err != nil {
+		return handleCommonApiErrors(c, err)
+	}
+	comment.Status = domain.CommentStatusPendingApproval
+	controller.activateSubscriptions(comment)
+	controller.notifyAdmins(comment)
+	return c.JSON(http.StatusOK, domain.NewApiManagedComment(comment))
+}
+
+func (controller *Controller) ApiGetAdminComments(c echo.Context) error {
+	adminUser, err := controller.apiRequireAdmin(c)
+	if err != nil || !adminUser.IsValid() {
+		return err
+	}
+	filter, err := parseCommentFilter(c, adminUser)
+	if err != nil {
+		return sendApiError(c, http.StatusBadRequest, "invalid filter")
+	}
+	cursor, limit, err := parsePageParams(c)
+	if err != nil {
+		return sendApiError(c, http.StatusBadRequest, err.Error())
+	}
+	page, err := controller.Store.GetComments(filter, cursor, limit)
+	if err != nil {
+		return sendApiInternalError(c, err)
+	}
+	comments := domain.NewApiManagedCommentList(page.Comments)
+	comments.NextCursor = page.NextCursor.String()
+	return c.JSON(http.StatusOK, comments)
+}
+
+func (controller *Controller) ApiAdminApproveComment(c echo.Context) error {
+	return controller.apiModerateComment(c, 0, domain.CommentStatusApproved, domain.ApiRejectRequest{})
+}
+
+// ApiAdminRejectComment accepts an optional ApiRejectRequest body with the reason for the rejection
+func (controller *Controller) ApiAdminRejectComment(c echo.Context) error {
+	request := domain.ApiRejectRequest{}
+	if c.Request().ContentLength != 0 {
+		if !strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), echo.MIMEApplicationJSON) || c.Bind(&request) != nil {
+			return sendApiError(c, http.StatusBadRequest, "invalid request")
+		}
+	}
+	return controller.apiModerateComment(c, 0, domain.CommentStatusRejected, request)
+}
+
+func (controller *Controller) ApiAdminUnapproveComment(c echo.Context) error {
+	return controller.apiModerateComment(c, domain.CommentStatusApproved, domain.CommentStatusPendingApproval, domain.ApiRejectRequest{})
+}
+
+func (controller *Controller) ApiAdminUnrejectComment(c echo.Context) error {
+	return controller.apiModerateComment(c, domain.CommentStatusRejected, domain.CommentStatusPendingApproval, domain.ApiRejectRequest{})
+}
+
+// apiModerateComment is the JSON counterpart of adminModerateComment, impossible status changes are a conflict
+func (controller *Controller) apiModerateComment(c echo.Context, expectedStatus domain.CommentStatus, newStatus domain.CommentStatus, request domain.ApiRejectRequest) error {
+	adminUser, comment, err := controller.apiRequireAdministeredComment(c)
+	if err != nil || !adminUser.IsValid() {
+		return err
+	}
+	reason := strings.TrimSpace(request.Reason)
+	if expectedStatus != 0 && comment.Status != expectedStatus {
+		err = ErrInvalidModeration
+	} else {
+		err = controller.moderateComment(adminUser, comment, newStatus, reason)
+	}
+	if errors.Is(err, ErrInvalidModeration) {
+		return sendApiError(c, http.StatusConflict, fmt.Sprintf("a comment that is %s can not be changed to %s", comment.Status, newStatus))
+	} else if err != nil {
+		return handleCommonApiErrors(c, err)
+	}
+	if request.NotifyAuthor {
+		err = controller.notifyAuthorOfRejection(comment, reason)
+		if err != nil {
+			logger.Error("Failed to notify author of rejected comment", "commentId", comment.Id, "error", err)
+		}
+	}
+	controller.notifySubscribers([]domain.Comment{comment}, newStatus, reason, request.NotifyAuthor)
+	updatedComment, err := controller.Store.GetComment(comment.Id)
+	if err != nil {
+		return handleCommonApiErrors(c, err)
+	}
+	return c.JSON(http.StatusOK, domain.NewApiManagedComment(updatedComment))
+}
+
+func (controller *Controller) ApiAdminDeleteComment(c echo.Context) error {
+	adminUser, comment, err := controller.apiRequireAdministeredComment(c)
+	if err != nil || !adminUser.IsValid() {
+		return err
+	}
+	err = controller.Store.DeleteComment(comment.Id, domain.AdminActor(adminUser))
+	if err != nil {
+		return handleCommonApiErrors(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,598 @@
+package server
+
+import (
+	"aggregat4/go-commentservice/internal/domain"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aggregat4/go-baselib/lang"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApiGetComments(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	res, err := http.Get(createServerUrl(serverConfig.Port, "/api/v1/services/"+TEST_SERVICE+"/posts/"+TEST_POSTKEY1+"/comments"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.True(t, strings.HasPrefix(res.Header.Get("Content-Type"), "application/json"))
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotContains(t, string(body), TEST_USER_AUTHTOKEN_VALID, "The email address of the author must never be exposed")
+	var list domain.ApiCommentList
+	assert.Nil(t, json.Unmarshal(body, &list))
+	assert.Equal(t, 1, len(list.Comments), "Only approved comments are public")
+	assert.Equal(t, TEST_COMMENT_APPROVED, list.Comments[0].Comment)
+	assert.Equal(t, TEST_AUTHOR1, list.Comments[0].Name)
+}
+
+func TestApiGetCommentsForUnknownService(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	res, err := http.Get(createServerUrl(serverConfig.Port, "/api/v1/services/NOSUCHSERVICE/posts/"+TEST_POSTKEY1+"/comments"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertApiError(t, res, http.StatusNotFound)
+}
+
+func TestApiGetCommentCounts(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	res, err := http.Get(createServerUrl(serverConfig.Port, "/api/v1/services/"+TEST_SERVICE+"/commentcounts?postKey="+TEST_POSTKEY1+"&postKey="+TEST_POSTKEY2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "public, max-age=60, stale-while-revalidate=300", res.Header.Get("Cache-Control"))
+	assert.Equal(t, "*", res.Header.Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, res.Header.Get("Set-Cookie"), "Cached responses must not set cookies")
+	var counts domain.ApiCommentCounts
+	assert.Nil(t, json.NewDecoder(res.Body).Decode(&counts))
+	assert.Equal(t, map[string]int{TEST_POSTKEY1: 1, TEST_POSTKEY2: 0}, counts.Counts, "Only approved comments are counted")
+}
+
+func TestApiGetCommentCountsFromStaticSiteBehindProxy(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	req, err := http.NewRequest(http.MethodGet, createServerUrl(serverConfig.Port, "/api/v1/services/"+TEST_SERVICE+"/commentcounts?postKey="+TEST_POSTKEY1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Host = "comments.example.com"
+	req.Header.Set("Origin", "https://blog.example.org")
+	res, err := createTestHttpClient(false).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "*", res.Header.Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, res.Header.Get("Access-Control-Allow-Credentials"), "Public responses can not be read with credentials")
+}
+
+func TestApiGetCommentCountsRequiresPostKeys(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	res, err := http.Get(createServerUrl(serverConfig.Port, "/api/v1/services/"+TEST_SERVICE+"/commentcounts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertApiError(t, res, http.StatusBadRequest)
+	query := url.Values{}
+	for i := 0; i <= maxCountedPosts; i++ {
+		query.Add("postKey", strconv.Itoa(i))
+	}
+	res, err = http.Get(createServerUrl(serverConfig.Port, "/api/v1/services/"+TEST_SERVICE+"/commentcounts?"+query.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertApiError(t, res, http.StatusBadRequest)
+	res, err = http.Get(createServerUrl(serverConfig.Port, "/api/v1/services/NOSUCHSERVICE/commentcounts?postKey="+TEST_POSTKEY1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertApiError(t, res, http.StatusNotFound)
+}
+
+func TestApiUnknownEndpoint(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	res, err := http.Get(createServerUrl(serverConfig.Port, "/api/v1/nosuchendpoint"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertApiError(t, res, http.StatusNotFound)
+}
+
+func TestApiPostCommentUnauthenticated(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	res := apiRequest(t, client, http.MethodPost, "/api/v1/services/"+TEST_SERVICE+"/posts/"+TEST_POSTKEY2+"/comments",
+		`{"email": "`+TEST_USER_NO_TOKEN+`", "name": "Jane", "comment": "A comment via the API"}`)
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	var comment domain.ApiManagedComment
+	assert.Nil(t, json.NewDecoder(res.Body).Decode(&comment))
+	assert.Equal(t, "pending-authentication", comment.Status)
+	assert.Equal(t, "A comment via the API", comment.Comment)
+	assert.Equal(t, TEST_POSTKEY2, comment.PostKey)
+	assert.False(t, comment.CreatedAt.IsZero())
+	storedComment, err := controller.Store.GetComment(comment.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := controller.Store.FindUserByEmail(TEST_USER_NO_TOKEN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, user.Id, storedComment.UserId)
+}
+
+func TestApiPostCommentAuthenticated(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	authenticateAndValidate(t, client, controller, TEST_USER_AUTHTOKEN_VALID, TEST_AUTHTOKEN_VALID)
+	res := apiRequest(t, client, http.MethodPost, "/api/v1/services/"+TEST_SERVICE+"/posts/"+TEST_POSTKEY2+"/comments",
+		`{"comment": "An authenticated comment via the API"}`)
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	var comment domain.ApiManagedComment
+	assert.Nil(t, json.NewDecoder(res.Body).Decode(&comment))
+	assert.Equal(t, "pending-approval", comment.Status)
+}
+
+func TestApiPostCommentWithoutContent(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	res := apiRequest(t, client, http.MethodPost, "/api/v1/services/"+TEST_SERVICE+"/posts/"+TEST_POSTKEY2+"/comments",
+		`{"email": "`+TEST_USER_NO_TOKEN+`"}`)
+	assertApiError(t, res, http.StatusBadRequest)
+	res = apiRequest(t, client, http.MethodPost, "/api/v1/services/"+TEST_SERVICE+"/posts/"+TEST_POSTKEY2+"/comments",
+		`not json`)
+	assertApiError(t, res, http.StatusBadRequest)
+}
+
+func TestApiPostCommentFromForeignOrigin(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	req, err := http.NewRequest(http.MethodPost, createServerUrl(serverConfig.Port, "/api/v1/services/"+TEST_SERVICE+"/posts/"+TEST_POSTKEY2+"/comments"),
+		strings.NewReader(`{"email": "`+TEST_USER_NO_TOKEN+`", "comment": "Cross site"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Origin", "http://evil.example.com")
+	res, err := createTestHttpClient(false).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertApiError(t, res, http.StatusForbidden)
+}
+
+func TestApiPostCommentFromServiceOrigin(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	req, err := http.NewRequest(http.MethodPost, createServerUrl(serverConfig.Port, "/api/v1/services/"+TEST_SERVICE+"/posts/"+TEST_POSTKEY2+"/comments"),
+		strings.NewReader(`{"email": "`+TEST_USER_NO_TOKEN+`", "comment": "From the blog"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Origin", "http://example.com")
+	res, err := createTestHttpClient(false).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	assert.Equal(t, "http://example.com", res.Header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", res.Header.Get("Access-Control-Allow-Credentials"))
+	assert.Contains(t, res.Header.Values("Vary"), "Origin")
+}
+
+func TestApiPreflightFromServiceOrigin(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	req, err := http.NewRequest(http.MethodOptions, createServerUrl(serverConfig.Port, "/api/v1/services/"+TEST_SERVICE+"/posts/"+TEST_POSTKEY2+"/comments"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Origin", "http://example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	res, err := createTestHttpClient(false).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+	assert.Equal(t, "http://example.com", res.Header.Get("Access-Control-Allow-Origin"))
+	assert.Contains(t, res.Header.Get("Access-Control-Allow-Methods"), http.MethodPost)
+	assert.Equal(t, "Content-Type", res.Header.Get("Access-Control-Allow-Headers"))
+}
+
+func TestApiPreflightFromForeignOrigin(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	req, err := http.NewRequest(http.MethodOptions, createServerUrl(serverConfig.Port, "/api/v1/services/"+TEST_SERVICE+"/posts/"+TEST_POSTKEY2+"/comments"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Origin", "http://evil.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	res, err := createTestHttpClient(false).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertApiError(t, res, http.StatusForbidden)
+	assert.Equal(t, "", res.Header.Get("Access-Control-Allow-Origin"))
+}
+
+func TestApiGetCommentsFromForeignOriginHasNoCorsHeaders(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	req, err := http.NewRequest(http.MethodGet, createServerUrl(serverConfig.Port, "/api/v1/services/"+TEST_SERVICE+"/posts/"+TEST_POSTKEY1+"/comments"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Origin", "http://evil.example.com")
+	res, err := createTestHttpClient(false).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "", res.Header.Get("Access-Control-Allow-Origin"))
+}
+
+func TestApiAdminFromServiceOriginIsForbidden(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	loginAdmin(t, client, domain.DefaultServiceAdminRole)
+	comment := findCommentByContent(TEST_COMMENTS, TEST_COMMENT_PENDING_APPROVAL)
+	req, err := http.NewRequest(http.MethodPost, createServerUrl(serverConfig.Port, "/api/v1/admin/comments/"+strconv.Itoa(comment.Id)+"/approve"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Origin", "http://example.com")
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertApiError(t, res, http.StatusForbidden)
+}
+
+func TestApiPostCommentBehindProxyWithoutPort(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	for _, test := range []struct {
+		origin    string
+		forwarded string
+		status    int
+	}{
+		{"http://comments.example.com", "", http.StatusCreated},
+		{"https://comments.example.com", "https", http.StatusCreated},
+		{"http://comments.example.com", "https", http.StatusForbidden},
+		{"https://comments.example.com", "", http.StatusForbidden},
+	} {
+		req, err := http.NewRequest(http.MethodPost, createServerUrl(serverConfig.Port, "/api/v1/services/"+TEST_SERVICE+"/posts/"+TEST_POSTKEY2+"/comments"),
+			strings.NewReader(`{"email": "`+TEST_USER_NO_TOKEN+`", "comment": "Behind a proxy"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// proxies on the default port send a Host header without a port
+		req.Host = "comments.example.com"
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Origin", test.origin)
+		if test.forwarded != "" {
+			req.Header.Set("X-Forwarded-Proto", test.forwarded)
+		}
+		res, err := createTestHttpClient(false).Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, test.status, res.StatusCode, test.origin+" forwarded as "+test.forwarded)
+		assert.Equal(t, "", res.Header.Get("Access-Control-Allow-Origin"), "Requests from this server need no CORS headers")
+		res.Body.Close()
+	}
+}
+
+func TestApiUserCommentsRequireAuthentication(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	user, err := controller.Store.FindUserByEmail(TEST_USER_AUTHTOKEN_VALID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := createTestHttpClient(false).Get(createServerUrl(serverConfig.Port, "/api/v1/users/"+strconv.Itoa(user.Id)+"/comments"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertApiError(t, res, http.StatusUnauthorized)
+}
+
+func TestApiGetCommentsForUser(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	user := authenticateAndValidate(t, client, controller, TEST_USER_AUTHTOKEN_VALID, TEST_AUTHTOKEN_VALID)
+	res, err := client.Get(createServerUrl(serverConfig.Port, "/api/v1/users/"+strconv.Itoa(user.Id)+"/comments"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var list domain.ApiManagedCommentList
+	assert.Nil(t, json.NewDecoder(res.Body).Decode(&list))
+	assert.Equal(t, 4, len(list.Comments))
+}
+
+func TestApiGetCommentsOfOtherUser(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	authenticateAndValidate(t, client, controller, TEST_USER_AUTHTOKEN_VALID2, TEST_AUTHTOKEN_VALID2)
+	otherUser, err := controller.Store.FindUserByEmail(TEST_USER_AUTHTOKEN_VALID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := client.Get(createServerUrl(serverConfig.Port, "/api/v1/users/"+strconv.Itoa(otherUser.Id)+"/comments"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertApiError(t, res, http.StatusForbidden)
+}
+
+func TestApiUpdateComment(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	user := authenticateAndValidate(t, client, controller, TEST_USER_AUTHTOKEN_VALID, TEST_AUTHTOKEN_VALID)
+	comment := findUserComment(t, controller, user, TEST_COMMENT_PENDING_APPROVAL)
+	res := apiRequest(t, client, http.MethodPut, "/api/v1/users/"+strconv.Itoa(user.Id)+"/comments/"+strconv.Itoa(comment.Id),
+		`{"name": "Jane", "comment": "Updated via the API"}`)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	updatedComment, err := controller.Store.GetComment(comment.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "Updated via the API", updatedComment.Comment)
+	assert.Equal(t, "Jane", updatedComment.Name)
+}
+
+func TestApiUpdateCommentDropsParentUrlOfOtherSites(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	user := authenticateAndValidate(t, client, controller, TEST_USER_AUTHTOKEN_VALID, TEST_AUTHTOKEN_VALID)
+	comment := findUserComment(t, controller, user, TEST_COMMENT_PENDING_APPROVAL)
+	res := apiRequest(t, client, http.MethodPut, "/api/v1/users/"+strconv.Itoa(user.Id)+"/comments/"+strconv.Itoa(comment.Id),
+		`{"comment": "Updated via the API", "parentUrl": "https://evil.example.net/phishing"}`)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	updatedComment, err := controller.Store.GetComment(comment.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "", updatedComment.ParentUrl)
+}
+
+func TestApiUpdateApprovedCommentIsForbidden(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	user := authenticateAndValidate(t, client, controller, TEST_USER_AUTHTOKEN_VALID, TEST_AUTHTOKEN_VALID)
+	comment := findUserComment(t, controller, user, TEST_COMMENT_APPROVED)
+	res := apiRequest(t, client, http.MethodPut, "/api/v1/users/"+strconv.Itoa(user.Id)+"/comments/"+strconv.Itoa(comment.Id),
+		`{"comment": "Sneaky change"}`)
+	assertApiError(t, res, http.StatusForbidden)
+}
+
+func TestApiDeleteComment(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	user := authenticateAndValidate(t, client, controller, TEST_USER_AUTHTOKEN_VALID, TEST_AUTHTOKEN_VALID)
+	comment := findUserComment(t, controller, user, TEST_COMMENT_REJECTED)
+	res := apiRequest(t, client, http.MethodDelete, "/api/v1/users/"+strconv.Itoa(user.Id)+"/comments/"+strconv.Itoa(comment.Id), "")
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+	_, err := controller.Store.GetComment(comment.Id)
+	assert.ErrorIs(t, err, lang.ErrNotFound)
+	res = apiRequest(t, client, http.MethodDelete, "/api/v1/users/"+strconv.Itoa(user.Id)+"/comments/"+strconv.Itoa(comment.Id), "")
+	assertApiError(t, res, http.StatusNotFound)
+}
+
+func TestApiConfirmComment(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	user := authenticateAndValidate(t, client, controller, TEST_USER_AUTHTOKEN_VALID, TEST_AUTHTOKEN_VALID)
+	comment := findUserComment(t, controller, user, TEST_COMMENT_PENDING_AUTHENTICATION)
+	confirmPath := "/api/v1/users/" + strconv.Itoa(user.Id) + "/comments/" + strconv.Itoa(comment.Id) + "/confirm"
+	res := apiRequest(t, client, http.MethodPost, confirmPath, "")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var confirmedComment domain.ApiManagedComment
+	assert.Nil(t, json.NewDecoder(res.Body).Decode(&confirmedComment))
+	assert.Equal(t, "pending-approval", confirmedComment.Status)
+	res = apiRequest(t, client, http.MethodPost, confirmPath, "")
+	assertApiError(t, res, http.StatusConflict)
+}
+
+func TestApiAdminRequiresAuthentication(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	res, err := client.Get(createServerUrl(serverConfig.Port, "/api/v1/admin/comments"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertApiError(t, res, http.StatusUnauthorized)
+	comment := findCommentByContent(TEST_COMMENTS, TEST_COMMENT_PENDING_APPROVAL)
+	res = apiRequest(t, client, http.MethodPost, "/api/v1/admin/comments/"+strconv.Itoa(comment.Id)+"/approve", "")
+	assertApiError(t, res, http.StatusUnauthorized)
+}
+
+func TestApiAdminCommentsAreFilteredByService(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	otherComment := createCommentOnOtherService(t, controller)
+	client := createTestHttpClient(false)
+	loginAdmin(t, client, OTHER_SERVICE_ADMIN_ROLE)
+	res, err := client.Get(createServerUrl(serverConfig.Port, "/api/v1/admin/comments"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var list domain.ApiManagedCommentList
+	assert.Nil(t, json.NewDecoder(res.Body).Decode(&list))
+	assert.Equal(t, 1, len(list.Comments))
+	assert.Equal(t, otherComment.Id, list.Comments[0].Id)
+
+	comment := findCommentByContent(TEST_COMMENTS, TEST_COMMENT_PENDING_APPROVAL)
+	res = apiRequest(t, client, http.MethodPost, "/api/v1/admin/comments/"+strconv.Itoa(comment.Id)+"/approve", "")
+	assertApiError(t, res, http.StatusForbidden)
+	res = apiRequest(t, client, http.MethodPost, "/api/v1/admin/comments/"+strconv.Itoa(otherComment.Id)+"/approve", "")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+}
+
+func TestApiAdminRejectAndUnrejectComment(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	loginAdmin(t, client, domain.DefaultServiceAdminRole)
+	comment := findCommentByContent(TEST_COMMENTS, TEST_COMMENT_PENDING_APPROVAL)
+	res := apiRequest(t, client, http.MethodPost, "/api/v1/admin/comments/"+strconv.Itoa(comment.Id)+"/reject", `{"reason": "Spam"}`)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var rejectedComment domain.ApiManagedComment
+	assert.Nil(t, json.NewDecoder(res.Body).Decode(&rejectedComment))
+	assert.Equal(t, "rejected", rejectedComment.Status)
+	assert.Equal(t, "Spam", rejectedComment.RejectionReason)
+
+	res = apiRequest(t, client, http.MethodPost, "/api/v1/admin/comments/"+strconv.Itoa(comment.Id)+"/reject", "")
+	assertApiError(t, res, http.StatusConflict)
+	res = apiRequest(t, client, http.MethodPost, "/api/v1/admin/comments/"+strconv.Itoa(comment.Id)+"/unapprove", "")
+	assertApiError(t, res, http.StatusConflict)
+
+	res = apiRequest(t, client, http.MethodPost, "/api/v1/admin/comments/"+strconv.Itoa(comment.Id)+"/unreject", "")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var reopenedComment domain.ApiManagedComment
+	assert.Nil(t, json.NewDecoder(res.Body).Decode(&reopenedComment))
+	assert.Equal(t, "pending-approval", reopenedComment.Status)
+	assert.Equal(t, "", reopenedComment.RejectionReason, "The reason is only kept for rejected comments")
+}
+
+func TestApiAdminCommentsArePaginatedAndFiltered(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	loginAdmin(t, client, domain.DefaultServiceAdminRole)
+	seen := map[int]bool{}
+	path := "/api/v1/admin/comments?limit=3"
+	for pages := 0; path != ""; pages++ {
+		res, err := client.Get(createServerUrl(serverConfig.Port, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		var list domain.ApiManagedCommentList
+		assert.Nil(t, json.NewDecoder(res.Body).Decode(&list))
+		assert.LessOrEqual(t, len(list.Comments), 3)
+		for _, comment := range list.Comments {
+			seen[comment.Id] = true
+		}
+		path = ""
+		if list.NextCursor != "" {
+			path = "/api/v1/admin/comments?limit=3&cursor=" + url.QueryEscape(list.NextCursor)
+		}
+		assert.Less(t, pages, 2, "Four comments fit on two pages")
+	}
+	assert.Equal(t, 4, len(seen), "All test comments are listed exactly once")
+
+	res, err := client.Get(createServerUrl(serverConfig.Port, "/api/v1/admin/comments?author="+url.QueryEscape(TEST_USER_AUTHTOKEN_VALID2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list domain.ApiManagedCommentList
+	assert.Nil(t, json.NewDecoder(res.Body).Decode(&list))
+	assert.Equal(t, 0, len(list.Comments))
+
+	res, err = client.Get(createServerUrl(serverConfig.Port, "/api/v1/admin/comments?limit=1000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertApiError(t, res, http.StatusBadRequest)
+	res, err = client.Get(createServerUrl(serverConfig.Port, "/api/v1/admin/comments?cursor=garbage"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertApiError(t, res, http.StatusBadRequest)
+}
+
+func findUserComment(t *testing.T, controller Controller, user domain.User, content string) domain.Comment {
+	comments, err := controller.Store.GetCommentsForUser(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	comment := findCommentByContent(comments, content)
+	if comment.Id == 0 {
+		t.Fatalf("No comment with content %q", content)
+	}
+	return comment
+}
+
+// apiRequest sends a JSON request from the same origin as the server
+func apiRequest(t *testing.T, client *http.Client, method string, path string, body string) *http.Response {
+	req, err := http.NewRequest(method, createServerUrl(serverConfig.Port, path), strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Origin", "http://localhost:"+strconv.Itoa(serverConfig.Port))
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func assertApiError(t *testing.T, res *http.Response, status int) {
+	assert.Equal(t, status, res.StatusCode)
+	assert.True(t, strings.HasPrefix(res.Header.Get("Content-Type"), "application/json"))
+	var apiError domain.ApiError
+	assert.Nil(t, json.NewDecoder(res.Body).Decode(&apiError))
+	assert.Equal(t, status, apiError.Status)
+	assert.NotEmpty(t, apiError.Message)
+}
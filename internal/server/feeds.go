@@ -0,0 +1,162 @@
+package server
+
+import (
+	"aggregat4/go-commentservice/internal/domain"
+	"aggregat4/go-commentservice/internal/markup"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// commentsPerFeed is the number of newest approved comments in a feed
+const commentsPerFeed = 50
+
+// GetPostCommentsFeed returns an Atom feed of the newest approved comments of a post
+func (controller *Controller) GetPostCommentsFeed(c echo.Context) error {
+	return controller.sendCommentsFeed(c, c.Param("postKey"))
+}
+
+// GetServiceCommentsFeed returns an Atom feed of the newest approved comments of all posts of a service
+func (controller *Controller) GetServiceCommentsFeed(c echo.Context) error {
+	return controller.sendCommentsFeed(c, "")
+}
+
+func (controller *Controller) sendCommentsFeed(c echo.Context, postKey string) error {
+	service, err := controller.Store.GetServiceForKey(c.Param("serviceKey"))
+	if err != nil {
+		return handleCommonErrors(c, err)
+	}
+	changedAt, err := controller.Store.GetCommentsChangedAt(service.Id, postKey)
+	if err != nil {
+		return sendInternalError(c, err)
+	}
+	page, err := controller.Store.GetComments(domain.CommentFilter{
+		ServiceIds: []int{service.Id},
+		Statuses:   []domain.CommentStatus{domain.CommentStatusApproved},
+		PostKey:    postKey,
+	}, domain.CommentCursor{}, commentsPerFeed)
+	if err != nil {
+		return sendInternalError(c, err)
+	}
+	commentIds := make([]int, 0, len(page.Comments))
+	for _, comment := range page.Comments {
+		commentIds = append(commentIds, comment.Id)
+	}
+	editedAt, err := controller.Store.GetCommentsEditedAt(commentIds)
+	if err != nil {
+		return sendInternalError(c, err)
+	}
+	feed := controller.commentsFeed(service, postKey, page.Comments, editedAt, changedAt, c.Request().URL.Path)
+	body, err := xml.Marshal(feed)
+	if err != nil {
+		return sendInternalError(c, err)
+	}
+	body = append([]byte(xml.Header), body...)
+	// the ETag is weak since the gzip middleware changes the bytes that are sent
+	hash := sha256.Sum256(body)
+	etag := `W/"` + hex.EncodeToString(hash[:])[:16] + `"`
+	setPublicCacheHeaders(c)
+	c.Response().Header().Set("ETag", etag)
+	if !changedAt.IsZero() {
+		c.Response().Header().Set("Last-Modified", changedAt.UTC().Format(http.TimeFormat))
+	}
+	if notModified(c.Request(), etag, changedAt) {
+		return c.NoContent(http.StatusNotModified)
+	}
+	return c.Blob(http.StatusOK, "application/atom+xml; charset=utf-8", body)
+}
+
+func (controller *Controller) commentsFeed(service *domain.Service, postKey string, comments []domain.Comment, editedAt map[int]time.Time, changedAt time.Time, path string) domain.AtomFeed {
+	feedUrl := strings.TrimSuffix(controller.Config.BaseURL, "/") + path
+	feed := domain.AtomFeed{
+		Id:      feedUrl,
+		Title:   "Comments on " + service.ServiceKey,
+		Updated: changedAt.UTC().Format(time.RFC3339),
+		Links:   []domain.AtomLink{{Rel: "self", Type: "application/atom+xml", Href: feedUrl}},
+		Entries: make([]domain.AtomEntry, 0, len(comments)),
+	}
+	if postKey != "" {
+		feed.Title = "Comments on " + postKey
+	}
+	// the feed links to the site of the service, or to the post when one of its comments knows where it is shown
+	alternateUrl := ""
+	if len(service.Origins) > 0 {
+		alternateUrl, _ = feedLinkUrl(service.Origins[0])
+	}
+	for _, comment := range comments {
+		if parentUrl, ok := feedLinkUrl(comment.ParentUrl); ok && postKey != "" {
+			alternateUrl = parentUrl
+			break
+		}
+	}
+	if alternateUrl != "" {
+		feed.Links = append(feed.Links, domain.AtomLink{Rel: "alternate", Type: "text/html", Href: alternateUrl})
+	}
+	host := hostOf(controller.Config.BaseURL)
+	for _, comment := range comments {
+		author := comment.Name
+		if author == "" {
+			author = "Anonymous"
+		}
+		updated := comment.CreatedAt
+		if edited, ok := editedAt[comment.Id]; ok && edited.After(updated) {
+			updated = edited
+		}
+		entry := domain.AtomEntry{
+			// tag URIs (RFC 4151) stay the same when the service key changes
+			Id:        "tag:" + host + "," + comment.CreatedAt.UTC().Format(time.DateOnly) + ":comment-" + strconv.Itoa(comment.Id),
+			Title:     author + " on " + comment.PostKey,
+			Published: comment.CreatedAt.UTC().Format(time.RFC3339),
+			Updated:   updated.UTC().Format(time.RFC3339),
+			Author:    domain.AtomPerson{Name: author},
+			Links:     []domain.AtomLink{},
+			Content:   domain.AtomContent{Type: "html", Body: string(markup.Render(comment.Comment))},
+		}
+		if parentUrl, ok := feedLinkUrl(comment.ParentUrl); ok {
+			entry.Links = append(entry.Links, domain.AtomLink{Rel: "alternate", Type: "text/html", Href: parentUrl})
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	return feed
+}
+
+// feedLinkUrl returns the url when it is an absolute http or https URL, the parent URL of comments is given by the
+// embedding page and is not checked when comments are posted
+func feedLinkUrl(link string) (string, bool) {
+	parsedUrl, err := url.Parse(link)
+	if err != nil || (parsedUrl.Scheme != "http" && parsedUrl.Scheme != "https") || parsedUrl.Host == "" {
+		return "", false
+	}
+	return parsedUrl.String(), true
+}
+
+func hostOf(link string) string {
+	parsedUrl, err := url.Parse(link)
+	if err != nil || parsedUrl.Hostname() == "" {
+		return "localhost"
+	}
+	return parsedUrl.Hostname()
+}
+
+// notModified evaluates the conditional request headers. Like in RFC 9110 If-Modified-Since is only used when there
+// is no If-None-Match, and entity tags are compared weakly.
+func notModified(request *http.Request, etag string, lastModified time.Time) bool {
+	if ifNoneMatch := request.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		for _, candidate := range strings.Split(ifNoneMatch, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	ifModifiedSince, err := http.ParseTime(request.Header.Get("If-Modified-Since"))
+	return err == nil && !lastModified.IsZero() && !lastModified.After(ifModifiedSince)
+}
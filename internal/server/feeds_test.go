@@ -0,0 +1,101 @@
+package server
+
+import (
+	"aggregat4/go-commentservice/internal/domain"
+	"encoding/xml"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func getFeed(t *testing.T, path string, headers map[string]string) *http.Response {
+	req, err := http.NewRequest(http.MethodGet, createServerUrl(serverConfig.Port, path), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestPostCommentsFeed(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	res := getFeed(t, "/services/"+TEST_SERVICE+"/posts/"+TEST_POSTKEY1+"/feed.atom", nil)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "application/atom+xml; charset=utf-8", res.Header.Get("Content-Type"))
+	body := readBody(res)
+	assert.NotContains(t, body, TEST_USER_AUTHTOKEN_VALID, "The email address of the author must never be exposed")
+	var feed domain.AtomFeed
+	assert.Nil(t, xml.Unmarshal([]byte(body), &feed))
+	assert.Equal(t, "Comments on "+TEST_POSTKEY1, feed.Title)
+	assert.Equal(t, 1, len(feed.Entries), "Only approved comments are in the feed")
+	entry := feed.Entries[0]
+	assert.Equal(t, TEST_AUTHOR1, entry.Author.Name)
+	assert.Equal(t, "html", entry.Content.Type)
+	assert.Equal(t, "<p>"+TEST_COMMENT_APPROVED+"</p>", entry.Content.Body)
+	assert.Equal(t, []domain.AtomLink{{Rel: "alternate", Type: "text/html", Href: "https://example.com"}}, entry.Links)
+	assert.Contains(t, feed.Links, domain.AtomLink{Rel: "alternate", Type: "text/html", Href: "https://example.com"})
+
+	res = getFeed(t, "/services/"+TEST_SERVICE+"/posts/"+TEST_POSTKEY2+"/feed.atom", nil)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	feed = domain.AtomFeed{}
+	assert.Nil(t, xml.Unmarshal([]byte(readBody(res)), &feed))
+	assert.Equal(t, 0, len(feed.Entries))
+
+	res = getFeed(t, "/services/unknown/posts/"+TEST_POSTKEY1+"/feed.atom", nil)
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+}
+
+func TestServiceCommentsFeed(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	createApprovedComments(t, controller, TEST_POSTKEY2, 2)
+	res := getFeed(t, "/services/"+TEST_SERVICE+"/feed.atom", nil)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var feed domain.AtomFeed
+	assert.Nil(t, xml.Unmarshal([]byte(readBody(res)), &feed))
+	assert.Equal(t, "Comments on "+TEST_SERVICE, feed.Title)
+	assert.Equal(t, 3, len(feed.Entries))
+	postKeys := make(map[string]int)
+	for _, entry := range feed.Entries {
+		postKeys[entry.Title]++
+	}
+	assert.Equal(t, map[string]int{TEST_AUTHOR1 + " on " + TEST_POSTKEY1: 1, "Anonymous on " + TEST_POSTKEY2: 2}, postKeys)
+}
+
+func TestCommentsFeedConditionalGet(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	path := "/services/" + TEST_SERVICE + "/posts/" + TEST_POSTKEY1 + "/feed.atom"
+	res := getFeed(t, path, nil)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	etag := res.Header.Get("ETag")
+	lastModified := res.Header.Get("Last-Modified")
+	assert.NotEmpty(t, etag)
+	assert.NotEmpty(t, lastModified)
+	assert.Equal(t, "public, max-age=60, stale-while-revalidate=300", res.Header.Get("Cache-Control"))
+
+	res = getFeed(t, path, map[string]string{"If-None-Match": etag})
+	assert.Equal(t, http.StatusNotModified, res.StatusCode)
+	assert.Empty(t, readBody(res))
+	res = getFeed(t, path, map[string]string{"If-Modified-Since": lastModified})
+	assert.Equal(t, http.StatusNotModified, res.StatusCode)
+	res = getFeed(t, path, map[string]string{"If-None-Match": `"outdated"`, "If-Modified-Since": lastModified})
+	assert.Equal(t, http.StatusOK, res.StatusCode, "The ETag takes precedence over the modification time")
+
+	approved := findCommentByContent(TEST_COMMENTS, TEST_COMMENT_APPROVED)
+	assert.Nil(t, controller.Store.DeleteComment(approved.Id, testAdminActor))
+	res = getFeed(t, path, map[string]string{"If-None-Match": etag})
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.NotEqual(t, etag, res.Header.Get("ETag"))
+}
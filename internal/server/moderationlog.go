@@ -0,0 +1,86 @@
+package server
+
+import (
+	"aggregat4/go-commentservice/internal/domain"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+const moderationLogEntriesPerPage = 50
+
+func (controller *Controller) GetAdminModerationLog(c echo.Context) error {
+	adminUser, err := controller.requireAdmin(c)
+	if err != nil || !adminUser.IsValid() {
+		return err
+	}
+	filter, err := parseModerationLogFilter(c, adminUser)
+	if err != nil {
+		return renderBadRequest(c)
+	}
+	cursor := 0
+	if cursorParam := c.QueryParam("cursor"); cursorParam != "" {
+		cursor, err = strconv.Atoi(cursorParam)
+		if err != nil || cursor < 0 {
+			return renderBadRequest(c)
+		}
+	}
+	page, err := controller.Store.GetModerationLog(filter, cursor, moderationLogEntriesPerPage)
+	if err != nil {
+		return sendInternalError(c, err)
+	}
+	nextPageUrl := ""
+	if page.NextCursor > 0 {
+		nextPageParams := c.Request().URL.Query()
+		nextPageParams.Set("cursor", strconv.Itoa(page.NextCursor))
+		nextPageUrl = "/admin/moderationlog?" + nextPageParams.Encode()
+	}
+	return c.Render(http.StatusOK, "admin-moderationlog", domain.AdminModerationLogPage{
+		BasePage: domain.BasePage{
+			Stylesheets: templateStylesheets,
+			Scripts:     templateScripts,
+		},
+		AdminUser: adminUser,
+		Entries:   page.Entries,
+		Filter: domain.ModerationLogFilterForm{
+			ServiceKey: c.QueryParam("service"),
+			CommentId:  c.QueryParam("commentId"),
+			ActorKind:  c.QueryParam("actorKind"),
+			Actor:      c.QueryParam("actor"),
+			From:       c.QueryParam("from"),
+			To:         c.QueryParam("to"),
+		},
+		NextPageUrl: nextPageUrl,
+	})
+}
+
+// parseModerationLogFilter reads the filters of the moderation log from the query parameters. Services and dates are
+// handled like in the comment filter, the actor is an admin subject or a user id.
+func parseModerationLogFilter(c echo.Context, adminUser domain.AdminUser) (domain.ModerationLogFilter, error) {
+	filter := domain.ModerationLogFilter{
+		ServiceIds: filterServiceIds(c, adminUser),
+		Actor:      domain.Actor{Id: strings.TrimSpace(c.QueryParam("actor"))},
+	}
+	if commentIdParam := strings.TrimSpace(c.QueryParam("commentId")); commentIdParam != "" {
+		commentId, err := strconv.Atoi(commentIdParam)
+		if err != nil || commentId <= 0 {
+			return domain.ModerationLogFilter{}, ErrIllegalArgument
+		}
+		filter.CommentId = commentId
+	}
+	if actorKindParam := c.QueryParam("actorKind"); actorKindParam != "" {
+		actorKind, err := domain.ParseActorKind(actorKindParam)
+		if err != nil {
+			return domain.ModerationLogFilter{}, ErrIllegalArgument
+		}
+		filter.Actor.Kind = actorKind
+	}
+	var err error
+	filter.From, filter.To, err = parseFilterDays(c)
+	if err != nil {
+		return domain.ModerationLogFilter{}, err
+	}
+	return filter, nil
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"aggregat4/go-commentservice/internal/domain"
+	"aggregat4/go-commentservice/internal/email"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	baseliboidc "github.com/aggregat4/go-baselib-services/v3/oidc"
+	"github.com/aggregat4/go-baselib/lang"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+// adminDigestCheckInterval is how often digests are checked for being due, digests are sent at most this late
+const adminDigestCheckInterval = 5 * time.Minute
+
+func (controller *Controller) GetAdminNotifications(c echo.Context) error {
+	adminUser, err := controller.requireAdmin(c)
+	if err != nil || !adminUser.IsValid() {
+		return err
+	}
+	settings, err := controller.Store.GetAdminNotificationSettings(adminUser.UserId)
+	if err != nil && !errors.Is(err, lang.ErrNotFound) {
+		return sendInternalError(c, err)
+	}
+	successFlashes, errorFlashes, err := baseliboidc.GetFlashes(c)
+	if err != nil {
+		return sendInternalError(c, err)
+	}
+	return c.Render(http.StatusOK, "admin-notifications", domain.AdminNotificationsPage{
+		BasePage: domain.BasePage{
+			Stylesheets: templateStylesheets,
+			Scripts:     templateScripts,
+			Error:       errorFlashes,
+			Success:     successFlashes,
+		},
+		AdminUser: adminUser,
+		Settings:  settings,
+	})
+}
+
+func (controller *Controller) UpdateAdminNotifications(c echo.Context) error {
+	adminUser, err := controller.requireAdmin(c)
+	if err != nil || !adminUser.IsValid() {
+		return err
+	}
+	mode, err := domain.ParseAdminNotificationMode(c.FormValue("mode"))
+	if err != nil {
+		return renderBadRequest(c)
+	}
+	emailAddress := strings.TrimSpace(c.FormValue("email"))
+	if mode != domain.AdminNotificationsOff {
+		if _, err := mail.ParseAddress(emailAddress); err != nil {
+			//nolint:errcheck
+			baseliboidc.SetFlash(c, "error", "Notifications need a valid email address.")
+			return c.Redirect(http.StatusFound, "/admin/notifications")
+		}
+	}
+	roles, err := getAdminRolesFromSession(c)
+	if err != nil {
+		return sendInternalError(c, err)
+	}
+	err = controller.Store.SaveAdminNotificationSettings(adminUser.UserId, emailAddress, mode, roles)
+	if err != nil {
+		return sendInternalError(c, err)
+	}
+	logger.Info("Updated notification settings", "mode", mode.String(), "admin", adminUser.UserId)
+	//nolint:errcheck
+	baseliboidc.SetFlash(c, "success", "Your notification settings have been saved.")
+	return c.Redirect(http.StatusFound, "/admin/notifications")
+}
+
+func pendingComment(comment domain.Comment) email.PendingComment {
+	return email.PendingComment{
+		CommentId:  comment.Id,
+		ServiceKey: comment.ServiceKey,
+		PostKey:    comment.PostKey,
+		ParentUrl:  comment.ParentUrl,
+		Name:       comment.Name,
+		Comment:    comment.Comment,
+	}
+}
+
+// notifyAdmins tells the admins of the comment's service that it waits for approval, or adds it to their next
+// digest. The comment has already been saved at this point, so failures are only logged.
+func (controller *Controller) notifyAdmins(comment domain.Comment) {
+	service, err := controller.Store.FindServiceById(comment.ServiceId)
+	if err != nil {
+		logger.Error("Failed to find the service of a comment for notifications", "commentId", comment.Id, "error", err)
+		return
+	}
+	allSettings, err := controller.Store.GetAllAdminNotificationSettings()
+	if err != nil {
+		logger.Error("Failed to read the notification settings of admins", "commentId", comment.Id, "error", err)
+		return
+	}
+	for _, settings := range allSettings {
+		if !settings.NotifiesAbout(service, controller.Config.SuperadminRole) {
+			continue
+		}
+		if settings.Mode == domain.AdminNotificationsImmediate {
+			err = controller.EmailSender.SendNotification(email.PendingCommentEmail{EmailAddress: settings.Email, Comment: pendingComment(comment)})
+		} else {
+			err = controller.Store.AddAdminDigestComment(settings.Id, comment.Id)
+		}
+		if err != nil {
+			logger.Error("Failed to notify an admin about a pending comment", "commentId", comment.Id, "admin", settings.AdminId, "error", err)
+		}
+	}
+}
+
+// SendAdminDigests sends the digests that are due at the given time. Comments that no longer wait for approval by
+// the time the digest is sent are left out.
+func (controller *Controller) SendAdminDigests(now time.Time) {
+	allSettings, err := controller.Store.GetAllAdminNotificationSettings()
+	if err != nil {
+		logger.Error("Failed to read the notification settings of admins", "error", err)
+		return
+	}
+	for _, settings := range allSettings {
+		if !settings.DigestDue(now) {
+			continue
+		}
+		commentIds, err := controller.Store.GetAdminDigestComments(settings.Id)
+		if err != nil {
+			logger.Error("Failed to read the comments of a digest", "admin", settings.AdminId, "error", err)
+			continue
+		}
+		comments, err := controller.Store.GetCommentsByIds(commentIds)
+		if err != nil {
+			logger.Error("Failed to read the comments of a digest", "admin", settings.AdminId, "error", err)
+			continue
+		}
+		digest := email.PendingCommentsDigestEmail{EmailAddress: settings.Email, Comments: []email.PendingComment{}}
+		for _, comment := range comments {
+			if comment.Status == domain.CommentStatusPendingApproval {
+				digest.Comments = append(digest.Comments, pendingComment(comment))
+			}
+		}
+		if len(digest.Comments) > 0 {
+			err = controller.EmailSender.SendNotification(digest)
+			if err != nil {
+				// the comments stay in the digest, it is tried again the next time the digests are checked
+				logger.Error("Failed to send a digest", "admin", settings.AdminId, "error", err)
+				continue
+			}
+		}
+		err = controller.Store.CompleteAdminDigest(settings.Id, commentIds, now)
+		if err != nil {
+			logger.Error("Failed to complete a digest", "admin", settings.AdminId, "error", err)
+		}
+	}
+}
+
+// runAdminDigests sends the digests that are due until the server stops
+func (controller *Controller) runAdminDigests() {
+	ticker := time.NewTicker(adminDigestCheckInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		controller.SendAdminDigests(now)
+	}
+}
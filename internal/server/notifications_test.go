@@ -0,0 +1,213 @@
+package server
+
+import (
+	"aggregat4/go-commentservice/internal/domain"
+	"aggregat4/go-commentservice/internal/email"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func outboxEmailsOfKind(t *testing.T, controller Controller, kind string) []domain.OutboxEmail {
+	emails, err := controller.Store.GetOutboxEmails([]domain.OutboxStatus{}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	emailsOfKind := make([]domain.OutboxEmail, 0)
+	for _, outboxEmail := range emails {
+		if outboxEmail.Kind == kind {
+			emailsOfKind = append(emailsOfKind, outboxEmail)
+		}
+	}
+	return emailsOfKind
+}
+
+func saveAdminNotifications(t *testing.T, client *http.Client, emailAddress string, mode string) *http.Response {
+	formParams := url.Values{"email": {emailAddress}, "mode": {mode}}
+	return postWithOrigin(t, client, createServerUrl(serverConfig.Port, "/admin/notifications"),
+		"application/x-www-form-urlencoded", strings.NewReader(formParams.Encode()))
+}
+
+func TestAdminNotificationSettings(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	loginAdmin(t, client, domain.DefaultServiceAdminRole)
+	res, err := client.Get(createServerUrl(serverConfig.Port, "/admin/notifications"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Contains(t, readBody(res), `value="off" checked`)
+
+	res = saveAdminNotifications(t, client, "not an email address", "immediate")
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	_, err = controller.Store.GetAdminNotificationSettings("admin")
+	assert.NotNil(t, err, "Notifications need a valid email address")
+	res = saveAdminNotifications(t, client, "admin@example.com", "sometimes")
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	res = saveAdminNotifications(t, client, "admin@example.com", "daily")
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	settings, err := controller.Store.GetAdminNotificationSettings("admin")
+	assert.Nil(t, err)
+	assert.Equal(t, "admin@example.com", settings.Email)
+	assert.Equal(t, domain.AdminNotificationsDaily, settings.Mode)
+	assert.Equal(t, []string{domain.DefaultServiceAdminRole}, settings.Roles)
+	res, err = client.Get(createServerUrl(serverConfig.Port, "/admin/notifications"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := readBody(res)
+	assert.Contains(t, body, `value="admin@example.com"`)
+	assert.Contains(t, body, `value="daily" checked`)
+
+	res = saveAdminNotifications(t, client, "", "off")
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	_, err = controller.Store.GetAdminNotificationSettings("admin")
+	assert.NotNil(t, err)
+}
+
+func TestAdminsAreNotifiedImmediatelyAboutPendingComments(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	assert.Nil(t, controller.Store.SaveAdminNotificationSettings("admin", "admin@example.com", domain.AdminNotificationsImmediate, []string{domain.DefaultServiceAdminRole}))
+	assert.Nil(t, controller.Store.SaveAdminNotificationSettings("other-admin", "other-admin@example.com", domain.AdminNotificationsImmediate, []string{"other-service-admin"}))
+	assert.Nil(t, controller.Store.SaveAdminNotificationSettings("superadmin", "superadmin@example.com", domain.AdminNotificationsImmediate, []string{serverConfig.SuperadminRole}))
+
+	client := createTestHttpClient(false)
+	user := authenticateAndValidate(t, client, controller, TEST_USER_AUTHTOKEN_VALID, TEST_AUTHTOKEN_VALID)
+	commentId := findCommentByContent(TEST_COMMENTS, TEST_COMMENT_PENDING_AUTHENTICATION).Id
+	res := confirmComment(t, client, user.Id, commentId)
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+
+	notifications := outboxEmailsOfKind(t, controller, "pending-comment")
+	recipients := make([]string, 0)
+	for _, notification := range notifications {
+		recipients = append(recipients, notification.Recipient)
+		assert.Contains(t, notification.Payload, TEST_COMMENT_PENDING_AUTHENTICATION)
+		assert.Contains(t, notification.Payload, `"CommentId":`+strconv.Itoa(commentId))
+		assert.NotContains(t, notification.Payload, TEST_USER_AUTHTOKEN_VALID, "Admins see the name of the author, not the email address")
+	}
+	assert.ElementsMatch(t, []string{"admin@example.com", "superadmin@example.com"}, recipients)
+
+	// the link in the email shows only that comment
+	adminClient := createTestHttpClient(false)
+	loginAdmin(t, adminClient, domain.DefaultServiceAdminRole)
+	res, err := adminClient.Get(createServerUrl(serverConfig.Port, "/admin/comments?commentId="+strconv.Itoa(commentId)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	body := readBody(res)
+	assert.Contains(t, body, TEST_COMMENT_PENDING_AUTHENTICATION)
+	assert.NotContains(t, body, TEST_COMMENT_PENDING_APPROVAL)
+	assert.Contains(t, body, "Only comment "+strconv.Itoa(commentId)+" is shown.")
+}
+
+func TestAdminsGetDigestsOfPendingComments(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	assert.Nil(t, controller.Store.SaveAdminNotificationSettings("admin", "admin@example.com", domain.AdminNotificationsHourly, []string{domain.DefaultServiceAdminRole}))
+	client := createTestHttpClient(false)
+	user := authenticateAndValidate(t, client, controller, TEST_USER_AUTHTOKEN_VALID, TEST_AUTHTOKEN_VALID)
+	commentId := findCommentByContent(TEST_COMMENTS, TEST_COMMENT_PENDING_AUTHENTICATION).Id
+	res := confirmComment(t, client, user.Id, commentId)
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	assert.Empty(t, outboxEmailsOfKind(t, controller, "pending-comment"))
+
+	now := time.Now()
+	controller.SendAdminDigests(now)
+	digests := outboxEmailsOfKind(t, controller, "pending-comments-digest")
+	assert.Equal(t, 1, len(digests))
+	assert.Equal(t, "admin@example.com", digests[0].Recipient)
+	assert.Contains(t, digests[0].Payload, TEST_COMMENT_PENDING_AUTHENTICATION)
+	controller.SendAdminDigests(now.Add(2 * time.Hour))
+	assert.Equal(t, 1, len(outboxEmailsOfKind(t, controller, "pending-comments-digest")), "Digests are only sent for new comments")
+
+	// the next digest waits for the hour to pass, comments that were moderated in the meantime are left out
+	formParams := url.Values{"email": {TEST_USER_AUTHTOKEN_VALID}, "name": {"Jane"}, "comment": {"A comment for the next digest"}}
+	res = postComment(t, client, formParams, TEST_POSTKEY2)
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	assert.Nil(t, controller.Store.UpdateCommentStatus(findCommentByContent(TEST_COMMENTS, TEST_COMMENT_PENDING_APPROVAL).Id, domain.CommentStatusApproved, "", testAdminActor))
+	controller.SendAdminDigests(now.Add(30 * time.Minute))
+	assert.Equal(t, 1, len(outboxEmailsOfKind(t, controller, "pending-comments-digest")))
+	controller.SendAdminDigests(now.Add(time.Hour))
+	digests = outboxEmailsOfKind(t, controller, "pending-comments-digest")
+	assert.Equal(t, 2, len(digests))
+	assert.True(t, strings.Contains(digests[0].Payload, "A comment for the next digest") != strings.Contains(digests[1].Payload, "A comment for the next digest"))
+}
+
+func TestAuthenticationLinkIsSentAfterBurstOfNotifications(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	// the admin uses the same address to comment
+	assert.Nil(t, controller.Store.SaveAdminNotificationSettings("admin", TEST_USER_NO_TOKEN, domain.AdminNotificationsImmediate, []string{domain.DefaultServiceAdminRole}))
+	comment, err := controller.Store.GetComment(findCommentByContent(TEST_COMMENTS, TEST_COMMENT_PENDING_APPROVAL).Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < email.DefaultRateLimits.PerRecipientPerHour*2; i++ {
+		controller.notifyAdmins(comment)
+	}
+	assert.Equal(t, email.DefaultRateLimits.PerRecipientPerHour*2, len(outboxEmailsOfKind(t, controller, "pending-comment")))
+
+	formParams := url.Values{"email": {TEST_USER_NO_TOKEN}}
+	res := postWithOrigin(t, createTestHttpClient(true), createServerUrl(serverConfig.Port, "/userauthentication/"),
+		"application/x-www-form-urlencoded", strings.NewReader(formParams.Encode()))
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Contains(t, readBody(res), "An authentication token is on the way")
+	assert.Equal(t, 1, len(outboxEmailsOfKind(t, controller, "authentication-code")))
+}
+
+func TestRateLimitedDigestIsSentLater(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	assert.Nil(t, controller.Store.SaveAdminNotificationSettings("admin", "admin@example.com", domain.AdminNotificationsHourly, []string{domain.DefaultServiceAdminRole}))
+	settings, err := controller.Store.GetAdminNotificationSettings("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	commentId := findCommentByContent(TEST_COMMENTS, TEST_COMMENT_PENDING_APPROVAL).Id
+	assert.Nil(t, controller.Store.AddAdminDigestComment(settings.Id, commentId))
+	for {
+		err := controller.EmailSender.SendNotification(email.PendingCommentEmail{EmailAddress: "admin@example.com"})
+		if errors.Is(err, email.ErrRecipientRateLimited) {
+			break
+		}
+		assert.Nil(t, err)
+	}
+
+	controller.SendAdminDigests(time.Now())
+	assert.Empty(t, outboxEmailsOfKind(t, controller, "pending-comments-digest"))
+	commentIds, err := controller.Store.GetAdminDigestComments(settings.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{commentId}, commentIds, "The comments of a digest that could not be sent are kept")
+	settings, err = controller.Store.GetAdminNotificationSettings("admin")
+	assert.Nil(t, err)
+	assert.True(t, settings.LastDigestAt.IsZero(), "A digest that could not be sent is still due")
+}
+
+func TestAdminDashboardRejectsInvalidCommentId(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	loginAdmin(t, client, domain.DefaultServiceAdminRole)
+	res, err := client.Get(createServerUrl(serverConfig.Port, "/admin/comments?commentId=foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
@@ -0,0 +1,48 @@
+/**
+ * Shows the number of approved comments on pages that do not embed the comments themselves, like the index of a
+ * blog. Include it with
+ *
+ *   <script src="https://comments.example.com/services/SERVICEKEY/commentcounts.js" defer></script>
+ *
+ * and every element with a data-comment-count="POSTKEY" attribute gets the comment count of that post as its text.
+ */
+(function () {
+    const script = document.currentScript;
+    if (script == null) {
+        return;
+    }
+    const countsUrl = new URL(script.src.replace(/\/services\/([^/]+)\/commentcounts\.js.*$/, '/api/v1/services/$1/commentcounts'));
+    // the endpoint counts at most 100 posts per request
+    const maxPostsPerRequest = 100;
+
+    function showCounts() {
+        const elements = Array.from(document.querySelectorAll('[data-comment-count]'));
+        const postKeys = Array.from(new Set(elements.map(element => element.dataset.commentCount))).sort();
+        for (let i = 0; i < postKeys.length; i += maxPostsPerRequest) {
+            const url = new URL(countsUrl);
+            postKeys.slice(i, i + maxPostsPerRequest).forEach(postKey => url.searchParams.append('postKey', postKey));
+            fetch(url)
+                .then(response => {
+                    if (!response.ok) {
+                        throw new Error(`Loading comment counts failed with status ${response.status}`);
+                    }
+                    return response.json();
+                })
+                .then(body => {
+                    elements.forEach(element => {
+                        const count = body.counts[element.dataset.commentCount];
+                        if (count !== undefined) {
+                            element.textContent = count === 1 ? '1 comment' : `${count} comments`;
+                        }
+                    });
+                })
+                .catch(error => console.error(error));
+        }
+    }
+
+    if (document.readyState === 'loading') {
+        document.addEventListener('DOMContentLoaded', showCounts);
+    } else {
+        showCounts();
+    }
+})();
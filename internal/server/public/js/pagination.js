@@ -0,0 +1,41 @@
+/**
+ * Turns "load more" links into in-place loading: the next page is fetched and its comments are appended to the
+ * current list instead of navigating away. Without JavaScript the link simply opens the next page.
+ */
+function loadMoreComments(event) {
+    const link = event.target.closest('a.load-more');
+    if (link == null) {
+        return;
+    }
+    event.preventDefault();
+    link.setAttribute('aria-busy', 'true');
+    fetch(link.href)
+        .then(response => {
+            if (!response.ok) {
+                throw new Error(`Loading more comments failed with status ${response.status}`);
+            }
+            return response.text();
+        })
+        .then(html => {
+            const nextPage = new DOMParser().parseFromString(html, 'text/html');
+            const comments = document.querySelector('main > dl.comments');
+            nextPage.querySelectorAll('main > dl.comments > *').forEach(element => {
+                comments.appendChild(document.importNode(element, true));
+            });
+            const currentPagination = link.closest('nav.pagination');
+            const nextPagination = nextPage.querySelector('nav.pagination');
+            if (nextPagination != null) {
+                currentPagination.replaceWith(document.importNode(nextPagination, true));
+            } else {
+                currentPagination.remove();
+            }
+            formatDates();
+        })
+        .catch(error => {
+            console.error(error);
+            // fall back to opening the next page
+            window.location.href = link.href;
+        });
+}
+
+document.addEventListener('click', loadMoreComments);
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"aggregat4/go-commentservice/internal/domain"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// GetAdminCommentRevisions shows all versions of a comment and what changed with every edit
+func (controller *Controller) GetAdminCommentRevisions(c echo.Context) error {
+	adminUser, err := controller.requireAdmin(c)
+	if err != nil || !adminUser.IsValid() {
+		return err
+	}
+	comment, err := controller.requireCommentAndRetrieve(c)
+	if err != nil {
+		return handleCommonErrors(c, err)
+	}
+	if !adminUser.CanAdminister(comment.ServiceId) {
+		return renderUnauthorized(c)
+	}
+	revisions, err := controller.Store.GetCommentRevisions(comment.Id)
+	if err != nil {
+		return sendInternalError(c, err)
+	}
+	return c.Render(http.StatusOK, "admin-commentrevisions", domain.AdminCommentRevisionsPage{
+		BasePage: domain.BasePage{
+			Stylesheets: templateStylesheets,
+			Scripts:     templateScripts,
+		},
+		AdminUser: adminUser,
+		Comment:   comment,
+		Versions:  domain.CommentVersions(comment, revisions),
+	})
+}
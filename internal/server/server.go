@@ -3,7 +3,9 @@ package server
 import (
 	"aggregat4/go-commentservice/internal/domain"
 	"aggregat4/go-commentservice/internal/email"
+	"aggregat4/go-commentservice/internal/markup"
 	"aggregat4/go-commentservice/internal/repository"
+	"aggregat4/go-commentservice/internal/spam"
 	"embed"
 	"fmt"
 	"html/template"
@@ -13,6 +15,7 @@ import (
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	baseliboidc "github.com/aggregat4/go-baselib-services/v3/oidc"
 	"github.com/aggregat4/go-baselib/lang"
@@ -48,16 +51,18 @@ var javaScript embed.FS
 var styleSheets embed.FS
 
 var templateStylesheets = []string{"css/main.css"}
-var templateScripts = []string{"js/components.js", "js/formatting.js"}
+var templateScripts = []string{"js/components.js", "js/formatting.js", "js/pagination.js"}
 
 type Controller struct {
 	Store       *repository.Store
 	Config      domain.Config
 	EmailSender *email.EmailSender
+	Spam        *spam.Pipeline
 }
 
 func RunServer(controller Controller) {
 	e := InitServer(controller)
+	go controller.runAdminDigests()
 	e.Logger.Fatal(e.Start(":" + strconv.Itoa(controller.Config.Port)))
 	// NO MORE CODE HERE, IT WILL NOT BE EXECUTED
 }
@@ -83,7 +88,16 @@ func InitServer(controller Controller) *echo.Echo {
 	oidcCallback := oidcMiddleware.CreateOidcCallbackEndpoint(
 		baseliboidc.CreateSessionBasedOidcDelegate(
 			func(c echo.Context, idToken *oidc.IDToken) error {
-				return createAdminSessionCookie(c, idToken.Subject)
+				roles, err := rolesFromIdToken(idToken, controller.Config.OidcRolesClaim)
+				if err != nil {
+					return sendInternalError(c, err)
+				}
+				// admins are notified about the services of their current roles
+				err = controller.Store.UpdateAdminNotificationRoles(idToken.Subject, roles)
+				if err != nil {
+					return sendInternalError(c, err)
+				}
+				return createAdminSessionCookie(c, idToken.Subject, roles)
 			},
 			"/admin", // TODO: change fallback URI
 		))
@@ -106,19 +120,31 @@ func InitServerWithOidcMiddleware(
 	// Set server timeouts based on advice from https://blog.cloudflare.com/the-complete-guide-to-golang-net-http-timeouts/#1687428081
 	e.Server.ReadTimeout = time.Duration(controller.Config.ServerReadTimeoutSeconds) * time.Second
 	e.Server.WriteTimeout = time.Duration(controller.Config.ServerWriteTimeoutSeconds) * time.Second
+	// the client IP address is used to rate limit emails, so it must not be taken from headers anyone can set
+	e.IPExtractor = ipExtractor(controller.Config.TrustedProxies)
 
 	var templateMap = map[string]*template.Template{
-		"addeditcomment":       template.Must(template.New("").ParseFS(viewTemplates, "public/views/addeditcomment.html", "public/views/components/*.html")),
-		"usercomments":         template.Must(template.New("").ParseFS(viewTemplates, "public/views/usercomments.html", "public/views/components/*.html")),
-		"postcomments":         template.Must(template.New("").ParseFS(viewTemplates, "public/views/postcomments.html", "public/views/components/*.html")),
-		"userauthentication":   template.Must(template.New("").ParseFS(viewTemplates, "public/views/userauthentication.html", "public/views/components/*.html")),
-		"adminlogin":           template.Must(template.New("").ParseFS(viewTemplates, "public/views/adminlogin.html", "public/views/components/*.html")),
-		"admin-dashboard":      template.Must(template.New("").ParseFS(viewTemplates, "public/views/admin-dashboard.html", "public/views/components/*.html")),
-		"error-internalserver": template.Must(template.New("").ParseFS(viewTemplates, "public/views/error-internalserver.html", "public/views/components/*.html")),
-		"error-notfound":       template.Must(template.New("").ParseFS(viewTemplates, "public/views/error-notfound.html", "public/views/components/*.html")),
-		"error-unauthorized":   template.Must(template.New("").ParseFS(viewTemplates, "public/views/error-unauthorized.html", "public/views/components/*.html")),
-		"error-badrequest":     template.Must(template.New("").ParseFS(viewTemplates, "public/views/error-badrequest.html", "public/views/components/*.html")),
-		"demo":                 template.Must(template.New("").ParseFS(viewTemplates, "public/views/demo.html", "public/views/components/*.html")),
+		"addeditcomment":         template.Must(template.New("").Funcs(templateFuncs).ParseFS(viewTemplates, "public/views/addeditcomment.html", "public/views/components/*.html")),
+		"usercomments":           template.Must(template.New("").Funcs(templateFuncs).ParseFS(viewTemplates, "public/views/usercomments.html", "public/views/components/*.html")),
+		"unsubscribe":            template.Must(template.New("").Funcs(templateFuncs).ParseFS(viewTemplates, "public/views/unsubscribe.html", "public/views/components/*.html")),
+		"unsubscribed":           template.Must(template.New("").Funcs(templateFuncs).ParseFS(viewTemplates, "public/views/unsubscribed.html", "public/views/components/*.html")),
+		"deleteaccount":          template.Must(template.New("").Funcs(templateFuncs).ParseFS(viewTemplates, "public/views/deleteaccount.html", "public/views/components/*.html")),
+		"postcomments":           template.Must(template.New("").Funcs(templateFuncs).ParseFS(viewTemplates, "public/views/postcomments.html", "public/views/components/*.html")),
+		"userauthentication":     template.Must(template.New("").Funcs(templateFuncs).ParseFS(viewTemplates, "public/views/userauthentication.html", "public/views/components/*.html")),
+		"adminlogin":             template.Must(template.New("").Funcs(templateFuncs).ParseFS(viewTemplates, "public/views/adminlogin.html", "public/views/components/*.html")),
+		"admin-emails":           template.Must(template.New("").Funcs(templateFuncs).ParseFS(viewTemplates, "public/views/admin-emails.html", "public/views/components/*.html")),
+		"admin-commentrevisions": template.Must(template.New("").Funcs(templateFuncs).ParseFS(viewTemplates, "public/views/admin-commentrevisions.html", "public/views/components/*.html")),
+		"admin-moderationlog":    template.Must(template.New("").Funcs(templateFuncs).ParseFS(viewTemplates, "public/views/admin-moderationlog.html", "public/views/components/*.html")),
+		"admin-notifications":    template.Must(template.New("").Funcs(templateFuncs).ParseFS(viewTemplates, "public/views/admin-notifications.html", "public/views/components/*.html")),
+		"admin-dashboard":        template.Must(template.New("").Funcs(templateFuncs).ParseFS(viewTemplates, "public/views/admin-dashboard.html", "public/views/components/*.html")),
+		"admin-services":         template.Must(template.New("").Funcs(templateFuncs).ParseFS(viewTemplates, "public/views/admin-services.html", "public/views/components/*.html")),
+		"admin-service":          template.Must(template.New("").Funcs(templateFuncs).ParseFS(viewTemplates, "public/views/admin-service.html", "public/views/components/*.html")),
+		"admin-deleteservice":    template.Must(template.New("").Funcs(templateFuncs).ParseFS(viewTemplates, "public/views/admin-deleteservice.html", "public/views/components/*.html")),
+		"error-internalserver":   template.Must(template.New("").Funcs(templateFuncs).ParseFS(viewTemplates, "public/views/error-internalserver.html", "public/views/components/*.html")),
+		"error-notfound":         template.Must(template.New("").Funcs(templateFuncs).ParseFS(viewTemplates, "public/views/error-notfound.html", "public/views/components/*.html")),
+		"error-unauthorized":     template.Must(template.New("").Funcs(templateFuncs).ParseFS(viewTemplates, "public/views/error-unauthorized.html", "public/views/components/*.html")),
+		"error-badrequest":       template.Must(template.New("").Funcs(templateFuncs).ParseFS(viewTemplates, "public/views/error-badrequest.html", "public/views/components/*.html")),
+		"demo":                   template.Must(template.New("").Funcs(templateFuncs).ParseFS(viewTemplates, "public/views/demo.html", "public/views/components/*.html")),
 	}
 
 	e.Renderer = &EchoTemplateRenderer{
@@ -170,6 +196,17 @@ func InitServerWithOidcMiddleware(
 	e.GET("/services/:serviceKey/posts/:postKey/commentform", controller.GetCommentForm)
 	// One can add that comment to the post (in state unauthenticated, assuming we have all the info we need (at least email and content))
 	e.POST("/services/:serviceKey/posts/:postKey/comments/", controller.PostComment)
+	// One can preview how the comment in the form will be shown
+	e.POST("/services/:serviceKey/posts/:postKey/commentpreview", controller.PostCommentPreview)
+	// One can show comment counts on any page of the service, like the index of a blog, by including this script
+	e.GET("/services/:serviceKey/commentcounts.js", controller.GetCommentCountsScript)
+	// One can follow the approved comments of a post or of all posts of a service with a feed reader
+	e.GET("/services/:serviceKey/posts/:postKey/feed.atom", controller.GetPostCommentsFeed)
+	e.GET("/services/:serviceKey/feed.atom", controller.GetServiceCommentsFeed)
+	// Emails to subscribers have a signed link to cancel the subscription without logging in, this has to be GET
+	// because it is opened from emails
+	e.GET(unsubscribePath, controller.ConfirmUnsubscribe)
+	e.POST(unsubscribePath, controller.Unsubscribe)
 	// ----- User Authentication
 	// If users are not authenticated (we check a cookie) then we redirect them to a page where they can request an authentication link
 	// This is just the "userauthentication" endpoint without a token, it has a form where you can enter your email address
@@ -182,7 +219,7 @@ func InitServerWithOidcMiddleware(
 	// 1. sets a cookie with the userId
 	// 2. redirects to a user's comment overview and management page
 	// ---- AUTHENTICATED WITH AUTH TOKEN (normal user)
-	// Calling this page with a special parameter or content-type allows you to export the page as a json document
+	// Calling this page with ?format=json or an Accept header of application/json exports the user's data as a json document
 	e.GET("/users/:userId/comments/", controller.GetCommentsForUser)
 	// Allow a user to modify his comment
 	e.GET("/users/:userId/comments/:commentId/edit", controller.GetUserCommentForm)
@@ -191,16 +228,51 @@ func InitServerWithOidcMiddleware(
 	// Users can delete comments, this redirects back to the comment overview page
 	e.POST("/users/:userId/comments/:commentId/confirm", controller.ConfirmUserComment)
 	// Users can update comments: see the PostComment route under /services/:serviceKey/posts/:postKey/comments
+	// Users can cancel the email subscriptions they made when writing comments
+	e.POST("/users/:userId/subscriptions/:subscriptionId/delete", controller.DeleteUserSubscription)
+	// Users can delete their account and all their data, the form explains the consequences and asks for confirmation
+	e.GET("/users/:userId/delete", controller.GetDeleteAccountForm)
+	e.POST("/users/:userId/delete", controller.DeleteUserAccount)
 
-	// ---- AUTHENTICATED WITH OIDC AND ROLE service-admin (admimistrator)
+	// ---- AUTHENTICATED WITH OIDC AND THE ADMIN ROLE OF A SERVICE (admimistrator)
 	e.GET("/adminlogin", controller.GetAdminLoginForm)
 	e.GET("/admin", controller.GetAdminHome)
 	e.GET("/admin/comments", controller.GetAdminDashboard)
 	e.POST("/admin/comments/:commentId/approve", controller.AdminApproveComment)
+	e.POST("/admin/comments/:commentId/reject", controller.AdminRejectComment)
+	e.POST("/admin/comments/:commentId/unapprove", controller.AdminUnapproveComment)
+	e.POST("/admin/comments/:commentId/unreject", controller.AdminUnrejectComment)
 	e.POST("/admin/comments/:commentId/delete", controller.AdminDeleteComment)
+	// Bulk actions on the comments selected in the dashboard, each runs in a single transaction
+	e.POST("/admin/comments/bulk/approve", controller.AdminBulkApproveComments)
+	e.POST("/admin/comments/bulk/reject", controller.AdminBulkRejectComments)
+	e.POST("/admin/comments/bulk/delete", controller.AdminBulkDeleteComments)
+	// Edited comments keep their earlier versions, admins can compare them
+	e.GET("/admin/comments/:commentId/revisions", controller.GetAdminCommentRevisions)
+	// Admins can browse the moderation log of the services they administer
+	e.GET("/admin/moderationlog", controller.GetAdminModerationLog)
+	// Admins can choose to be notified by email about comments pending approval, immediately or in a digest
+	e.GET("/admin/notifications", controller.GetAdminNotifications)
+	e.POST("/admin/notifications", controller.UpdateAdminNotifications)
+	// ---- AUTHENTICATED WITH OIDC AND THE SUPERADMIN ROLE
+	// Superadmins can inspect the delivery state of the emails we send
+	e.GET("/admin/emails", controller.GetAdminEmails)
+	// Superadmins manage the services (sites) that can embed comments
+	e.GET("/admin/services", controller.GetAdminServices)
+	e.POST("/admin/services", controller.CreateAdminService)
+	e.GET("/admin/services/:serviceId", controller.GetAdminService)
+	e.POST("/admin/services/:serviceId", controller.UpdateAdminService)
+	e.POST("/admin/services/:serviceId/editpolicy", controller.UpdateAdminServiceEditPolicy)
+	e.POST("/admin/services/:serviceId/rotatekey", controller.RotateAdminServiceKey)
+	// Deleting a service deletes all its comments, the form explains the consequences and asks for confirmation
+	e.GET("/admin/services/:serviceId/delete", controller.GetAdminDeleteServiceForm)
+	e.POST("/admin/services/:serviceId/delete", controller.DeleteAdminService)
 
 	e.GET("/demo", controller.GetDemo)
 
+	// ---- JSON API, mirrors the endpoints above
+	registerApiEndpoints(e, &controller)
+
 	return e
 }
 
@@ -210,6 +282,13 @@ func customHTTPErrorHandler(err error, c echo.Context) {
 	if he, ok := err.(*echo.HTTPError); ok {
 		code = he.Code
 	}
+	if isApiRequest(c) {
+		err = sendApiError(c, code, strings.ToLower(http.StatusText(code)))
+		if err != nil {
+			c.Logger().Error(err)
+		}
+		return
+	}
 
 	var errorPageTemplate = "error-internalserver"
 	switch code {
@@ -231,7 +310,11 @@ func customHTTPErrorHandler(err error, c echo.Context) {
 	}
 }
 
-// Update the GetComments handler
+// commentThreadsPerPage limits how many threads the embedded page shows at once, long discussions are continued with
+// a "load more" link
+const commentThreadsPerPage = 50
+
+// GetComments renders a page of the comment threads of a post, the cursor query parameter selects the page
 func (controller *Controller) GetComments(c echo.Context) error {
 	user, err := getUserFromSession(c, controller)
 	if err != nil && !errors.Is(err, lang.ErrNotFound) {
@@ -250,7 +333,22 @@ func (controller *Controller) GetComments(c echo.Context) error {
 		}
 		return sendInternalError(c, err)
 	}
-	comments, err := controller.Store.GetCommentsForPost(service.Id, postKey)
+	cursor, err := domain.ParseCommentCursor(c.QueryParam("cursor"))
+	if err != nil {
+		return renderBadRequest(c)
+	}
+	page, err := controller.Store.GetCommentThreadsForPost(service.Id, postKey, cursor, commentThreadsPerPage)
+	if err != nil {
+		return sendInternalError(c, err)
+	}
+	// only the comments of the current user can be edited
+	ownComments := make([]domain.Comment, 0)
+	for _, comment := range page.Comments {
+		if user.IsValid() && comment.UserId == user.Id {
+			ownComments = append(ownComments, comment)
+		}
+	}
+	editableComments, err := controller.editableComments(ownComments)
 	if err != nil {
 		return sendInternalError(c, err)
 	}
@@ -259,7 +357,7 @@ func (controller *Controller) GetComments(c echo.Context) error {
 		// TODO: consider not failing on just flash messages having an error, but also just log and ignore them
 		return sendInternalError(c, err)
 	}
-	c.Response().Header().Set("Content-Security-Policy", "frame-ancestors "+service.Origin)
+	c.Response().Header().Set("Content-Security-Policy", domain.FrameAncestorsPolicy(service.Origins))
 	// c.Response().Header().Set("Cache-Control", "public, max-age=60, stale-while-revalidate=300") // Cache for 1 minute, allow stale content for 5 minutes while revalidating
 	return c.Render(http.StatusOK, "postcomments", domain.PostCommentsPage{
 		BasePage: domain.BasePage{
@@ -268,10 +366,12 @@ func (controller *Controller) GetComments(c echo.Context) error {
 			Error:       errorFlashes,
 			Success:     successFlashes,
 		},
-		User:       user,
-		ServiceKey: serviceKey,
-		PostKey:    postKey,
-		Comments:   comments,
+		User:             user,
+		ServiceKey:       serviceKey,
+		PostKey:          postKey,
+		Threads:          domain.BuildCommentThreads(page.Comments, page.HiddenParents),
+		NextCursor:       page.NextCursor.String(),
+		EditableComments: editableComments,
 	})
 }
 
@@ -316,6 +416,7 @@ func (controller *Controller) RequestAuthenticationLink(c echo.Context) error {
 		}
 		return sendInternalError(c, err)
 	}
+	previousUser := user
 	if !validToken(user) {
 		user.AuthTokenSentToClient = 0
 		user.AuthToken = uuid.New().String()
@@ -329,26 +430,39 @@ func (controller *Controller) RequestAuthenticationLink(c echo.Context) error {
 		if err != nil {
 			return sendInternalError(c, err)
 		}
+		// the first email is sent right away, repeated requests are throttled
 		var delay = 0 * time.Minute
-		if user.AuthTokenSentToClient == 1 {
+		if user.AuthTokenSentToClient == 2 {
 			delay = 1 * time.Minute
-		} else if user.AuthTokenSentToClient == 2 {
+		} else if user.AuthTokenSentToClient == 3 {
 			delay = 5 * time.Minute
 		}
-		emailSuccessfullyQueued := controller.EmailSender.SendEmail(email.AuthenticationCodeEmail{
+		err = controller.EmailSender.ScheduleEmail(email.AuthenticationCodeEmail{
 			EmailAddress: emailAddress,
 			Code:         user.AuthToken,
-		})
-		if emailSuccessfullyQueued {
-			if delay > 0 {
-				//nolint:errcheck
-				baseliboidc.SetFlash(c, "success", "An authentication token will be sent in "+delay.String()+".")
-			} else {
-				//nolint:errcheck
-				baseliboidc.SetFlash(c, "success", "An authentication token is on the way, please check your email.")
+		}, c.RealIP(), delay)
+		if errors.Is(err, email.ErrRateLimited) {
+			// a refused email must not count as an attempt, otherwise it would delay the next email
+			rollbackErr := controller.Store.UpdateUser(previousUser)
+			if rollbackErr != nil {
+				return sendInternalError(c, rollbackErr)
 			}
-		} else {
-			// TODO error message too vague?
+		}
+		switch {
+		case err == nil && delay > 0:
+			//nolint:errcheck
+			baseliboidc.SetFlash(c, "success", "An authentication token will be sent in "+delay.String()+".")
+		case err == nil:
+			//nolint:errcheck
+			baseliboidc.SetFlash(c, "success", "An authentication token is on the way, please check your email.")
+		case errors.Is(err, email.ErrRecipientRateLimited):
+			//nolint:errcheck
+			baseliboidc.SetFlash(c, "error", "Too many emails have been sent to this address recently. Please try again in an hour.")
+		case errors.Is(err, email.ErrRateLimited):
+			//nolint:errcheck
+			baseliboidc.SetFlash(c, "error", "Too many emails have been requested recently. Please try again later.")
+		default:
+			logger.Error("Failed to queue authentication email", "error", err)
 			//nolint:errcheck
 			baseliboidc.SetFlash(c, "error", "Could not send an email at this time, please try again later.")
 		}
@@ -390,32 +504,125 @@ func handleAuthenticationError(c echo.Context, err error) error {
 
 func (controller *Controller) GetCommentsForUser(c echo.Context) error {
 	// validate that the userid in the url is the same as the userid in the session
-	userIdString := c.Param("userId")
-	userId, err := strconv.Atoi(userIdString)
+	user, err := controller.requireUserFromPath(c)
+	if err != nil || !user.IsValid() {
+		return err
+	}
+	comments, err := controller.Store.GetCommentsForUser(user.Id)
 	if err != nil {
-		return c.Render(http.StatusBadRequest, "error-badrequest", nil)
+		return sendInternalError(c, err)
+	}
+	subscriptions, err := controller.Store.GetSubscriptionsForUser(user.Id)
+	if err != nil {
+		return sendInternalError(c, err)
+	}
+	if wantsJsonExport(c) {
+		// an explicit format parameter comes from the download button, so make the browser save the file
+		if c.QueryParam("format") == "json" {
+			c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="comments-export.json"`)
+		}
+		revisions, err := controller.Store.GetCommentRevisionsForUser(user.Id)
+		if err != nil {
+			return sendInternalError(c, err)
+		}
+		c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+		return c.JSONPretty(http.StatusOK, domain.NewUserDataExport(user, comments, revisions, subscriptions, time.Now()), "  ")
+	}
+	editableComments, err := controller.editableComments(comments)
+	if err != nil {
+		return sendInternalError(c, err)
+	}
+	return c.Render(http.StatusOK, "usercomments", domain.UserCommentsPage{
+		BasePage: domain.BasePage{
+			Stylesheets: templateStylesheets,
+			Scripts:     templateScripts,
+		},
+		User:             user,
+		Comments:         comments,
+		EditableComments: editableComments,
+		Subscriptions:    subscriptions,
+	})
+}
+
+func wantsJsonExport(c echo.Context) bool {
+	if c.QueryParam("format") == "json" {
+		return true
+	}
+	for _, accepted := range strings.Split(c.Request().Header.Get(echo.HeaderAccept), ",") {
+		mediaType := strings.TrimSpace(strings.Split(accepted, ";")[0])
+		if mediaType == echo.MIMEApplicationJSON {
+			return true
+		}
+	}
+	return false
+}
+
+// requireUserFromPath returns the authenticated user when it matches the userId in the path, otherwise it renders
+// the appropriate error and returns an invalid user
+func (controller *Controller) requireUserFromPath(c echo.Context) (domain.User, error) {
+	userId, err := strconv.Atoi(c.Param("userId"))
+	if err != nil {
+		return domain.User{}, renderBadRequest(c)
 	}
 	user, err := getUserFromSession(c, controller)
 	if err != nil {
-		return handleAuthenticationError(c, err)
+		return domain.User{}, handleAuthenticationError(c, err)
 	}
 	if user.Id != userId {
-		return c.Render(http.StatusUnauthorized, "error-unauthorized", nil)
+		return domain.User{}, renderUnauthorized(c)
+	}
+	return user, nil
+}
+
+func (controller *Controller) GetDeleteAccountForm(c echo.Context) error {
+	user, err := controller.requireUserFromPath(c)
+	if err != nil || !user.IsValid() {
+		return err
 	}
 	comments, err := controller.Store.GetCommentsForUser(user.Id)
 	if err != nil {
 		return sendInternalError(c, err)
 	}
-	return c.Render(http.StatusOK, "usercomments", domain.UserCommentsPage{
+	successFlashes, errorFlashes, err := baseliboidc.GetFlashes(c)
+	if err != nil {
+		return sendInternalError(c, err)
+	}
+	return c.Render(http.StatusOK, "deleteaccount", domain.DeleteAccountPage{
 		BasePage: domain.BasePage{
 			Stylesheets: templateStylesheets,
 			Scripts:     templateScripts,
+			Error:       errorFlashes,
+			Success:     successFlashes,
 		},
-		User:     user,
-		Comments: comments,
+		User:             user,
+		NumberOfComments: len(comments),
 	})
 }
 
+func (controller *Controller) DeleteUserAccount(c echo.Context) error {
+	user, err := controller.requireUserFromPath(c)
+	if err != nil || !user.IsValid() {
+		return err
+	}
+	if c.FormValue("confirm") != "on" {
+		//nolint:errcheck
+		baseliboidc.SetFlash(c, "error", "Please confirm that you want to delete your account and all your comments.")
+		return c.Redirect(http.StatusFound, "/users/"+strconv.Itoa(user.Id)+"/delete")
+	}
+	deletedComments, err := controller.Store.DeleteUser(user.Id)
+	if err != nil && !errors.Is(err, lang.ErrNotFound) {
+		return sendInternalError(c, err)
+	}
+	logger.Info("Deleted user account", "deletedComments", deletedComments)
+	err = clearUserSessionCookie(c)
+	if err != nil {
+		return sendInternalError(c, err)
+	}
+	//nolint:errcheck
+	baseliboidc.SetFlash(c, "success", "Your account and all your comments have been deleted.")
+	return c.Redirect(http.StatusFound, "/userauthentication/")
+}
+
 func (controller *Controller) GetCommentForm(c echo.Context) error {
 	serviceKey := c.Param("serviceKey")
 	postKey := c.Param("postKey")
@@ -453,21 +660,75 @@ func (controller *Controller) GetCommentForm(c echo.Context) error {
 		// TODO: better error to indicate that this service does not exist?
 		return c.Render(http.StatusNotFound, "error-notfound", nil)
 	}
-	c.Response().Header().Set("Content-Security-Policy", "frame-ancestors "+service.Origin)
+	parentFound := false
+	parentComment := domain.Comment{}
+	if parentCommentIdString := c.QueryParam("parentCommentId"); parentCommentIdString != "" && !commentFound {
+		parentComment, err = controller.findReplyParent(service, postKey, parentCommentIdString)
+		if err != nil {
+			return handleCommonErrors(c, err)
+		}
+		parentFound = true
+	}
+	c.Response().Header().Set("Content-Security-Policy", domain.FrameAncestorsPolicy(service.Origins))
 	return c.Render(http.StatusOK, "addeditcomment", domain.AddOrEditCommentPage{
 		BasePage: domain.BasePage{
 			Stylesheets: templateStylesheets,
 			Scripts:     templateScripts,
 		},
-		ServiceKey:   serviceKey,
-		PostKey:      postKey,
-		UserFound:    userFoundError == nil,
-		User:         user,
-		CommentFound: commentFound,
-		Comment:      comment,
+		ServiceKey:    serviceKey,
+		PostKey:       postKey,
+		UserFound:     userFoundError == nil,
+		User:          user,
+		CommentFound:  commentFound,
+		Comment:       comment,
+		ParentFound:   parentFound,
+		ParentComment: parentComment,
+		FormToken:     controller.Spam.FormToken(time.Now()),
 	})
 }
 
+// PostCommentPreview renders the comment text as it will be shown on the comments page
+func (controller *Controller) PostCommentPreview(c echo.Context) error {
+	_, err := controller.Store.GetServiceForKey(c.Param("serviceKey"))
+	if err != nil {
+		return handleCommonErrors(c, err)
+	}
+	return c.HTML(http.StatusOK, string(markup.Render(c.FormValue("comment"))))
+}
+
+// GetCommentCountsScript serves the script that shows comment counts on pages of the service, it loads the counts
+// from the JSON API
+func (controller *Controller) GetCommentCountsScript(c echo.Context) error {
+	_, err := controller.Store.GetServiceForKey(c.Param("serviceKey"))
+	if err != nil {
+		return handleCommonErrors(c, err)
+	}
+	script, err := javaScript.ReadFile("public/js/commentcounts.js")
+	if err != nil {
+		return sendInternalError(c, err)
+	}
+	// the URL of the script can not change with its content, so it is cached for a shorter time than other assets
+	c.Response().Header().Set("Cache-Control", "public, max-age=3600")
+	return c.Blob(http.StatusOK, "text/javascript; charset=utf-8", script)
+}
+
+// findReplyParent resolves the comment that a new reply is addressed to. Replies are only allowed to
+// approved comments on the same post of the same service.
+func (controller *Controller) findReplyParent(service *domain.Service, postKey string, parentCommentIdString string) (domain.Comment, error) {
+	parentCommentId, err := strconv.Atoi(parentCommentIdString)
+	if err != nil {
+		return domain.Comment{}, ErrIllegalArgument
+	}
+	parentComment, err := controller.Store.GetComment(parentCommentId)
+	if err != nil {
+		return domain.Comment{}, err
+	}
+	if parentComment.ServiceId != service.Id || parentComment.PostKey != postKey || !parentComment.AcceptsReplies() {
+		return domain.Comment{}, ErrIllegalArgument
+	}
+	return parentComment, nil
+}
+
 func (controller *Controller) GetUserCommentForm(c echo.Context) error {
 	user, comment, err := controller.extractAndValidateUserAndCommentFromRequest(c)
 	if err != nil || !user.IsValid() {
@@ -481,9 +742,10 @@ func (controller *Controller) GetUserCommentForm(c echo.Context) error {
 			return sendInternalError(c, err)
 		}
 	}
-	// NO CSP header to prevent embedding because this URL presupposes a logged in user and it can be called from
-	// some general dashboard where a user can manage their comments
-	return c.Render(http.StatusOK, "addeditcomment", domain.AddOrEditCommentPage{
+	if !service.CanEdit(comment, time.Now()) {
+		return renderUnauthorized(c)
+	}
+	page := domain.AddOrEditCommentPage{
 		BasePage: domain.BasePage{
 			Stylesheets: templateStylesheets,
 			Scripts:     templateScripts,
@@ -494,7 +756,16 @@ func (controller *Controller) GetUserCommentForm(c echo.Context) error {
 		User:         user,
 		CommentFound: true,
 		Comment:      comment,
-	})
+	}
+	if comment.Status == domain.CommentStatusApproved {
+		page.EditRequiresApproval = service.StatusAfterEdit(comment) != domain.CommentStatusApproved
+		if service.EditPolicy == domain.EditPolicyGraceWindow {
+			page.EditableUntil = comment.CreatedAt.Add(service.EditGraceWindow)
+		}
+	}
+	// NO CSP header to prevent embedding because this URL presupposes a logged in user and it can be called from
+	// some general dashboard where a user can manage their comments
+	return c.Render(http.StatusOK, "addeditcomment", page)
 }
 
 func (controller *Controller) DeleteUserComment(c echo.Context) error {
@@ -502,7 +773,7 @@ func (controller *Controller) DeleteUserComment(c echo.Context) error {
 	if err != nil || !user.IsValid() {
 		return err
 	}
-	err = controller.Store.DeleteComment(comment.Id)
+	err = controller.Store.DeleteComment(comment.Id, domain.UserActor(user.Id))
 	if err != nil {
 		if errors.Is(err, lang.ErrNotFound) {
 			// TODO: toast to show that the comment has NOT been deleted
@@ -524,7 +795,8 @@ func (controller *Controller) ConfirmUserComment(c echo.Context) error {
 		// TODO: return to original page and show toast to indicate that the comment is not pending authentication
 		return c.Redirect(http.StatusFound, "/users/"+strconv.Itoa(user.Id)+"/comments/")
 	}
-	err = controller.Store.UpdateComment(comment.Id, domain.CommentStatusPendingApproval, comment.Comment, comment.Name, comment.Website, comment.ParentUrl)
+	// confirming only changes the status, the comment is not edited
+	err = controller.Store.UpdateCommentStatus(comment.Id, domain.CommentStatusPendingApproval, "", domain.UserActor(user.Id))
 	if err != nil {
 		if errors.Is(err, lang.ErrNotFound) {
 			// TODO: toast to show that the comment could not be found for confirmation
@@ -533,6 +805,9 @@ func (controller *Controller) ConfirmUserComment(c echo.Context) error {
 			return sendInternalError(c, err)
 		}
 	}
+	comment.Status = domain.CommentStatusPendingApproval
+	controller.activateSubscriptions(comment)
+	controller.notifyAdmins(comment)
 	return c.Redirect(http.StatusFound, "/users/"+strconv.Itoa(user.Id)+"/comments/")
 }
 
@@ -603,6 +878,7 @@ func (controller *Controller) PostComment(c echo.Context) error {
 	website := c.FormValue("website")
 	commentContent := c.FormValue("comment")
 	parentUrl := c.FormValue("parentUrl")
+	parentCommentIdString := c.FormValue("parentCommentId")
 	// TODO: give better error messages
 	if emailAddress == "" {
 		return renderBadRequest(c)
@@ -630,16 +906,20 @@ func (controller *Controller) PostComment(c echo.Context) error {
 		if !userAuthenticated || comment.UserId != user.Id {
 			return renderUnauthorized(c)
 		}
-		// prevent editing approved comments
-		if comment.Status == domain.CommentStatusApproved {
-			return renderUnauthorized(c)
-		}
-		err = controller.Store.UpdateComment(comment.Id, comment.Status, commentContent, name, website, parentUrl)
+		status, err := controller.updateOwnComment(comment, commentContent, name, website, parentUrl)
 		if err != nil {
+			if errors.Is(err, ErrCommentNotEditable) {
+				return renderUnauthorized(c)
+			}
 			return sendInternalError(c, err)
 		}
-		//nolint:errcheck
-		baseliboidc.SetFlash(c, "success", "Your comment has been updated")
+		if comment.Status == domain.CommentStatusApproved && status != domain.CommentStatusApproved {
+			//nolint:errcheck
+			baseliboidc.SetFlash(c, "success", "Your comment has been updated and will be shown again once it has been approved")
+		} else {
+			//nolint:errcheck
+			baseliboidc.SetFlash(c, "success", "Your comment has been updated")
+		}
 		return c.Redirect(http.StatusFound, "/services/"+serviceKey+"/posts/"+postKey+"/comments/")
 
 	} else {
@@ -648,37 +928,145 @@ func (controller *Controller) PostComment(c echo.Context) error {
 		if err != nil {
 			return sendInternalError(c, err)
 		}
-		// find or create a user
-		var userId int
-		if !userAuthenticated {
-			user, err := controller.Store.FindUserByEmail(emailAddress)
-			if err == nil {
-				// we found an existing user
-				userId = user.Id
-			} else if errors.Is(err, lang.ErrNotFound) {
-				// we need to create a new user
-				userId, err = controller.Store.CreateUserByEmail(emailAddress)
-				if err != nil {
-					return sendInternalError(c, err)
-				}
-			} else {
-				return sendInternalError(c, err)
-			}
-		} else {
-			userId = user.Id
-		}
-		commentStatus := lang.IfElse(userAuthenticated, domain.CommentStatusPendingApproval, domain.CommentStatusPendingAuthentication)
-		_, err = controller.Store.CreateComment(
-			commentStatus, service.Id, service.ServiceKey, userId, postKey, commentContent, name, website, parentUrl)
-		if err != nil {
-			return sendInternalError(c, err)
+		createdComment, err := controller.createComment(service, user, userAuthenticated, emailAddress, parentCommentIdString, domain.Comment{
+			PostKey:   postKey,
+			Comment:   commentContent,
+			Name:      name,
+			Website:   website,
+			ParentUrl: parentUrl,
+		}, &spam.FormFields{
+			Honeypot:  c.FormValue("homepage"),
+			FormToken: c.FormValue("formToken"),
+		})
+		if errors.Is(err, ErrSpam) {
+			return renderBadRequest(c)
+		} else if err != nil {
+			return handleCommonErrors(c, err)
 		}
+		controller.subscribeToComment(c, createdComment)
 		//nolint:errcheck
 		baseliboidc.SetFlash(c, "success", "Your comment has been added")
 		return c.Redirect(http.StatusFound, "/services/"+serviceKey+"/posts/"+postKey+"/comments/")
 	}
 }
 
+// createComment stores a new comment for the authenticated user or, when there is no authenticated user, for the user
+// with the given email address who is created when necessary. Comments of unauthenticated users stay pending
+// authentication until the email address has been verified. The spam filters rate the comment before anything is
+// stored, the form fields are nil for comments submitted with the API. A parent URL that is not on one of the origins
+// of the service is dropped.
+func (controller *Controller) createComment(service *domain.Service, user domain.User, userAuthenticated bool, emailAddress string, parentCommentIdString string, comment domain.Comment, form *spam.FormFields) (domain.Comment, error) {
+	// replies go through the same moderation as top level comments, we only verify the parent here
+	if parentCommentIdString != "" {
+		parentComment, err := controller.findReplyParent(service, comment.PostKey, parentCommentIdString)
+		if err != nil {
+			return domain.Comment{}, err
+		}
+		comment.ParentCommentId = parentComment.Id
+	}
+	verdict, err := controller.Spam.Check(spam.Submission{
+		ServiceId:   service.Id,
+		Comment:     comment.Comment,
+		Name:        comment.Name,
+		Website:     comment.Website,
+		Form:        form,
+		SubmittedAt: time.Now(),
+	})
+	if err != nil {
+		return domain.Comment{}, err
+	}
+	if verdict.Reject {
+		logger.Info("Rejected comment as spam", "serviceKey", service.ServiceKey, "postKey", comment.PostKey, "score", verdict.Score, "reasons", verdict.Reasons)
+		return domain.Comment{}, ErrSpam
+	}
+	comment.SpamScore = verdict.Score
+	// the parent URL ends up in emails and feeds, so it has to point to the site of the service
+	if !domain.PageUrlAllowed(service.Origins, comment.ParentUrl) {
+		comment.ParentUrl = ""
+	}
+	// find or create a user
+	if !userAuthenticated {
+		existingUser, err := controller.Store.FindUserByEmail(emailAddress)
+		if err == nil {
+			// we found an existing user
+			comment.UserId = existingUser.Id
+		} else if errors.Is(err, lang.ErrNotFound) {
+			// we need to create a new user
+			comment.UserId, err = controller.Store.CreateUserByEmail(emailAddress)
+			if err != nil {
+				return domain.Comment{}, err
+			}
+		} else {
+			return domain.Comment{}, err
+		}
+	} else {
+		comment.UserId = user.Id
+	}
+	comment.Status = lang.IfElse(userAuthenticated, domain.CommentStatusPendingApproval, domain.CommentStatusPendingAuthentication)
+	comment.ServiceId = service.Id
+	comment.ServiceKey = service.ServiceKey
+	commentId, err := controller.Store.CreateComment(
+		comment.Status, service.Id, service.ServiceKey, comment.UserId, comment.PostKey, comment.Comment, comment.Name, comment.Website, comment.ParentUrl, comment.ParentCommentId, comment.SpamScore)
+	if err != nil {
+		return domain.Comment{}, err
+	}
+	comment.Id = commentId
+	if verdict.Score > 0 {
+		logger.Info("Comment may be spam", "commentId", commentId, "score", verdict.Score, "reasons", verdict.Reasons)
+	}
+	if comment.Status == domain.CommentStatusPendingApproval {
+		controller.notifyAdmins(comment)
+	}
+	return comment, nil
+}
+
+// updateOwnComment changes the contents of a comment as allowed by the edit policy of its service and returns its new
+// status. The caller has to verify that the comment belongs to the current user. A parent URL that is not on one of
+// the origins of the service is dropped like in createComment.
+func (controller *Controller) updateOwnComment(comment domain.Comment, commentContent string, name string, website string, parentUrl string) (domain.CommentStatus, error) {
+	service, err := controller.Store.FindServiceById(comment.ServiceId)
+	if err != nil {
+		return 0, err
+	}
+	if !service.CanEdit(comment, time.Now()) {
+		return 0, ErrCommentNotEditable
+	}
+	status := service.StatusAfterEdit(comment)
+	if !domain.PageUrlAllowed(service.Origins, parentUrl) {
+		parentUrl = ""
+	}
+	err = controller.Store.UpdateComment(comment.Id, status, commentContent, name, website, parentUrl, domain.UserActor(comment.UserId))
+	if err != nil {
+		return 0, err
+	}
+	if status == domain.CommentStatusPendingApproval && comment.Status != domain.CommentStatusPendingApproval {
+		comment.Status, comment.Comment, comment.Name, comment.Website, comment.ParentUrl = status, commentContent, name, website, parentUrl
+		controller.notifyAdmins(comment)
+	}
+	return status, nil
+}
+
+// editableComments tells for each of the comments whether its author may still edit it under the edit policy of its
+// service
+func (controller *Controller) editableComments(comments []domain.Comment) (map[int]bool, error) {
+	services := make(map[int]domain.Service)
+	editable := make(map[int]bool, len(comments))
+	now := time.Now()
+	for _, comment := range comments {
+		service, found := services[comment.ServiceId]
+		if !found {
+			var err error
+			service, err = controller.Store.FindServiceById(comment.ServiceId)
+			if err != nil {
+				return nil, err
+			}
+			services[comment.ServiceId] = service
+		}
+		editable[comment.Id] = service.CanEdit(comment, now)
+	}
+	return editable, nil
+}
+
 func (controller *Controller) GetAdminLoginForm(c echo.Context) error {
 	return c.Render(http.StatusOK, "adminlogin", templateData{
 		Data: domain.BasePage{},
@@ -690,35 +1078,43 @@ func (controller *Controller) GetAdminHome(c echo.Context) error {
 }
 
 // Service administrators can access a service comment dashboard where they can approve or deny comments
-// They require successful OIDC authentication and they require the "service-admin" value as part of the values
-// in the "roles" claim. In the current model the admin is admin over all services on this server.
-// We need to store not only the user Id but also the admin claims in his cookie here so we can always verify he or she has access
-// to the particular service
+// They require successful OIDC authentication and the admin role of a service as one of the values in the roles
+// claim. The roles are stored in the session cookie when logging in, the dashboard only shows the comments of the
+// services the admin administers. Superadmins see the comments of all services.
 // Don't show unauthenticated comments by default
 func (controller *Controller) GetAdminDashboard(c echo.Context) error {
-	adminUserId, err := getAdminUserIdFromSession(c)
-	if err != nil && !errors.Is(err, lang.ErrNotFound) {
-		return sendInternalError(c, err)
-	} else if err != nil {
-		return c.Redirect(http.StatusUnauthorized, "/adminlogin/")
+	adminUser, err := controller.requireAdmin(c)
+	if err != nil || !adminUser.IsValid() {
+		return err
 	}
 
-	// Fetch comments for all services, depending on the showStatus parameter we filter the comments
-	showStatusParam := c.QueryParam("showStatus")
-	statuses := []domain.CommentStatus{}
-	if showStatusParam != "" {
-		for _, status := range strings.Split(showStatusParam, ",") {
-			parsedStatus, err := domain.ParseCommentStatus(status)
-			if err != nil {
-				return c.Redirect(http.StatusBadRequest, "/admin")
-			}
-			statuses = append(statuses, parsedStatus)
-		}
+	// Fetch a page of the comments of the admin's services, the query parameters filter the comments
+	filter, err := parseCommentFilter(c, adminUser)
+	if err != nil {
+		return renderBadRequest(c)
 	}
-	comments, err := controller.Store.GetCommentsByStatus(statuses)
+	cursor, err := domain.ParseCommentCursor(c.QueryParam("cursor"))
+	if err != nil {
+		return renderBadRequest(c)
+	}
+	page, err := controller.Store.GetComments(filter, cursor, adminCommentsPerPage)
 	if err != nil {
 		return sendInternalError(c, err)
 	}
+	nextPageUrl := ""
+	if page.NextCursor.IsValid() {
+		nextPageParams := c.Request().URL.Query()
+		nextPageParams.Set("cursor", page.NextCursor.String())
+		nextPageUrl = "/admin/comments?" + nextPageParams.Encode()
+	}
+	// account deletions can not be attributed to a service, so only superadmins see them
+	accountDeletions := domain.AccountDeletionStatistics{}
+	if adminUser.Superadmin {
+		accountDeletions, err = controller.Store.GetAccountDeletionStatistics()
+		if err != nil {
+			return sendInternalError(c, err)
+		}
+	}
 
 	// Get flash messages
 	successFlashes, errorFlashes, err := baseliboidc.GetFlashes(c)
@@ -733,50 +1129,415 @@ func (controller *Controller) GetAdminDashboard(c echo.Context) error {
 			Error:       errorFlashes,
 			Success:     successFlashes,
 		},
-		AdminUser: domain.AdminUser{UserId: adminUserId},
-		Comments:  comments,
-		Statuses:  statuses,
+		AdminUser: adminUser,
+		Comments:  page.Comments,
+		Statuses:  filter.Statuses,
+		Filter: domain.CommentFilterForm{
+			CommentId:  c.QueryParam("commentId"),
+			ShowStatus: c.QueryParam("showStatus"),
+			ServiceKey: c.QueryParam("service"),
+			PostKey:    c.QueryParam("postKey"),
+			Author:     c.QueryParam("author"),
+			From:       c.QueryParam("from"),
+			To:         c.QueryParam("to"),
+		},
+		AccountDeletions: accountDeletions,
+		NextPageUrl:      nextPageUrl,
 	}
 	// Prepare data for the dashboard
 	return c.Render(http.StatusOK, "admin-dashboard", templateData)
 }
 
+const adminCommentsPerPage = 50
+
+const filterDateLayout = "2006-01-02"
+
+// parseCommentFilter reads the comment filters of the admin dashboard and the admin API from the query parameters.
+// The service is given by its key and can only narrow down the services the admin administers. Dates are days and
+// both the from and the to day are included.
+func parseCommentFilter(c echo.Context, adminUser domain.AdminUser) (domain.CommentFilter, error) {
+	filter := domain.CommentFilter{
+		Statuses:    []domain.CommentStatus{},
+		PostKey:     strings.TrimSpace(c.QueryParam("postKey")),
+		AuthorEmail: strings.TrimSpace(c.QueryParam("author")),
+	}
+	if showStatusParam := c.QueryParam("showStatus"); showStatusParam != "" {
+		for _, status := range strings.Split(showStatusParam, ",") {
+			parsedStatus, err := domain.ParseCommentStatus(status)
+			if err != nil {
+				return domain.CommentFilter{}, ErrIllegalArgument
+			}
+			filter.Statuses = append(filter.Statuses, parsedStatus)
+		}
+	}
+	if commentIdParam := strings.TrimSpace(c.QueryParam("commentId")); commentIdParam != "" {
+		commentId, err := strconv.Atoi(commentIdParam)
+		if err != nil || commentId <= 0 {
+			return domain.CommentFilter{}, ErrIllegalArgument
+		}
+		filter.CommentId = commentId
+	}
+	filter.ServiceIds = filterServiceIds(c, adminUser)
+	var err error
+	filter.From, filter.To, err = parseFilterDays(c)
+	if err != nil {
+		return domain.CommentFilter{}, err
+	}
+	return filter, nil
+}
+
+// filterServiceIds returns the ids of the services the admin administers, narrowed down to the service with the key
+// in the service query parameter when it is set
+func filterServiceIds(c echo.Context, adminUser domain.AdminUser) []int {
+	serviceKey := strings.TrimSpace(c.QueryParam("service"))
+	if serviceKey == "" {
+		return adminUser.ServiceIds()
+	}
+	serviceIds := []int{}
+	for _, service := range adminUser.Services {
+		if service.ServiceKey == serviceKey {
+			serviceIds = append(serviceIds, service.Id)
+		}
+	}
+	return serviceIds
+}
+
+// parseFilterDays reads the from and to query parameters, the returned end is exclusive so that the to day is included
+func parseFilterDays(c echo.Context) (time.Time, time.Time, error) {
+	var from, to time.Time
+	var err error
+	if fromParam := c.QueryParam("from"); fromParam != "" {
+		from, err = time.Parse(filterDateLayout, fromParam)
+		if err != nil {
+			return time.Time{}, time.Time{}, ErrIllegalArgument
+		}
+	}
+	if toParam := c.QueryParam("to"); toParam != "" {
+		to, err = time.Parse(filterDateLayout, toParam)
+		if err != nil {
+			return time.Time{}, time.Time{}, ErrIllegalArgument
+		}
+		to = to.AddDate(0, 0, 1)
+	}
+	return from, to, nil
+}
+
 func (controller *Controller) AdminApproveComment(c echo.Context) error {
-	_, err := getAdminUserIdFromSession(c)
-	if err != nil && !errors.Is(err, lang.ErrNotFound) {
-		return sendInternalError(c, err)
-	} else if err != nil {
-		return c.Redirect(http.StatusUnauthorized, "/adminlogin/")
+	return controller.adminModerateComment(c, 0, domain.CommentStatusApproved)
+}
+
+// AdminRejectComment rejects a comment with an optional reason that is shown to the author. When requested the
+// author is also notified by email.
+func (controller *Controller) AdminRejectComment(c echo.Context) error {
+	return controller.adminModerateComment(c, 0, domain.CommentStatusRejected)
+}
+
+// AdminUnapproveComment hides an approved comment again and puts it back into moderation
+func (controller *Controller) AdminUnapproveComment(c echo.Context) error {
+	return controller.adminModerateComment(c, domain.CommentStatusApproved, domain.CommentStatusPendingApproval)
+}
+
+// AdminUnrejectComment puts a rejected comment back into moderation
+func (controller *Controller) AdminUnrejectComment(c echo.Context) error {
+	return controller.adminModerateComment(c, domain.CommentStatusRejected, domain.CommentStatusPendingApproval)
+}
+
+// adminModerateComment changes the status of the comment in the path to newStatus. When expectedStatus is set the
+// comment must currently have that status.
+func (controller *Controller) adminModerateComment(c echo.Context, expectedStatus domain.CommentStatus, newStatus domain.CommentStatus) error {
+	adminUser, err := controller.requireAdmin(c)
+	if err != nil || !adminUser.IsValid() {
+		return err
 	}
 	comment, err := controller.requireCommentAndRetrieve(c)
 	if err != nil {
 		return handleCommonErrors(c, err)
 	}
-	err = controller.Store.UpdateComment(comment.Id, domain.CommentStatusApproved, comment.Comment, comment.Name, comment.Website, comment.ParentUrl)
-	if err != nil {
-		return sendInternalError(c, err)
+	if !adminUser.CanAdminister(comment.ServiceId) {
+		return renderUnauthorized(c)
+	}
+	reason := strings.TrimSpace(c.FormValue("reason"))
+	if expectedStatus != 0 && comment.Status != expectedStatus {
+		err = ErrInvalidModeration
+	} else {
+		err = controller.moderateComment(adminUser, comment, newStatus, reason)
 	}
+	if errors.Is(err, ErrInvalidModeration) {
+		//nolint:errcheck
+		baseliboidc.SetFlash(c, "error", fmt.Sprintf("Comment #%d is %s and can not be changed to %s.", comment.Id, comment.Status, newStatus))
+		return c.Redirect(http.StatusFound, "/admin")
+	} else if errors.Is(err, ErrIllegalArgument) {
+		//nolint:errcheck
+		baseliboidc.SetFlash(c, "error", fmt.Sprintf("The reason must not be longer than %d characters.", maxRejectionReasonLength))
+		return c.Redirect(http.StatusFound, "/admin")
+	} else if err != nil {
+		return handleCommonErrors(c, err)
+	}
+	if newStatus == domain.CommentStatusRejected && c.FormValue("notifyAuthor") != "" && !comment.AuthorConfirmed() {
+		//nolint:errcheck
+		baseliboidc.SetFlash(c, "success", fmt.Sprintf("Comment #%d has been rejected, its author was not notified since they never confirmed their email address.", comment.Id))
+	} else if newStatus == domain.CommentStatusRejected && c.FormValue("notifyAuthor") != "" {
+		err = controller.notifyAuthorOfRejection(comment, reason)
+		if err != nil {
+			logger.Error("Failed to notify author of rejected comment", "commentId", comment.Id, "error", err)
+			//nolint:errcheck
+			baseliboidc.SetFlash(c, "error", fmt.Sprintf("Comment #%d has been rejected, but its author could not be notified.", comment.Id))
+		} else {
+			//nolint:errcheck
+			baseliboidc.SetFlash(c, "success", fmt.Sprintf("Comment #%d has been rejected and its author will be notified.", comment.Id))
+		}
+	}
+	controller.notifySubscribers([]domain.Comment{comment}, newStatus, reason, c.FormValue("notifyAuthor") != "")
 	return c.Redirect(http.StatusFound, "/admin")
 }
 
+const maxRejectionReasonLength = 1000
+
+// moderateComment changes the status of a comment, the caller has to verify that the admin administers its service.
+// The reason is only stored for rejections.
+func (controller *Controller) moderateComment(adminUser domain.AdminUser, comment domain.Comment, newStatus domain.CommentStatus, reason string) error {
+	if !comment.CanBeModeratedTo(newStatus) {
+		return ErrInvalidModeration
+	}
+	if utf8.RuneCountInString(reason) > maxRejectionReasonLength {
+		return ErrIllegalArgument
+	}
+	err := controller.Store.UpdateCommentStatus(comment.Id, newStatus, reason, domain.AdminActor(adminUser))
+	if err != nil {
+		return err
+	}
+	logger.Info("Moderated comment", "commentId", comment.Id, "oldStatus", comment.Status, "newStatus", newStatus, "admin", adminUser.UserId)
+	controller.learnFromModeration([]domain.Comment{comment}, newStatus)
+	return nil
+}
+
+// learnFromModeration teaches the spam filters that approved comments are not spam and rejected comments are
+func (controller *Controller) learnFromModeration(comments []domain.Comment, newStatus domain.CommentStatus) {
+	if newStatus != domain.CommentStatusApproved && newStatus != domain.CommentStatusRejected {
+		return
+	}
+	for _, comment := range comments {
+		err := controller.Spam.Learn(comment.Id, spam.Submission{
+			ServiceId: comment.ServiceId,
+			Comment:   comment.Comment,
+			Name:      comment.Name,
+			Website:   comment.Website,
+		}, newStatus == domain.CommentStatusRejected)
+		if err != nil {
+			logger.Error("Failed to learn from moderated comment", "commentId", comment.Id, "error", err)
+		}
+	}
+}
+
+// notifyAuthorOfRejection sends the author of a rejected comment an email with the reason, unless the author never
+// confirmed their email address
+func (controller *Controller) notifyAuthorOfRejection(comment domain.Comment, reason string) error {
+	if !comment.AuthorConfirmed() {
+		return nil
+	}
+	user, err := controller.Store.FindUserById(comment.UserId)
+	if err != nil {
+		return err
+	}
+	return controller.EmailSender.SendNotification(email.CommentRejectedEmail{
+		EmailAddress: user.Email,
+		UserId:       user.Id,
+		ParentUrl:    comment.ParentUrl,
+		Comment:      comment.Comment,
+		Reason:       reason,
+	})
+}
+
 func (controller *Controller) AdminDeleteComment(c echo.Context) error {
-	_, err := getAdminUserIdFromSession(c)
-	if err != nil && !errors.Is(err, lang.ErrNotFound) {
-		return sendInternalError(c, err)
-	} else if err != nil {
-		return c.Redirect(http.StatusUnauthorized, "/adminlogin/")
+	adminUser, err := controller.requireAdmin(c)
+	if err != nil || !adminUser.IsValid() {
+		return err
 	}
 	comment, err := controller.requireCommentAndRetrieve(c)
 	if err != nil {
 		return handleCommonErrors(c, err)
 	}
-	err = controller.Store.DeleteComment(comment.Id)
+	if !adminUser.CanAdminister(comment.ServiceId) {
+		return renderUnauthorized(c)
+	}
+	err = controller.Store.DeleteComment(comment.Id, domain.AdminActor(adminUser))
+	if err != nil {
+		return sendInternalError(c, err)
+	}
+	return c.Redirect(http.StatusFound, "/admin")
+}
+
+const maxBulkModerationComments = 500
+
+func (controller *Controller) AdminBulkApproveComments(c echo.Context) error {
+	return controller.adminBulkModerateComments(c, domain.CommentStatusApproved, "Approved")
+}
+
+// AdminBulkRejectComments rejects all selected comments with the same optional reason and, when requested, notifies
+// their authors
+func (controller *Controller) AdminBulkRejectComments(c echo.Context) error {
+	return controller.adminBulkModerateComments(c, domain.CommentStatusRejected, "Rejected")
+}
+
+// adminBulkModerateComments changes the status of all selected comments in one transaction. Comments of services
+// the admin does not administer and comments that can not be changed to newStatus are skipped and reported.
+func (controller *Controller) adminBulkModerateComments(c echo.Context, newStatus domain.CommentStatus, verb string) error {
+	adminUser, err := controller.requireAdmin(c)
+	if err != nil || !adminUser.IsValid() {
+		return err
+	}
+	commentIds, comments, err := controller.requireSelectedComments(c)
+	if err != nil || commentIds == nil {
+		return err
+	}
+	reason := strings.TrimSpace(c.FormValue("reason"))
+	if utf8.RuneCountInString(reason) > maxRejectionReasonLength {
+		//nolint:errcheck
+		baseliboidc.SetFlash(c, "error", fmt.Sprintf("The reason must not be longer than %d characters.", maxRejectionReasonLength))
+		return c.Redirect(http.StatusFound, "/admin")
+	}
+	moderatedComments := make([]domain.Comment, 0, len(comments))
+	moderatedCommentIds := make([]int, 0, len(comments))
+	for _, comment := range comments {
+		if adminUser.CanAdminister(comment.ServiceId) && comment.CanBeModeratedTo(newStatus) {
+			moderatedComments = append(moderatedComments, comment)
+			moderatedCommentIds = append(moderatedCommentIds, comment.Id)
+		}
+	}
+	changed, err := controller.Store.UpdateCommentStatuses(moderatedCommentIds, newStatus, reason, domain.AdminActor(adminUser))
 	if err != nil {
 		return sendInternalError(c, err)
 	}
+	logger.Info("Moderated comments", "commentIds", moderatedCommentIds, "newStatus", newStatus, "admin", adminUser.UserId)
+	controller.learnFromModeration(moderatedComments, newStatus)
+	//nolint:errcheck
+	baseliboidc.SetFlash(c, "success", fmt.Sprintf("%s %s.", verb, countComments(changed)))
+	if skipped := len(commentIds) - changed; skipped > 0 {
+		//nolint:errcheck
+		baseliboidc.SetFlash(c, "error", fmt.Sprintf("%s could not be changed to %s.", countComments(skipped), newStatus))
+	}
+	if newStatus == domain.CommentStatusRejected && c.FormValue("notifyAuthor") != "" {
+		failedNotifications := 0
+		for _, comment := range moderatedComments {
+			err = controller.notifyAuthorOfRejection(comment, reason)
+			if err != nil {
+				logger.Error("Failed to notify author of rejected comment", "commentId", comment.Id, "error", err)
+				failedNotifications++
+			}
+		}
+		if failedNotifications > 0 {
+			//nolint:errcheck
+			baseliboidc.SetFlash(c, "error", fmt.Sprintf("The authors of %s could not be notified.", countComments(failedNotifications)))
+		}
+	}
+	controller.notifySubscribers(moderatedComments, newStatus, reason, c.FormValue("notifyAuthor") != "")
 	return c.Redirect(http.StatusFound, "/admin")
 }
 
+// AdminBulkDeleteComments deletes all selected comments of services the admin administers in one transaction
+func (controller *Controller) AdminBulkDeleteComments(c echo.Context) error {
+	adminUser, err := controller.requireAdmin(c)
+	if err != nil || !adminUser.IsValid() {
+		return err
+	}
+	commentIds, comments, err := controller.requireSelectedComments(c)
+	if err != nil || commentIds == nil {
+		return err
+	}
+	deletedCommentIds := make([]int, 0, len(comments))
+	for _, comment := range comments {
+		if adminUser.CanAdminister(comment.ServiceId) {
+			deletedCommentIds = append(deletedCommentIds, comment.Id)
+		}
+	}
+	deleted, err := controller.Store.DeleteComments(deletedCommentIds, domain.AdminActor(adminUser))
+	if err != nil {
+		return sendInternalError(c, err)
+	}
+	logger.Info("Deleted comments", "commentIds", deletedCommentIds, "admin", adminUser.UserId)
+	//nolint:errcheck
+	baseliboidc.SetFlash(c, "success", fmt.Sprintf("Deleted %s.", countComments(deleted)))
+	if skipped := len(commentIds) - deleted; skipped > 0 {
+		//nolint:errcheck
+		baseliboidc.SetFlash(c, "error", fmt.Sprintf("%s could not be deleted.", countComments(skipped)))
+	}
+	return c.Redirect(http.StatusFound, "/admin")
+}
+
+// requireSelectedComments parses the commentId form values of the bulk actions and loads the selected comments.
+// When nothing was selected it flashes an error and redirects back to the dashboard, the returned ids are nil then.
+func (controller *Controller) requireSelectedComments(c echo.Context) ([]int, []domain.Comment, error) {
+	formParams, err := c.FormParams()
+	if err != nil {
+		return nil, nil, renderBadRequest(c)
+	}
+	commentIds := make([]int, 0, len(formParams["commentId"]))
+	seen := make(map[int]bool)
+	for _, commentIdParam := range formParams["commentId"] {
+		commentId, err := strconv.Atoi(commentIdParam)
+		if err != nil {
+			return nil, nil, renderBadRequest(c)
+		}
+		if !seen[commentId] {
+			seen[commentId] = true
+			commentIds = append(commentIds, commentId)
+		}
+	}
+	if len(commentIds) == 0 {
+		//nolint:errcheck
+		baseliboidc.SetFlash(c, "error", "Select at least one comment.")
+		return nil, nil, c.Redirect(http.StatusFound, "/admin")
+	}
+	if len(commentIds) > maxBulkModerationComments {
+		//nolint:errcheck
+		baseliboidc.SetFlash(c, "error", fmt.Sprintf("Select at most %d comments.", maxBulkModerationComments))
+		return nil, nil, c.Redirect(http.StatusFound, "/admin")
+	}
+	comments, err := controller.Store.GetCommentsByIds(commentIds)
+	if err != nil {
+		return nil, nil, sendInternalError(c, err)
+	}
+	return commentIds, comments, nil
+}
+
+func countComments(count int) string {
+	return fmt.Sprintf("%d %s", count, lang.IfElse(count == 1, "comment", "comments"))
+}
+
+var maxOutboxEmailsToShow = 200
+
+// GetAdminEmails is only available to superadmins since the outbox contains the emails of all services
+func (controller *Controller) GetAdminEmails(c echo.Context) error {
+	adminUser, err := controller.requireSuperadmin(c)
+	if err != nil || !adminUser.IsValid() {
+		return err
+	}
+	showStatusParam := c.QueryParam("showStatus")
+	statuses := []domain.OutboxStatus{}
+	if showStatusParam != "" {
+		for _, status := range strings.Split(showStatusParam, ",") {
+			parsedStatus, err := domain.ParseOutboxStatus(status)
+			if err != nil {
+				return renderBadRequest(c)
+			}
+			statuses = append(statuses, parsedStatus)
+		}
+	}
+	emails, err := controller.Store.GetOutboxEmails(statuses, maxOutboxEmailsToShow)
+	if err != nil {
+		return sendInternalError(c, err)
+	}
+	return c.Render(http.StatusOK, "admin-emails", domain.AdminEmailsPage{
+		BasePage: domain.BasePage{
+			Stylesheets: templateStylesheets,
+			Scripts:     templateScripts,
+		},
+		AdminUser: adminUser,
+		Emails:    emails,
+		Statuses:  statuses,
+	})
+}
+
 func (controller *Controller) GetDemo(c echo.Context) error {
 	user, err := getUserFromSession(c, controller)
 	if err != nil && !errors.Is(err, lang.ErrNotFound) {
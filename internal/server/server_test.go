@@ -2,13 +2,22 @@ package server
 
 import (
 	"aggregat4/go-commentservice/internal/domain"
+	"aggregat4/go-commentservice/internal/email"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/aggregat4/go-baselib/lang"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/stretchr/testify/assert"
 )
@@ -113,7 +122,7 @@ func TestRequestAuthenticationLinkWithNonExistingEmailParam(t *testing.T) {
 	assert.Equal(t, 200, res.StatusCode)
 	body := readBody(res)
 	assert.Contains(t, body, "No data was found for the user with email")
-	assert.Equal(t, 0, controller.EmailSender.NumberOfEmailsSent, "EmailSender should NOT have been called")
+	assert.Equal(t, 0, controller.EmailSender.NumberOfEmailsSent(), "EmailSender should NOT have been called")
 }
 
 func TestRequestAuthenticationLinkWithExistingEmailParam(t *testing.T) {
@@ -134,8 +143,121 @@ func TestRequestAuthenticationLinkWithExistingEmailParam(t *testing.T) {
 	)
 	assert.Equal(t, 200, res.StatusCode)
 	body := readBody(res)
-	assert.Contains(t, body, "An authentication token will be sent in")
-	assert.Equal(t, 1, controller.EmailSender.NumberOfEmailsSent, "EmailSender should have been called")
+	assert.Contains(t, body, "An authentication token is on the way")
+	assert.Equal(t, 1, controller.EmailSender.NumberOfEmailsSent(), "EmailSender should have been called")
+}
+
+func TestRequestAuthenticationLinkWhenRateLimited(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	// use up the emails allowed for this address, as if they had been requested from somewhere else
+	for {
+		err := controller.EmailSender.SendEmail(email.AuthenticationCodeEmail{EmailAddress: TEST_USER_NO_TOKEN, Code: "OTHERCODE"}, "192.0.2.1")
+		if errors.Is(err, email.ErrRecipientRateLimited) {
+			break
+		}
+		assert.Nil(t, err)
+	}
+	client := createTestHttpClient(true)
+	formParams := url.Values{}
+	formParams.Set("email", TEST_USER_NO_TOKEN)
+	res := postWithOrigin(
+		t,
+		client,
+		createServerUrl(serverConfig.Port, "/userauthentication/"),
+		"application/x-www-form-urlencoded",
+		strings.NewReader(formParams.Encode()),
+	)
+	assert.Equal(t, 200, res.StatusCode)
+	body := readBody(res)
+	assert.Contains(t, body, "Too many emails have been sent to this address recently")
+	emails, err := controller.Store.GetOutboxEmails([]domain.OutboxStatus{}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, email.DefaultRateLimits.PerRecipientPerHour, len(emails), "Rate limited requests should not queue an email")
+	user, err := controller.Store.FindUserByEmail(TEST_USER_NO_TOKEN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 0, user.AuthTokenSentToClient, "Rate limited requests should not count as an attempt")
+}
+
+func TestSpoofedForwardedForDoesNotResetClientRateLimit(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(true)
+	var body string
+	for i := 0; i <= email.DefaultRateLimits.PerClientPerHour; i++ {
+		emailAddress := "client-limit-" + strconv.Itoa(i) + "@example.com"
+		_, err := controller.Store.CreateUserByEmail(emailAddress)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest(http.MethodPost, createServerUrl(serverConfig.Port, "/userauthentication/"), strings.NewReader(url.Values{"email": {emailAddress}}.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Origin", "http://localhost:"+strconv.Itoa(serverConfig.Port))
+		req.Header.Set("X-Forwarded-For", "203.0.113."+strconv.Itoa(i))
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body = readBody(res)
+	}
+	assert.Contains(t, body, "Too many emails have been requested recently")
+	assert.Equal(t, email.DefaultRateLimits.PerClientPerHour, len(outboxEmailsOfKind(t, controller, "authentication-code")))
+}
+
+func TestIpExtractorOnlyTrustsConfiguredProxies(t *testing.T) {
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.RemoteAddr = "10.0.0.1:1234"
+	request.Header.Set("X-Forwarded-For", "203.0.113.7")
+	assert.Equal(t, "10.0.0.1", ipExtractor(nil)(request))
+	assert.Equal(t, "203.0.113.7", ipExtractor([]string{"10.0.0.0/8"})(request))
+	assert.Equal(t, "10.0.0.1", ipExtractor([]string{"192.168.0.0/16"})(request))
+	assert.Panics(t, func() { ipExtractor([]string{"10.0.0.1"}) })
+}
+
+func TestRequestAuthenticationLinkRepeatedlyIsThrottled(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(true)
+	expectedDelays := []time.Duration{0, 1 * time.Minute, 5 * time.Minute}
+	for i, expectedDelay := range expectedDelays {
+		formParams := url.Values{}
+		formParams.Set("email", TEST_USER_NO_TOKEN)
+		requestedAt := time.Now()
+		res := postWithOrigin(
+			t,
+			client,
+			createServerUrl(serverConfig.Port, "/userauthentication/"),
+			"application/x-www-form-urlencoded",
+			strings.NewReader(formParams.Encode()),
+		)
+		assert.Equal(t, 200, res.StatusCode)
+		body := readBody(res)
+		if expectedDelay == 0 {
+			assert.Contains(t, body, "An authentication token is on the way")
+		} else {
+			assert.Contains(t, body, "An authentication token will be sent in "+expectedDelay.String())
+		}
+		emails, err := controller.Store.GetOutboxEmails([]domain.OutboxStatus{}, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, i+1, len(emails))
+		// emails are returned newest first
+		assert.WithinDuration(t, requestedAt.Add(expectedDelay), emails[0].NextAttemptAt, 2*time.Second)
+		if expectedDelay > 0 {
+			assert.Equal(t, domain.OutboxStatusPending, emails[0].Status, "Delayed emails should not have been sent yet")
+		}
+	}
 }
 
 func TestUserAuthenticationWithUnknownToken(t *testing.T) {
@@ -233,6 +355,28 @@ func TestCreateNewCommentUnauthenticated(t *testing.T) {
 	checkCommentExistenceForPost(t, TEST_POSTKEY2, comment, false)
 }
 
+func TestCreateNewCommentDropsParentUrlOfOtherSites(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	for _, parentUrl := range []string{"https://example.com/posts/1", "https://evil.example.net/phishing", "javascript:alert(1)"} {
+		formParams := url.Values{}
+		formParams.Set("email", "foo@example.com")
+		formParams.Set("comment", "A comment on "+parentUrl)
+		formParams.Set("parentUrl", parentUrl)
+		res := postComment(t, client, formParams, TEST_POSTKEY2)
+		assert.Equal(t, http.StatusFound, res.StatusCode)
+	}
+	user, err := controller.Store.FindUserByEmail("foo@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "https://example.com/posts/1", findUserComment(t, controller, user, "A comment on https://example.com/posts/1").ParentUrl)
+	assert.Equal(t, "", findUserComment(t, controller, user, "A comment on https://evil.example.net/phishing").ParentUrl)
+	assert.Equal(t, "", findUserComment(t, controller, user, "A comment on javascript:alert(1)").ParentUrl)
+}
+
 func TestCreateNewCommentAuthenticated(t *testing.T) {
 	echoServer, controller := waitForServer(t)
 	defer echoServer.Close()
@@ -321,6 +465,101 @@ func TestGetUserCommentsPageWithWrongUser(t *testing.T) {
 	assert.Equal(t, 401, res.StatusCode)
 }
 
+func TestExportUserDataAsJson(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	user := authenticateAndValidate(t, client, controller, TEST_USER_AUTHTOKEN_VALID, TEST_AUTHTOKEN_VALID)
+	edited := findCommentByContent(TEST_COMMENTS, TEST_COMMENT_APPROVED)
+	err := controller.Store.UpdateComment(edited.Id, edited.Status, "An edited comment", edited.Name, edited.Website, edited.ParentUrl, domain.UserActor(user.Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := client.Get(createServerUrl(serverConfig.Port, "/users/"+strconv.Itoa(user.Id)+"/comments/?format=json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 200, res.StatusCode)
+	assert.True(t, strings.HasPrefix(res.Header.Get("Content-Type"), "application/json"))
+	assert.Contains(t, res.Header.Get("Content-Disposition"), "attachment")
+	var export domain.UserDataExport
+	err = json.Unmarshal([]byte(readBody(res)), &export)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, domain.UserDataExportVersion, export.Version)
+	assert.Equal(t, TEST_USER_AUTHTOKEN_VALID, export.User.Email)
+	expectedComments, err := controller.Store.GetCommentsForUser(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotEmpty(t, export.Comments)
+	assert.Equal(t, len(expectedComments), len(export.Comments))
+	for _, expected := range expectedComments {
+		found := false
+		for _, exported := range export.Comments {
+			if exported.Id == expected.Id {
+				found = true
+				assert.Equal(t, expected.Comment, exported.Comment)
+				assert.Equal(t, expected.Status.String(), exported.Status)
+				assert.Equal(t, TEST_SERVICE, exported.Service)
+				assert.Equal(t, expected.PostKey, exported.PostKey)
+				assert.Equal(t, expected.ParentUrl, exported.ParentUrl)
+				assert.True(t, expected.CreatedAt.Equal(exported.CreatedAt))
+				if exported.Id == edited.Id {
+					assert.Equal(t, 1, len(exported.Revisions))
+					assert.Equal(t, TEST_COMMENT_APPROVED, exported.Revisions[0].Comment, "Earlier versions are exported")
+				} else {
+					assert.Empty(t, exported.Revisions)
+				}
+			}
+		}
+		assert.True(t, found, "Comment %d should have been exported", expected.Id)
+	}
+}
+
+func TestExportUserDataWithAcceptHeader(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	user := authenticateAndValidate(t, client, controller, TEST_USER_AUTHTOKEN_VALID, TEST_AUTHTOKEN_VALID)
+	req, err := http.NewRequest("GET", createServerUrl(serverConfig.Port, "/users/"+strconv.Itoa(user.Id)+"/comments/"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 200, res.StatusCode)
+	assert.True(t, strings.HasPrefix(res.Header.Get("Content-Type"), "application/json"))
+	assert.Equal(t, "", res.Header.Get("Content-Disposition"))
+	body := readBody(res)
+	assert.Contains(t, body, `"version": 1`)
+	assert.Contains(t, body, TEST_USER_AUTHTOKEN_VALID)
+}
+
+func TestExportUserDataOfOtherUser(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	authenticateAndValidate(t, client, controller, TEST_USER_AUTHTOKEN_VALID, TEST_AUTHTOKEN_VALID)
+	otherUser, err := controller.Store.FindUserByEmail(TEST_USER_AUTHTOKEN_VALID2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := client.Get(createServerUrl(serverConfig.Port, "/users/"+strconv.Itoa(otherUser.Id)+"/comments/?format=json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 401, res.StatusCode)
+	assert.NotContains(t, readBody(res), TEST_USER_AUTHTOKEN_VALID2)
+}
+
 func TestGetUserCommentForm(t *testing.T) {
 	echoServer, controller := waitForServer(t)
 	defer echoServer.Close()
@@ -435,6 +674,115 @@ func TestUpdateExistingCommentAsTheWrongUser(t *testing.T) {
 	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
 }
 
+func setTestServiceEditPolicy(t *testing.T, controller Controller, editPolicy domain.EditPolicy) {
+	service := getTestService(t, controller)
+	err := controller.Store.UpdateServiceEditPolicy(service.Id, editPolicy, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func editOwnComment(t *testing.T, client *http.Client, comment domain.Comment, content string) *http.Response {
+	formParams := url.Values{}
+	formParams.Set("email", TEST_USER_AUTHTOKEN_VALID)
+	formParams.Set("commentId", strconv.Itoa(comment.Id))
+	formParams.Set("comment", content)
+	return postComment(t, client, formParams, comment.PostKey)
+}
+
+func getEditCommentForm(t *testing.T, client *http.Client, user domain.User, comment domain.Comment) *http.Response {
+	res, err := client.Get(createServerUrl(serverConfig.Port, "/users/"+strconv.Itoa(user.Id)+"/comments/"+strconv.Itoa(comment.Id)+"/edit"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func getUserCommentsBody(t *testing.T, client *http.Client, user domain.User) string {
+	res, err := client.Get(createServerUrl(serverConfig.Port, "/users/"+strconv.Itoa(user.Id)+"/comments/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	return readBody(res)
+}
+
+func TestApprovedCommentsCanNotBeEditedByDefault(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	user := authenticateAndValidate(t, client, controller, TEST_USER_AUTHTOKEN_VALID, TEST_AUTHTOKEN_VALID)
+	approved := findCommentByContent(TEST_COMMENTS, TEST_COMMENT_APPROVED)
+	rejected := findCommentByContent(TEST_COMMENTS, TEST_COMMENT_REJECTED)
+	editLink := func(comment domain.Comment) string {
+		return `href="/users/` + strconv.Itoa(user.Id) + `/comments/` + strconv.Itoa(comment.Id) + `/edit"`
+	}
+
+	body := getUserCommentsBody(t, client, user)
+	assert.NotContains(t, body, editLink(approved))
+	assert.Contains(t, body, editLink(rejected), "Comments that are not approved can be edited")
+	res := getEditCommentForm(t, client, user, approved)
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	res = editOwnComment(t, client, approved, "Sneaky change")
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	comment, err := controller.Store.GetComment(approved.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, TEST_COMMENT_APPROVED, comment.Comment)
+}
+
+func TestApprovedCommentsCanBeEditedWithinTheGraceWindow(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	setTestServiceEditPolicy(t, controller, domain.EditPolicyGraceWindow)
+	client := createTestHttpClient(false)
+	user := authenticateAndValidate(t, client, controller, TEST_USER_AUTHTOKEN_VALID, TEST_AUTHTOKEN_VALID)
+	approved := findCommentByContent(TEST_COMMENTS, TEST_COMMENT_APPROVED)
+
+	res, err := client.Get(createServerUrl(serverConfig.Port, "/services/"+TEST_SERVICE+"/posts/"+TEST_POSTKEY1+"/comments/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(t, readBody(res), "/comments/"+strconv.Itoa(approved.Id)+"/edit", "The post page links to the edit form")
+	res = getEditCommentForm(t, client, user, approved)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Contains(t, readBody(res), "You can change your comment until")
+	res = editOwnComment(t, client, approved, "Fixed a typo")
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	comment, err := controller.Store.GetComment(approved.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "Fixed a typo", comment.Comment)
+	assert.Equal(t, domain.CommentStatusApproved, comment.Status, "Edits within the grace window stay approved")
+}
+
+func TestEditingApprovedCommentsCanRequireModeration(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	setTestServiceEditPolicy(t, controller, domain.EditPolicyRemoderate)
+	client := createTestHttpClient(false)
+	user := authenticateAndValidate(t, client, controller, TEST_USER_AUTHTOKEN_VALID, TEST_AUTHTOKEN_VALID)
+	approved := findCommentByContent(TEST_COMMENTS, TEST_COMMENT_APPROVED)
+
+	res := getEditCommentForm(t, client, user, approved)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Contains(t, readBody(res), "it will be hidden until it has been approved again")
+	res = editOwnComment(t, client, approved, "A different opinion")
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	comment, err := controller.Store.GetComment(approved.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "A different opinion", comment.Comment)
+	assert.Equal(t, domain.CommentStatusPendingApproval, comment.Status)
+	assert.NotContains(t, getPostCommentsBody(t, TEST_POSTKEY1), "A different opinion")
+}
+
 // TODO: update test to also check post page once we have a way to approve a comment and then check it on the post page
 func TestDeleteExistingCommentWithValidCommentId(t *testing.T) {
 	echoServer, controller := waitForServer(t)
@@ -516,6 +864,7 @@ func TestConfirmExistingCommentWithAuthentication(t *testing.T) {
 		t.Fatal(err)
 	}
 	assert.Equal(t, domain.CommentStatusPendingApproval, comment.Status, "Confirming comment should change the status to pending approval")
+	assert.False(t, comment.Edited, "Confirming a comment does not edit it")
 }
 
 func TestConfirmExistingCommentWithoutAuthentication(t *testing.T) {
@@ -564,26 +913,997 @@ func TestConfirmExistingCommentWithValidCommentIdButWrongUser(t *testing.T) {
 	assert.Equal(t, http.StatusUnauthorized, res.StatusCode, "Confirming comment with invalid user id should redirect to the user's comment overview page")
 }
 
-//// HELPER FUNCTIONS
+func TestGetDeleteAccountForm(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	user := authenticateAndValidate(t, client, controller, TEST_USER_AUTHTOKEN_VALID, TEST_AUTHTOKEN_VALID)
+	res, err := client.Get(createServerUrl(serverConfig.Port, "/users/"+strconv.Itoa(user.Id)+"/delete"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 200, res.StatusCode)
+	body := readBody(res)
+	assert.Contains(t, body, "<h1>Delete Your Account</h1>")
+	assert.Contains(t, body, `action="/users/`+strconv.Itoa(user.Id)+`/delete"`)
+	assert.Contains(t, body, `name="confirm"`)
+}
 
-func confirmComment(t *testing.T, client *http.Client, userId int, commentId int) *http.Response {
-	return postWithOrigin(
-		t,
-		client,
-		createServerUrl(serverConfig.Port, "/users/"+strconv.Itoa(userId)+"/comments/"+strconv.Itoa(commentId)+"/confirm"),
-		"application/x-www-form-urlencoded",
-		nil,
-	)
+func TestDeleteAccountWithoutConfirmation(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	user := authenticateAndValidate(t, client, controller, TEST_USER_AUTHTOKEN_VALID, TEST_AUTHTOKEN_VALID)
+	res := deleteAccount(t, client, user.Id, false)
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	assert.Equal(t, "/users/"+strconv.Itoa(user.Id)+"/delete", res.Header.Get("Location"))
+	_, err := controller.Store.FindUserById(user.Id)
+	assert.Nil(t, err, "User should not be deleted without confirmation")
+	checkCommentExistenceForUser(t, client, user.Id, TEST_COMMENT_PENDING_AUTHENTICATION, true)
 }
 
-func deleteComment(t *testing.T, client *http.Client, userId int, commentId int) *http.Response {
-	return postWithOrigin(
-		t,
-		client,
-		createServerUrl(serverConfig.Port, "/users/"+strconv.Itoa(userId)+"/comments/"+strconv.Itoa(commentId)+"/delete"),
-		"application/x-www-form-urlencoded",
-		nil,
-	)
+func TestDeleteAccount(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	user := authenticateAndValidate(t, client, controller, TEST_USER_AUTHTOKEN_VALID, TEST_AUTHTOKEN_VALID)
+	comments, err := controller.Store.GetCommentsForUser(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotEmpty(t, comments)
+	err = controller.EmailSender.ScheduleEmail(email.AuthenticationCodeEmail{EmailAddress: TEST_USER_AUTHTOKEN_VALID, Code: "SOMECODE"}, "192.0.2.1", time.Hour)
+	assert.Nil(t, err)
+	err = controller.EmailSender.ScheduleEmail(email.AuthenticationCodeEmail{EmailAddress: TEST_USER_NO_TOKEN, Code: "OTHERCODE"}, "192.0.2.1", time.Hour)
+	assert.Nil(t, err)
+
+	res := deleteAccount(t, client, user.Id, true)
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	assert.Equal(t, "/userauthentication/", res.Header.Get("Location"))
+
+	_, err = controller.Store.FindUserByEmail(TEST_USER_AUTHTOKEN_VALID)
+	assert.ErrorIs(t, err, lang.ErrNotFound)
+	for _, comment := range comments {
+		_, err = controller.Store.GetComment(comment.Id)
+		assert.ErrorIs(t, err, lang.ErrNotFound, "Comment %d should have been deleted", comment.Id)
+	}
+	emails, err := controller.Store.GetOutboxEmails([]domain.OutboxStatus{}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(emails), "Only emails to other users should remain in the outbox")
+	assert.Equal(t, TEST_USER_NO_TOKEN, emails[0].Recipient)
+	statistics, err := controller.Store.GetAccountDeletionStatistics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, domain.AccountDeletionStatistics{DeletedAccounts: 1, DeletedComments: len(comments)}, statistics)
+	// the session no longer refers to the deleted user
+	res, err = client.Get(createServerUrl(serverConfig.Port, "/users/"+strconv.Itoa(user.Id)+"/comments/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	assert.Equal(t, "/userauthentication/", res.Header.Get("Location"))
+	// other users are not affected
+	_, err = controller.Store.FindUserByEmail(TEST_USER_AUTHTOKEN_VALID2)
+	assert.Nil(t, err)
+}
+
+func TestDeleteAccountOfOtherUser(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	authenticateAndValidate(t, client, controller, TEST_USER_AUTHTOKEN_VALID, TEST_AUTHTOKEN_VALID)
+	otherUser, err := controller.Store.FindUserByEmail(TEST_USER_AUTHTOKEN_VALID2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := deleteAccount(t, client, otherUser.Id, true)
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	_, err = controller.Store.FindUserById(otherUser.Id)
+	assert.Nil(t, err)
+}
+
+func TestGetReplyForm(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	parentCommentId := findCommentByContent(TEST_COMMENTS, TEST_COMMENT_APPROVED).Id
+	res, err := http.Get(createServerUrl(serverConfig.Port, "/services/"+TEST_SERVICE+"/posts/"+TEST_POSTKEY1+"/commentform?parentCommentId="+strconv.Itoa(parentCommentId)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	body := readBody(res)
+	assert.Contains(t, body, "<h1>Reply to Comment</h1>")
+	assert.Contains(t, body, TEST_COMMENT_APPROVED)
+	assert.Contains(t, body, "<input type=\"hidden\" name=\"parentCommentId\" value=\""+strconv.Itoa(parentCommentId)+"\">")
+}
+
+func TestGetReplyFormForUnapprovedComment(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	parentCommentId := findCommentByContent(TEST_COMMENTS, TEST_COMMENT_PENDING_APPROVAL).Id
+	res, err := http.Get(createServerUrl(serverConfig.Port, "/services/"+TEST_SERVICE+"/posts/"+TEST_POSTKEY1+"/commentform?parentCommentId="+strconv.Itoa(parentCommentId)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
+
+func TestCreateReplyToApprovedComment(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	parentCommentId := findCommentByContent(TEST_COMMENTS, TEST_COMMENT_APPROVED).Id
+	reply := "This is a reply"
+	res := postReply(t, client, reply, TEST_POSTKEY1, parentCommentId)
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	replyComment := approveCommentByContent(t, controller, reply)
+	assert.Equal(t, parentCommentId, replyComment.ParentCommentId)
+	body := getPostCommentsBody(t, TEST_POSTKEY1)
+	assert.Contains(t, body, "<dl class=\"comments replies\">")
+	assert.Contains(t, body, "<dd><p>"+reply)
+	assert.Less(t, strings.Index(body, TEST_COMMENT_APPROVED), strings.Index(body, reply), "Reply should be rendered after its parent")
+}
+
+func TestCreateReplyToUnapprovedComment(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	parentCommentId := findCommentByContent(TEST_COMMENTS, TEST_COMMENT_REJECTED).Id
+	res := postReply(t, client, "This is a reply", TEST_POSTKEY1, parentCommentId)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
+
+func TestCreateReplyToCommentOnOtherPost(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	parentCommentId := findCommentByContent(TEST_COMMENTS, TEST_COMMENT_APPROVED).Id
+	res := postReply(t, client, "This is a reply", TEST_POSTKEY2, parentCommentId)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
+
+func TestRepliesRemainVisibleWhenParentIsDeleted(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	parentCommentId := findCommentByContent(TEST_COMMENTS, TEST_COMMENT_APPROVED).Id
+	reply := "This reply outlives its parent"
+	res := postReply(t, client, reply, TEST_POSTKEY1, parentCommentId)
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	approveCommentByContent(t, controller, reply)
+	err := controller.Store.DeleteComment(parentCommentId, testAdminActor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := getPostCommentsBody(t, TEST_POSTKEY1)
+	assert.NotContains(t, body, TEST_COMMENT_APPROVED)
+	assert.Contains(t, body, "This comment is no longer available.")
+	assert.Contains(t, body, "<dd><p>"+reply)
+}
+
+func TestAdminDashboardRequiresAdminRole(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	loginAdmin(t, client)
+	res, err := client.Get(createServerUrl(serverConfig.Port, "/admin/comments"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+}
+
+func TestAdminDashboardOnlyShowsAdministeredServices(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	otherComment := createCommentOnOtherService(t, controller)
+	client := createTestHttpClient(false)
+	loginAdmin(t, client, OTHER_SERVICE_ADMIN_ROLE)
+	body := getAdminDashboardBody(t, client)
+	assert.Contains(t, body, otherComment.Comment)
+	assert.NotContains(t, body, TEST_COMMENT_PENDING_APPROVAL)
+	assert.NotContains(t, body, "/admin/emails", "Only superadmins can inspect emails")
+
+	client = createTestHttpClient(false)
+	loginAdmin(t, client, domain.DefaultServiceAdminRole)
+	body = getAdminDashboardBody(t, client)
+	assert.NotContains(t, body, otherComment.Comment)
+	assert.Contains(t, body, TEST_COMMENT_PENDING_APPROVAL)
+
+	client = createTestHttpClient(false)
+	loginAdmin(t, client, serverConfig.SuperadminRole)
+	body = getAdminDashboardBody(t, client)
+	assert.Contains(t, body, otherComment.Comment)
+	assert.Contains(t, body, TEST_COMMENT_PENDING_APPROVAL)
+	assert.Contains(t, body, "/admin/emails")
+}
+
+func TestAdminApproveComment(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	loginAdmin(t, client, domain.DefaultServiceAdminRole)
+	comment := findCommentByContent(TEST_COMMENTS, TEST_COMMENT_PENDING_APPROVAL)
+	res := adminApproveComment(t, client, comment.Id)
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	approvedComment, err := controller.Store.GetComment(comment.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, domain.CommentStatusApproved, approvedComment.Status)
+}
+
+func TestAdminCanNotApproveCommentOfOtherService(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	createCommentOnOtherService(t, controller)
+	client := createTestHttpClient(false)
+	loginAdmin(t, client, OTHER_SERVICE_ADMIN_ROLE)
+	comment := findCommentByContent(TEST_COMMENTS, TEST_COMMENT_PENDING_APPROVAL)
+	res := adminApproveComment(t, client, comment.Id)
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	unchangedComment, err := controller.Store.GetComment(comment.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, domain.CommentStatusPendingApproval, unchangedComment.Status)
+}
+
+func TestAdminRejectCommentWithReason(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	loginAdmin(t, client, domain.DefaultServiceAdminRole)
+	comment := findCommentByContent(TEST_COMMENTS, TEST_COMMENT_PENDING_APPROVAL)
+	res := adminModerateComment(t, client, comment.Id, "reject", url.Values{"reason": {"Off topic"}, "notifyAuthor": {"true"}})
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	rejectedComment, err := controller.Store.GetComment(comment.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, domain.CommentStatusRejected, rejectedComment.Status)
+	assert.Equal(t, "Off topic", rejectedComment.RejectionReason)
+	assert.False(t, rejectedComment.Edited, "Moderation does not mark comments as edited")
+
+	emails, err := controller.Store.GetOutboxEmails([]domain.OutboxStatus{}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rejectionEmails := 0
+	for _, outboxEmail := range emails {
+		if outboxEmail.Kind == "comment-rejected" {
+			rejectionEmails++
+			assert.Equal(t, TEST_USER_AUTHTOKEN_VALID, outboxEmail.Recipient)
+			assert.Contains(t, outboxEmail.Payload, "Off topic")
+		}
+	}
+	assert.Equal(t, 1, rejectionEmails, "The author should be notified")
+
+	userClient := createTestHttpClient(false)
+	user := authenticateAndValidate(t, userClient, controller, TEST_USER_AUTHTOKEN_VALID, TEST_AUTHTOKEN_VALID)
+	res, err = userClient.Get(createServerUrl(serverConfig.Port, "/users/"+strconv.Itoa(user.Id)+"/comments/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(t, readBody(res), "Reason for the rejection: Off topic")
+}
+
+func TestAdminRejectCommentWithoutNotification(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	loginAdmin(t, client, domain.DefaultServiceAdminRole)
+	comment := findCommentByContent(TEST_COMMENTS, TEST_COMMENT_APPROVED)
+	res := adminModerateComment(t, client, comment.Id, "reject", url.Values{})
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	rejectedComment, err := controller.Store.GetComment(comment.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, domain.CommentStatusRejected, rejectedComment.Status)
+	assert.Equal(t, "", rejectedComment.RejectionReason)
+	emails, err := controller.Store.GetOutboxEmails([]domain.OutboxStatus{}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 0, len(emails))
+	assert.NotContains(t, getPostCommentsBody(t, TEST_POSTKEY1), TEST_COMMENT_APPROVED)
+}
+
+func TestAdminUnapproveAndUnrejectComment(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	loginAdmin(t, client, domain.DefaultServiceAdminRole)
+	for _, transition := range []struct {
+		content string
+		action  string
+	}{
+		{TEST_COMMENT_APPROVED, "unapprove"},
+		{TEST_COMMENT_REJECTED, "unreject"},
+	} {
+		comment := findCommentByContent(TEST_COMMENTS, transition.content)
+		res := adminModerateComment(t, client, comment.Id, transition.action, url.Values{})
+		assert.Equal(t, http.StatusFound, res.StatusCode)
+		updatedComment, err := controller.Store.GetComment(comment.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, domain.CommentStatusPendingApproval, updatedComment.Status, transition.action)
+	}
+	assert.NotContains(t, getPostCommentsBody(t, TEST_POSTKEY1), TEST_COMMENT_APPROVED)
+	// the comment is pending approval now and can not be unapproved again
+	comment := findCommentByContent(TEST_COMMENTS, TEST_COMMENT_APPROVED)
+	res := adminModerateComment(t, client, comment.Id, "unapprove", url.Values{})
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	assert.Contains(t, getAdminDashboardBody(t, client), "can not be changed to pending-approval")
+}
+
+func TestAdminCanNotRejectCommentOfOtherService(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	createCommentOnOtherService(t, controller)
+	client := createTestHttpClient(false)
+	loginAdmin(t, client, OTHER_SERVICE_ADMIN_ROLE)
+	comment := findCommentByContent(TEST_COMMENTS, TEST_COMMENT_APPROVED)
+	res := adminModerateComment(t, client, comment.Id, "reject", url.Values{"reason": {"Spam"}})
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	unchangedComment, err := controller.Store.GetComment(comment.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, domain.CommentStatusApproved, unchangedComment.Status)
+}
+
+func TestAdminBulkApproveComments(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	loginAdmin(t, client, domain.DefaultServiceAdminRole)
+	pendingAuthentication := findCommentByContent(TEST_COMMENTS, TEST_COMMENT_PENDING_AUTHENTICATION)
+	pendingApproval := findCommentByContent(TEST_COMMENTS, TEST_COMMENT_PENDING_APPROVAL)
+	approved := findCommentByContent(TEST_COMMENTS, TEST_COMMENT_APPROVED)
+	res := adminBulkModerateComments(t, client, "approve", []int{pendingAuthentication.Id, pendingApproval.Id, approved.Id}, url.Values{})
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	for _, comment := range []domain.Comment{pendingAuthentication, pendingApproval, approved} {
+		updatedComment, err := controller.Store.GetComment(comment.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, domain.CommentStatusApproved, updatedComment.Status)
+	}
+	body := getAdminDashboardBody(t, client)
+	assert.Contains(t, body, "Approved 2 comments.")
+	assert.Contains(t, body, "1 comment could not be changed to approved.", "Already approved comments are skipped")
+}
+
+func TestAdminBulkRejectCommentsWithReason(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	loginAdmin(t, client, domain.DefaultServiceAdminRole)
+	pendingApproval := findCommentByContent(TEST_COMMENTS, TEST_COMMENT_PENDING_APPROVAL)
+	approved := findCommentByContent(TEST_COMMENTS, TEST_COMMENT_APPROVED)
+	res := adminBulkModerateComments(t, client, "reject", []int{pendingApproval.Id, approved.Id}, url.Values{"reason": {"Spam"}, "notifyAuthor": {"true"}})
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	for _, comment := range []domain.Comment{pendingApproval, approved} {
+		updatedComment, err := controller.Store.GetComment(comment.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, domain.CommentStatusRejected, updatedComment.Status)
+		assert.Equal(t, "Spam", updatedComment.RejectionReason)
+	}
+	emails, err := controller.Store.GetOutboxEmails([]domain.OutboxStatus{}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, len(emails), "Every author should be notified")
+	assert.Contains(t, getAdminDashboardBody(t, client), "Rejected 2 comments.")
+}
+
+func TestAdminRejectCommentPendingAuthenticationDoesNotNotifyAuthor(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	loginAdmin(t, client, domain.DefaultServiceAdminRole)
+	comment := findCommentByContent(TEST_COMMENTS, TEST_COMMENT_PENDING_AUTHENTICATION)
+	res := adminModerateComment(t, client, comment.Id, "reject", url.Values{"reason": {"Spam"}, "notifyAuthor": {"true"}})
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	rejectedComment, err := controller.Store.GetComment(comment.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, domain.CommentStatusRejected, rejectedComment.Status)
+	assert.Equal(t, 0, len(outboxEmailsOfKind(t, controller, "comment-rejected")), "The unconfirmed address must not be emailed")
+	assert.Contains(t, getAdminDashboardBody(t, client), "its author was not notified since they never confirmed their email address")
+}
+
+func TestAdminBulkRejectCommentsOnlyNotifiesConfirmedAuthors(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	loginAdmin(t, client, domain.DefaultServiceAdminRole)
+	pendingApproval := findCommentByContent(TEST_COMMENTS, TEST_COMMENT_PENDING_APPROVAL)
+	pendingAuthentication := findCommentByContent(TEST_COMMENTS, TEST_COMMENT_PENDING_AUTHENTICATION)
+	res := adminBulkModerateComments(t, client, "reject", []int{pendingApproval.Id, pendingAuthentication.Id}, url.Values{"reason": {"Spam"}, "notifyAuthor": {"true"}})
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	rejectionEmails := outboxEmailsOfKind(t, controller, "comment-rejected")
+	assert.Equal(t, 1, len(rejectionEmails), "Only the author of the confirmed comment should be notified")
+	body := getAdminDashboardBody(t, client)
+	assert.Contains(t, body, "Rejected 2 comments.")
+	assert.NotContains(t, body, "could not be notified")
+}
+
+func TestAdminBulkDeleteComments(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	loginAdmin(t, client, domain.DefaultServiceAdminRole)
+	approved := findCommentByContent(TEST_COMMENTS, TEST_COMMENT_APPROVED)
+	rejected := findCommentByContent(TEST_COMMENTS, TEST_COMMENT_REJECTED)
+	res := adminBulkModerateComments(t, client, "delete", []int{approved.Id, rejected.Id}, url.Values{})
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	for _, comment := range []domain.Comment{approved, rejected} {
+		_, err := controller.Store.GetComment(comment.Id)
+		assert.ErrorIs(t, err, lang.ErrNotFound)
+	}
+	assert.Contains(t, getAdminDashboardBody(t, client), "Deleted 2 comments.")
+}
+
+func TestAdminBulkModerationSkipsCommentsOfOtherServices(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	otherComment := createCommentOnOtherService(t, controller)
+	client := createTestHttpClient(false)
+	loginAdmin(t, client, OTHER_SERVICE_ADMIN_ROLE)
+	comment := findCommentByContent(TEST_COMMENTS, TEST_COMMENT_PENDING_APPROVAL)
+	res := adminBulkModerateComments(t, client, "delete", []int{otherComment.Id, comment.Id}, url.Values{})
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	_, err := controller.Store.GetComment(otherComment.Id)
+	assert.ErrorIs(t, err, lang.ErrNotFound)
+	unchangedComment, err := controller.Store.GetComment(comment.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, domain.CommentStatusPendingApproval, unchangedComment.Status)
+	body := getAdminDashboardBody(t, client)
+	assert.Contains(t, body, "Deleted 1 comment.")
+	assert.Contains(t, body, "1 comment could not be deleted.")
+}
+
+func TestAdminBulkModerationRequiresSelection(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	loginAdmin(t, client, domain.DefaultServiceAdminRole)
+	res := adminBulkModerateComments(t, client, "approve", []int{}, url.Values{})
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	assert.Contains(t, getAdminDashboardBody(t, client), "Select at least one comment.")
+	res = adminBulkModerateComments(t, client, "approve", []int{}, url.Values{"commentId": {"notanumber"}})
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
+
+func TestAdminDashboardIsPaginated(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	createApprovedComments(t, controller, TEST_POSTKEY2, adminCommentsPerPage)
+	client := createTestHttpClient(false)
+	loginAdmin(t, client, domain.DefaultServiceAdminRole)
+	body := getAdminDashboardBody(t, client)
+	assert.Contains(t, body, "Generated comment 049")
+	assert.NotContains(t, body, TEST_COMMENT_REJECTED, "The oldest comments are on the next page")
+	nextPageUrl := regexp.MustCompile(`<a href="(/admin/comments\?[^"]+)">Older comments</a>`).FindStringSubmatch(body)
+	if nextPageUrl == nil {
+		t.Fatal("The dashboard should link to the next page")
+	}
+	res, err := client.Get(createServerUrl(serverConfig.Port, html.UnescapeString(nextPageUrl[1])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	body = readBody(res)
+	assert.Contains(t, body, TEST_COMMENT_REJECTED)
+	assert.NotContains(t, body, "Generated comment 049")
+	assert.NotContains(t, body, "Older comments")
+}
+
+func TestAdminDashboardFilters(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	createApprovedComments(t, controller, TEST_POSTKEY2, 1)
+	client := createTestHttpClient(false)
+	loginAdmin(t, client, domain.DefaultServiceAdminRole)
+	getFilteredDashboard := func(query string) *http.Response {
+		res, err := client.Get(createServerUrl(serverConfig.Port, "/admin/comments?"+query))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	body := readBody(getFilteredDashboard("postKey=" + TEST_POSTKEY2))
+	assert.Contains(t, body, "Generated comment 000")
+	assert.NotContains(t, body, TEST_COMMENT_APPROVED)
+
+	body = readBody(getFilteredDashboard("author=" + url.QueryEscape(TEST_USER_AUTHTOKEN_VALID) + "&showStatus=approved"))
+	assert.Contains(t, body, TEST_COMMENT_APPROVED)
+	assert.NotContains(t, body, TEST_COMMENT_REJECTED)
+	assert.NotContains(t, body, "Generated comment 000")
+
+	today := time.Now().Format("2006-01-02")
+	body = readBody(getFilteredDashboard("from=" + today + "&to=" + today + "&service=" + TEST_SERVICE))
+	assert.Contains(t, body, TEST_COMMENT_APPROVED)
+	assert.Contains(t, body, "Generated comment 000")
+	body = readBody(getFilteredDashboard("to=2000-01-01"))
+	assert.NotContains(t, body, TEST_COMMENT_APPROVED)
+
+	res := getFilteredDashboard("from=yesterday")
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
+
+func TestPostCommentsLoadMore(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	createApprovedComments(t, controller, TEST_POSTKEY2, commentThreadsPerPage+1)
+	body := getPostCommentsBody(t, TEST_POSTKEY2)
+	assert.Contains(t, body, "Generated comment 000")
+	assert.NotContains(t, body, fmt.Sprintf("Generated comment %03d", commentThreadsPerPage))
+	loadMoreUrl := regexp.MustCompile(`<a class="load-more" href="([^"]+)">`).FindStringSubmatch(body)
+	if loadMoreUrl == nil {
+		t.Fatal("The page should link to the next threads")
+	}
+	res, err := http.Get(createServerUrl(serverConfig.Port, html.UnescapeString(loadMoreUrl[1])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	body = readBody(res)
+	assert.Contains(t, body, fmt.Sprintf("Generated comment %03d", commentThreadsPerPage))
+	assert.NotContains(t, body, "Generated comment 000")
+	assert.NotContains(t, body, "load-more")
+}
+
+func TestEditedCommentsAreMarkedAndKeepTheirHistory(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	comment := findCommentByContent(TEST_COMMENTS, TEST_COMMENT_APPROVED)
+	err := controller.Store.UpdateComment(comment.Id, comment.Status, "A corrected comment", comment.Name, comment.Website, comment.ParentUrl, domain.UserActor(comment.UserId))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := getPostCommentsBody(t, TEST_POSTKEY1)
+	assert.Contains(t, body, `<span class="edited">(edited)</span>`)
+
+	client := createTestHttpClient(false)
+	loginAdmin(t, client, domain.DefaultServiceAdminRole)
+	revisionsUrl := "/admin/comments/" + strconv.Itoa(comment.Id) + "/revisions"
+	assert.Contains(t, getAdminDashboardBody(t, client), `href="`+revisionsUrl+`"`)
+	res, err := client.Get(createServerUrl(serverConfig.Port, revisionsUrl))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	body = readBody(res)
+	assert.Contains(t, body, "Version 1")
+	assert.Contains(t, body, "<del>approved</del><ins>corrected</ins> comment", "The changes are shown word by word")
+	assert.Contains(t, body, TEST_COMMENT_APPROVED, "The first version is shown")
+
+	otherClient := createTestHttpClient(false)
+	loginAdmin(t, otherClient, OTHER_SERVICE_ADMIN_ROLE)
+	res, err = otherClient.Get(createServerUrl(serverConfig.Port, revisionsUrl))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+}
+
+func TestSpamCommentsAreRefusedOrScored(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	res, err := http.Get(createServerUrl(serverConfig.Port, "/services/"+TEST_SERVICE+"/posts/"+TEST_POSTKEY2+"/commentform"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(t, readBody(res), `name="homepage"`, "The comment form has a honeypot field")
+
+	client := createTestHttpClient(false)
+	user := authenticateAndValidate(t, client, controller, TEST_USER_AUTHTOKEN_VALID, TEST_AUTHTOKEN_VALID)
+	formParams := url.Values{}
+	formParams.Set("email", TEST_USER_AUTHTOKEN_VALID)
+	formParams.Set("comment", "A comment by a bot")
+	formParams.Set("homepage", "https://bot.example")
+	res = postComment(t, client, formParams, TEST_POSTKEY2)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	formParams.Del("homepage")
+	formParams.Set("comment", "The best casino in town")
+	res = postComment(t, client, formParams, TEST_POSTKEY2)
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	comments, err := controller.Store.GetCommentsForUser(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, domain.Comment{}, findCommentByContent(comments, "A comment by a bot"), "Certain spam is not stored")
+	comment := findCommentByContent(comments, "The best casino in town")
+	assert.Equal(t, 0.9, comment.SpamScore)
+
+	adminClient := createTestHttpClient(false)
+	loginAdmin(t, adminClient, domain.DefaultServiceAdminRole)
+	assert.Contains(t, getAdminDashboardBody(t, adminClient), `<span class="spam-score likely-spam">spam score 90%</span>`)
+}
+
+func TestModerationTeachesTheSpamClassifier(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	loginAdmin(t, client, domain.DefaultServiceAdminRole)
+	pendingAuthentication := findCommentByContent(TEST_COMMENTS, TEST_COMMENT_PENDING_AUTHENTICATION)
+	pendingApproval := findCommentByContent(TEST_COMMENTS, TEST_COMMENT_PENDING_APPROVAL)
+	res := adminApproveComment(t, client, pendingApproval.Id)
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	res = adminBulkModerateComments(t, client, "reject", []int{pendingAuthentication.Id}, url.Values{})
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	_, totals, err := controller.Store.GetSpamTokenCounts(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, domain.SpamTokenCounts{Spam: 1, Ham: 1}, totals)
+
+	res = adminModerateComment(t, client, pendingApproval.Id, "unapprove", url.Values{})
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	res = adminModerateComment(t, client, pendingApproval.Id, "reject", url.Values{})
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	_, totals, err = controller.Store.GetSpamTokenCounts(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, domain.SpamTokenCounts{Spam: 2}, totals, "A comment that is rejected after all is learned as spam instead")
+}
+
+func TestCommentsAreRenderedFromMarkdown(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	service, err := controller.Store.GetServiceForKey(TEST_SERVICE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := controller.Store.FindUserByEmail(TEST_USER_AUTHTOKEN_VALID2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := "> Quoted\n\nHello *world*, see [the docs](https://example.com/docs).\n<script>alert(1)</script>"
+	_, err = controller.Store.CreateComment(domain.CommentStatusApproved, service.Id, TEST_SERVICE, user.Id, TEST_POSTKEY2, content, "", "", "", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := getPostCommentsBody(t, TEST_POSTKEY2)
+	assert.Contains(t, body, `<dd><blockquote><p>Quoted</p></blockquote><p>Hello <em>world</em>, see <a href="https://example.com/docs" rel="nofollow ugc">the docs</a>.<br>`)
+	assert.Contains(t, body, "&lt;script&gt;alert(1)&lt;/script&gt;</p>")
+	assert.NotContains(t, body, "<script>alert(1)")
+}
+
+func TestCommentPreview(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	formParams := url.Values{}
+	formParams.Set("comment", "**Bold** and `code`")
+	res := postWithOrigin(t, client, createServerUrl(serverConfig.Port, "/services/"+TEST_SERVICE+"/posts/"+TEST_POSTKEY1+"/commentpreview"),
+		"application/x-www-form-urlencoded", strings.NewReader(formParams.Encode()))
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "<p><strong>Bold</strong> and <code>code</code></p>", readBody(res))
+
+	res = postWithOrigin(t, client, createServerUrl(serverConfig.Port, "/services/unknown/posts/"+TEST_POSTKEY1+"/commentpreview"),
+		"application/x-www-form-urlencoded", strings.NewReader(formParams.Encode()))
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+}
+
+func TestCommentCountsScript(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	res, err := http.Get(createServerUrl(serverConfig.Port, "/services/"+TEST_SERVICE+"/commentcounts.js"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.True(t, strings.HasPrefix(res.Header.Get("Content-Type"), "text/javascript"))
+	assert.Equal(t, "public, max-age=3600", res.Header.Get("Cache-Control"))
+	assert.Contains(t, readBody(res), "data-comment-count")
+
+	res, err = http.Get(createServerUrl(serverConfig.Port, "/services/unknown/commentcounts.js"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+}
+
+func getAdminModerationLog(t *testing.T, client *http.Client, query string) *http.Response {
+	res, err := client.Get(createServerUrl(serverConfig.Port, "/admin/moderationlog?"+query))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestAdminModerationLogShowsModerationAndDeletion(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	loginAdmin(t, client, domain.DefaultServiceAdminRole)
+	pendingApproval := findCommentByContent(TEST_COMMENTS, TEST_COMMENT_PENDING_APPROVAL)
+	approved := findCommentByContent(TEST_COMMENTS, TEST_COMMENT_APPROVED)
+	res := adminModerateComment(t, client, pendingApproval.Id, "reject", url.Values{"reason": {"Off topic"}})
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	res = adminBulkModerateComments(t, client, "delete", []int{approved.Id}, url.Values{})
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+
+	res = getAdminModerationLog(t, client, "")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	body := readBody(res)
+	assert.Contains(t, body, "#"+strconv.Itoa(pendingApproval.Id))
+	assert.Contains(t, body, "Off topic")
+	assert.Contains(t, body, "#"+strconv.Itoa(approved.Id))
+	assert.Contains(t, body, "admin admin", "The admin is recorded by their subject")
+	assert.Contains(t, body, `<span class="badge deleted">deleted</span>`)
+
+	body = readBody(getAdminModerationLog(t, client, "commentId="+strconv.Itoa(approved.Id)))
+	assert.Contains(t, body, "#"+strconv.Itoa(approved.Id))
+	assert.NotContains(t, body, "#"+strconv.Itoa(pendingApproval.Id))
+
+	body = readBody(getAdminModerationLog(t, client, "actorKind=user"))
+	assert.NotContains(t, body, "#"+strconv.Itoa(approved.Id))
+
+	res = getAdminModerationLog(t, client, "commentId=first")
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	res = getAdminModerationLog(t, client, "actorKind=robot")
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
+
+func TestAdminModerationLogIsLimitedToAdministeredServices(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	createCommentOnOtherService(t, controller)
+	client := createTestHttpClient(false)
+	loginAdmin(t, client, domain.DefaultServiceAdminRole)
+	approved := findCommentByContent(TEST_COMMENTS, TEST_COMMENT_APPROVED)
+	res := adminModerateComment(t, client, approved.Id, "reject", url.Values{})
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+
+	otherClient := createTestHttpClient(false)
+	loginAdmin(t, otherClient, OTHER_SERVICE_ADMIN_ROLE)
+	res = getAdminModerationLog(t, otherClient, "")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.NotContains(t, readBody(res), "#"+strconv.Itoa(approved.Id))
+}
+
+func TestAdminEmailsRequireSuperadmin(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	loginAdmin(t, client, domain.DefaultServiceAdminRole)
+	res, err := client.Get(createServerUrl(serverConfig.Port, "/admin/emails"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+	client = createTestHttpClient(false)
+	loginAdmin(t, client, serverConfig.SuperadminRole)
+	res, err = client.Get(createServerUrl(serverConfig.Port, "/admin/emails"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+}
+
+//// HELPER FUNCTIONS
+
+var OTHER_SERVICE = "OTHERSERVICE"
+var OTHER_SERVICE_ADMIN_ROLE = "other-service-admin"
+
+// createCommentOnOtherService creates a second service with its own admin role and a comment waiting for approval
+func createCommentOnOtherService(t *testing.T, controller Controller) domain.Comment {
+	serviceId, err := controller.Store.CreateService(OTHER_SERVICE, []string{"other.example.com"}, OTHER_SERVICE_ADMIN_ROLE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := controller.Store.FindUserByEmail(TEST_USER_AUTHTOKEN_VALID2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := "A comment on another service"
+	commentId, err := controller.Store.CreateComment(domain.CommentStatusPendingApproval, serviceId, OTHER_SERVICE, user.Id, TEST_POSTKEY1, content, "", "", "", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return domain.Comment{Id: commentId, ServiceId: serviceId, Comment: content}
+}
+
+// loginAdmin logs in an admin with the given roles through the mock OIDC callback
+func loginAdmin(t *testing.T, client *http.Client, roles ...string) {
+	params := url.Values{}
+	params.Set("subject", "admin")
+	for _, role := range roles {
+		params.Add("role", role)
+	}
+	res, err := client.Get(createServerUrl(serverConfig.Port, "/oidccallback?"+params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+}
+
+func getAdminDashboardBody(t *testing.T, client *http.Client) string {
+	res, err := client.Get(createServerUrl(serverConfig.Port, "/admin/comments"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func adminApproveComment(t *testing.T, client *http.Client, commentId int) *http.Response {
+	return postWithOrigin(
+		t,
+		client,
+		createServerUrl(serverConfig.Port, "/admin/comments/"+strconv.Itoa(commentId)+"/approve"),
+		"application/x-www-form-urlencoded",
+		nil,
+	)
+}
+
+func adminModerateComment(t *testing.T, client *http.Client, commentId int, action string, formParams url.Values) *http.Response {
+	return postWithOrigin(
+		t,
+		client,
+		createServerUrl(serverConfig.Port, "/admin/comments/"+strconv.Itoa(commentId)+"/"+action),
+		"application/x-www-form-urlencoded",
+		strings.NewReader(formParams.Encode()),
+	)
+}
+
+// createApprovedComments creates numbered approved comments, they are newer than the test data
+func createApprovedComments(t *testing.T, controller Controller, postKey string, count int) {
+	service, err := controller.Store.GetServiceForKey(TEST_SERVICE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := controller.Store.FindUserByEmail(TEST_USER_AUTHTOKEN_VALID2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < count; i++ {
+		_, err = controller.Store.CreateComment(domain.CommentStatusApproved, service.Id, TEST_SERVICE, user.Id, postKey, fmt.Sprintf("Generated comment %03d", i), "", "", "", 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func adminBulkModerateComments(t *testing.T, client *http.Client, action string, commentIds []int, formParams url.Values) *http.Response {
+	for _, commentId := range commentIds {
+		formParams.Add("commentId", strconv.Itoa(commentId))
+	}
+	return postWithOrigin(
+		t,
+		client,
+		createServerUrl(serverConfig.Port, "/admin/comments/bulk/"+action),
+		"application/x-www-form-urlencoded",
+		strings.NewReader(formParams.Encode()),
+	)
+}
+
+func confirmComment(t *testing.T, client *http.Client, userId int, commentId int) *http.Response {
+	return postWithOrigin(
+		t,
+		client,
+		createServerUrl(serverConfig.Port, "/users/"+strconv.Itoa(userId)+"/comments/"+strconv.Itoa(commentId)+"/confirm"),
+		"application/x-www-form-urlencoded",
+		nil,
+	)
+}
+
+func deleteComment(t *testing.T, client *http.Client, userId int, commentId int) *http.Response {
+	return postWithOrigin(
+		t,
+		client,
+		createServerUrl(serverConfig.Port, "/users/"+strconv.Itoa(userId)+"/comments/"+strconv.Itoa(commentId)+"/delete"),
+		"application/x-www-form-urlencoded",
+		nil,
+	)
+}
+
+func deleteAccount(t *testing.T, client *http.Client, userId int, confirm bool) *http.Response {
+	formParams := url.Values{}
+	if confirm {
+		formParams.Set("confirm", "on")
+	}
+	return postWithOrigin(
+		t,
+		client,
+		createServerUrl(serverConfig.Port, "/users/"+strconv.Itoa(userId)+"/delete"),
+		"application/x-www-form-urlencoded",
+		strings.NewReader(formParams.Encode()),
+	)
+}
+
+func postReply(t *testing.T, client *http.Client, reply string, postKey string, parentCommentId int) *http.Response {
+	formParams := url.Values{}
+	formParams.Set("email", "replier@example.com")
+	formParams.Set("name", "Jane Replier")
+	formParams.Set("comment", reply)
+	formParams.Set("parentCommentId", strconv.Itoa(parentCommentId))
+	return postComment(t, client, formParams, postKey)
+}
+
+func approveCommentByContent(t *testing.T, controller Controller, content string) domain.Comment {
+	service, err := controller.Store.GetServiceForKey(TEST_SERVICE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := controller.Store.GetComments(domain.CommentFilter{ServiceIds: []int{service.Id}}, domain.CommentCursor{}, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	comment := findCommentByContent(page.Comments, content)
+	err = controller.Store.UpdateComment(comment.Id, domain.CommentStatusApproved, comment.Comment, comment.Name, comment.Website, comment.ParentUrl, testAdminActor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return comment
+}
+
+func getPostCommentsBody(t *testing.T, postKey string) string {
+	res, err := http.Get(createServerUrl(serverConfig.Port, "/services/"+TEST_SERVICE+"/posts/"+postKey+"/comments/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	return readBody(res)
 }
 
 func postComment(t *testing.T, client *http.Client, formParams url.Values, postKey string) *http.Response {
@@ -608,9 +1928,9 @@ func checkCommentExistenceForUser(t *testing.T, client *http.Client, userId int,
 	assert.Contains(t, body, "<h1>Your Comments</h1>")
 	assert.Contains(t, body, "<dl class=\"comments\">")
 	if expectedExistence {
-		assert.Contains(t, body, "<dd>"+comment)
+		assert.Contains(t, body, "<dd><p>"+comment)
 	} else {
-		assert.NotContains(t, body, "<dd>"+comment+"</dd>")
+		assert.NotContains(t, body, "<dd><p>"+comment+"</p>")
 	}
 }
 
@@ -627,9 +1947,9 @@ func checkCommentExistenceForPost(t *testing.T, postKey string, comment string,
 	assert.Contains(t, body, "<h1>Comments</h1>")
 	assert.Contains(t, body, "<dl class=\"comments\">")
 	if shouldContain {
-		assert.Contains(t, body, "<dd>"+comment, "Comment should be displayed")
+		assert.Contains(t, body, "<dd><p>"+comment, "Comment should be displayed")
 	} else {
-		assert.NotContains(t, body, "<dd>"+comment, "Comment should not be displayed")
+		assert.NotContains(t, body, "<dd><p>"+comment, "Comment should not be displayed")
 	}
 }
 
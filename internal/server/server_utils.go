@@ -3,6 +3,7 @@ package server
 import (
 	"aggregat4/go-commentservice/internal/domain"
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
 	"strings"
@@ -32,34 +33,77 @@ func csrfMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 		if c.Request().Method == "HEAD" || c.Request().Method == "GET" {
 			return next(c)
 		}
-		originHeader := c.Request().Header.Get("Origin")
-		hostHeader := c.Request().Host
-		// parse the target origin from the host header and the X-Forwarded-Host header when present
-		hostParts := strings.Split(hostHeader, ":")
-		hostName := hostParts[0]
-		targetOriginPort := hostParts[1]
-		targetOriginHostname := c.Request().Header.Get("X-Forwarded-Host")
-		if targetOriginHostname == "" {
-			targetOriginHostname = hostName
-		}
-		// parse the hostname and the port from the Origin header
-		parsedURL, err := url.Parse(originHeader)
-		if err != nil {
-			return err
+		// the API also accepts requests from the origins of a service, it checks the Origin itself, see apiOriginMiddleware
+		if isApiRequest(c) {
+			return next(c)
 		}
-		originHostname := parsedURL.Hostname()
-		originPort := "80"
-		if parsedURL.Port() != "" {
-			originPort = parsedURL.Port()
+		// mail clients unsubscribe with one click from wherever they are, the signed link authenticates the request
+		if c.Path() == unsubscribePath {
+			return next(c)
 		}
-		if originHostname != targetOriginHostname || originPort != targetOriginPort {
-			logger.Info("CSRF check failed: Origin does not match target origin", "originHostname", originHostname, "targetOriginHostname", targetOriginHostname, "originPort", originPort, "targetOriginPort", targetOriginPort)
+		if !isSameOrigin(c, c.Request().Header.Get("Origin")) {
 			return echo.NewHTTPError(http.StatusForbidden, "forbidden")
 		}
 		return next(c)
 	}
 }
 
+// isSameOrigin tells whether the origin is the origin of this server as the client sees it
+func isSameOrigin(c echo.Context, originHeader string) bool {
+	// the target origin is taken from the X-Forwarded-Host header when present and the Host header otherwise, both
+	// only have a port when it is not the default port of the scheme
+	targetOriginHost := c.Request().Header.Get("X-Forwarded-Host")
+	if targetOriginHost == "" {
+		targetOriginHost = c.Request().Host
+	}
+	targetOriginHostname, targetOriginPort := splitHostPort(targetOriginHost, c.Scheme())
+	// parse the hostname and the port from the Origin header
+	parsedURL, err := url.Parse(originHeader)
+	if err != nil {
+		return false
+	}
+	originHostname := parsedURL.Hostname()
+	originPort := parsedURL.Port()
+	if originPort == "" {
+		originPort = domain.DefaultPort(parsedURL.Scheme)
+	}
+	if originHostname != targetOriginHostname || originPort != targetOriginPort {
+		logger.Info("CSRF check failed: Origin does not match target origin", "originHostname", originHostname, "targetOriginHostname", targetOriginHostname, "originPort", originPort, "targetOriginPort", targetOriginPort)
+		return false
+	}
+	return true
+}
+
+// splitHostPort splits a Host header into the hostname and the port, which defaults to the port of the scheme
+func splitHostPort(host string, scheme string) (string, string) {
+	hostname, port, err := net.SplitHostPort(host)
+	if err != nil {
+		hostname, port = host, ""
+	}
+	if port == "" {
+		port = domain.DefaultPort(scheme)
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(hostname, "["), "]"), port
+}
+
+// ipExtractor returns the address of the connecting client, unless it is one of the trusted proxies. Only then the
+// X-Forwarded-For header is used, otherwise clients could pretend to be someone else with every request. The ranges
+// are part of the configuration, so invalid ones stop the server from starting.
+func ipExtractor(trustedProxies []string) echo.IPExtractor {
+	if len(trustedProxies) == 0 {
+		return echo.ExtractIPDirect()
+	}
+	trustOptions := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, trustedProxy := range trustedProxies {
+		_, ipRange, err := net.ParseCIDR(trustedProxy)
+		if err != nil {
+			panic(fmt.Sprintf("invalid trusted proxy range %q: %s", trustedProxy, err))
+		}
+		trustOptions = append(trustOptions, echo.TrustIPRange(ipRange))
+	}
+	return echo.ExtractIPFromXFFHeader(trustOptions...)
+}
+
 func httpResponseLogger(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		err := next(c)
@@ -97,3 +141,9 @@ func renderNotFound(c echo.Context) error {
 }
 
 var ErrIllegalArgument = errors.New("illegal argumen")
+
+var ErrCommentNotEditable = errors.New("the comment can no longer be edited")
+
+var ErrInvalidModeration = errors.New("the comment can not be moderated to this status")
+
+var ErrSpam = errors.New("the comment has been rejected as spam")
@@ -1,6 +1,8 @@
 package server
 
 import (
+	"aggregat4/go-commentservice/internal/domain"
+	"aggregat4/go-commentservice/internal/markup"
 	"crypto/sha256"
 	"embed"
 	"encoding/hex"
@@ -20,6 +22,21 @@ type templateData struct {
 	AssetPath func(string) string
 }
 
+// commentThreadView is the data passed to the recursive commentThread template: the thread to render
+// together with the page it is rendered on, since nested comments need the page context for their links
+type commentThreadView struct {
+	Page   domain.PostCommentsPage
+	Thread domain.CommentThread
+}
+
+var templateFuncs = template.FuncMap{
+	"threadView": func(page domain.PostCommentsPage, thread domain.CommentThread) commentThreadView {
+		return commentThreadView{Page: page, Thread: thread}
+	},
+	"join":   strings.Join,
+	"markup": markup.Render,
+}
+
 type EchoTemplateRenderer struct {
 	templates map[string]*template.Template
 }
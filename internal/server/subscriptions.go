@@ -0,0 +1,212 @@
+package server
+
+import (
+	"aggregat4/go-commentservice/internal/domain"
+	"aggregat4/go-commentservice/internal/email"
+	"net/http"
+	"strconv"
+
+	"github.com/aggregat4/go-baselib/lang"
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+// subscribeToComment records the subscriptions chosen in the comment form, they become active with the comment
+func (controller *Controller) subscribeToComment(c echo.Context, comment domain.Comment) {
+	active := comment.Status != domain.CommentStatusPendingAuthentication
+	if c.FormValue("subscribeModeration") != "" {
+		err := controller.Store.CreateSubscription(comment.UserId, domain.SubscriptionModeration, comment.ServiceId, comment.PostKey, comment.Id, active)
+		if err != nil {
+			logger.Error("Failed to subscribe to the moderation of a comment", "commentId", comment.Id, "error", err)
+		}
+	}
+	if c.FormValue("subscribePost") != "" {
+		err := controller.Store.CreateSubscription(comment.UserId, domain.SubscriptionPost, comment.ServiceId, comment.PostKey, 0, active)
+		if err != nil {
+			logger.Error("Failed to subscribe to a post", "commentId", comment.Id, "error", err)
+		}
+	}
+}
+
+// activateSubscriptions activates the subscriptions made with a comment once its author has confirmed it
+func (controller *Controller) activateSubscriptions(comment domain.Comment) {
+	err := controller.Store.ActivateSubscriptions(comment)
+	if err != nil {
+		logger.Error("Failed to activate the subscriptions of a comment", "commentId", comment.Id, "error", err)
+	}
+}
+
+// notifySubscribers emails the subscribers about moderated comments, except authors the admin notified already
+func (controller *Controller) notifySubscribers(comments []domain.Comment, newStatus domain.CommentStatus, reason string, authorNotified bool) {
+	if newStatus != domain.CommentStatusApproved && (newStatus != domain.CommentStatusRejected || authorNotified) {
+		return
+	}
+	for _, comment := range comments {
+		err := controller.notifyModerationSubscriber(comment, newStatus, reason)
+		if err != nil {
+			logger.Error("Failed to notify the author of a moderated comment", "commentId", comment.Id, "error", err)
+		}
+	}
+	if newStatus == domain.CommentStatusApproved {
+		controller.notifyPostSubscribers(comments)
+	}
+}
+
+func (controller *Controller) notifyModerationSubscriber(comment domain.Comment, newStatus domain.CommentStatus, reason string) error {
+	subscription, err := controller.Store.FindModerationSubscription(comment.Id)
+	if errors.Is(err, lang.ErrNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	user, err := controller.Store.FindUserById(comment.UserId)
+	if err != nil {
+		return err
+	}
+	return controller.EmailSender.SendNotification(email.CommentModeratedEmail{
+		EmailAddress: user.Email,
+		UserId:       user.Id,
+		ParentUrl:    comment.ParentUrl,
+		Comment:      comment.Comment,
+		Approved:     newStatus == domain.CommentStatusApproved,
+		Reason:       reason,
+		Unsubscribe:  controller.unsubscribe(subscription),
+	})
+}
+
+// notifyPostSubscribers tells everyone who subscribed to the posts of the approved comments about them, except their
+// authors. A subscriber gets one email per post with all of its comments that were approved at once.
+func (controller *Controller) notifyPostSubscribers(comments []domain.Comment) {
+	type post struct {
+		serviceId int
+		postKey   string
+	}
+	posts := make([]post, 0)
+	commentsByPost := make(map[post][]domain.Comment)
+	for _, comment := range comments {
+		key := post{comment.ServiceId, comment.PostKey}
+		if _, ok := commentsByPost[key]; !ok {
+			posts = append(posts, key)
+		}
+		commentsByPost[key] = append(commentsByPost[key], comment)
+	}
+	for _, key := range posts {
+		subscriptions, err := controller.Store.GetPostSubscriptions(key.serviceId, key.postKey)
+		if err != nil {
+			logger.Error("Failed to read the subscriptions of a post", "serviceId", key.serviceId, "postKey", key.postKey, "error", err)
+			continue
+		}
+		for _, subscription := range subscriptions {
+			newCommentEmail := email.NewCommentEmail{PostKey: key.postKey, Comments: []email.NewComment{}, Unsubscribe: controller.unsubscribe(subscription)}
+			for _, comment := range commentsByPost[key] {
+				if comment.UserId == subscription.UserId {
+					continue
+				}
+				newCommentEmail.Comments = append(newCommentEmail.Comments, email.NewComment{Name: comment.Name, Comment: comment.Comment})
+				if newCommentEmail.ParentUrl == "" {
+					newCommentEmail.ParentUrl = comment.ParentUrl
+				}
+			}
+			if len(newCommentEmail.Comments) == 0 {
+				continue
+			}
+			user, err := controller.Store.FindUserById(subscription.UserId)
+			if err == nil {
+				newCommentEmail.EmailAddress = user.Email
+				err = controller.EmailSender.SendNotification(newCommentEmail)
+			}
+			if err != nil {
+				logger.Error("Failed to notify a subscriber of a post", "subscriptionId", subscription.Id, "error", err)
+			}
+		}
+	}
+}
+
+func (controller *Controller) unsubscribe(subscription domain.Subscription) email.Unsubscribe {
+	return email.Unsubscribe{SubscriptionId: subscription.Id, Token: controller.Store.UnsubscribeToken(subscription.Id)}
+}
+
+// unsubscribePath is the signed link in subscription emails. Following it shows a confirmation page, the subscription
+// is only cancelled with a POST, which mail clients also send for one click unsubscribing (RFC 8058).
+const unsubscribePath = "/subscriptions/:subscriptionId/unsubscribe"
+
+// requireUnsubscribeLink returns the id of the subscription of a correctly signed unsubscribe link, 0 when it has
+// rendered an error page instead
+func (controller *Controller) requireUnsubscribeLink(c echo.Context) (int, error) {
+	subscriptionId, err := strconv.Atoi(c.Param("subscriptionId"))
+	if err != nil {
+		return 0, renderBadRequest(c)
+	}
+	if !controller.Store.VerifyUnsubscribeToken(subscriptionId, c.QueryParam("token")) {
+		return 0, renderUnauthorized(c)
+	}
+	return subscriptionId, nil
+}
+
+// ConfirmUnsubscribe asks for confirmation before cancelling a subscription, so that link scanners that follow the
+// link in an email do not cancel it
+func (controller *Controller) ConfirmUnsubscribe(c echo.Context) error {
+	subscriptionId, err := controller.requireUnsubscribeLink(c)
+	if err != nil || subscriptionId == 0 {
+		return err
+	}
+	subscription, err := controller.Store.GetSubscription(subscriptionId)
+	if err != nil && !errors.Is(err, lang.ErrNotFound) {
+		return sendInternalError(c, err)
+	}
+	return c.Render(http.StatusOK, "unsubscribe", domain.UnsubscribePage{
+		BasePage: domain.BasePage{
+			Stylesheets: templateStylesheets,
+			Scripts:     templateScripts,
+		},
+		Subscription: subscription,
+		Token:        c.QueryParam("token"),
+	})
+}
+
+// Unsubscribe cancels a subscription with the signed link from an email. Unsubscribing again after the
+// subscription was cancelled shows the same page.
+func (controller *Controller) Unsubscribe(c echo.Context) error {
+	subscriptionId, err := controller.requireUnsubscribeLink(c)
+	if err != nil || subscriptionId == 0 {
+		return err
+	}
+	subscription, err := controller.Store.GetSubscription(subscriptionId)
+	if err == nil {
+		err = controller.Store.DeleteSubscription(subscriptionId)
+	}
+	if err != nil && !errors.Is(err, lang.ErrNotFound) {
+		return sendInternalError(c, err)
+	}
+	return c.Render(http.StatusOK, "unsubscribed", domain.UnsubscribedPage{
+		BasePage: domain.BasePage{
+			Stylesheets: templateStylesheets,
+			Scripts:     templateScripts,
+		},
+		Subscription: subscription,
+	})
+}
+
+// DeleteUserSubscription cancels a subscription from the comment overview page of its user
+func (controller *Controller) DeleteUserSubscription(c echo.Context) error {
+	user, err := controller.requireUserFromPath(c)
+	if err != nil || !user.IsValid() {
+		return err
+	}
+	subscriptionId, err := strconv.Atoi(c.Param("subscriptionId"))
+	if err != nil {
+		return renderBadRequest(c)
+	}
+	subscription, err := controller.Store.GetSubscription(subscriptionId)
+	if err != nil {
+		return handleCommonErrors(c, err)
+	}
+	if subscription.UserId != user.Id {
+		return renderUnauthorized(c)
+	}
+	err = controller.Store.DeleteSubscription(subscriptionId)
+	if err != nil {
+		return handleCommonErrors(c, err)
+	}
+	return c.Redirect(http.StatusFound, "/users/"+strconv.Itoa(user.Id)+"/comments/")
+}
@@ -0,0 +1,265 @@
+package server
+
+import (
+	"aggregat4/go-commentservice/internal/domain"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscriptionsAreActivatedWhenTheCommentIsConfirmed(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	formParams := url.Values{
+		"email":               {TEST_USER_AUTHTOKEN_VALID},
+		"name":                {"Jane"},
+		"comment":             {"A comment with subscriptions"},
+		"subscribeModeration": {"true"},
+		"subscribePost":       {"true"},
+	}
+	res := postComment(t, client, formParams, TEST_POSTKEY2)
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	user, err := controller.Store.FindUserByEmail(TEST_USER_AUTHTOKEN_VALID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subscriptions, err := controller.Store.GetSubscriptionsForUser(user.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(subscriptions))
+	for _, subscription := range subscriptions {
+		assert.False(t, subscription.Active, "Subscriptions of unconfirmed comments are not active")
+	}
+
+	authenticateAndValidate(t, client, controller, TEST_USER_AUTHTOKEN_VALID, TEST_AUTHTOKEN_VALID)
+	comment := findUserComment(t, controller, user, "A comment with subscriptions")
+	res = confirmComment(t, client, user.Id, comment.Id)
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	subscriptions, err = controller.Store.GetSubscriptionsForUser(user.Id)
+	assert.Nil(t, err)
+	for _, subscription := range subscriptions {
+		assert.True(t, subscription.Active)
+	}
+
+	// the subscriptions are listed on the comments page and in the export
+	res, err = client.Get(createServerUrl(serverConfig.Port, "/users/"+strconv.Itoa(user.Id)+"/comments/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := readBody(res)
+	assert.Contains(t, body, "New comments on "+TEST_POSTKEY2)
+	assert.Contains(t, body, "/users/"+strconv.Itoa(user.Id)+"/subscriptions/"+strconv.Itoa(subscriptions[0].Id)+"/delete")
+	res, err = client.Get(createServerUrl(serverConfig.Port, "/users/"+strconv.Itoa(user.Id)+"/comments/?format=json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var export domain.UserDataExport
+	assert.Nil(t, json.Unmarshal([]byte(readBody(res)), &export))
+	assert.Equal(t, 2, len(export.Subscriptions))
+}
+
+func TestSubscribersAreNotifiedAboutModeration(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	author := authenticateAndValidate(t, client, controller, TEST_USER_AUTHTOKEN_VALID, TEST_AUTHTOKEN_VALID)
+	formParams := url.Values{
+		"email":               {TEST_USER_AUTHTOKEN_VALID},
+		"comment":             {"Please tell me when this is approved"},
+		"subscribeModeration": {"true"},
+		"subscribePost":       {"true"},
+	}
+	res := postComment(t, client, formParams, TEST_POSTKEY2)
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	comment := findUserComment(t, controller, author, "Please tell me when this is approved")
+	subscriberId, err := controller.Store.CreateUserByEmail("subscriber@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, controller.Store.CreateSubscription(subscriberId, domain.SubscriptionPost, comment.ServiceId, TEST_POSTKEY2, 0, true))
+	inactiveId, err := controller.Store.CreateUserByEmail("unconfirmed@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, controller.Store.CreateSubscription(inactiveId, domain.SubscriptionPost, comment.ServiceId, TEST_POSTKEY2, 0, false))
+
+	adminClient := createTestHttpClient(false)
+	loginAdmin(t, adminClient, domain.DefaultServiceAdminRole)
+	res = adminModerateComment(t, adminClient, comment.Id, "approve", url.Values{})
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+
+	moderated := outboxEmailsOfKind(t, controller, "comment-moderated")
+	assert.Equal(t, 1, len(moderated))
+	assert.Equal(t, TEST_USER_AUTHTOKEN_VALID, moderated[0].Recipient)
+	assert.Contains(t, moderated[0].Payload, `"Approved":true`)
+	newComments := outboxEmailsOfKind(t, controller, "new-comment")
+	assert.Equal(t, 1, len(newComments), "Neither the author nor unconfirmed subscribers are notified")
+	assert.Equal(t, "subscriber@example.com", newComments[0].Recipient)
+	assert.Contains(t, newComments[0].Payload, "Please tell me when this is approved")
+
+	// the author is not notified twice when the admin notifies them of a rejection
+	res = adminModerateComment(t, adminClient, comment.Id, "unapprove", url.Values{})
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	res = adminModerateComment(t, adminClient, comment.Id, "reject", url.Values{"reason": {"Off topic"}, "notifyAuthor": {"true"}})
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	assert.Equal(t, 1, len(outboxEmailsOfKind(t, controller, "comment-moderated")))
+	assert.Equal(t, 1, len(outboxEmailsOfKind(t, controller, "comment-rejected")))
+	res = adminModerateComment(t, adminClient, comment.Id, "unreject", url.Values{})
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	res = adminModerateComment(t, adminClient, comment.Id, "reject", url.Values{"reason": {"Off topic"}})
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	moderated = outboxEmailsOfKind(t, controller, "comment-moderated")
+	assert.Equal(t, 2, len(moderated))
+}
+
+func TestUnsubscribeWithSignedLink(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	userId, err := controller.Store.CreateUserByEmail("subscriber@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, err := controller.Store.GetServiceForKey(TEST_SERVICE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, controller.Store.CreateSubscription(userId, domain.SubscriptionPost, service.Id, TEST_POSTKEY1, 0, true))
+	subscriptions, err := controller.Store.GetSubscriptionsForUser(userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subscriptionId := strconv.Itoa(subscriptions[0].Id)
+	client := createTestHttpClient(false)
+
+	res, err := client.Get(createServerUrl(serverConfig.Port, "/subscriptions/"+subscriptionId+"/unsubscribe?token=0123"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	subscriptions, err = controller.Store.GetSubscriptionsForUser(userId)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(subscriptions))
+
+	// following the link only asks for confirmation
+	unsubscribeUrl := createServerUrl(serverConfig.Port, "/subscriptions/"+subscriptionId+"/unsubscribe?token="+controller.Store.UnsubscribeToken(subscriptions[0].Id))
+	res, err = client.Get(unsubscribeUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	body := readBody(res)
+	assert.Contains(t, body, "Do you no longer want to get emails about new comments on "+TEST_POSTKEY1+"?")
+	assert.Contains(t, body, `method="POST"`)
+	subscriptions, err = controller.Store.GetSubscriptionsForUser(userId)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(subscriptions))
+
+	// mail clients unsubscribe with one click without an Origin of this server
+	res, err = client.Post(unsubscribeUrl, "application/x-www-form-urlencoded", strings.NewReader("List-Unsubscribe=One-Click"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Contains(t, readBody(res), "You will no longer get emails about new comments on "+TEST_POSTKEY1)
+	subscriptions, err = controller.Store.GetSubscriptionsForUser(userId)
+	assert.Nil(t, err)
+	assert.Empty(t, subscriptions)
+	res, err = client.Post(unsubscribeUrl, "application/x-www-form-urlencoded", strings.NewReader("List-Unsubscribe=One-Click"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Contains(t, readBody(res), "You have already been unsubscribed.")
+	res, err = client.Get(unsubscribeUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(t, readBody(res), "You have already been unsubscribed.")
+
+	res, err = client.Post(createServerUrl(serverConfig.Port, "/subscriptions/"+subscriptionId+"/unsubscribe?token=0123"), "application/x-www-form-urlencoded", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+}
+
+func TestSubscribersGetOneEmailForBulkApprovedComments(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	service, err := controller.Store.GetServiceForKey(TEST_SERVICE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subscriberId, err := controller.Store.CreateUserByEmail("subscriber@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, controller.Store.CreateSubscription(subscriberId, domain.SubscriptionPost, service.Id, TEST_POSTKEY2, 0, true))
+	client := createTestHttpClient(false)
+	author := authenticateAndValidate(t, client, controller, TEST_USER_AUTHTOKEN_VALID, TEST_AUTHTOKEN_VALID)
+	commentIds := make([]int, 0)
+	for _, content := range []string{"The first new comment", "The second new comment"} {
+		res := postComment(t, client, url.Values{"email": {TEST_USER_AUTHTOKEN_VALID}, "comment": {content}}, TEST_POSTKEY2)
+		assert.Equal(t, http.StatusFound, res.StatusCode)
+		commentIds = append(commentIds, findUserComment(t, controller, author, content).Id)
+	}
+
+	adminClient := createTestHttpClient(false)
+	loginAdmin(t, adminClient, domain.DefaultServiceAdminRole)
+	res := adminBulkModerateComments(t, adminClient, "approve", commentIds, url.Values{})
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	newComments := outboxEmailsOfKind(t, controller, "new-comment")
+	assert.Equal(t, 1, len(newComments))
+	assert.Equal(t, "subscriber@example.com", newComments[0].Recipient)
+	assert.Contains(t, newComments[0].Payload, "The first new comment")
+	assert.Contains(t, newComments[0].Payload, "The second new comment")
+}
+
+func TestDeleteUserSubscription(t *testing.T) {
+	echoServer, controller := waitForServer(t)
+	defer echoServer.Close()
+	defer controller.Store.Close()
+	client := createTestHttpClient(false)
+	user := authenticateAndValidate(t, client, controller, TEST_USER_AUTHTOKEN_VALID, TEST_AUTHTOKEN_VALID)
+	otherUserId, err := controller.Store.CreateUserByEmail("other@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, err := controller.Store.GetServiceForKey(TEST_SERVICE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, controller.Store.CreateSubscription(user.Id, domain.SubscriptionPost, service.Id, TEST_POSTKEY1, 0, true))
+	assert.Nil(t, controller.Store.CreateSubscription(otherUserId, domain.SubscriptionPost, service.Id, TEST_POSTKEY1, 0, true))
+	own, err := controller.Store.GetSubscriptionsForUser(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := controller.Store.GetSubscriptionsForUser(otherUserId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deleteUrl := func(subscriptionId int) string {
+		return createServerUrl(serverConfig.Port, "/users/"+strconv.Itoa(user.Id)+"/subscriptions/"+strconv.Itoa(subscriptionId)+"/delete")
+	}
+	res := postWithOrigin(t, client, deleteUrl(other[0].Id), "application/x-www-form-urlencoded", nil)
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	res = postWithOrigin(t, client, deleteUrl(own[0].Id), "application/x-www-form-urlencoded", nil)
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	own, err = controller.Store.GetSubscriptionsForUser(user.Id)
+	assert.Nil(t, err)
+	assert.Empty(t, own)
+	other, err = controller.Store.GetSubscriptionsForUser(otherUserId)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(other))
+}
@@ -4,11 +4,11 @@ import (
 	"aggregat4/go-commentservice/internal/domain"
 	"aggregat4/go-commentservice/internal/email"
 	"aggregat4/go-commentservice/internal/repository"
+	"aggregat4/go-commentservice/internal/spam"
 	"strconv"
 	"testing"
 	"time"
 
-	"github.com/aggregat4/go-baselib/crypto"
 	"github.com/labstack/echo/v4"
 )
 
@@ -37,6 +37,9 @@ var TEST_WEBSITE1 = "http://example.com"
 
 var TEST_COMMENTS []domain.Comment
 
+// testAdminActor is recorded in the moderation log for changes that tests make directly in the store
+var testAdminActor = domain.Actor{Kind: domain.ActorKindAdmin, Id: "test-admin"}
+
 var serverConfig = domain.Config{
 	Port:                      8080,
 	DatabaseFilename:          "",
@@ -49,6 +52,18 @@ var serverConfig = domain.Config{
 	EncryptionKey:             "testencryptionkey",
 	SessionCookieSecretKey:    "testsessioncookiesecretkey",
 	SessionCookieSecureFlag:   false,
+	OidcRolesClaim:            "roles",
+	SuperadminRole:            "superadmin",
+}
+
+// testSpamOptions do not check the time to submit the comment form, since the tests post comments without showing the
+// form first
+var testSpamOptions = spam.Options{
+	RejectScore:          1,
+	MaxLinks:             3,
+	BlockedWords:         []string{"casino"},
+	BlockedDomains:       []string{"spam.example"},
+	BayesMinimumTraining: 2,
 }
 
 func findCommentByContent(comments []domain.Comment, content string) domain.Comment {
@@ -61,12 +76,12 @@ func findCommentByContent(comments []domain.Comment, content string) domain.Comm
 }
 
 func waitForServer(t *testing.T) (*echo.Echo, Controller) {
-	aesCipher, err := crypto.CreateAes256GcmAead([]byte(TEST_ENCRYPTIONKEY))
+	keys, err := repository.NewKeyring(1, []byte(TEST_ENCRYPTIONKEY))
 	if err != nil {
 		panic(err)
 	}
 	var store = repository.Store{
-		Cipher: aesCipher,
+		Keys: keys,
 	}
 	err = store.InitAndVerifyDb(repository.CreateInMemoryDbUrl())
 	if err != nil {
@@ -74,7 +89,9 @@ func waitForServer(t *testing.T) (*echo.Echo, Controller) {
 	}
 	createTestData(t, store)
 	mockEmailSender := email.NewMockEmailSender()
-	controller := Controller{&store, serverConfig, email.NewEmailSender(mockEmailSender.MockEmailSenderStrategy)}
+	emailSender := email.NewEmailSender(&store, mockEmailSender.MockEmailSenderStrategy, email.DefaultRetryPolicy, email.DefaultRateLimits)
+	t.Cleanup(emailSender.Close)
+	controller := Controller{&store, serverConfig, emailSender, spam.NewPipeline(testSpamOptions, &store)}
 	echoServer := InitServerWithOidcMiddleware(controller, createMockOidcMiddleware(), createMockOidcCallback())
 	go func() {
 		_ = echoServer.Start(":" + strconv.Itoa(serverConfig.Port))
@@ -84,7 +101,7 @@ func waitForServer(t *testing.T) (*echo.Echo, Controller) {
 }
 
 func createTestData(t *testing.T, store repository.Store) {
-	serviceId, err := store.CreateService(TEST_SERVICE, "example.com")
+	serviceId, err := store.CreateService(TEST_SERVICE, []string{"example.com"}, domain.DefaultServiceAdminRole)
 	if err != nil {
 		t.Fatal("Error creating test service: " + err.Error())
 	}
@@ -151,7 +168,7 @@ func createTestData(t *testing.T, store repository.Store) {
 	}
 
 	for _, c := range comments {
-		commentId, err := store.CreateComment(c.status, serviceId, TEST_SERVICE, testUserValidTokenId, TEST_POSTKEY1, c.comment, TEST_AUTHOR1, TEST_WEBSITE1, "https://example.com")
+		commentId, err := store.CreateComment(c.status, serviceId, TEST_SERVICE, testUserValidTokenId, TEST_POSTKEY1, c.comment, TEST_AUTHOR1, TEST_WEBSITE1, "https://example.com", 0, 0)
 		if err != nil {
 			t.Fatal("Error creating test comment: " + err.Error())
 		}
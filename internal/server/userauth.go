@@ -29,7 +29,8 @@ func getAdminUserIdFromSession(c echo.Context) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	if sess.Values["adminuserid"] != nil {
+	// sessions from before roles were stored in the session have to log in again
+	if sess.Values["adminuserid"] != nil && sess.Values["adminroles"] != nil {
 		return sess.Values["adminuserid"].(string), nil
 	} else {
 		return "", lang.ErrNotFound
@@ -45,12 +46,23 @@ func createUserSessionCookie(c echo.Context, userId int) error {
 	return sess.Save(c.Request(), c.Response())
 }
 
-func createAdminSessionCookie(c echo.Context, adminUserId string) error {
+// clearUserSessionCookie logs the user out, the session no longer refers to any user afterwards
+func clearUserSessionCookie(c echo.Context) error {
+	sess, err := session.Get(authenticatedUserCookieName, c)
+	if err != nil {
+		return err
+	}
+	delete(sess.Values, "userid")
+	return sess.Save(c.Request(), c.Response())
+}
+
+func createAdminSessionCookie(c echo.Context, adminUserId string, roles []string) error {
 	sess, err := session.Get(authenticatedUserCookieName, c)
 	if err != nil {
 		return err
 	}
 	sess.Values["adminuserid"] = adminUserId
+	sess.Values["adminroles"] = roles
 	err = sess.Save(c.Request(), c.Response())
 	if err != nil {
 		return sendInternalError(c, err)
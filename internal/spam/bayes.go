@@ -0,0 +1,114 @@
+package spam
+
+import (
+	"aggregat4/go-commentservice/internal/domain"
+	"cmp"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// TokenStore keeps the statistics of the Bayesian classifier, it is implemented by repository.Store. Implementations
+// must not store the tokens in plain text.
+type TokenStore interface {
+	// GetSpamTokenCounts returns the counts of the known tokens and the number of comments learned as spam and ham
+	GetSpamTokenCounts(tokens []string) (map[string]domain.SpamTokenCounts, domain.SpamTokenCounts, error)
+	// LearnSpamTokens counts the tokens of a comment as spam or ham. A comment is only counted once, when it was
+	// already learned the other way it is moved over.
+	LearnSpamTokens(commentId int, tokens []string, spam bool) error
+}
+
+const (
+	// maxClassifiedTokens limits how many tokens of a comment are classified and learned
+	maxClassifiedTokens = 500
+	// interestingTokens is how many of the tokens that are furthest from neutral decide the score
+	interestingTokens = 15
+	// tokenStrength is how many comments the assumed probability of 0.5 for unknown tokens is worth
+	tokenStrength = 1.0
+	// maxBayesScore keeps the classifier from rejecting comments on its own, it only informs the admins
+	maxBayesScore = 0.99
+)
+
+// BayesianFilter is a naive Bayesian classifier that learns from the comments that admins approve and reject. It is
+// not used until it has learned at least MinimumTraining comments of each kind.
+type BayesianFilter struct {
+	Store           TokenStore
+	MinimumTraining int
+}
+
+func (f BayesianFilter) Name() string {
+	return "bayesian classifier"
+}
+
+var words = regexp.MustCompile(`[\p{L}\p{N}][\p{L}\p{N}'_-]*`)
+
+// classifierTokens splits the submission into the tokens of the classifier, every token is only returned once. The
+// name and website of the author are left out so that the statistics contain no data about authors.
+func classifierTokens(submission Submission) []string {
+	tokens := make([]string, 0)
+	seen := make(map[string]bool)
+	add := func(token string) {
+		if len(tokens) < maxClassifiedTokens && !seen[token] {
+			seen[token] = true
+			tokens = append(tokens, token)
+		}
+	}
+	for _, word := range words.FindAllString(strings.ToLower(submission.Comment), -1) {
+		if length := utf8.RuneCountInString(word); length >= 2 && length <= 40 {
+			add("word:" + word)
+		}
+	}
+	for _, host := range commentLinkHosts(submission.Comment) {
+		add("host:" + host)
+	}
+	return tokens
+}
+
+func (f BayesianFilter) Check(submission Submission) (Result, error) {
+	tokens := classifierTokens(submission)
+	counts, totals, err := f.Store.GetSpamTokenCounts(tokens)
+	if err != nil {
+		return Result{}, err
+	}
+	minimumTraining := max(f.MinimumTraining, 1)
+	if totals.Spam < minimumTraining || totals.Ham < minimumTraining {
+		return NotSpam, nil
+	}
+	// the probability that a comment with the token is spam as proposed by Gary Robinson, tokens that occurred in
+	// few comments stay close to neutral
+	probabilities := make([]float64, 0, len(counts))
+	for _, tokenCounts := range counts {
+		occurrences := float64(tokenCounts.Spam + tokenCounts.Ham)
+		if occurrences == 0 {
+			continue
+		}
+		spamFrequency := float64(tokenCounts.Spam) / float64(totals.Spam)
+		hamFrequency := float64(tokenCounts.Ham) / float64(totals.Ham)
+		probability := spamFrequency / (spamFrequency + hamFrequency)
+		probabilities = append(probabilities, (tokenStrength*0.5+occurrences*probability)/(tokenStrength+occurrences))
+	}
+	if len(probabilities) == 0 {
+		return NotSpam, nil
+	}
+	slices.SortFunc(probabilities, func(a, b float64) int {
+		return cmp.Compare(math.Abs(b-0.5), math.Abs(a-0.5))
+	})
+	probabilities = probabilities[:min(len(probabilities), interestingTokens)]
+	// combine the probabilities in log space to avoid underflows
+	eta := 0.0
+	for _, probability := range probabilities {
+		eta += math.Log(1-probability) - math.Log(probability)
+	}
+	score := 1 / (1 + math.Exp(eta))
+	if score <= 0.5 {
+		return NotSpam, nil
+	}
+	return Result{Score: min(score, maxBayesScore), Reason: fmt.Sprintf("resembles rejected comments (%.0f%%)", score*100)}, nil
+}
+
+func (f BayesianFilter) Learn(commentId int, submission Submission, spam bool) error {
+	return f.Store.LearnSpamTokens(commentId, classifierTokens(submission), spam)
+}
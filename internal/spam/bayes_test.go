@@ -0,0 +1,88 @@
+package spam
+
+import (
+	"aggregat4/go-commentservice/internal/domain"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryTokenStore keeps the statistics of the classifier like repository.Store, but in memory
+type memoryTokenStore struct {
+	counts  map[string]domain.SpamTokenCounts
+	totals  domain.SpamTokenCounts
+	learned map[int]bool
+}
+
+func newMemoryTokenStore() *memoryTokenStore {
+	return &memoryTokenStore{counts: make(map[string]domain.SpamTokenCounts), learned: make(map[int]bool)}
+}
+
+func (store *memoryTokenStore) GetSpamTokenCounts(tokens []string) (map[string]domain.SpamTokenCounts, domain.SpamTokenCounts, error) {
+	counts := make(map[string]domain.SpamTokenCounts)
+	for _, token := range tokens {
+		if tokenCounts, found := store.counts[token]; found {
+			counts[token] = tokenCounts
+		}
+	}
+	return counts, store.totals, nil
+}
+
+func (store *memoryTokenStore) LearnSpamTokens(commentId int, tokens []string, spam bool) error {
+	if _, found := store.learned[commentId]; found {
+		panic("the tests learn every comment only once")
+	}
+	store.learned[commentId] = spam
+	for _, token := range tokens {
+		tokenCounts := store.counts[token]
+		if spam {
+			tokenCounts.Spam++
+		} else {
+			tokenCounts.Ham++
+		}
+		store.counts[token] = tokenCounts
+	}
+	if spam {
+		store.totals.Spam++
+	} else {
+		store.totals.Ham++
+	}
+	return nil
+}
+
+func TestClassifierTokensAreUniqueAndLeaveOutTheAuthor(t *testing.T) {
+	tokens := classifierTokens(Submission{
+		Comment: "Hello hello world, see https://example.com",
+		Name:    "World",
+		Website: "https://author.example",
+	})
+	assert.Equal(t, []string{"word:hello", "word:world", "word:see", "word:https", "word:example", "word:com", "host:example.com"}, tokens)
+}
+
+func TestBayesianFilterLearnsFromModeration(t *testing.T) {
+	filter := BayesianFilter{Store: newMemoryTokenStore(), MinimumTraining: 2}
+	spamComments := []string{
+		"Cheap replica watches at https://watches.example",
+		"Best replica watches, cheap prices",
+		"Buy cheap watches now at https://watches.example",
+	}
+	hamComments := []string{
+		"Thanks for the article, the part about goroutines helped me",
+		"I think the benchmark in the second part is misleading",
+		"Great article, looking forward to the next part",
+	}
+	assert.Nil(t, filter.Learn(1, Submission{Comment: spamComments[0]}, true))
+	assert.Nil(t, filter.Learn(2, Submission{Comment: hamComments[0]}, false))
+	assert.Equal(t, NotSpam, checkFilter(t, filter, Submission{Comment: spamComments[1]}), "The classifier needs more training")
+
+	for i := 1; i < len(spamComments); i++ {
+		assert.Nil(t, filter.Learn(10+i, Submission{Comment: spamComments[i]}, true))
+		assert.Nil(t, filter.Learn(20+i, Submission{Comment: hamComments[i]}, false))
+	}
+	result := checkFilter(t, filter, Submission{Comment: "Cheap watches for sale at https://watches.example"})
+	assert.Greater(t, result.Score, 0.9)
+	assert.LessOrEqual(t, result.Score, maxBayesScore)
+	assert.Contains(t, result.Reason, "resembles rejected comments")
+	assert.Equal(t, NotSpam, checkFilter(t, filter, Submission{Comment: "Thanks, the second part of the article helped me"}))
+	assert.Equal(t, NotSpam, checkFilter(t, filter, Submission{Comment: "Completely unknown words"}))
+}
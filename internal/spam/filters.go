@@ -0,0 +1,197 @@
+package spam
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HoneypotFilter recognizes bots by a form field that is hidden from humans
+type HoneypotFilter struct{}
+
+func (f HoneypotFilter) Name() string {
+	return "honeypot"
+}
+
+func (f HoneypotFilter) Check(submission Submission) (Result, error) {
+	if submission.Form != nil && submission.Form.Honeypot != "" {
+		return Result{Score: 1, Reason: "the hidden honeypot field was filled in"}, nil
+	}
+	return NotSpam, nil
+}
+
+// FormTokens are signed timestamps that are put into the comment form when it is shown. They tell how long it took
+// to submit the form without having to keep state on the server.
+type FormTokens struct {
+	key        []byte
+	minimumAge time.Duration
+	maximumAge time.Duration
+}
+
+// NewFormTokens derives the signing key from the secret so that the secret can be shared with other uses. Tokens
+// older than maximumAge are not accepted, 0 accepts tokens of any age.
+func NewFormTokens(secret []byte, minimumAge time.Duration, maximumAge time.Duration) FormTokens {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("go-commentservice spam form token"))
+	return FormTokens{key: mac.Sum(nil), minimumAge: minimumAge, maximumAge: maximumAge}
+}
+
+func (tokens FormTokens) IsValid() bool {
+	return len(tokens.key) > 0
+}
+
+func (tokens FormTokens) sign(timestamp string) string {
+	mac := hmac.New(sha256.New, tokens.key)
+	mac.Write([]byte(timestamp))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Issue returns the token for a form shown at the given time
+func (tokens FormTokens) Issue(now time.Time) string {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	return timestamp + "." + tokens.sign(timestamp)
+}
+
+// Check verifies the token of a form that was submitted at the given time
+func (tokens FormTokens) Check(token string, submittedAt time.Time) Result {
+	timestamp, signature, found := strings.Cut(token, ".")
+	if !found || !hmac.Equal([]byte(signature), []byte(tokens.sign(timestamp))) {
+		return Result{Score: 1, Reason: "the form token is missing or invalid"}
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return Result{Score: 1, Reason: "the form token is missing or invalid"}
+	}
+	age := submittedAt.Sub(time.Unix(seconds, 0))
+	if age < tokens.minimumAge {
+		return Result{Score: 1, Reason: fmt.Sprintf("the form was submitted after %s", age.Round(time.Second))}
+	}
+	if tokens.maximumAge > 0 && age > tokens.maximumAge {
+		// a human may well have left the form open, but the token could also have been harvested
+		return Result{Score: 0.5, Reason: fmt.Sprintf("the form was submitted after %s", age.Round(time.Minute))}
+	}
+	return NotSpam
+}
+
+// MinimumTimeFilter recognizes bots that submit the comment form faster than a human could write a comment. Comments
+// submitted with the API have no form token and are not checked.
+type MinimumTimeFilter struct {
+	Tokens FormTokens
+}
+
+func (f MinimumTimeFilter) Name() string {
+	return "minimum time"
+}
+
+func (f MinimumTimeFilter) Check(submission Submission) (Result, error) {
+	if submission.Form == nil {
+		return NotSpam, nil
+	}
+	return f.Tokens.Check(submission.Form.FormToken, submission.SubmittedAt), nil
+}
+
+var links = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"']+`)
+
+// hostOf returns the lower case host name of the link, links without a scheme are assumed to be http links
+func hostOf(link string) string {
+	link = strings.TrimSpace(link)
+	if !strings.Contains(link, "://") {
+		link = "http://" + link
+	}
+	parsedUrl, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(parsedUrl.Hostname())
+}
+
+// linkHosts returns the host names of all links in the comment and of the website of the submission
+func linkHosts(submission Submission) []string {
+	hosts := commentLinkHosts(submission.Comment)
+	if host := hostOf(submission.Website); submission.Website != "" && host != "" {
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
+
+// commentLinkHosts returns the hosts of the links in the text of a comment
+func commentLinkHosts(comment string) []string {
+	hosts := make([]string, 0)
+	for _, link := range links.FindAllString(comment, -1) {
+		if host := hostOf(link); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// LinkLimitFilter rates comments with more links than MaxLinks as likely spam
+type LinkLimitFilter struct {
+	MaxLinks int
+}
+
+func (f LinkLimitFilter) Name() string {
+	return "link limit"
+}
+
+func (f LinkLimitFilter) Check(submission Submission) (Result, error) {
+	count := len(links.FindAllString(submission.Comment, -1))
+	if count > f.MaxLinks {
+		return Result{Score: 0.8, Reason: fmt.Sprintf("the comment contains %d links, at most %d are expected", count, f.MaxLinks)}, nil
+	}
+	return NotSpam, nil
+}
+
+// BlocklistFilter rates comments that contain one of the blocked words or link to one of the blocked domains, or
+// their subdomains, as likely spam
+type BlocklistFilter struct {
+	words   *regexp.Regexp
+	domains []string
+}
+
+func NewBlocklistFilter(words []string, domains []string) BlocklistFilter {
+	filter := BlocklistFilter{domains: make([]string, 0, len(domains))}
+	quotedWords := make([]string, 0, len(words))
+	for _, word := range words {
+		if word = strings.TrimSpace(word); word != "" {
+			quotedWords = append(quotedWords, regexp.QuoteMeta(word))
+		}
+	}
+	if len(quotedWords) > 0 {
+		filter.words = regexp.MustCompile(`(?i)\b(?:` + strings.Join(quotedWords, "|") + `)\b`)
+	}
+	for _, domain := range domains {
+		if domain = strings.Trim(strings.ToLower(strings.TrimSpace(domain)), "."); domain != "" {
+			filter.domains = append(filter.domains, domain)
+		}
+	}
+	return filter
+}
+
+func (f BlocklistFilter) Name() string {
+	return "blocklist"
+}
+
+func (f BlocklistFilter) Check(submission Submission) (Result, error) {
+	if f.words != nil {
+		for _, text := range []string{submission.Comment, submission.Name} {
+			if word := f.words.FindString(text); word != "" {
+				return Result{Score: 0.9, Reason: fmt.Sprintf("contains the blocked word %q", strings.ToLower(word))}, nil
+			}
+		}
+	}
+	for _, host := range linkHosts(submission) {
+		for _, domain := range f.domains {
+			if host == domain || strings.HasSuffix(host, "."+domain) {
+				return Result{Score: 0.9, Reason: fmt.Sprintf("links to the blocked domain %s", domain)}, nil
+			}
+		}
+	}
+	return NotSpam, nil
+}
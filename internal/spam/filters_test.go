@@ -0,0 +1,63 @@
+package spam
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testNow = time.Unix(1_700_000_000, 0)
+
+func checkFilter(t *testing.T, filter SpamFilter, submission Submission) Result {
+	result, err := filter.Check(submission)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestHoneypotOnlyChecksFormSubmissions(t *testing.T) {
+	filter := HoneypotFilter{}
+	assert.Equal(t, NotSpam, checkFilter(t, filter, Submission{Comment: "Hello", Form: &FormFields{}}))
+	assert.Equal(t, 1.0, checkFilter(t, filter, Submission{Comment: "Hello", Form: &FormFields{Honeypot: "https://spam.example"}}).Score)
+	assert.Equal(t, NotSpam, checkFilter(t, filter, Submission{Comment: "Hello"}))
+}
+
+func TestFormTokens(t *testing.T) {
+	tokens := NewFormTokens([]byte("secret"), 3*time.Second, 24*time.Hour)
+	token := tokens.Issue(testNow)
+
+	assert.Equal(t, NotSpam, tokens.Check(token, testNow.Add(time.Minute)))
+	assert.Equal(t, 1.0, tokens.Check(token, testNow.Add(time.Second)).Score, "Submitted faster than a human can")
+	assert.Equal(t, 0.5, tokens.Check(token, testNow.Add(25*time.Hour)).Score, "The form was open for too long")
+	assert.Equal(t, 1.0, tokens.Check("", testNow.Add(time.Minute)).Score)
+	assert.Equal(t, 1.0, tokens.Check("1699999000."+tokens.sign("1700000000"), testNow.Add(time.Minute)).Score, "The timestamp was changed")
+	otherTokens := NewFormTokens([]byte("other secret"), 3*time.Second, 24*time.Hour)
+	assert.Equal(t, 1.0, otherTokens.Check(token, testNow.Add(time.Minute)).Score, "The token was signed with another key")
+}
+
+func TestMinimumTimeFilterSkipsApiSubmissions(t *testing.T) {
+	filter := MinimumTimeFilter{Tokens: NewFormTokens([]byte("secret"), 3*time.Second, 0)}
+	assert.Equal(t, NotSpam, checkFilter(t, filter, Submission{Comment: "Hello", SubmittedAt: testNow}))
+	assert.Equal(t, 1.0, checkFilter(t, filter, Submission{Comment: "Hello", Form: &FormFields{}, SubmittedAt: testNow}).Score)
+}
+
+func TestLinkLimit(t *testing.T) {
+	filter := LinkLimitFilter{MaxLinks: 2}
+	assert.Equal(t, NotSpam, checkFilter(t, filter, Submission{Comment: "See https://example.com and www.example.org"}))
+	result := checkFilter(t, filter, Submission{Comment: "https://a.example http://b.example www.c.example"})
+	assert.Equal(t, 0.8, result.Score)
+	assert.Contains(t, result.Reason, "3 links")
+}
+
+func TestBlocklist(t *testing.T) {
+	filter := NewBlocklistFilter([]string{"casino", " cheap pills "}, []string{"spam.example", ""})
+	assert.Equal(t, NotSpam, checkFilter(t, filter, Submission{Comment: "Occasionally I visit https://example.com", Website: "https://myblog.example"}))
+	assert.Equal(t, 0.9, checkFilter(t, filter, Submission{Comment: "The best CASINO in town"}).Score)
+	assert.Equal(t, 0.9, checkFilter(t, filter, Submission{Comment: "Buy cheap pills now"}).Score)
+	assert.Equal(t, 0.9, checkFilter(t, filter, Submission{Comment: "Hello", Name: "Casino Bot"}).Score)
+	assert.Equal(t, 0.9, checkFilter(t, filter, Submission{Comment: "Visit https://www.spam.example/offer"}).Score)
+	assert.Equal(t, 0.9, checkFilter(t, filter, Submission{Comment: "Hello", Website: "shop.spam.example"}).Score)
+	assert.Equal(t, NotSpam, checkFilter(t, filter, Submission{Comment: "Visit https://notspam.example"}))
+}
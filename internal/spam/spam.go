@@ -0,0 +1,139 @@
+package spam
+
+import (
+	"fmt"
+	"time"
+)
+
+// Submission is a new comment as it was submitted, before it is stored
+type Submission struct {
+	ServiceId int
+	Comment   string
+	Name      string
+	Website   string
+	// Form holds the hidden fields of the HTML comment form, it is nil for comments submitted with the API
+	Form        *FormFields
+	SubmittedAt time.Time
+}
+
+// FormFields are the hidden fields of the HTML comment form that only bots fill in or get wrong
+type FormFields struct {
+	Honeypot  string
+	FormToken string
+}
+
+// Result is the verdict of a single filter. The score is between 0 for certainly not spam and 1 for certainly spam,
+// the reason explains a score above 0 to admins.
+type Result struct {
+	Score  float64
+	Reason string
+}
+
+// NotSpam is the result of filters that found nothing suspicious
+var NotSpam = Result{}
+
+// SpamFilter rates a submission, it is only asked about new comments
+type SpamFilter interface {
+	Name() string
+	Check(submission Submission) (Result, error)
+}
+
+// Learner is a SpamFilter that learns from the moderation decisions of admins
+type Learner interface {
+	Learn(commentId int, submission Submission, spam bool) error
+}
+
+// Verdict combines the results of all filters of a pipeline
+type Verdict struct {
+	// Score is the highest score of all filters
+	Score   float64
+	Reasons []string
+	// Reject is set when the score is so high that the comment should not even be stored
+	Reject bool
+}
+
+// Pipeline runs every submission through all of its filters
+type Pipeline struct {
+	filters     []SpamFilter
+	rejectScore float64
+	formTokens  FormTokens
+}
+
+// Options configures the built-in filters of a pipeline. Filters that are configured with their zero value are not
+// used.
+type Options struct {
+	// RejectScore is the score at which comments are refused instead of stored for moderation
+	RejectScore float64
+	// FormTokenKey signs the tokens of the comment form, MinimumSubmitTime is how long it takes a human at least to
+	// write a comment and MaximumFormAge is how long a form can be left open
+	FormTokenKey      []byte
+	MinimumSubmitTime time.Duration
+	MaximumFormAge    time.Duration
+	MaxLinks          int
+	BlockedWords      []string
+	BlockedDomains    []string
+	// BayesMinimumTraining is how many comments the classifier must have learned as spam and as ham before it is
+	// used, the classifier is not used when there is no TokenStore
+	BayesMinimumTraining int
+}
+
+// NewPipeline creates a pipeline with the built-in filters as configured by the options followed by the additional
+// filters
+func NewPipeline(options Options, tokenStore TokenStore, additionalFilters ...SpamFilter) *Pipeline {
+	pipeline := &Pipeline{rejectScore: options.RejectScore}
+	pipeline.filters = append(pipeline.filters, HoneypotFilter{})
+	if options.MinimumSubmitTime > 0 && len(options.FormTokenKey) > 0 {
+		pipeline.formTokens = NewFormTokens(options.FormTokenKey, options.MinimumSubmitTime, options.MaximumFormAge)
+		pipeline.filters = append(pipeline.filters, MinimumTimeFilter{Tokens: pipeline.formTokens})
+	}
+	if options.MaxLinks > 0 {
+		pipeline.filters = append(pipeline.filters, LinkLimitFilter{MaxLinks: options.MaxLinks})
+	}
+	if len(options.BlockedWords) > 0 || len(options.BlockedDomains) > 0 {
+		pipeline.filters = append(pipeline.filters, NewBlocklistFilter(options.BlockedWords, options.BlockedDomains))
+	}
+	if tokenStore != nil {
+		pipeline.filters = append(pipeline.filters, BayesianFilter{Store: tokenStore, MinimumTraining: options.BayesMinimumTraining})
+	}
+	pipeline.filters = append(pipeline.filters, additionalFilters...)
+	return pipeline
+}
+
+// FormToken returns the token for a comment form that is shown now, it is empty when the minimum time to submit is
+// not checked
+func (pipeline *Pipeline) FormToken(now time.Time) string {
+	if !pipeline.formTokens.IsValid() {
+		return ""
+	}
+	return pipeline.formTokens.Issue(now)
+}
+
+// Check runs the submission through all filters
+func (pipeline *Pipeline) Check(submission Submission) (Verdict, error) {
+	verdict := Verdict{Reasons: make([]string, 0)}
+	for _, filter := range pipeline.filters {
+		result, err := filter.Check(submission)
+		if err != nil {
+			return Verdict{}, fmt.Errorf("spam filter %s: %w", filter.Name(), err)
+		}
+		if result.Score > 0 {
+			verdict.Score = max(verdict.Score, min(result.Score, 1))
+			verdict.Reasons = append(verdict.Reasons, filter.Name()+": "+result.Reason)
+		}
+	}
+	verdict.Reject = pipeline.rejectScore > 0 && verdict.Score >= pipeline.rejectScore
+	return verdict, nil
+}
+
+// Learn passes the moderation decision about a comment on to all filters that learn
+func (pipeline *Pipeline) Learn(commentId int, submission Submission, spam bool) error {
+	for _, filter := range pipeline.filters {
+		if learner, ok := filter.(Learner); ok {
+			err := learner.Learn(commentId, submission, spam)
+			if err != nil {
+				return fmt.Errorf("spam filter %s: %w", filter.Name(), err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package spam
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fixedFilter struct {
+	result Result
+	err    error
+}
+
+func (f fixedFilter) Name() string {
+	return "fixed"
+}
+
+func (f fixedFilter) Check(submission Submission) (Result, error) {
+	return f.result, f.err
+}
+
+func TestPipelineTakesTheHighestScore(t *testing.T) {
+	pipeline := NewPipeline(Options{RejectScore: 1, MaxLinks: 1, BlockedWords: []string{"casino"}}, nil)
+	verdict, err := pipeline.Check(Submission{Comment: "Casino at https://a.example and https://b.example"})
+	assert.Nil(t, err)
+	assert.Equal(t, 0.9, verdict.Score)
+	assert.False(t, verdict.Reject)
+	assert.Equal(t, 2, len(verdict.Reasons))
+	assert.True(t, strings.HasPrefix(verdict.Reasons[0], "link limit: "))
+	assert.True(t, strings.HasPrefix(verdict.Reasons[1], "blocklist: "))
+
+	verdict, err = pipeline.Check(Submission{Comment: "Hello", Form: &FormFields{Honeypot: "filled"}})
+	assert.Nil(t, err)
+	assert.Equal(t, 1.0, verdict.Score)
+	assert.True(t, verdict.Reject)
+
+	verdict, err = pipeline.Check(Submission{Comment: "Hello"})
+	assert.Nil(t, err)
+	assert.Equal(t, Verdict{Reasons: []string{}}, verdict)
+}
+
+func TestPipelineWithoutRejectScoreNeverRejects(t *testing.T) {
+	pipeline := NewPipeline(Options{}, nil)
+	verdict, err := pipeline.Check(Submission{Comment: "Hello", Form: &FormFields{Honeypot: "filled"}})
+	assert.Nil(t, err)
+	assert.Equal(t, 1.0, verdict.Score)
+	assert.False(t, verdict.Reject)
+}
+
+func TestPipelineRunsAdditionalFilters(t *testing.T) {
+	pipeline := NewPipeline(Options{RejectScore: 0.7}, nil, fixedFilter{result: Result{Score: 0.75, Reason: "custom"}})
+	verdict, err := pipeline.Check(Submission{Comment: "Hello"})
+	assert.Nil(t, err)
+	assert.True(t, verdict.Reject)
+	assert.Equal(t, []string{"fixed: custom"}, verdict.Reasons)
+
+	failure := errors.New("unavailable")
+	pipeline = NewPipeline(Options{}, nil, fixedFilter{err: failure})
+	_, err = pipeline.Check(Submission{Comment: "Hello"})
+	assert.ErrorIs(t, err, failure)
+}
+
+func TestPipelineIssuesFormTokensOnlyWhenItChecksThem(t *testing.T) {
+	assert.Equal(t, "", NewPipeline(Options{}, nil).FormToken(testNow))
+	pipeline := NewPipeline(Options{FormTokenKey: []byte("secret"), MinimumSubmitTime: 3 * time.Second}, nil)
+	token := pipeline.FormToken(testNow)
+	assert.NotEqual(t, "", token)
+	verdict, err := pipeline.Check(Submission{Comment: "Hello", Form: &FormFields{FormToken: token}, SubmittedAt: testNow.Add(time.Minute)})
+	assert.Nil(t, err)
+	assert.Equal(t, 0.0, verdict.Score)
+}
+
+func TestPipelineLearnsWithTheBayesianFilter(t *testing.T) {
+	store := newMemoryTokenStore()
+	pipeline := NewPipeline(Options{}, store)
+	assert.Nil(t, pipeline.Learn(1, Submission{Comment: "Cheap watches"}, true))
+	assert.Equal(t, 1, store.totals.Spam)
+}
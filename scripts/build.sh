@@ -1,3 +1,4 @@
 #!/bin/bash
 go build -v --tags "fts5" -o bin/gocomments-server cmd/runserver/main.go
 go build -v --tags "fts5" -o bin/gocomments-createencryptionkey cmd/createencryptionkey/main.go
+go build -v --tags "fts5" -o bin/gocomments-rotatekey cmd/rotatekey/main.go